@@ -13,6 +13,7 @@ import (
 	"github.com/globocom/tsuru/api/service"
 	"github.com/globocom/tsuru/app"
 	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/dotenv"
 	"github.com/globocom/tsuru/errors"
 	"github.com/globocom/tsuru/log"
 	"github.com/globocom/tsuru/repository"
@@ -21,6 +22,7 @@ import (
 	"labix.org/v2/mgo/bson"
 	"net/http"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -49,43 +51,37 @@ func getAppOrError(name string, u *auth.User) (app.App, error) {
 	return app, nil
 }
 
-func CloneRepositoryHandler(w http.ResponseWriter, r *http.Request) error {
-	w.Header().Set("Content-Type", "text")
-	instance := app.App{Name: r.URL.Query().Get(":name")}
-	err := instance.Get()
-	logWriter := LogWriter{&instance, w}
-	if err != nil {
-		return &errors.Http{Code: http.StatusNotFound, Message: fmt.Sprintf("App %s not found.", instance.Name)}
-	}
-	err = write(&logWriter, []byte("\n ---> Tsuru receiving push\n"))
-	if err != nil {
-		return err
-	}
-	err = write(&logWriter, []byte("\n ---> Cloning your code in your machines\n"))
-	if err != nil {
-		return err
-	}
-	out, err := repository.CloneOrPull(&instance) // should iterate over the machines
-	if err != nil {
-		return &errors.Http{Code: http.StatusInternalServerError, Message: string(out)}
-	}
-	err = write(&logWriter, out)
-	if err != nil {
-		return err
+// lockApp acquires the lock of the app, returning a conflict error when the
+// app is already locked. The app is loaded again after acquiring the lock, so
+// that changes made while it was locked are not overwritten.
+func lockApp(a *app.App, owner, reason string) (*app.AppLock, error) {
+	lock, err := app.AcquireLock(a.Name, owner, reason)
+	if e, ok := err.(*app.LockedError); ok {
+		return nil, &errors.Http{Code: http.StatusConflict, Message: e.Error()}
+	} else if err != nil {
+		return nil, err
 	}
-	err = write(&logWriter, []byte("\n ---> Installing dependencies\n"))
-	if err != nil {
-		return err
+	fresh := app.App{Name: a.Name}
+	if err := fresh.Get(); err != nil {
+		lock.Release()
+		return nil, &errors.Http{Code: http.StatusNotFound, Message: fmt.Sprintf("App %s not found.", a.Name)}
 	}
-	err = instance.InstallDeps(&logWriter)
-	if err != nil {
-		return err
+	*a = fresh
+	return lock, nil
+}
+
+// UnlockHandler forcibly releases the lock of an app. Only admins are allowed
+// to do it.
+func UnlockHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	if !u.IsAdmin() {
+		return &errors.Http{Code: http.StatusForbidden, Message: "Only admins are allowed to unlock apps."}
 	}
-	err = instance.Restart(&logWriter)
-	if err != nil {
-		return err
+	appName := r.URL.Query().Get(":name")
+	err := app.ForceReleaseLock(appName)
+	if err == app.ErrNotLocked {
+		return &errors.Http{Code: http.StatusNotFound, Message: fmt.Sprintf("App %s is not locked.", appName)}
 	}
-	return write(&logWriter, []byte("\n ---> Deploy done!\n\n"))
+	return err
 }
 
 // AppIsAvaliableHandler verify if the app.Unit().State() is
@@ -249,6 +245,14 @@ func AddUnitsHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error
 	if err != nil {
 		return err
 	}
+	lock, err := lockApp(&app, u.Email, "add units")
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	if process := r.URL.Query().Get("process"); process != "" {
+		return processError(app.AddProcessUnits(n, process))
+	}
 	return app.AddUnits(n)
 }
 
@@ -262,9 +266,30 @@ func RemoveUnitsHandler(w http.ResponseWriter, r *http.Request, u *auth.User) er
 	if err != nil {
 		return err
 	}
+	lock, err := lockApp(&app, u.Email, "remove units")
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	if process := r.URL.Query().Get("process"); process != "" {
+		return processError(app.RemoveProcessUnits(n, process))
+	}
 	return app.RemoveUnits(uint(n))
 }
 
+// processError converts errors about unknown process types in bad request
+// errors, and errors about provisioners that don't support process types in
+// precondition failed errors.
+func processError(err error) error {
+	switch err {
+	case app.ErrUnknownProcess:
+		return &errors.Http{Code: http.StatusBadRequest, Message: err.Error()}
+	case app.ErrProcessNotSupported:
+		return &errors.Http{Code: http.StatusPreconditionFailed, Message: err.Error()}
+	}
+	return err
+}
+
 func grantAccessToTeam(appName, teamName string, u *auth.User) error {
 	t := new(auth.Team)
 	app, err := getAppOrError(appName, u)
@@ -411,6 +436,52 @@ func GetEnv(w http.ResponseWriter, r *http.Request, u *auth.User) (err error) {
 	return nil
 }
 
+var envAssignRegexp = regexp.MustCompile(`(?:^|\s)([^\s=]+)=`)
+
+// parseEnvs parses a list of assignments in the form NAME=value, separated by
+// spaces. Values extend up to the next assignment, so they may contain spaces
+// and equal signs. Names are validated, an invalid name results in a bad
+// request error.
+func parseEnvs(body string) ([]bind.EnvVar, error) {
+	body = strings.TrimSpace(body)
+	matches := envAssignRegexp.FindAllStringSubmatchIndex(body, -1)
+	if len(matches) == 0 || matches[0][0] != 0 {
+		return nil, &errors.Http{
+			Code:    http.StatusBadRequest,
+			Message: "Invalid environment variables. Use the format NAME=value.",
+		}
+	}
+	envs := make([]bind.EnvVar, len(matches))
+	for i, m := range matches {
+		end := len(body)
+		if i+1 < len(matches) {
+			end = matches[i+1][0]
+		}
+		name := body[m[2]:m[3]]
+		if err := validateEnvNames([]string{name}); err != nil {
+			return nil, err
+		}
+		envs[i] = bind.EnvVar{Name: name, Value: strings.TrimSpace(body[m[1]:end]), Public: true}
+	}
+	return envs, nil
+}
+
+func validateEnvNames(names []string) error {
+	for _, name := range names {
+		if err := envError(app.ValidateEnvName(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func envError(err error) error {
+	if e, ok := err.(*app.InvalidEnvError); ok {
+		return &errors.Http{Code: http.StatusBadRequest, Message: e.Error()}
+	}
+	return err
+}
+
 func SetEnv(w http.ResponseWriter, r *http.Request, u *auth.User) error {
 	msg := "You must provide the environment variables"
 	if r.Body == nil {
@@ -428,17 +499,19 @@ func SetEnv(w http.ResponseWriter, r *http.Request, u *auth.User) error {
 	if err != nil {
 		return err
 	}
-	regex, err := regexp.Compile(`(\w+=[^=]+)(\s|$)`)
+	lock, err := lockApp(&app, u.Email, "set env")
 	if err != nil {
 		return err
 	}
-	variables := regex.FindAllStringSubmatch(string(body), -1)
-	envs := make([]bind.EnvVar, len(variables))
-	for i, v := range variables {
-		parts := strings.Split(v[1], "=")
-		envs[i] = bind.EnvVar{Name: parts[0], Value: parts[1], Public: true}
+	defer lock.Release()
+	envs, err := parseEnvs(string(body))
+	if err != nil {
+		return err
 	}
-	return app.SetEnvsToApp(envs, true, false)
+	if err := app.SetEnvsToApp(envs, true, false, u.Email); err != nil {
+		return envError(err)
+	}
+	return restartAfterEnvChange(r, &app)
 }
 
 func UnsetEnv(w http.ResponseWriter, r *http.Request, u *auth.User) error {
@@ -458,47 +531,271 @@ func UnsetEnv(w http.ResponseWriter, r *http.Request, u *auth.User) error {
 	if err != nil {
 		return err
 	}
-	return app.UnsetEnvsFromApp(strings.Fields(string(body)), true, false)
+	lock, err := lockApp(&app, u.Email, "unset env")
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	variables := strings.Fields(string(body))
+	if err := validateEnvNames(variables); err != nil {
+		return err
+	}
+	if err := app.UnsetEnvsFromApp(variables, true, false, u.Email); err != nil {
+		return err
+	}
+	return restartAfterEnvChange(r, &app)
 }
 
-func AppLog(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+// restartAfterEnvChange queues the restart of the app when the request asks
+// for it, in the restart parameter. Apps that restart whenever their
+// environment variables change are not restarted again (see
+// app.App.RestartOnEnvChange).
+func restartAfterEnvChange(r *http.Request, a *app.App) error {
+	if r.URL.Query().Get("restart") != "true" || a.RestartOnEnvChange {
+		return nil
+	}
+	return a.EnqueueRestart()
+}
+
+// ExportEnvHandler writes the public environment variables of the app in the
+// dotenv format. Private variables are listed in comments, without their
+// values. As in GetEnv, the body may contain the names of the variables to
+// export.
+func ExportEnvHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	var names []string
+	if r.Body != nil {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		names = strings.Fields(string(body))
+	}
+	instance, err := getAppOrError(r.URL.Query().Get(":name"), u)
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		for name := range instance.Env {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var vars []dotenv.Var
+	var private []string
+	for _, name := range names {
+		if env, ok := instance.Env[name]; ok && env.Public {
+			vars = append(vars, dotenv.Var{Name: env.Name, Value: env.Value})
+		} else if ok {
+			private = append(private, name)
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	if err := dotenv.Write(w, vars); err != nil {
+		return err
+	}
+	for _, name := range private {
+		if _, err := fmt.Fprintf(w, "# %s=*** (private variable)\n", name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ImportEnvHandler sets the environment variables given in the body, in the
+// dotenv format. All variables are set at once: if any of them is invalid,
+// none is set, and the variables are serialized in the units of the app only
+// once. Like in SetEnv, private variables are not overridden.
+func ImportEnvHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	msg := "You must provide the environment variables"
+	if r.Body == nil {
+		return &errors.Http{Code: http.StatusBadRequest, Message: msg}
+	}
+	vars, err := dotenv.Parse(r.Body)
+	if e, ok := err.(*dotenv.SyntaxError); ok {
+		return &errors.Http{Code: http.StatusBadRequest, Message: "Invalid dotenv file, " + e.Error()}
+	} else if err != nil {
+		return err
+	}
+	if len(vars) == 0 {
+		return &errors.Http{Code: http.StatusBadRequest, Message: msg}
+	}
+	envs := make([]bind.EnvVar, len(vars))
+	names := make([]string, len(vars))
+	for i, v := range vars {
+		envs[i] = bind.EnvVar{Name: v.Name, Value: v.Value, Public: true}
+		names[i] = v.Name
+	}
+	if err := validateEnvNames(names); err != nil {
+		return err
+	}
+	instance, err := getAppOrError(r.URL.Query().Get(":name"), u)
+	if err != nil {
+		return err
+	}
+	lock, err := lockApp(&instance, u.Email, "set env")
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	if err := instance.SetEnvsToApp(envs, true, false, u.Email); err != nil {
+		return envError(err)
+	}
+	return restartAfterEnvChange(r, &instance)
+}
+
+// EnvHistoryHandler lists the revisions of the environment variables of the
+// app, most recent first.
+func EnvHistoryHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	instance, err := getAppOrError(r.URL.Query().Get(":name"), u)
+	if err != nil {
+		return err
+	}
+	revisions, err := app.ListEnvRevisions(&instance)
+	if err != nil {
+		return err
+	}
+	if len(revisions) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
 	w.Header().Set("Content-Type", "application/json")
-	appName := r.URL.Query().Get(":name")
-	pipe := []bson.M{}
-	pipe = append(pipe, bson.M{"$unwind": "$logs"})
-	match := bson.M{}
-	match["name"] = appName
-	if source := r.URL.Query().Get("source"); source != "" {
-		match["logs.source"] = source
+	return json.NewEncoder(w).Encode(revisions)
+}
+
+// EnvRollbackHandler restores the public environment variables of the app to
+// the ones it had in a previous revision.
+func EnvRollbackHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	instance, err := getAppOrError(r.URL.Query().Get(":name"), u)
+	if err != nil {
+		return err
+	}
+	revision, err := strconv.Atoi(r.URL.Query().Get(":revision"))
+	if err != nil {
+		return &errors.Http{Code: http.StatusBadRequest, Message: "Invalid revision."}
+	}
+	lock, err := lockApp(&instance, u.Email, "env rollback")
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	err = instance.RollbackEnvs(revision, u.Email)
+	if err == app.ErrEnvRevisionNotFound {
+		return &errors.Http{Code: http.StatusNotFound, Message: err.Error()}
 	}
-	_, err := getAppOrError(appName, u)
+	return err
+}
+
+// EnvRestartPolicyHandler sets whether the app should be restarted whenever
+// its environment variables change. The body must be either "on" or "off".
+func EnvRestartPolicyHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	msg := `You must provide the restart policy: "on" or "off".`
+	if r.Body == nil {
+		return &errors.Http{Code: http.StatusBadRequest, Message: msg}
+	}
+	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		return err
 	}
-	pipe = append(pipe, bson.M{"$match": match})
-	pipe = append(pipe, bson.M{"$project": bson.M{"_id": 0, "logs": 1}})
-	pipe = append(pipe, bson.M{"$sort": bson.M{"logs.date": -1}})
+	var restart bool
+	switch strings.TrimSpace(string(body)) {
+	case "on":
+		restart = true
+	case "off":
+		restart = false
+	default:
+		return &errors.Http{Code: http.StatusBadRequest, Message: msg}
+	}
+	instance, err := getAppOrError(r.URL.Query().Get(":name"), u)
+	if err != nil {
+		return err
+	}
+	lock, err := lockApp(&instance, u.Email, "env restart policy")
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	return instance.SetRestartOnEnvChange(restart)
+}
+
+// HealingHandler sets whether the broken units of the app are replaced by the
+// healer. The body must be either "on" or "off".
+func HealingHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	msg := `You must provide the healing policy: "on" or "off".`
+	if r.Body == nil {
+		return &errors.Http{Code: http.StatusBadRequest, Message: msg}
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	var disable bool
+	switch strings.TrimSpace(string(body)) {
+	case "on":
+		disable = false
+	case "off":
+		disable = true
+	default:
+		return &errors.Http{Code: http.StatusBadRequest, Message: msg}
+	}
+	instance, err := getAppOrError(r.URL.Query().Get(":name"), u)
+	if err != nil {
+		return err
+	}
+	lock, err := lockApp(&instance, u.Email, "healing")
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	return instance.SetDisableHealing(disable)
+}
+
+// ReencryptEnvsHandler re-encrypts the private environment variables of all
+// apps with the current key. It's used to rotate the encryption key, and only
+// admins are allowed to do it.
+func ReencryptEnvsHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	if !u.IsAdmin() {
+		return &errors.Http{Code: http.StatusForbidden, Message: "Only admins are allowed to re-encrypt environment variables."}
+	}
+	w.Header().Set("Content-Type", "text")
+	return app.ReencryptEnvs(w, u.Email)
+}
+
+func AppLog(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	w.Header().Set("Content-Type", "application/json")
+	instance, err := getAppOrError(r.URL.Query().Get(":name"), u)
+	if err != nil {
+		return err
+	}
+	var lines int
 	if l := r.URL.Query().Get("lines"); l != "" {
-		lines, err := strconv.Atoi(l)
+		lines, err = strconv.Atoi(l)
 		if err != nil {
 			return err
 		}
-		pipe = append(pipe, bson.M{"$limit": lines})
 	}
-	var result []map[string]map[string]interface{}
-	err = db.Session.Apps().Pipe(pipe).All(&result)
+	filter, err := logFilter(r)
 	if err != nil {
 		return err
 	}
-	n := len(result)
-	logs := make([]app.Applog, n)
-	for i, row := range result {
-		log := app.Applog{
-			Message: row["logs"]["message"].(string),
-			Source:  row["logs"]["source"].(string),
-			Date:    row["logs"]["date"].(time.Time),
-		}
-		logs[n-i-1] = log
+	follow := r.URL.Query().Get("follow") == "1"
+	if follow && !filter.Until.IsZero() {
+		return &errors.Http{Code: http.StatusBadRequest, Message: "The until parameter can't be used with follow."}
+	}
+	// Entries written while the last ones are read are followed, when there
+	// are no last ones.
+	followed := filter
+	if followed.Since.IsZero() {
+		followed.Since = time.Now()
+	}
+	logs, err := instance.LastLogs(lines, filter)
+	if err != nil {
+		return err
+	}
+	if logs == nil {
+		logs = []app.Applog{}
+	}
+	if follow {
+		return followLogs(w, &instance, logs, followed)
 	}
 	b, err := json.Marshal(logs)
 	if err != nil {
@@ -507,6 +804,80 @@ func AppLog(w http.ResponseWriter, r *http.Request, u *auth.User) error {
 	return write(w, b)
 }
 
+// maxLogFilterLength is the maximum length of the text used to filter the
+// messages of log entries, which is matched by the database.
+const maxLogFilterLength = 256
+
+// logFilter reads the filter of the log entries from the query string of the
+// request: the source and the unit of the entries, the dates since and until
+// when they were written, in the RFC 3339 format, and a text to look for in
+// their messages (filter). With regex=1, the text is a regular expression.
+func logFilter(r *http.Request) (app.LogFilter, error) {
+	query := r.URL.Query()
+	filter := app.LogFilter{Source: query.Get("source"), Unit: query.Get("unit")}
+	var err error
+	if filter.Since, err = logDate(query.Get("since")); err != nil {
+		return filter, err
+	}
+	if filter.Until, err = logDate(query.Get("until")); err != nil {
+		return filter, err
+	}
+	if text := query.Get("filter"); len(text) > maxLogFilterLength {
+		msg := fmt.Sprintf("The filter can't be longer than %d characters.", maxLogFilterLength)
+		return filter, &errors.Http{Code: http.StatusBadRequest, Message: msg}
+	} else if text != "" {
+		if query.Get("regex") != "1" {
+			filter.Message = regexp.QuoteMeta(text)
+		} else if _, err := regexp.Compile(text); err != nil {
+			return filter, &errors.Http{Code: http.StatusBadRequest, Message: fmt.Sprintf("Invalid regular expression: %s.", err)}
+		} else {
+			filter.Message = text
+		}
+	}
+	return filter, nil
+}
+
+func logDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	date, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		msg := fmt.Sprintf("Invalid date %q, dates must be in the RFC 3339 format (%s).", value, time.RFC3339)
+		return date, &errors.Http{Code: http.StatusBadRequest, Message: msg}
+	}
+	return date, nil
+}
+
+// followLogs writes the given entries to w, followed by the new entries in
+// the logs of the app, as they arrive. Entries are written as JSON arrays, one
+// per line. When there are no new entries, an empty line is written instead,
+// so clients can tell the stream is still alive.
+//
+// followLogs returns when the client goes away, or when the logs can't be
+// read anymore.
+func followLogs(w io.Writer, a *app.App, logs []app.Applog, filter app.LogFilter) error {
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(logs); err != nil {
+		return nil
+	}
+	var gone bool
+	err := a.FollowLogs(logs, filter, func(logs []app.Applog) error {
+		var err error
+		if len(logs) == 0 {
+			err = write(w, []byte("\n"))
+		} else {
+			err = encoder.Encode(logs)
+		}
+		gone = err != nil
+		return err
+	})
+	if gone {
+		return nil
+	}
+	return err
+}
+
 func serviceInstanceAndAppOrError(instanceName, appName string, u *auth.User) (instance service.ServiceInstance, a app.App, err error) {
 	err = db.Session.ServiceInstances().Find(bson.M{"name": instanceName}).One(&instance)
 	if err != nil {
@@ -535,10 +906,18 @@ func BindHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
 	if err != nil {
 		return err
 	}
+	lock, err := lockApp(&a, u.Email, "bind")
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
 	err = instance.Bind(&a)
 	if err != nil {
 		return err
 	}
+	if err = restartAfterEnvChange(r, &a); err != nil {
+		return err
+	}
 	var envs []string
 	for k := range a.InstanceEnv(instanceName) {
 		envs = append(envs, k)
@@ -554,7 +933,15 @@ func UnbindHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
 	if err != nil {
 		return err
 	}
-	return instance.Unbind(&a)
+	lock, err := lockApp(&a, u.Email, "unbind")
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	if err = instance.Unbind(&a); err != nil {
+		return err
+	}
+	return restartAfterEnvChange(r, &a)
 }
 
 func RestartHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
@@ -563,9 +950,56 @@ func RestartHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error
 	if err != nil {
 		return err
 	}
+	lock, err := lockApp(&instance, u.Email, "restart")
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
 	return instance.Restart(w)
 }
 
+func StopHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	w.Header().Set("Content-Type", "text")
+	instance, err := getAppOrError(r.URL.Query().Get(":name"), u)
+	if err != nil {
+		return err
+	}
+	lock, err := lockApp(&instance, u.Email, "stop")
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	err = instance.Stop(w)
+	if e, ok := err.(*app.ValidationError); ok {
+		return &errors.Http{Code: http.StatusPreconditionFailed, Message: e.Message}
+	} else if err == app.ErrStopNotSupported {
+		return &errors.Http{Code: http.StatusPreconditionFailed, Message: err.Error()}
+	}
+	return err
+}
+
+func StartHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	w.Header().Set("Content-Type", "text")
+	instance, err := getAppOrError(r.URL.Query().Get(":name"), u)
+	if err != nil {
+		return err
+	}
+	lock, err := lockApp(&instance, u.Email, "start")
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	err = instance.Start(w)
+	if e, ok := err.(*app.ValidationError); ok {
+		return &errors.Http{Code: http.StatusPreconditionFailed, Message: e.Message}
+	}
+	return err
+}
+
+// AddLogHandler adds the messages in the body of the request, a JSON array of
+// strings, to the logs of the app. Units of the app identify themselves with
+// the unit parameter in the query string, which is ignored when the app has no
+// unit with the given name.
 func AddLogHandler(w http.ResponseWriter, r *http.Request) error {
 	app := app.App{Name: r.URL.Query().Get(":name")}
 	err := app.Get()
@@ -578,12 +1012,20 @@ func AddLogHandler(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 	var logs []string
-	err = json.Unmarshal(body, &logs)
-	for _, log := range logs {
-		err := app.Log(log, "app")
-		if err != nil {
-			return err
-		}
+	if err = json.Unmarshal(body, &logs); err != nil {
+		return err
+	}
+	// Entries are attributed only to units that belong to the app.
+	unit := r.URL.Query().Get("unit")
+	found := false
+	for _, u := range app.Units {
+		found = found || u.Name == unit
+	}
+	if !found {
+		unit = ""
+	}
+	if err = app.LogUnit(strings.Join(logs, "\n"), "app", unit); err != nil {
+		return err
 	}
 	w.WriteHeader(http.StatusOK)
 	return nil
@@ -17,6 +17,7 @@ import (
 	"github.com/globocom/tsuru/errors"
 	"github.com/globocom/tsuru/log"
 	"github.com/globocom/tsuru/provision"
+	"github.com/globocom/tsuru/queue"
 	"github.com/globocom/tsuru/repository"
 	"github.com/globocom/tsuru/testing"
 	"io"
@@ -96,12 +97,14 @@ func (s *S) TestAppIsAvaliableHandlerShouldReturn200WhenAppUnitStatusIsStarted(c
 }
 
 func (s *S) TestCloneRepositoryHandlerShouldAddLogs(c *C) {
+	commit := "8e0f1ab1fc30b7e7aea8e7f6fc5f1a7d9e4ad3d1"
 	output := `pre-restart:
   - pre.sh
 pos-restart:
   - pos.sh
 `
 	s.provisioner.PrepareOutput(nil)            // clone
+	s.provisioner.PrepareOutput([]byte(commit)) // commit
 	s.provisioner.PrepareOutput(nil)            // install
 	s.provisioner.PrepareOutput([]byte(output)) // loadHooks
 	s.provisioner.PrepareOutput(nil)            // pre-restart
@@ -130,19 +133,21 @@ pos-restart:
 		" ---> Deploy done!",
 	}
 	for _, msg := range messages {
-		length, err := db.Session.Apps().Find(bson.M{"logs.message": msg}).Count()
+		length, err := db.Session.Logs().Find(bson.M{"appname": a.Name, "message": msg}).Count()
 		c.Check(err, IsNil)
 		c.Check(length, Equals, 1)
 	}
 }
 
 func (s *S) TestCloneRepositoryHandler(c *C) {
+	commit := "8e0f1ab1fc30b7e7aea8e7f6fc5f1a7d9e4ad3d1"
 	output := `pre-restart:
   - pre.sh
 pos-restart:
   - pos.sh
 `
 	s.provisioner.PrepareOutput(nil)            // clone
+	s.provisioner.PrepareOutput([]byte(commit)) // commit
 	s.provisioner.PrepareOutput(nil)            // install
 	s.provisioner.PrepareOutput([]byte(output)) // loadHooks
 	s.provisioner.PrepareOutput(nil)            // pre-restart
@@ -180,12 +185,14 @@ func (s *S) TestCloneRepositoryRunsCloneOrPullThenPreRestartThenRestartThenPosRe
 	w := new(bytes.Buffer)
 	l := stdlog.New(w, "", stdlog.LstdFlags)
 	log.SetLogger(l)
+	commit := "8e0f1ab1fc30b7e7aea8e7f6fc5f1a7d9e4ad3d1"
 	output := `pre-restart:
   - pre.sh
 pos-restart:
   - pos.sh
 `
 	s.provisioner.PrepareOutput(nil)            // clone
+	s.provisioner.PrepareOutput([]byte(commit)) // commit
 	s.provisioner.PrepareOutput(nil)            // install
 	s.provisioner.PrepareOutput([]byte(output)) // loadHooks
 	s.provisioner.PrepareOutput(nil)            // pre-restart
@@ -1517,6 +1524,79 @@ func (s *S) TestSetEnvHandlerReturnsBadRequestIfVariablesAreMissing(c *C) {
 	}
 }
 
+func (s *S) TestSetEnvHandlerSupportsValuesWithEqualSignsAndShellCharacters(c *C) {
+	a := app.App{
+		Name:  "hostile",
+		Teams: []string{s.team.Name},
+		Units: []app.Unit{{Machine: 1}},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/env?:name=%s", a.Name, a.Name)
+	body := `DATABASE_URL=postgres://db/app?sslmode=require QUOTED="$(rm -rf /)" it's`
+	request, err := http.NewRequest("POST", url, strings.NewReader(body))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = SetEnv(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	app := &app.App{Name: "hostile"}
+	err = app.Get()
+	c.Assert(err, IsNil)
+	expectedURL := bind.EnvVar{Name: "DATABASE_URL", Value: "postgres://db/app?sslmode=require", Public: true}
+	expectedQuoted := bind.EnvVar{Name: "QUOTED", Value: `"$(rm -rf /)" it's`, Public: true}
+	c.Assert(app.Env["DATABASE_URL"], DeepEquals, expectedURL)
+	c.Assert(app.Env["QUOTED"], DeepEquals, expectedQuoted)
+}
+
+func (s *S) TestSetEnvHandlerReturnsBadRequestIfTheNameIsInvalid(c *C) {
+	a := app.App{
+		Name:  "hostile",
+		Teams: []string{s.team.Name},
+		Units: []app.Unit{{Machine: 1}},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/env?:name=%s", a.Name, a.Name)
+	bodies := []string{"DATABASE_HOST=localhost FOO-BAR=1", "2FAST=1", "$(reboot)=1"}
+	for _, body := range bodies {
+		request, err := http.NewRequest("POST", url, strings.NewReader(body))
+		c.Assert(err, IsNil)
+		recorder := httptest.NewRecorder()
+		err = SetEnv(recorder, request, s.user)
+		c.Assert(err, NotNil)
+		e, ok := err.(*errors.Http)
+		c.Assert(ok, Equals, true)
+		c.Assert(e.Code, Equals, http.StatusBadRequest)
+		c.Assert(e.Message, Matches, "^Invalid environment variable name: .*")
+	}
+	app := &app.App{Name: "hostile"}
+	err = app.Get()
+	c.Assert(err, IsNil)
+	c.Assert(app.Env, HasLen, 0)
+}
+
+func (s *S) TestSetEnvHandlerReturnsBadRequestIfTheBodyIsNotAnAssignment(c *C) {
+	a := app.App{
+		Name:  "hostile",
+		Teams: []string{s.team.Name},
+		Units: []app.Unit{{Machine: 1}},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/env?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("localhost DATABASE_HOST=localhost"))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = SetEnv(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusBadRequest)
+}
+
 func (s *S) TestSetEnvHandlerReturnsNotFoundIfTheAppDoesNotExist(c *C) {
 	b := strings.NewReader("DATABASE_HOST=localhost")
 	request, err := http.NewRequest("POST", "/apps/unknown/env/?:name=unknown", b)
@@ -1662,6 +1742,27 @@ func (s *S) TestUnsetEnvHandlerReturnsBadRequestIfVariablesAreMissing(c *C) {
 	}
 }
 
+func (s *S) TestUnsetEnvHandlerReturnsBadRequestIfTheNameIsInvalid(c *C) {
+	a := app.App{
+		Name:  "hostile",
+		Teams: []string{s.team.Name},
+		Units: []app.Unit{{Machine: 1}},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/env?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("DATABASE_HOST FOO-BAR"))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = UnsetEnv(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusBadRequest)
+	c.Assert(e.Message, Equals, `Invalid environment variable name: "FOO-BAR". Names must start with a letter or an underscore, followed by letters, digits and underscores.`)
+}
+
 func (s *S) TestUnsetEnvHandlerReturnsNotFoundIfTheAppDoesNotExist(c *C) {
 	b := strings.NewReader("DATABASE_HOST")
 	request, err := http.NewRequest("POST", "/apps/unknown/env/?:name=unknown", b)
@@ -1727,16 +1828,11 @@ func (s *S) TestAppLogShouldHaveContentType(c *C) {
 		Name:      "lost",
 		Framework: "vougan",
 		Teams:     []string{s.team.Name},
-		Logs: []app.Applog{
-			{
-				Date:    time.Now(),
-				Message: "Something new",
-			},
-		},
 	}
 	err := db.Session.Apps().Insert(a)
 	c.Assert(err, IsNil)
 	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	a.Log("Something new", "tsuru")
 	url := fmt.Sprintf("/apps/%s/log/?:name=%s", a.Name, a.Name)
 	request, err := http.NewRequest("GET", url, nil)
 	c.Assert(err, IsNil)
@@ -1813,18 +1909,17 @@ func (s *S) TestAppLogSelectByLinesShouldReturnTheLastestEntries(c *C) {
 	err := db.Session.Apps().Insert(a)
 	c.Assert(err, IsNil)
 	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
-	logs := make([]app.Applog, 15)
 	now := time.Now()
 	for i := 0; i < 15; i++ {
-		logs[i] = app.Applog{
+		l := app.Applog{
 			Date:    now.Add(time.Duration(i) * time.Hour),
 			Message: strconv.Itoa(i),
 			Source:  "source",
+			AppName: a.Name,
 		}
+		err = db.Session.Logs().Insert(l)
+		c.Assert(err, IsNil)
 	}
-	a.Logs = logs
-	err = db.Session.Apps().Update(bson.M{"name": a.Name}, a)
-	c.Assert(err, IsNil)
 	url := fmt.Sprintf("/apps/%s/log/?:name=%s&lines=3", a.Name, a.Name)
 	request, err := http.NewRequest("GET", url, nil)
 	c.Assert(err, IsNil)
@@ -1835,7 +1930,7 @@ func (s *S) TestAppLogSelectByLinesShouldReturnTheLastestEntries(c *C) {
 	c.Assert(recorder.Code, Equals, http.StatusOK)
 	body, err := ioutil.ReadAll(recorder.Body)
 	c.Assert(err, IsNil)
-	logs = []app.Applog{}
+	logs := []app.Applog{}
 	err = json.Unmarshal(body, &logs)
 	c.Assert(err, IsNil)
 	c.Assert(logs, HasLen, 3)
@@ -1844,6 +1939,234 @@ func (s *S) TestAppLogSelectByLinesShouldReturnTheLastestEntries(c *C) {
 	c.Assert(logs[2].Message, Equals, "14")
 }
 
+func (s *S) TestAppLogSelectByUnit(c *C) {
+	a := app.App{
+		Name:      "lost",
+		Framework: "vougan",
+		Teams:     []string{s.team.Name},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	a.LogUnit("first unit log", "app", "lost/0")
+	a.LogUnit("second unit log", "app", "lost/1")
+	url := fmt.Sprintf("/apps/%s/log/?:name=%s&unit=lost/1", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AppLog(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	var logs []app.Applog
+	err = json.Unmarshal(recorder.Body.Bytes(), &logs)
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 1)
+	c.Assert(logs[0].Message, Equals, "second unit log")
+	c.Assert(logs[0].Unit, Equals, "lost/1")
+}
+
+func (s *S) TestAppLogSelectByDate(c *C) {
+	a := app.App{
+		Name:      "lost",
+		Framework: "vougan",
+		Teams:     []string{s.team.Name},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	date := time.Date(2012, time.June, 20, 14, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		l := app.Applog{
+			Date:    date.Add(time.Duration(i) * time.Hour),
+			Message: strconv.Itoa(i),
+			Source:  "app",
+			AppName: a.Name,
+		}
+		err = db.Session.Logs().Insert(l)
+		c.Assert(err, IsNil)
+	}
+	url := fmt.Sprintf("/apps/%s/log/?:name=%s&since=2012-06-20T12:00:00-03:00&until=2012-06-20T17:00:00Z", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AppLog(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	var logs []app.Applog
+	err = json.Unmarshal(recorder.Body.Bytes(), &logs)
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 2)
+	c.Assert(logs[0].Message, Equals, "1")
+	c.Assert(logs[1].Message, Equals, "2")
+}
+
+func (s *S) TestAppLogInvalidDate(c *C) {
+	a := app.App{
+		Name:      "lost",
+		Framework: "vougan",
+		Teams:     []string{s.team.Name},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/log/?:name=%s&since=yesterday", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AppLog(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusBadRequest)
+	c.Assert(e.Message, Equals, `Invalid date "yesterday", dates must be in the RFC 3339 format (2006-01-02T15:04:05Z07:00).`)
+}
+
+func (s *S) TestAppLogSelectByText(c *C) {
+	a := app.App{
+		Name:      "lost",
+		Framework: "vougan",
+		Teams:     []string{s.team.Name},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	a.Log("GET /users?page=1 200\nGET /users 200", "app")
+	url := fmt.Sprintf("/apps/%s/log/?:name=%s&filter=%s", a.Name, a.Name, "%2Fusers%3Fpage")
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AppLog(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	var logs []app.Applog
+	err = json.Unmarshal(recorder.Body.Bytes(), &logs)
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 1)
+	c.Assert(logs[0].Message, Equals, "GET /users?page=1 200")
+}
+
+func (s *S) TestAppLogSelectByRegex(c *C) {
+	a := app.App{
+		Name:      "lost",
+		Framework: "vougan",
+		Teams:     []string{s.team.Name},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	a.Log("GET /users 200\nGET /admin 500", "app")
+	url := fmt.Sprintf("/apps/%s/log/?:name=%s&filter=%s&regex=1", a.Name, a.Name, "5%5B0-9%5D%2B%24")
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AppLog(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	var logs []app.Applog
+	err = json.Unmarshal(recorder.Body.Bytes(), &logs)
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 1)
+	c.Assert(logs[0].Message, Equals, "GET /admin 500")
+}
+
+func (s *S) TestAppLogInvalidRegex(c *C) {
+	a := app.App{
+		Name:      "lost",
+		Framework: "vougan",
+		Teams:     []string{s.team.Name},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/log/?:name=%s&filter=%s&regex=1", a.Name, a.Name, "%28")
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AppLog(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusBadRequest)
+	c.Assert(e.Message, Matches, "Invalid regular expression: .*")
+}
+
+func (s *S) TestAppLogFilterTooLong(c *C) {
+	a := app.App{
+		Name:      "lost",
+		Framework: "vougan",
+		Teams:     []string{s.team.Name},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	filter := strings.Repeat("a", maxLogFilterLength+1)
+	url := fmt.Sprintf("/apps/%s/log/?:name=%s&filter=%s", a.Name, a.Name, filter)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AppLog(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusBadRequest)
+	c.Assert(e.Message, Equals, "The filter can't be longer than 256 characters.")
+}
+
+func (s *S) TestAppLogFollowWithUntil(c *C) {
+	a := app.App{
+		Name:      "lost",
+		Framework: "vougan",
+		Teams:     []string{s.team.Name},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/log/?:name=%s&follow=1&until=2012-06-20T17:00:00Z", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AppLog(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusBadRequest)
+}
+
+// goneClientRecorder is a recorder that fails once the given number of writes
+// is reached, like a response to a client that went away.
+type goneClientRecorder struct {
+	*httptest.ResponseRecorder
+	writes int
+}
+
+func (r *goneClientRecorder) Write(b []byte) (int, error) {
+	if r.writes == 0 {
+		return 0, io.ErrClosedPipe
+	}
+	r.writes--
+	return r.ResponseRecorder.Write(b)
+}
+
+func (s *S) TestAppLogFollow(c *C) {
+	a := app.App{
+		Name:      "lost",
+		Framework: "vougan",
+		Teams:     []string{s.team.Name},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	a.Log("mars log", "mars")
+	url := fmt.Sprintf("/apps/%s/log/?:name=%s&follow=1", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := goneClientRecorder{httptest.NewRecorder(), 1}
+	err = AppLog(&recorder, request, s.user)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Code, Equals, http.StatusOK)
+	var logs []app.Applog
+	err = json.NewDecoder(recorder.Body).Decode(&logs)
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 1)
+	c.Assert(logs[0].Message, Equals, "mars log")
+}
+
 func (s *S) TestAppLogShouldReturnLogByApp(c *C) {
 	app1 := app.App{
 		Name:      "app1",
@@ -2263,29 +2586,921 @@ func (s *S) TestRestartHandlerReturns403IfTheUserDoesNotHaveAccessToTheApp(c *C)
 	c.Assert(e.Code, Equals, http.StatusForbidden)
 }
 
-func (s *S) TestAddLogHandler(c *C) {
+func (s *S) TestStopHandler(c *C) {
+	p := testing.NewExtensibleFakeProvisioner()
+	provision.Register("extensible", p)
 	a := app.App{
-		Name:      "myapp",
-		Framework: "python",
+		Name:        "stress",
+		Provisioner: "extensible",
+		Teams:       []string{s.team.Name},
+		State:       string(provision.StatusStarted),
+		Units:       []app.Unit{{Name: "stress/0", State: string(provision.StatusStarted)}},
 	}
 	err := db.Session.Apps().Insert(a)
 	c.Assert(err, IsNil)
 	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
-	b := strings.NewReader(`["message 1", "message 2", "message 3"]`)
-	request, err := http.NewRequest("POST", "/apps/myapp/log/?:name=myapp", b)
+	err = p.Provision(&a)
+	c.Assert(err, IsNil)
+	defer p.Destroy(&a)
+	url := fmt.Sprintf("/apps/%s/stop?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, nil)
 	c.Assert(err, IsNil)
 	recorder := httptest.NewRecorder()
-	err = AddLogHandler(recorder, request)
+	err = StopHandler(recorder, request, s.user)
 	c.Assert(err, IsNil)
-	c.Assert(recorder.Code, Equals, http.StatusOK)
-	messages := []string{
-		"message 1",
-		"message 2",
-		"message 3",
-	}
-	for _, msg := range messages {
-		length, err := db.Session.Apps().Find(bson.M{"name": a.Name, "logs.message": msg}).Count()
-		c.Check(err, IsNil)
-		c.Check(length, Equals, 1)
-	}
+	result := strings.Replace(recorder.Body.String(), "\n", "#", -1)
+	c.Assert(result, Matches, ".*# ---> Stopping your app#.*")
+	c.Assert(recorder.Header().Get("Content-Type"), Equals, "text")
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.State, Equals, string(provision.StatusStopped))
+	c.Assert(a.Units[0].State, Equals, string(provision.StatusStopped))
+}
+
+func (s *S) TestStopHandlerProvisionerIsNotAStopper(c *C) {
+	a := app.App{
+		Name:  "stress",
+		Teams: []string{s.team.Name},
+		State: string(provision.StatusStarted),
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/stop?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = StopHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusPreconditionFailed)
+	c.Assert(e.Message, Equals, app.ErrStopNotSupported.Error())
+}
+
+func (s *S) TestStopHandlerAppAlreadyStopped(c *C) {
+	a := app.App{
+		Name:  "stress",
+		Teams: []string{s.team.Name},
+		State: string(provision.StatusStopped),
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/stop?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = StopHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusPreconditionFailed)
+	c.Assert(e.Message, Equals, "App is already stopped.")
+}
+
+func (s *S) TestStopHandlerReturns404IfTheAppDoesNotExist(c *C) {
+	request, err := http.NewRequest("POST", "/apps/unknown/stop?:name=unknown", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = StopHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusNotFound)
+}
+
+func (s *S) TestStopHandlerReturns403IfTheUserDoesNotHaveAccessToTheApp(c *C) {
+	a := app.App{Name: "nightmist"}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/stop?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = StopHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusForbidden)
+}
+
+func (s *S) TestStartHandler(c *C) {
+	s.provisioner.PrepareOutput(nil) // loadHooks
+	s.provisioner.PrepareOutput([]byte("started"))
+	a := app.App{
+		Name:  "stress",
+		Teams: []string{s.team.Name},
+		State: string(provision.StatusStopped),
+		Units: []app.Unit{{Name: "stress/0", State: string(provision.StatusStopped)}},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/start?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = StartHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	result := strings.Replace(recorder.Body.String(), "\n", "#", -1)
+	c.Assert(result, Matches, ".*# ---> Restarting your app#.*")
+	c.Assert(recorder.Header().Get("Content-Type"), Equals, "text")
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.State, Equals, string(provision.StatusStarted))
+	c.Assert(a.Units[0].State, Equals, string(provision.StatusStarted))
+}
+
+func (s *S) TestStartHandlerAppNotStopped(c *C) {
+	a := app.App{
+		Name:  "stress",
+		Teams: []string{s.team.Name},
+		State: string(provision.StatusStarted),
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/start?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = StartHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusPreconditionFailed)
+	c.Assert(e.Message, Equals, `App must be stopped to be started, but it is "started".`)
+}
+
+func (s *S) TestStartHandlerReturns404IfTheAppDoesNotExist(c *C) {
+	request, err := http.NewRequest("POST", "/apps/unknown/start?:name=unknown", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = StartHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusNotFound)
+}
+
+func (s *S) TestAddLogHandler(c *C) {
+	a := app.App{
+		Name:      "myapp",
+		Framework: "python",
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	b := strings.NewReader(`["message 1", "message 2", "message 3"]`)
+	request, err := http.NewRequest("POST", "/apps/myapp/log/?:name=myapp", b)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AddLogHandler(recorder, request)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Code, Equals, http.StatusOK)
+	messages := []string{
+		"message 1",
+		"message 2",
+		"message 3",
+	}
+	for _, msg := range messages {
+		length, err := db.Session.Logs().Find(bson.M{"appname": a.Name, "message": msg, "source": "app"}).Count()
+		c.Check(err, IsNil)
+		c.Check(length, Equals, 1)
+	}
+}
+
+func (s *S) TestAddLogHandlerFromUnit(c *C) {
+	a := app.App{
+		Name:      "myapp",
+		Framework: "python",
+		Units:     []app.Unit{{Name: "myapp/0"}},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	b := strings.NewReader(`["message 1"]`)
+	request, err := http.NewRequest("POST", "/apps/myapp/log/?:name=myapp&unit=myapp/0", b)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AddLogHandler(recorder, request)
+	c.Assert(err, IsNil)
+	logs, err := a.LastLogs(0, app.LogFilter{})
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 1)
+	c.Assert(logs[0].Message, Equals, "message 1")
+	c.Assert(logs[0].Unit, Equals, "myapp/0")
+}
+
+func (s *S) TestAddLogHandlerFromUnknownUnit(c *C) {
+	a := app.App{
+		Name:      "myapp",
+		Framework: "python",
+		Units:     []app.Unit{{Name: "myapp/0"}},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	b := strings.NewReader(`["message 1"]`)
+	request, err := http.NewRequest("POST", "/apps/myapp/log/?:name=myapp&unit=otherapp/0", b)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AddLogHandler(recorder, request)
+	c.Assert(err, IsNil)
+	logs, err := a.LastLogs(0, app.LogFilter{})
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 1)
+	c.Assert(logs[0].Message, Equals, "message 1")
+	c.Assert(logs[0].Unit, Equals, "")
+}
+
+func (s *S) TestAddUnitsReturns409IfTheAppIsLocked(c *C) {
+	a := app.App{Name: "armorandsword", Framework: "python", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	lock, err := app.AcquireLock(a.Name, "someone@tsuru.io", "deploy")
+	c.Assert(err, IsNil)
+	defer lock.Release()
+	body := strings.NewReader("1")
+	request, err := http.NewRequest("PUT", "/apps/armorandsword/units?:name=armorandsword", body)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AddUnitsHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusConflict)
+	c.Assert(e.Message, Matches, `^App "armorandsword" is locked by someone@tsuru.io since .* \(deploy\)\.$`)
+}
+
+func (s *S) TestRemoveUnitsReturns409IfTheAppIsLocked(c *C) {
+	a := app.App{Name: "armorandsword", Framework: "python", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	lock, err := app.AcquireLock(a.Name, "someone@tsuru.io", "deploy")
+	c.Assert(err, IsNil)
+	defer lock.Release()
+	body := strings.NewReader("1")
+	request, err := http.NewRequest("DELETE", "/apps/armorandsword/units?:name=armorandsword", body)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = RemoveUnitsHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusConflict)
+}
+
+func (s *S) TestSetEnvHandlerReturns409IfTheAppIsLocked(c *C) {
+	a := app.App{Name: "black-dog", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	lock, err := app.AcquireLock(a.Name, "someone@tsuru.io", "deploy")
+	c.Assert(err, IsNil)
+	defer lock.Release()
+	url := fmt.Sprintf("/apps/%s/env/?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("DATABASE_HOST=localhost"))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = SetEnv(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusConflict)
+}
+
+func (s *S) TestUnsetEnvHandlerReturns409IfTheAppIsLocked(c *C) {
+	a := app.App{Name: "black-dog", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	lock, err := app.AcquireLock(a.Name, "someone@tsuru.io", "deploy")
+	c.Assert(err, IsNil)
+	defer lock.Release()
+	url := fmt.Sprintf("/apps/%s/env/?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("DELETE", url, strings.NewReader("DATABASE_HOST"))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = UnsetEnv(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusConflict)
+}
+
+func (s *S) TestRestartHandlerReturns409IfTheAppIsLocked(c *C) {
+	a := app.App{Name: "stress", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	lock, err := app.AcquireLock(a.Name, "someone@tsuru.io", "deploy")
+	c.Assert(err, IsNil)
+	defer lock.Release()
+	url := fmt.Sprintf("/apps/%s/restart?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = RestartHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusConflict)
+}
+
+func (s *S) TestEnvRestartPolicyHandlerReturns409IfTheAppIsLocked(c *C) {
+	a := app.App{Name: "restless", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	lock, err := app.AcquireLock(a.Name, "someone@tsuru.io", "deploy")
+	c.Assert(err, IsNil)
+	defer lock.Release()
+	request, err := http.NewRequest("PUT", "/apps/restless/env/restart-policy?:name=restless", strings.NewReader("on"))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = EnvRestartPolicyHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusConflict)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.RestartOnEnvChange, Equals, false)
+}
+
+func (s *S) TestBindHandlerReturns409IfTheAppIsLocked(c *C) {
+	instance := service.ServiceInstance{
+		Name:        "my-mysql",
+		ServiceName: "mysql",
+		Teams:       []string{s.team.Name},
+	}
+	err := instance.Create()
+	c.Assert(err, IsNil)
+	defer db.Session.ServiceInstances().Remove(bson.M{"name": "my-mysql"})
+	a := app.App{Name: "painkiller", Teams: []string{s.team.Name}}
+	err = db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	lock, err := app.AcquireLock(a.Name, "someone@tsuru.io", "deploy")
+	c.Assert(err, IsNil)
+	defer lock.Release()
+	url := fmt.Sprintf("/services/instances/%s/%s?:instance=%s&:app=%s", instance.Name, a.Name, instance.Name, a.Name)
+	request, err := http.NewRequest("PUT", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = BindHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusConflict)
+}
+
+func (s *S) TestUnlockHandler(c *C) {
+	team := auth.Team{Name: "admin", Users: []string{s.user.Email}}
+	err := db.Session.Teams().Insert(team)
+	c.Assert(err, IsNil)
+	defer db.Session.Teams().Remove(bson.M{"_id": team.Name})
+	_, err = app.AcquireLock("stress", "someone@tsuru.io", "deploy")
+	c.Assert(err, IsNil)
+	request, err := http.NewRequest("DELETE", "/apps/stress/lock?:name=stress", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = UnlockHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	_, err = app.GetLock("stress")
+	c.Assert(err, NotNil)
+}
+
+func (s *S) TestUnlockHandlerReturns404IfTheAppIsNotLocked(c *C) {
+	team := auth.Team{Name: "admin", Users: []string{s.user.Email}}
+	err := db.Session.Teams().Insert(team)
+	c.Assert(err, IsNil)
+	defer db.Session.Teams().Remove(bson.M{"_id": team.Name})
+	request, err := http.NewRequest("DELETE", "/apps/stress/lock?:name=stress", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = UnlockHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusNotFound)
+	c.Assert(e.Message, Equals, "App stress is not locked.")
+}
+
+func (s *S) TestUnlockHandlerReturns403IfTheUserIsNotAnAdmin(c *C) {
+	lock, err := app.AcquireLock("stress", "someone@tsuru.io", "deploy")
+	c.Assert(err, IsNil)
+	defer lock.Release()
+	request, err := http.NewRequest("DELETE", "/apps/stress/lock?:name=stress", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = UnlockHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusForbidden)
+	_, err = app.GetLock("stress")
+	c.Assert(err, IsNil)
+}
+
+func (s *S) TestAddUnitsReturns400IfTheProcessIsUnknown(c *C) {
+	p := testing.NewExtensibleFakeProvisioner()
+	provision.Register("extensible", p)
+	p.PrepareOutput([]byte("processes:\n  worker: celery worker\n")) // loadHooks
+	a := app.App{
+		Name:        "armorandsword",
+		Framework:   "python",
+		Provisioner: "extensible",
+		Teams:       []string{s.team.Name},
+		State:       string(provision.StatusStarted),
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	body := strings.NewReader("1")
+	request, err := http.NewRequest("PUT", "/apps/armorandsword/units?:name=armorandsword&process=clock", body)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AddUnitsHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusBadRequest)
+	c.Assert(e.Message, Equals, app.ErrUnknownProcess.Error())
+}
+
+func (s *S) TestAddUnitsReturns412IfTheProvisionerDoesNotSupportProcesses(c *C) {
+	a := app.App{
+		Name:      "armorandsword",
+		Framework: "python",
+		Teams:     []string{s.team.Name},
+		State:     string(provision.StatusStarted),
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	body := strings.NewReader("1")
+	request, err := http.NewRequest("PUT", "/apps/armorandsword/units?:name=armorandsword&process=worker", body)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AddUnitsHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusPreconditionFailed)
+	c.Assert(e.Message, Equals, app.ErrProcessNotSupported.Error())
+}
+
+func (s *S) TestRemoveUnitsOfAProcess(c *C) {
+	a := app.App{
+		Name:      "velha",
+		Framework: "python",
+		Teams:     []string{s.team.Name},
+		Units: []app.Unit{
+			{Name: "velha/0"},
+			{Name: "velha/1", Process: "worker"},
+			{Name: "velha/2"},
+		},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	err = s.provisioner.Provision(&a)
+	c.Assert(err, IsNil)
+	defer s.provisioner.Destroy(&a)
+	s.provisioner.AddUnits(&a, 2)
+	body := strings.NewReader("1")
+	request, err := http.NewRequest("DELETE", "/apps/velha/units?:name=velha&process=worker", body)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = RemoveUnitsHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Units, HasLen, 2)
+	c.Assert(a.Units[0].Name, Equals, "velha/0")
+	c.Assert(a.Units[1].Name, Equals, "velha/2")
+}
+
+func (s *S) TestReencryptEnvsHandler(c *C) {
+	team := auth.Team{Name: "admin", Users: []string{s.user.Email}}
+	err := db.Session.Teams().Insert(team)
+	c.Assert(err, IsNil)
+	defer db.Session.Teams().Remove(bson.M{"_id": team.Name})
+	config.Set("env-encryption:key", "new-key")
+	defer config.Unset("env-encryption")
+	a := app.App{
+		Name:  "cryptic",
+		Teams: []string{s.team.Name},
+		Env: map[string]bind.EnvVar{
+			"SECRET": {Name: "SECRET", Value: "s3cr3t", Public: false},
+		},
+	}
+	err = db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	request, err := http.NewRequest("POST", "/envs/reencrypt", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = ReencryptEnvsHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Body.String(), Matches, `(?s).*Re-encrypted 1 variables of the app "cryptic".*`)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Env["SECRET"].Value, Not(Equals), "s3cr3t")
+}
+
+func (s *S) TestReencryptEnvsHandlerReturns403IfTheUserIsNotAnAdmin(c *C) {
+	request, err := http.NewRequest("POST", "/envs/reencrypt", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = ReencryptEnvsHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusForbidden)
+}
+
+func (s *S) TestEnvHistoryHandler(c *C) {
+	a := app.App{
+		Name:  "history",
+		Teams: []string{s.team.Name},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.EnvRevisions().RemoveAll(bson.M{"app": a.Name})
+	envs := []bind.EnvVar{
+		{Name: "DATABASE_HOST", Value: "localhost", Public: true},
+		{Name: "DATABASE_PASSWORD", Value: "s3cr3t", Public: false},
+	}
+	err = a.SetEnvsToApp(envs, false, false, s.user.Email)
+	c.Assert(err, IsNil)
+	request, err := http.NewRequest("GET", "/apps/history/env/history?:name=history", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = EnvHistoryHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Header().Get("Content-Type"), Equals, "application/json")
+	c.Assert(strings.Contains(recorder.Body.String(), "s3cr3t"), Equals, false)
+	var revisions []app.EnvRevision
+	err = json.Unmarshal(recorder.Body.Bytes(), &revisions)
+	c.Assert(err, IsNil)
+	c.Assert(revisions, HasLen, 1)
+	c.Assert(revisions[0].Revision, Equals, 1)
+	c.Assert(revisions[0].User, Equals, s.user.Email)
+	c.Assert(revisions[0].Added, DeepEquals, []string{"DATABASE_HOST", "DATABASE_PASSWORD"})
+	c.Assert(revisions[0].Public, DeepEquals, map[string]string{"DATABASE_HOST": "localhost"})
+}
+
+func (s *S) TestEnvHistoryHandlerWithoutRevisions(c *C) {
+	a := app.App{
+		Name:  "history",
+		Teams: []string{s.team.Name},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	request, err := http.NewRequest("GET", "/apps/history/env/history?:name=history", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = EnvHistoryHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Code, Equals, http.StatusNoContent)
+}
+
+func (s *S) TestEnvRollbackHandler(c *C) {
+	a := app.App{
+		Name:  "history",
+		Teams: []string{s.team.Name},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.EnvRevisions().RemoveAll(bson.M{"app": a.Name})
+	err = a.SetEnvsToApp([]bind.EnvVar{{Name: "PORT", Value: "8888", Public: true}}, true, false, s.user.Email)
+	c.Assert(err, IsNil)
+	err = a.SetEnvsToApp([]bind.EnvVar{{Name: "PORT", Value: "9999", Public: true}}, true, false, s.user.Email)
+	c.Assert(err, IsNil)
+	request, err := http.NewRequest("POST", "/apps/history/env/rollback/1?:name=history&:revision=1", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = EnvRollbackHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Env["PORT"].Value, Equals, "8888")
+	_, err = app.GetLock(a.Name)
+	c.Assert(err, NotNil)
+}
+
+func (s *S) TestEnvRollbackHandlerInvalidOrUnknownRevision(c *C) {
+	a := app.App{
+		Name:  "history",
+		Teams: []string{s.team.Name},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	var tests = []struct {
+		revision string
+		code     int
+	}{
+		{"abc", http.StatusBadRequest},
+		{"42", http.StatusNotFound},
+	}
+	for _, t := range tests {
+		url := fmt.Sprintf("/apps/history/env/rollback/%s?:name=history&:revision=%s", t.revision, t.revision)
+		request, err := http.NewRequest("POST", url, nil)
+		c.Assert(err, IsNil)
+		recorder := httptest.NewRecorder()
+		err = EnvRollbackHandler(recorder, request, s.user)
+		c.Assert(err, NotNil)
+		e, ok := err.(*errors.Http)
+		c.Assert(ok, Equals, true)
+		c.Assert(e.Code, Equals, t.code)
+	}
+}
+
+func (s *S) TestEnvRollbackHandlerReturns409IfTheAppIsLocked(c *C) {
+	a := app.App{
+		Name:  "history",
+		Teams: []string{s.team.Name},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	lock, err := app.AcquireLock(a.Name, "someone@tsuru.io", "deploy")
+	c.Assert(err, IsNil)
+	defer lock.Release()
+	request, err := http.NewRequest("POST", "/apps/history/env/rollback/1?:name=history&:revision=1", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = EnvRollbackHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusConflict)
+}
+
+func (s *S) TestExportEnvHandler(c *C) {
+	a := app.App{
+		Name:  "dotenv",
+		Teams: []string{s.team.Name},
+		Env: map[string]bind.EnvVar{
+			"DATABASE_HOST":     {Name: "DATABASE_HOST", Value: "localhost", Public: true},
+			"DATABASE_PASSWORD": {Name: "DATABASE_PASSWORD", Value: "s3cr3t", Public: false},
+			"MOTD":              {Name: "MOTD", Value: "Hello,\n\"world\"", Public: true},
+		},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	request, err := http.NewRequest("GET", "/apps/dotenv/env/dotenv?:name=dotenv", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = ExportEnvHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	expected := `DATABASE_HOST=localhost
+MOTD="Hello,\n\"world\""
+# DATABASE_PASSWORD=*** (private variable)
+`
+	c.Assert(recorder.Body.String(), Equals, expected)
+}
+
+func (s *S) TestExportEnvHandlerWithNames(c *C) {
+	a := app.App{
+		Name:  "dotenv",
+		Teams: []string{s.team.Name},
+		Env: map[string]bind.EnvVar{
+			"DATABASE_HOST": {Name: "DATABASE_HOST", Value: "localhost", Public: true},
+			"DATABASE_USER": {Name: "DATABASE_USER", Value: "root", Public: true},
+		},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	body := strings.NewReader("DATABASE_USER UNKNOWN")
+	request, err := http.NewRequest("GET", "/apps/dotenv/env/dotenv?:name=dotenv", body)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = ExportEnvHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Body.String(), Equals, "DATABASE_USER=root\n")
+}
+
+func (s *S) TestImportEnvHandler(c *C) {
+	a := app.App{
+		Name:  "dotenv",
+		Teams: []string{s.team.Name},
+		Env: map[string]bind.EnvVar{
+			"DATABASE_PASSWORD": {Name: "DATABASE_PASSWORD", Value: "s3cr3t", Public: false},
+		},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.EnvRevisions().RemoveAll(bson.M{"app": a.Name})
+	body := strings.NewReader(`# settings
+DATABASE_HOST=localhost
+DATABASE_PASSWORD=overridden
+MOTD="Hello,
+\"world\""
+`)
+	request, err := http.NewRequest("POST", "/apps/dotenv/env/dotenv?:name=dotenv", body)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = ImportEnvHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	expected := map[string]bind.EnvVar{
+		"DATABASE_HOST":     {Name: "DATABASE_HOST", Value: "localhost", Public: true},
+		"DATABASE_PASSWORD": {Name: "DATABASE_PASSWORD", Value: "s3cr3t", Public: false},
+		"MOTD":              {Name: "MOTD", Value: "Hello,\n\"world\"", Public: true},
+	}
+	c.Assert(a.Env, DeepEquals, expected)
+	revisions, err := app.ListEnvRevisions(&a)
+	c.Assert(err, IsNil)
+	c.Assert(revisions, HasLen, 1)
+	c.Assert(revisions[0].Added, DeepEquals, []string{"DATABASE_HOST", "MOTD"})
+}
+
+func (s *S) TestImportEnvHandlerIsAtomic(c *C) {
+	a := app.App{
+		Name:  "dotenv",
+		Teams: []string{s.team.Name},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	var tests = []struct {
+		body    string
+		message string
+	}{
+		{"DATABASE_HOST=localhost\nINVALID-NAME=1\n", `^Invalid environment variable name: "INVALID-NAME".*`},
+		{"DATABASE_HOST=localhost\nMOTD=\"unterminated\n", `^Invalid dotenv file, line 2: unterminated quoted value$`},
+		{"# nothing\n", "^You must provide the environment variables$"},
+	}
+	for _, t := range tests {
+		request, err := http.NewRequest("POST", "/apps/dotenv/env/dotenv?:name=dotenv", strings.NewReader(t.body))
+		c.Assert(err, IsNil)
+		recorder := httptest.NewRecorder()
+		err = ImportEnvHandler(recorder, request, s.user)
+		c.Assert(err, NotNil)
+		e, ok := err.(*errors.Http)
+		c.Assert(ok, Equals, true)
+		c.Assert(e.Code, Equals, http.StatusBadRequest)
+		c.Assert(e.Message, Matches, t.message)
+	}
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Env, HasLen, 0)
+}
+
+func (s *S) TestSetEnvHandlerWithRestart(c *C) {
+	server := testing.FakeQueueServer{}
+	err := server.Start("127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer server.Stop()
+	old, err := config.Get("queue-server")
+	if err == nil {
+		defer config.Set("queue-server", old)
+	}
+	config.Set("queue-server", server.Addr())
+	a := app.App{
+		Name:  "restless",
+		Teams: []string{s.team.Name},
+	}
+	err = db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.EnvRevisions().RemoveAll(bson.M{"app": a.Name})
+	url := fmt.Sprintf("/apps/%s/env?:name=%s&restart=true", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("DATABASE_HOST=localhost"))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = SetEnv(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	url = fmt.Sprintf("/apps/%s/env?:name=%s", a.Name, a.Name)
+	request, err = http.NewRequest("DELETE", url, strings.NewReader("DATABASE_HOST"))
+	c.Assert(err, IsNil)
+	recorder = httptest.NewRecorder()
+	err = UnsetEnv(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	time.Sleep(1e6)
+	c.Assert(server.Messages(), DeepEquals, []queue.Message{{Action: app.StartApp, Args: []string{a.Name}}})
+}
+
+func (s *S) TestSetEnvHandlerWithRestartAndRestartPolicy(c *C) {
+	server := testing.FakeQueueServer{}
+	err := server.Start("127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer server.Stop()
+	old, err := config.Get("queue-server")
+	if err == nil {
+		defer config.Set("queue-server", old)
+	}
+	config.Set("queue-server", server.Addr())
+	a := app.App{
+		Name:               "restless",
+		Teams:              []string{s.team.Name},
+		RestartOnEnvChange: true,
+	}
+	err = db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.EnvRevisions().RemoveAll(bson.M{"app": a.Name})
+	url := fmt.Sprintf("/apps/%s/env?:name=%s&restart=true", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("DATABASE_HOST=localhost"))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = SetEnv(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	time.Sleep(1e6)
+	c.Assert(server.Messages(), DeepEquals, []queue.Message{{Action: app.StartApp, Args: []string{a.Name}}})
+}
+
+func (s *S) TestEnvRestartPolicyHandler(c *C) {
+	a := app.App{
+		Name:  "restless",
+		Teams: []string{s.team.Name},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	for _, policy := range []string{"on", "off"} {
+		request, err := http.NewRequest("PUT", "/apps/restless/env/restart-policy?:name=restless", strings.NewReader(policy))
+		c.Assert(err, IsNil)
+		recorder := httptest.NewRecorder()
+		err = EnvRestartPolicyHandler(recorder, request, s.user)
+		c.Assert(err, IsNil)
+		err = a.Get()
+		c.Assert(err, IsNil)
+		c.Assert(a.RestartOnEnvChange, Equals, policy == "on")
+	}
+}
+
+func (s *S) TestHealingHandler(c *C) {
+	a := app.App{
+		Name:  "fragile",
+		Teams: []string{s.team.Name},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	for _, policy := range []string{"off", "on"} {
+		request, err := http.NewRequest("PUT", "/apps/fragile/healing?:name=fragile", strings.NewReader(policy))
+		c.Assert(err, IsNil)
+		recorder := httptest.NewRecorder()
+		err = HealingHandler(recorder, request, s.user)
+		c.Assert(err, IsNil)
+		err = a.Get()
+		c.Assert(err, IsNil)
+		c.Assert(a.DisableHealing, Equals, policy == "off")
+	}
+}
+
+func (s *S) TestHealingHandlerInvalidPolicy(c *C) {
+	request, err := http.NewRequest("PUT", "/apps/fragile/healing?:name=fragile", strings.NewReader("sometimes"))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = HealingHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusBadRequest)
+	c.Assert(e.Message, Equals, `You must provide the healing policy: "on" or "off".`)
+}
+
+func (s *S) TestHealingHandlerWithoutAccess(c *C) {
+	a := app.App{Name: "fragile"}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	request, err := http.NewRequest("PUT", "/apps/fragile/healing?:name=fragile", strings.NewReader("off"))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = HealingHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusForbidden)
+}
+
+func (s *S) TestEnvRestartPolicyHandlerInvalidPolicy(c *C) {
+	request, err := http.NewRequest("PUT", "/apps/restless/env/restart-policy?:name=restless", strings.NewReader("sometimes"))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = EnvRestartPolicyHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusBadRequest)
+	c.Assert(e.Message, Equals, `You must provide the restart policy: "on" or "off".`)
 }
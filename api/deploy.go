@@ -0,0 +1,244 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/globocom/tsuru/api/auth"
+	"github.com/globocom/tsuru/app"
+	"github.com/globocom/tsuru/errors"
+	"github.com/globocom/tsuru/log"
+	"github.com/globocom/tsuru/repository"
+	"io"
+	"net/http"
+)
+
+// updater brings the code of a deploy to the units of the app, in the first
+// phase of the deploy. It returns the commit being deployed, if known.
+type updater struct {
+	phase   string
+	message string
+	run     func(io.Writer) (string, error)
+}
+
+// deployWriter keeps the output of a deploy, so it can be recorded.
+type deployWriter struct {
+	progress
+	output bytes.Buffer
+}
+
+func (w *deployWriter) Write(data []byte) (int, error) {
+	w.output.Write(data)
+	return w.progress.Write(data)
+}
+
+func (w *deployWriter) start(phase, msg string) error {
+	if msg != "" {
+		w.output.WriteString("\n ---> " + msg + "\n")
+	}
+	return w.progress.start(phase, msg)
+}
+
+func (w *deployWriter) phase(name, msg string, fn func() error) error {
+	err := w.start(name, msg)
+	if err != nil {
+		return err
+	}
+	err = fn()
+	if ferr := w.finish(name, err); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// deploy runs the deploy pipeline in the units of the app: update brings the
+// code to the units, then the dependencies of the app are installed, the
+// build and pre-deploy hooks run, the app is restarted and the post-deploy
+// hooks run. A failing hook aborts the deploy. The deploy is recorded, along
+// with its output.
+func deploy(p progress, instance *app.App, user string, update updater) error {
+	w := &deployWriter{progress: p}
+	d := app.NewDeploy(instance, user)
+	err := runDeploy(w, instance, d, update)
+	if err == nil {
+		w.output.WriteString("\n ---> Deploy done!\n\n")
+	}
+	if ferr := d.Finish(w.output.String(), err); ferr != nil {
+		log.Printf("Failed to record the deploy of the app %q: %s.", instance.Name, ferr)
+	}
+	return p.done(err)
+}
+
+func runDeploy(w *deployWriter, instance *app.App, d *app.Deploy, update updater) error {
+	err := w.phase(update.phase, update.message, func() error {
+		var err error
+		d.Commit, err = update.run(w)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	err = w.phase("dependencies", "Installing dependencies", func() error {
+		return instance.InstallDeps(w)
+	})
+	if err != nil {
+		return err
+	}
+	if err = hookPhase(w, instance, "build"); err != nil {
+		return err
+	}
+	if err = hookPhase(w, instance, "pre-deploy"); err != nil {
+		return err
+	}
+	err = w.phase("restart", "", func() error {
+		if err := instance.Restart(w); err != nil {
+			return err
+		}
+		for _, u := range instance.Units {
+			if err := w.unit(u.Name, u.State); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return hookPhase(w, instance, "post-deploy")
+}
+
+// hookPhase runs the hooks declared in app.conf for the given stage in a phase
+// of the deploy. Stages without hooks are skipped.
+func hookPhase(w *deployWriter, instance *app.App, stage string) error {
+	ok, err := instance.HasHooks(stage)
+	if err != nil || !ok {
+		return err
+	}
+	return w.phase(stage, "", func() error {
+		return instance.RunHooks(w, stage)
+	})
+}
+
+func CloneRepositoryHandler(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "text")
+	instance := app.App{Name: r.URL.Query().Get(":name")}
+	err := instance.Get()
+	if err != nil {
+		return &errors.Http{Code: http.StatusNotFound, Message: fmt.Sprintf("App %s not found.", instance.Name)}
+	}
+	// The user is optional, git hooks may send it in the query string.
+	user := r.URL.Query().Get("user")
+	owner := user
+	if owner == "" {
+		owner = "git push"
+	}
+	lock, err := lockApp(&instance, owner, "deploy")
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	p := newProgress(w, r, &instance)
+	err = write(p, []byte("\n ---> Tsuru receiving push\n"))
+	if err != nil {
+		return err
+	}
+	return deploy(p, &instance, user, updater{
+		phase:   "clone",
+		message: "Cloning your code in your machines",
+		run: func(w io.Writer) (string, error) {
+			out, err := repository.CloneOrPull(&instance) // should iterate over the machines
+			if err != nil {
+				return "", &errors.Http{Code: http.StatusInternalServerError, Message: string(out)}
+			}
+			if err = write(w, out); err != nil {
+				return "", err
+			}
+			commit, err := repository.Head(&instance)
+			if err != nil {
+				log.Printf("Failed to get the current commit of the app %q: %s.", instance.Name, err)
+			}
+			return commit, nil
+		},
+	})
+}
+
+func DeployListHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	instance, err := getAppOrError(r.URL.Query().Get(":name"), u)
+	if err != nil {
+		return err
+	}
+	deploys, err := app.ListDeploys(&instance)
+	if err != nil {
+		return err
+	}
+	if len(deploys) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(deploys)
+}
+
+// RollbackHandler moves the code in the units of the app back to the commit
+// of a previous deploy, and runs the deploy pipeline.
+func RollbackHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	w.Header().Set("Content-Type", "text")
+	instance, err := getAppOrError(r.URL.Query().Get(":name"), u)
+	if err != nil {
+		return err
+	}
+	d, err := app.GetDeploy(&instance, r.URL.Query().Get(":deploy"))
+	if err != nil {
+		return &errors.Http{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	if d.Commit == "" {
+		msg := "This deploy did not record a commit, it's not possible to roll back to it."
+		return &errors.Http{Code: http.StatusPreconditionFailed, Message: msg}
+	}
+	lock, err := lockApp(&instance, u.Email, "rollback")
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	return deploy(newProgress(w, r, &instance), &instance, u.Email, updater{
+		phase:   "checkout",
+		message: "Rolling back to " + d.Commit,
+		run: func(w io.Writer) (string, error) {
+			out, err := repository.Checkout(&instance, d.Commit)
+			if err != nil {
+				return "", &errors.Http{Code: http.StatusInternalServerError, Message: string(out)}
+			}
+			return d.Commit, write(w, out)
+		},
+	})
+}
+
+// DeployHandler deploys the code in the gzipped tarball sent in the body of
+// the request, instead of the code in the git repository of the app.
+func DeployHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	w.Header().Set("Content-Type", "text")
+	instance, err := getAppOrError(r.URL.Query().Get(":name"), u)
+	if err != nil {
+		return err
+	}
+	lock, err := lockApp(&instance, u.Email, "deploy")
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	return deploy(newProgress(w, r, &instance), &instance, u.Email, updater{
+		phase:   "upload",
+		message: "Uploading your code to your machines",
+		run: func(w io.Writer) (string, error) {
+			err := instance.ExtractArchive(r.Body, w)
+			if err == app.ErrArchiveNotSupported {
+				return "", &errors.Http{Code: http.StatusPreconditionFailed, Message: err.Error()}
+			}
+			return "", err
+		},
+	})
+}
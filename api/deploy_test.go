@@ -0,0 +1,515 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/globocom/tsuru/app"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/errors"
+	"github.com/globocom/tsuru/provision"
+	"github.com/globocom/tsuru/testing"
+	"labix.org/v2/mgo/bson"
+	. "launchpad.net/gocheck"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+)
+
+func (s *S) TestCloneRepositoryHandlerRecordsTheDeploy(c *C) {
+	commit := "8e0f1ab1fc30b7e7aea8e7f6fc5f1a7d9e4ad3d1"
+	s.provisioner.PrepareOutput([]byte("cloned"))  // clone
+	s.provisioner.PrepareOutput([]byte(commit))    // commit
+	s.provisioner.PrepareOutput(nil)               // install
+	s.provisioner.PrepareOutput([]byte("nothing")) // loadHooks
+	s.provisioner.PrepareOutput(nil)               // restart
+	a := app.App{
+		Name:      "someapp",
+		Framework: "django",
+		Teams:     []string{s.team.Name},
+		State:     string(provision.StatusStarted),
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.Deploys().RemoveAll(bson.M{"app": a.Name})
+	url := fmt.Sprintf("/apps/%s/repository/clone?:name=%s&user=gopher@golang.org", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = CloneRepositoryHandler(recorder, request)
+	c.Assert(err, IsNil)
+	var d app.Deploy
+	err = db.Session.Deploys().Find(bson.M{"app": a.Name}).One(&d)
+	c.Assert(err, IsNil)
+	c.Assert(d.Commit, Equals, commit)
+	c.Assert(d.User, Equals, "gopher@golang.org")
+	c.Assert(d.Success, Equals, true)
+	c.Assert(d.Output, Matches, "(?s).*cloned.*---> Deploy done!.*")
+	c.Assert(d.End.After(d.Start), Equals, true)
+}
+
+func (s *S) TestCloneRepositoryHandlerRecordsFailedDeploys(c *C) {
+	s.provisioner.PrepareOutput([]byte("fatal: could not read from remote repository"))
+	s.provisioner.PrepareFailure("ExecuteCommand", fmt.Errorf("exit status 128"))
+	s.provisioner.PrepareOutput([]byte("fatal: could not read from remote repository"))
+	s.provisioner.PrepareFailure("ExecuteCommand", fmt.Errorf("exit status 128"))
+	a := app.App{
+		Name:      "someapp",
+		Framework: "django",
+		Teams:     []string{s.team.Name},
+		State:     string(provision.StatusStarted),
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.Deploys().RemoveAll(bson.M{"app": a.Name})
+	url := fmt.Sprintf("/apps/%s/repository/clone?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = CloneRepositoryHandler(recorder, request)
+	c.Assert(err, NotNil)
+	var d app.Deploy
+	err = db.Session.Deploys().Find(bson.M{"app": a.Name}).One(&d)
+	c.Assert(err, IsNil)
+	c.Assert(d.Success, Equals, false)
+	c.Assert(d.Error, Equals, "fatal: could not read from remote repository")
+}
+
+func (s *S) TestDeployListHandler(c *C) {
+	a := app.App{Name: "someapp", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	now := time.Now()
+	deploys := []app.Deploy{
+		{Id: bson.NewObjectId(), App: a.Name, Commit: "abc", Start: now.Add(-time.Hour), Output: "old", Success: true},
+		{Id: bson.NewObjectId(), App: a.Name, Commit: "def", Start: now, Output: "new", Success: true},
+	}
+	for _, d := range deploys {
+		err = db.Session.Deploys().Insert(d)
+		c.Assert(err, IsNil)
+	}
+	defer db.Session.Deploys().RemoveAll(bson.M{"app": a.Name})
+	url := fmt.Sprintf("/apps/%s/deploys?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = DeployListHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Header().Get("Content-Type"), Equals, "application/json")
+	var result []app.Deploy
+	err = json.Unmarshal(recorder.Body.Bytes(), &result)
+	c.Assert(err, IsNil)
+	c.Assert(result, HasLen, 2)
+	c.Assert(result[0].Commit, Equals, "def")
+	c.Assert(result[0].Output, Equals, "")
+	c.Assert(result[1].Commit, Equals, "abc")
+}
+
+func (s *S) TestDeployListHandlerWithoutDeploys(c *C) {
+	a := app.App{Name: "someapp", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/deploys?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = DeployListHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Code, Equals, http.StatusNoContent)
+}
+
+func (s *S) TestDeployListHandlerReturns403IfTheUserDoesNotHaveAccessToTheApp(c *C) {
+	a := app.App{Name: "someapp"}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/deploys?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = DeployListHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusForbidden)
+}
+
+func (s *S) TestRollbackHandler(c *C) {
+	commit := "8e0f1ab1fc30b7e7aea8e7f6fc5f1a7d9e4ad3d1"
+	s.provisioner.PrepareOutput(nil)               // checkout
+	s.provisioner.PrepareOutput(nil)               // install
+	s.provisioner.PrepareOutput([]byte("nothing")) // loadHooks
+	s.provisioner.PrepareOutput(nil)               // restart
+	a := app.App{
+		Name:      "someapp",
+		Framework: "django",
+		Teams:     []string{s.team.Name},
+		State:     string(provision.StatusStarted),
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	old := app.Deploy{Id: bson.NewObjectId(), App: a.Name, Commit: commit, Success: true}
+	err = db.Session.Deploys().Insert(old)
+	c.Assert(err, IsNil)
+	defer db.Session.Deploys().RemoveAll(bson.M{"app": a.Name})
+	url := fmt.Sprintf("/apps/%s/rollback/%s?:name=%s&:deploy=%s", a.Name, old.Id.Hex(), a.Name, old.Id.Hex())
+	request, err := http.NewRequest("POST", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = RollbackHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	regexp := `^# ---> Rolling back to ` + commit + `#.*` +
+		`# ---> Installing dependencies#.*` +
+		`# ---> Restarting your app#.*` +
+		`# ---> Deploy done!##$`
+	c.Assert(strings.Replace(recorder.Body.String(), "\n", "#", -1), Matches, regexp)
+	cmds := s.provisioner.GetCmds("cd /home/application/current && git reset --hard "+commit, &a)
+	c.Assert(cmds, HasLen, 1)
+	n, err := db.Session.Deploys().Find(bson.M{"app": a.Name, "commit": commit, "user": s.user.Email}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+}
+
+func (s *S) TestRollbackHandlerUnknownDeploy(c *C) {
+	a := app.App{Name: "someapp", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	id := bson.NewObjectId().Hex()
+	url := fmt.Sprintf("/apps/%s/rollback/%s?:name=%s&:deploy=%s", a.Name, id, a.Name, id)
+	request, err := http.NewRequest("POST", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = RollbackHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusNotFound)
+	c.Assert(e.Message, Equals, "Deploy not found.")
+}
+
+func (s *S) TestRollbackHandlerDeployWithoutCommit(c *C) {
+	a := app.App{Name: "someapp", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	old := app.Deploy{Id: bson.NewObjectId(), App: a.Name}
+	err = db.Session.Deploys().Insert(old)
+	c.Assert(err, IsNil)
+	defer db.Session.Deploys().RemoveAll(bson.M{"app": a.Name})
+	url := fmt.Sprintf("/apps/%s/rollback/%s?:name=%s&:deploy=%s", a.Name, old.Id.Hex(), a.Name, old.Id.Hex())
+	request, err := http.NewRequest("POST", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = RollbackHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusPreconditionFailed)
+}
+
+func (s *S) TestRollbackHandlerReturns404IfTheAppDoesNotExist(c *C) {
+	request, err := http.NewRequest("POST", "/apps/unknown/rollback/abc?:name=unknown&:deploy=abc", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = RollbackHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusNotFound)
+	c.Assert(e.Message, Equals, "App unknown not found.")
+}
+
+func (s *S) TestDeployHandler(c *C) {
+	p := testing.NewExtensibleFakeProvisioner()
+	provision.Register("extensible", p)
+	p.PrepareOutput([]byte("extracted")) // extract
+	p.PrepareOutput(nil)                 // install
+	p.PrepareOutput([]byte("nothing"))   // loadHooks
+	a := app.App{
+		Name:        "someapp",
+		Framework:   "django",
+		Provisioner: "extensible",
+		Teams:       []string{s.team.Name},
+		State:       string(provision.StatusStarted),
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.Deploys().RemoveAll(bson.M{"app": a.Name})
+	err = p.Provision(&a)
+	c.Assert(err, IsNil)
+	defer p.Destroy(&a)
+	url := fmt.Sprintf("/apps/%s/deploy?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("archive"))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = DeployHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	regexp := `^# ---> Uploading your code to your machines#extracted#.*` +
+		`# ---> Installing dependencies#.*` +
+		`# ---> Restarting your app#.*` +
+		`# ---> Deploy done!##$`
+	c.Assert(strings.Replace(recorder.Body.String(), "\n", "#", -1), Matches, regexp)
+	c.Assert(string(p.Uploaded(&a, "/home/application/archive.tar.gz")), Equals, "archive")
+	c.Assert(p.Restarts(&a), Equals, 1)
+	var d app.Deploy
+	err = db.Session.Deploys().Find(bson.M{"app": a.Name}).One(&d)
+	c.Assert(err, IsNil)
+	c.Assert(d.User, Equals, s.user.Email)
+	c.Assert(d.Commit, Equals, "")
+	c.Assert(d.Success, Equals, true)
+}
+
+func (s *S) TestDeployHandlerProvisionerIsNotAnUploader(c *C) {
+	a := app.App{
+		Name:      "someapp",
+		Framework: "django",
+		Teams:     []string{s.team.Name},
+		State:     string(provision.StatusStarted),
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.Deploys().RemoveAll(bson.M{"app": a.Name})
+	url := fmt.Sprintf("/apps/%s/deploy?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("archive"))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = DeployHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusPreconditionFailed)
+	c.Assert(e.Message, Equals, app.ErrArchiveNotSupported.Error())
+}
+
+func (s *S) TestDeployHandlerReturns403IfTheUserDoesNotHaveAccessToTheApp(c *C) {
+	a := app.App{Name: "someapp"}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/deploy?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("archive"))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = DeployHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusForbidden)
+}
+
+func (s *S) TestDeployHandlerJSONProgress(c *C) {
+	p := testing.NewExtensibleFakeProvisioner()
+	provision.Register("extensible", p)
+	p.PrepareOutput([]byte("extracted"))
+	p.PrepareOutput(nil)               // install
+	p.PrepareOutput([]byte("nothing")) // loadHooks
+	a := app.App{
+		Name:        "someapp",
+		Framework:   "django",
+		Provisioner: "extensible",
+		Teams:       []string{s.team.Name},
+		State:       string(provision.StatusStarted),
+		Units:       []app.Unit{{Name: "someapp/0", State: string(provision.StatusStarted)}},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.Deploys().RemoveAll(bson.M{"app": a.Name})
+	err = p.Provision(&a)
+	c.Assert(err, IsNil)
+	defer p.Destroy(&a)
+	url := fmt.Sprintf("/apps/%s/deploy?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("archive"))
+	c.Assert(err, IsNil)
+	request.Header.Set("Accept", "application/x-json-stream")
+	recorder := httptest.NewRecorder()
+	err = DeployHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Header().Get("Content-Type"), Equals, "application/x-json-stream")
+	events := decodeEvents(c, recorder.Body.Bytes())
+	var types []string
+	for _, ev := range events {
+		if ev.Type != "output" {
+			types = append(types, ev.Type+" "+ev.Phase)
+		}
+	}
+	expected := []string{
+		"phase-start upload", "phase-end upload",
+		"phase-start dependencies", "phase-end dependencies",
+		"phase-start restart", "unit restart", "phase-end restart",
+		"status ",
+	}
+	c.Assert(types, DeepEquals, expected)
+	c.Assert(events[1], DeepEquals, deployEvent{Type: "output", Phase: "upload", Output: "extracted"})
+	c.Assert(events[len(events)-1].Status, Equals, "success")
+	var d app.Deploy
+	err = db.Session.Deploys().Find(bson.M{"app": a.Name}).One(&d)
+	c.Assert(err, IsNil)
+	c.Assert(d.Output, Matches, "(?s)^\n ---> Uploading your code to your machines\nextracted.*---> Deploy done!\n\n$")
+}
+
+func (s *S) TestDeployHandlerJSONProgressFailure(c *C) {
+	a := app.App{
+		Name:      "someapp",
+		Framework: "django",
+		Teams:     []string{s.team.Name},
+		State:     string(provision.StatusStarted),
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.Deploys().RemoveAll(bson.M{"app": a.Name})
+	url := fmt.Sprintf("/apps/%s/deploy?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("archive"))
+	c.Assert(err, IsNil)
+	request.Header.Set("Accept", "application/x-json-stream")
+	recorder := httptest.NewRecorder()
+	err = DeployHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	expected := []deployEvent{
+		{Type: "phase-start", Phase: "upload", Message: "Uploading your code to your machines"},
+		{Type: "phase-end", Phase: "upload", Status: "failure"},
+		{Type: "error", Phase: "upload", Error: app.ErrArchiveNotSupported.Error()},
+		{Type: "status", Status: "failure"},
+	}
+	c.Assert(decodeEvents(c, recorder.Body.Bytes()), DeepEquals, expected)
+}
+
+func (s *S) TestCloneRepositoryHandlerReturns409IfTheAppIsLocked(c *C) {
+	a := app.App{Name: "someapp", Framework: "django", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	lock, err := app.AcquireLock(a.Name, "gopher@golang.org", "deploy")
+	c.Assert(err, IsNil)
+	defer lock.Release()
+	url := fmt.Sprintf("/apps/%s/repository/clone?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = CloneRepositoryHandler(recorder, request)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusConflict)
+	c.Assert(e.Message, Matches, `^App "someapp" is locked by gopher@golang.org since .* \(deploy\)\.$`)
+	c.Assert(recorder.Body.String(), Equals, "")
+}
+
+func (s *S) TestCloneRepositoryHandlerReleasesTheLock(c *C) {
+	s.provisioner.PrepareOutput([]byte("cloned"))  // clone
+	s.provisioner.PrepareOutput(nil)               // commit
+	s.provisioner.PrepareOutput(nil)               // install
+	s.provisioner.PrepareOutput([]byte("nothing")) // loadHooks
+	s.provisioner.PrepareOutput(nil)               // restart
+	a := app.App{
+		Name:      "someapp",
+		Framework: "django",
+		Teams:     []string{s.team.Name},
+		State:     string(provision.StatusStarted),
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.Deploys().RemoveAll(bson.M{"app": a.Name})
+	url := fmt.Sprintf("/apps/%s/repository/clone?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = CloneRepositoryHandler(recorder, request)
+	c.Assert(err, IsNil)
+	_, err = app.GetLock(a.Name)
+	c.Assert(err, NotNil)
+}
+
+func (s *S) TestDeployHandlerReturns409IfTheAppIsLocked(c *C) {
+	a := app.App{Name: "someapp", Framework: "django", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	lock, err := app.AcquireLock(a.Name, "gopher@golang.org", "add units")
+	c.Assert(err, IsNil)
+	defer lock.Release()
+	url := fmt.Sprintf("/apps/%s/deploy?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("archive"))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = DeployHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusConflict)
+}
+
+func (s *S) TestCloneRepositoryHandlerRunsTheDeployHooks(c *C) {
+	conf := "build:\n  - make\npost-deploy:\n  - notify.sh\n"
+	s.provisioner.PrepareOutput([]byte("cloned"))   // clone
+	s.provisioner.PrepareOutput(nil)                // commit
+	s.provisioner.PrepareOutput(nil)                // install
+	s.provisioner.PrepareOutput([]byte(conf))       // loadHooks
+	s.provisioner.PrepareOutput([]byte("built"))    // build
+	s.provisioner.PrepareOutput(nil)                // restart
+	s.provisioner.PrepareOutput([]byte("notified")) // post-deploy
+	a := app.App{
+		Name:      "someapp",
+		Framework: "django",
+		Teams:     []string{s.team.Name},
+		State:     string(provision.StatusStarted),
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.Deploys().RemoveAll(bson.M{"app": a.Name})
+	url := fmt.Sprintf("/apps/%s/repository/clone?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = CloneRepositoryHandler(recorder, request)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Body.String(), Matches, "(?s).* ---> Running build\nbuilt.* ---> Running post-deploy\nnotified.*")
+}
+
+func (s *S) TestCloneRepositoryHandlerAbortsWhenAHookFails(c *C) {
+	conf := "pre-deploy:\n  - migrate.sh\n"
+	s.provisioner.PrepareOutput([]byte("cloned"))   // clone
+	s.provisioner.PrepareOutput(nil)                // commit
+	s.provisioner.PrepareOutput(nil)                // install
+	s.provisioner.PrepareOutput([]byte(conf))       // loadHooks
+	s.provisioner.PrepareOutput([]byte("no table")) // pre-deploy
+	s.provisioner.PrepareFailure("ExecuteCommand", fmt.Errorf("exit status 1"))
+	a := app.App{
+		Name:      "someapp",
+		Framework: "django",
+		Teams:     []string{s.team.Name},
+		State:     string(provision.StatusStarted),
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.Deploys().RemoveAll(bson.M{"app": a.Name})
+	url := fmt.Sprintf("/apps/%s/repository/clone?:name=%s", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = CloneRepositoryHandler(recorder, request)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, `pre-deploy hook "migrate.sh" failed: exit status 1`)
+	cmds := s.provisioner.GetCmds("/var/lib/tsuru/hooks/restart", &a)
+	c.Assert(cmds, HasLen, 0)
+	var d app.Deploy
+	err = db.Session.Deploys().Find(bson.M{"app": a.Name}).One(&d)
+	c.Assert(err, IsNil)
+	c.Assert(d.Success, Equals, false)
+}
@@ -0,0 +1,89 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"github.com/globocom/tsuru/api/auth"
+	"github.com/globocom/tsuru/app"
+	"github.com/globocom/tsuru/errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// drainUrl reads the URL of a drain from the body of the request.
+func drainUrl(r *http.Request) (string, error) {
+	msg := "You must provide the URL of the drain."
+	if r.Body == nil {
+		return "", &errors.Http{Code: http.StatusBadRequest, Message: msg}
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return "", err
+	}
+	url := strings.TrimSpace(string(body))
+	if url == "" {
+		return "", &errors.Http{Code: http.StatusBadRequest, Message: msg}
+	}
+	return url, nil
+}
+
+// AddDrainHandler adds a drain to the app. The body must be the URL of the
+// drain.
+func AddDrainHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	url, err := drainUrl(r)
+	if err != nil {
+		return err
+	}
+	instance, err := getAppOrError(r.URL.Query().Get(":name"), u)
+	if err != nil {
+		return err
+	}
+	err = instance.AddDrain(url)
+	if e, ok := err.(*app.ValidationError); ok {
+		return &errors.Http{Code: http.StatusPreconditionFailed, Message: e.Message}
+	}
+	if err == app.ErrDrainExists {
+		return &errors.Http{Code: http.StatusConflict, Message: err.Error()}
+	}
+	return err
+}
+
+// ListDrainsHandler lists the drains of the app.
+func ListDrainsHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	instance, err := getAppOrError(r.URL.Query().Get(":name"), u)
+	if err != nil {
+		return err
+	}
+	drains, err := instance.Drains()
+	if err != nil {
+		return err
+	}
+	if len(drains) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(drains)
+}
+
+// RemoveDrainHandler removes a drain from the app. The body must be the URL
+// of the drain.
+func RemoveDrainHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	url, err := drainUrl(r)
+	if err != nil {
+		return err
+	}
+	instance, err := getAppOrError(r.URL.Query().Get(":name"), u)
+	if err != nil {
+		return err
+	}
+	err = instance.RemoveDrain(url)
+	if err == app.ErrDrainNotFound {
+		return &errors.Http{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	return err
+}
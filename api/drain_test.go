@@ -0,0 +1,166 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"github.com/globocom/tsuru/app"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/errors"
+	"labix.org/v2/mgo/bson"
+	. "launchpad.net/gocheck"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+func (s *S) TestAddDrainHandler(c *C) {
+	a := app.App{Name: "drained", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	body := strings.NewReader("syslog://logs.example.com:514\n")
+	request, err := http.NewRequest("POST", "/apps/drained/drains?:name=drained", body)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AddDrainHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	drains, err := a.Drains()
+	c.Assert(err, IsNil)
+	c.Assert(drains, DeepEquals, []app.Drain{{AppName: "drained", Url: "syslog://logs.example.com:514"}})
+}
+
+func (s *S) TestAddDrainHandlerWithoutUrl(c *C) {
+	a := app.App{Name: "drained", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	request, err := http.NewRequest("POST", "/apps/drained/drains?:name=drained", strings.NewReader(""))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AddDrainHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusBadRequest)
+	c.Assert(e.Message, Equals, "You must provide the URL of the drain.")
+}
+
+func (s *S) TestAddDrainHandlerInvalidUrl(c *C) {
+	a := app.App{Name: "drained", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	body := strings.NewReader("ftp://logs.example.com")
+	request, err := http.NewRequest("POST", "/apps/drained/drains?:name=drained", body)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AddDrainHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusPreconditionFailed)
+}
+
+func (s *S) TestAddDrainHandlerTwice(c *C) {
+	a := app.App{Name: "drained", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	err = a.AddDrain("syslog://logs.example.com:514")
+	c.Assert(err, IsNil)
+	body := strings.NewReader("syslog://logs.example.com:514")
+	request, err := http.NewRequest("POST", "/apps/drained/drains?:name=drained", body)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AddDrainHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusConflict)
+}
+
+func (s *S) TestAddDrainHandlerAppWithoutAccess(c *C) {
+	a := app.App{Name: "drained"}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	body := strings.NewReader("syslog://logs.example.com:514")
+	request, err := http.NewRequest("POST", "/apps/drained/drains?:name=drained", body)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = AddDrainHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusForbidden)
+}
+
+func (s *S) TestListDrainsHandler(c *C) {
+	a := app.App{Name: "drained", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	err = a.AddDrain("syslog://logs.example.com:514")
+	c.Assert(err, IsNil)
+	request, err := http.NewRequest("GET", "/apps/drained/drains?:name=drained", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = ListDrainsHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Header().Get("Content-Type"), Equals, "application/json")
+	var drains []app.Drain
+	err = json.Unmarshal(recorder.Body.Bytes(), &drains)
+	c.Assert(err, IsNil)
+	c.Assert(drains, DeepEquals, []app.Drain{{AppName: "drained", Url: "syslog://logs.example.com:514"}})
+}
+
+func (s *S) TestListDrainsHandlerWithoutDrains(c *C) {
+	a := app.App{Name: "drained", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	request, err := http.NewRequest("GET", "/apps/drained/drains?:name=drained", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = ListDrainsHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Code, Equals, http.StatusNoContent)
+}
+
+func (s *S) TestRemoveDrainHandler(c *C) {
+	a := app.App{Name: "drained", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	err = a.AddDrain("syslog://logs.example.com:514")
+	c.Assert(err, IsNil)
+	body := strings.NewReader("syslog://logs.example.com:514")
+	request, err := http.NewRequest("DELETE", "/apps/drained/drains?:name=drained", body)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = RemoveDrainHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	drains, err := a.Drains()
+	c.Assert(err, IsNil)
+	c.Assert(drains, HasLen, 0)
+}
+
+func (s *S) TestRemoveDrainHandlerNotFound(c *C) {
+	a := app.App{Name: "drained", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	body := strings.NewReader("syslog://logs.example.com:514")
+	request, err := http.NewRequest("DELETE", "/apps/drained/drains?:name=drained", body)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = RemoveDrainHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusNotFound)
+	c.Assert(e.Message, Equals, "Drain not found.")
+}
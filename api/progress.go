@@ -0,0 +1,155 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"github.com/globocom/tsuru/app"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// jsonStream is the media type of the JSON deploy progress protocol. Clients
+// select it using the Accept header.
+const jsonStream = "application/x-json-stream"
+
+// progress reports the progress of a deploy to the client. The output of the
+// deploy is written to it, and the deploy is split in phases.
+type progress interface {
+	io.Writer
+
+	// start reports that a phase started. msg is a human readable
+	// description of the phase, and may be empty.
+	start(phase, msg string) error
+
+	// finish reports that a phase finished. A nil err means success.
+	finish(phase string, err error) error
+
+	// unit reports the status of a unit of the app.
+	unit(name, status string) error
+
+	// done reports the final status of the deploy. It returns the error
+	// that the handler should return.
+	done(err error) error
+}
+
+// newProgress returns the progress that should be used for the request: the
+// JSON progress protocol if the client accepts it, or plain text otherwise.
+// The output of the deploy is also written to the log of the app.
+func newProgress(w http.ResponseWriter, r *http.Request, instance *app.App) progress {
+	if strings.Contains(r.Header.Get("Accept"), jsonStream) {
+		w.Header().Set("Content-Type", jsonStream)
+		return &jsonProgress{
+			encoder: json.NewEncoder(w),
+			log:     &LogWriter{instance, ioutil.Discard},
+		}
+	}
+	return &textProgress{&LogWriter{instance, w}}
+}
+
+// textProgress writes the output of the deploy as it is, with a banner for
+// each phase.
+type textProgress struct {
+	io.Writer
+}
+
+func (p *textProgress) start(phase, msg string) error {
+	if msg == "" {
+		return nil
+	}
+	return write(p, []byte("\n ---> "+msg+"\n"))
+}
+
+func (p *textProgress) finish(phase string, err error) error {
+	return nil
+}
+
+func (p *textProgress) unit(name, status string) error {
+	return nil
+}
+
+func (p *textProgress) done(err error) error {
+	if err != nil {
+		return err
+	}
+	return write(p, []byte("\n ---> Deploy done!\n\n"))
+}
+
+// deployEvent is an event of the JSON deploy progress protocol. Events are
+// sent one per line.
+//
+// The type of the event is one of:
+//
+//   * phase-start: a phase started, Message describes it;
+//   * phase-end: a phase finished, Status is either "success" or "failure";
+//   * unit: the status of a unit of the app, in Unit and Status;
+//   * output: a chunk of output of the current phase;
+//   * error: the deploy failed in the given phase;
+//   * status: the final status of the deploy, either "success" or "failure".
+type deployEvent struct {
+	Type    string `json:"type"`
+	Phase   string `json:"phase,omitempty"`
+	Message string `json:"message,omitempty"`
+	Unit    string `json:"unit,omitempty"`
+	Status  string `json:"status,omitempty"`
+	Output  string `json:"output,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type jsonProgress struct {
+	encoder *json.Encoder
+	log     io.Writer
+	phase   string
+	failed  string
+}
+
+func (p *jsonProgress) Write(data []byte) (int, error) {
+	if _, err := p.log.Write(data); err != nil {
+		return 0, err
+	}
+	err := p.encoder.Encode(deployEvent{Type: "output", Phase: p.phase, Output: string(data)})
+	if err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (p *jsonProgress) start(phase, msg string) error {
+	p.phase = phase
+	if msg != "" {
+		p.log.Write([]byte("\n ---> " + msg + "\n"))
+	}
+	return p.encoder.Encode(deployEvent{Type: "phase-start", Phase: phase, Message: msg})
+}
+
+func (p *jsonProgress) finish(phase string, err error) error {
+	p.phase = ""
+	status := "success"
+	if err != nil {
+		status = "failure"
+		p.failed = phase
+	}
+	return p.encoder.Encode(deployEvent{Type: "phase-end", Phase: phase, Status: status})
+}
+
+func (p *jsonProgress) unit(name, status string) error {
+	return p.encoder.Encode(deployEvent{Type: "unit", Phase: p.phase, Unit: name, Status: status})
+}
+
+// done reports the final status of the deploy. Errors are part of the
+// stream, so done returns nil unless it fails to write the events.
+func (p *jsonProgress) done(err error) error {
+	status := "success"
+	if err != nil {
+		status = "failure"
+		ev := deployEvent{Type: "error", Phase: p.failed, Error: err.Error()}
+		if werr := p.encoder.Encode(ev); werr != nil {
+			return werr
+		}
+	}
+	return p.encoder.Encode(deployEvent{Type: "status", Status: status})
+}
@@ -0,0 +1,112 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	stderrors "errors"
+	"github.com/globocom/tsuru/app"
+	"io"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"net/http"
+	"net/http/httptest"
+)
+
+func decodeEvents(c *C, data []byte) []deployEvent {
+	var events []deployEvent
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var ev deployEvent
+		err := decoder.Decode(&ev)
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, IsNil)
+		events = append(events, ev)
+	}
+	return events
+}
+
+func (s *S) TestNewProgressText(c *C) {
+	request, err := http.NewRequest("POST", "/apps/someapp/deploy", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	p := newProgress(recorder, request, &app.App{Name: "someapp"})
+	_, ok := p.(*textProgress)
+	c.Assert(ok, Equals, true)
+}
+
+func (s *S) TestNewProgressJSON(c *C) {
+	request, err := http.NewRequest("POST", "/apps/someapp/deploy", nil)
+	c.Assert(err, IsNil)
+	request.Header.Set("Accept", "application/x-json-stream")
+	recorder := httptest.NewRecorder()
+	p := newProgress(recorder, request, &app.App{Name: "someapp"})
+	_, ok := p.(*jsonProgress)
+	c.Assert(ok, Equals, true)
+	c.Assert(recorder.Header().Get("Content-Type"), Equals, "application/x-json-stream")
+}
+
+func (s *S) TestTextProgress(c *C) {
+	var buf bytes.Buffer
+	p := textProgress{&buf}
+	c.Assert(p.start("dependencies", "Installing dependencies"), IsNil)
+	p.Write([]byte("installed"))
+	c.Assert(p.finish("dependencies", nil), IsNil)
+	c.Assert(p.start("restart", ""), IsNil)
+	c.Assert(p.unit("someapp/0", "started"), IsNil)
+	c.Assert(p.done(nil), IsNil)
+	c.Assert(buf.String(), Equals, "\n ---> Installing dependencies\ninstalled\n ---> Deploy done!\n\n")
+}
+
+func (s *S) TestTextProgressDoneWithError(c *C) {
+	var buf bytes.Buffer
+	p := textProgress{&buf}
+	err := stderrors.New("exit status 1")
+	c.Assert(p.done(err), Equals, err)
+	c.Assert(buf.String(), Equals, "")
+}
+
+func (s *S) TestJSONProgress(c *C) {
+	var buf bytes.Buffer
+	p := jsonProgress{encoder: json.NewEncoder(&buf), log: ioutil.Discard}
+	c.Assert(p.start("dependencies", "Installing dependencies"), IsNil)
+	n, err := p.Write([]byte("installed"))
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, len("installed"))
+	c.Assert(p.finish("dependencies", nil), IsNil)
+	c.Assert(p.start("restart", ""), IsNil)
+	c.Assert(p.unit("someapp/0", "started"), IsNil)
+	c.Assert(p.finish("restart", nil), IsNil)
+	c.Assert(p.done(nil), IsNil)
+	expected := []deployEvent{
+		{Type: "phase-start", Phase: "dependencies", Message: "Installing dependencies"},
+		{Type: "output", Phase: "dependencies", Output: "installed"},
+		{Type: "phase-end", Phase: "dependencies", Status: "success"},
+		{Type: "phase-start", Phase: "restart"},
+		{Type: "unit", Phase: "restart", Unit: "someapp/0", Status: "started"},
+		{Type: "phase-end", Phase: "restart", Status: "success"},
+		{Type: "status", Status: "success"},
+	}
+	c.Assert(decodeEvents(c, buf.Bytes()), DeepEquals, expected)
+}
+
+func (s *S) TestJSONProgressFailure(c *C) {
+	var buf bytes.Buffer
+	p := jsonProgress{encoder: json.NewEncoder(&buf), log: ioutil.Discard}
+	c.Assert(p.start("dependencies", "Installing dependencies"), IsNil)
+	err := stderrors.New("exit status 1")
+	c.Assert(p.finish("dependencies", err), IsNil)
+	c.Assert(p.done(err), IsNil)
+	expected := []deployEvent{
+		{Type: "phase-start", Phase: "dependencies", Message: "Installing dependencies"},
+		{Type: "phase-end", Phase: "dependencies", Status: "failure"},
+		{Type: "error", Phase: "dependencies", Error: "exit status 1"},
+		{Type: "status", Status: "failure"},
+	}
+	c.Assert(decodeEvents(c, buf.Bytes()), DeepEquals, expected)
+}
@@ -0,0 +1,71 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"github.com/globocom/tsuru/api/auth"
+	"github.com/globocom/tsuru/app"
+	"github.com/globocom/tsuru/errors"
+	"io/ioutil"
+	"net/http"
+)
+
+// ListRetentionHandler lists the retention policies of the logs of the app,
+// by source.
+func ListRetentionHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	instance, err := getAppOrError(r.URL.Query().Get(":name"), u)
+	if err != nil {
+		return err
+	}
+	policies, err := instance.RetentionPolicies()
+	if err != nil {
+		return err
+	}
+	if len(policies) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(policies)
+}
+
+// SetRetentionHandler sets the retention policy of a source in the logs of
+// the app. The body must be a JSON object, with the MaxAge (in days) and
+// MaxCount fields.
+func SetRetentionHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	var policy app.RetentionPolicy
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(body, &policy); err != nil {
+		return &errors.Http{Code: http.StatusBadRequest, Message: "Invalid retention policy."}
+	}
+	instance, err := getAppOrError(r.URL.Query().Get(":name"), u)
+	if err != nil {
+		return err
+	}
+	err = instance.SetRetentionPolicy(r.URL.Query().Get(":source"), policy)
+	if e, ok := err.(*app.ValidationError); ok {
+		return &errors.Http{Code: http.StatusPreconditionFailed, Message: e.Message}
+	}
+	return err
+}
+
+// UnsetRetentionHandler removes the retention policy of a source set for the
+// app, which goes back to the one defined in tsuru.conf.
+func UnsetRetentionHandler(w http.ResponseWriter, r *http.Request, u *auth.User) error {
+	instance, err := getAppOrError(r.URL.Query().Get(":name"), u)
+	if err != nil {
+		return err
+	}
+	err = instance.UnsetRetentionPolicy(r.URL.Query().Get(":source"))
+	if err == app.ErrRetentionPolicyNotFound {
+		return &errors.Http{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	return err
+}
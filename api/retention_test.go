@@ -0,0 +1,153 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"github.com/globocom/tsuru/app"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/errors"
+	"labix.org/v2/mgo/bson"
+	. "launchpad.net/gocheck"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+func (s *S) TestListRetentionHandler(c *C) {
+	a := app.App{
+		Name:         "retained",
+		Teams:        []string{s.team.Name},
+		LogRetention: map[string]app.RetentionPolicy{"tsuru": {MaxAge: 365}},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	err = a.Log("listening", "app")
+	c.Assert(err, IsNil)
+	request, err := http.NewRequest("GET", "/apps/retained/log/retention?:name=retained", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = ListRetentionHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Header().Get("Content-Type"), Equals, "application/json")
+	var policies []app.SourceRetentionPolicy
+	err = json.Unmarshal(recorder.Body.Bytes(), &policies)
+	c.Assert(err, IsNil)
+	expected := []app.SourceRetentionPolicy{
+		{Source: "app", RetentionPolicy: app.RetentionPolicy{MaxAge: 30}},
+		{Source: "tsuru", RetentionPolicy: app.RetentionPolicy{MaxAge: 365}, Custom: true},
+	}
+	c.Assert(policies, DeepEquals, expected)
+}
+
+func (s *S) TestListRetentionHandlerWithoutLogs(c *C) {
+	a := app.App{Name: "retained", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	request, err := http.NewRequest("GET", "/apps/retained/log/retention?:name=retained", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = ListRetentionHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.Code, Equals, http.StatusNoContent)
+}
+
+func (s *S) TestSetRetentionHandler(c *C) {
+	a := app.App{Name: "retained", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	body := strings.NewReader(`{"MaxAge":7,"MaxCount":1000}`)
+	request, err := http.NewRequest("PUT", "/apps/retained/log/retention/app?:name=retained&:source=app", body)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = SetRetentionHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.LogRetention, DeepEquals, map[string]app.RetentionPolicy{"app": {MaxAge: 7, MaxCount: 1000}})
+}
+
+func (s *S) TestSetRetentionHandlerInvalidBody(c *C) {
+	a := app.App{Name: "retained", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	request, err := http.NewRequest("PUT", "/apps/retained/log/retention/app?:name=retained&:source=app", strings.NewReader("7"))
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = SetRetentionHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusBadRequest)
+}
+
+func (s *S) TestSetRetentionHandlerNegativeValues(c *C) {
+	a := app.App{Name: "retained", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	body := strings.NewReader(`{"MaxAge":-1}`)
+	request, err := http.NewRequest("PUT", "/apps/retained/log/retention/app?:name=retained&:source=app", body)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = SetRetentionHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusPreconditionFailed)
+}
+
+func (s *S) TestUnsetRetentionHandler(c *C) {
+	a := app.App{
+		Name:         "retained",
+		Teams:        []string{s.team.Name},
+		LogRetention: map[string]app.RetentionPolicy{"app": {MaxAge: 7}},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	request, err := http.NewRequest("DELETE", "/apps/retained/log/retention/app?:name=retained&:source=app", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = UnsetRetentionHandler(recorder, request, s.user)
+	c.Assert(err, IsNil)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.LogRetention, HasLen, 0)
+}
+
+func (s *S) TestUnsetRetentionHandlerNotFound(c *C) {
+	a := app.App{Name: "retained", Teams: []string{s.team.Name}}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	request, err := http.NewRequest("DELETE", "/apps/retained/log/retention/app?:name=retained&:source=app", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = UnsetRetentionHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusNotFound)
+}
+
+func (s *S) TestRetentionHandlersRequireAccess(c *C) {
+	a := app.App{Name: "retained"}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	request, err := http.NewRequest("DELETE", "/apps/retained/log/retention/app?:name=retained&:source=app", nil)
+	c.Assert(err, IsNil)
+	recorder := httptest.NewRecorder()
+	err = UnsetRetentionHandler(recorder, request, s.user)
+	c.Assert(err, NotNil)
+	e, ok := err.(*errors.Http)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, http.StatusForbidden)
+}
@@ -18,8 +18,8 @@ import (
 	"github.com/globocom/tsuru/log"
 	"github.com/globocom/tsuru/provision"
 	_ "github.com/globocom/tsuru/provision/juju"
+	_ "github.com/globocom/tsuru/provision/local"
 	stdlog "log"
-	"log/syslog"
 	"net/http"
 	"os"
 )
@@ -30,11 +30,11 @@ func fatal(err error) {
 }
 
 func main() {
-	logger, err := syslog.NewLogger(syslog.LOG_INFO, stdlog.LstdFlags)
+	writer, err := log.NewSyslogWriter("", log.TextFormat)
 	if err != nil {
 		stdlog.Fatal(err)
 	}
-	log.SetLogger(logger)
+	log.SetWriters(writer)
 	configFile := flag.String("config", "/etc/tsuru/tsuru.conf", "tsuru config file")
 	dry := flag.Bool("dry", false, "dry-run: does not start the server (for testing purpose)")
 	flag.Parse()
@@ -42,6 +42,9 @@ func main() {
 	if err != nil {
 		fatal(err)
 	}
+	if err = log.ReadConfig(); err != nil {
+		fatal(err)
+	}
 	connString, err := config.GetString("database:url")
 	if err != nil {
 		fatal(err)
@@ -57,6 +60,9 @@ func main() {
 	defer db.Session.Close()
 	fmt.Printf("Connected to MongoDB server at %s.\n", connString)
 	fmt.Printf("Using the database %q.\n\n", dbName)
+	if err = app.MigrateLogs(os.Stdout); err != nil {
+		fmt.Printf("Warning: %s\n", err)
+	}
 
 	m := pat.New()
 
@@ -80,19 +86,38 @@ func main() {
 
 	m.Del("/apps/:name", AuthorizationRequiredHandler(api.AppDelete))
 	m.Get("/apps/:name/repository/clone", Handler(api.CloneRepositoryHandler))
+	m.Get("/apps/:name/deploys", AuthorizationRequiredHandler(api.DeployListHandler))
+	m.Post("/apps/:name/rollback/:deploy", AuthorizationRequiredHandler(api.RollbackHandler))
+	m.Post("/apps/:name/deploy", AuthorizationRequiredHandler(api.DeployHandler))
+	m.Del("/apps/:name/lock", AuthorizationRequiredHandler(api.UnlockHandler))
 	m.Get("/apps/:name/avaliable", Handler(api.AppIsAvaliableHandler))
 	m.Get("/apps/:name", AuthorizationRequiredHandler(api.AppInfo))
 	m.Post("/apps/:name/run", AuthorizationRequiredHandler(api.RunCommand))
 	m.Get("/apps/:name/restart", AuthorizationRequiredHandler(api.RestartHandler))
+	m.Post("/apps/:name/stop", AuthorizationRequiredHandler(api.StopHandler))
+	m.Post("/apps/:name/start", AuthorizationRequiredHandler(api.StartHandler))
+	m.Get("/apps/:name/env/dotenv", AuthorizationRequiredHandler(api.ExportEnvHandler))
+	m.Post("/apps/:name/env/dotenv", AuthorizationRequiredHandler(api.ImportEnvHandler))
+	m.Get("/apps/:name/env/history", AuthorizationRequiredHandler(api.EnvHistoryHandler))
+	m.Post("/apps/:name/env/rollback/:revision", AuthorizationRequiredHandler(api.EnvRollbackHandler))
+	m.Put("/apps/:name/env/restart-policy", AuthorizationRequiredHandler(api.EnvRestartPolicyHandler))
+	m.Put("/apps/:name/healing", AuthorizationRequiredHandler(api.HealingHandler))
 	m.Get("/apps/:name/env", AuthorizationRequiredHandler(api.GetEnv))
 	m.Post("/apps/:name/env", AuthorizationRequiredHandler(api.SetEnv))
 	m.Del("/apps/:name/env", AuthorizationRequiredHandler(api.UnsetEnv))
+	m.Post("/envs/reencrypt", AuthorizationRequiredHandler(api.ReencryptEnvsHandler))
+	m.Get("/apps/:name/drains", AuthorizationRequiredHandler(api.ListDrainsHandler))
+	m.Post("/apps/:name/drains", AuthorizationRequiredHandler(api.AddDrainHandler))
+	m.Del("/apps/:name/drains", AuthorizationRequiredHandler(api.RemoveDrainHandler))
 	m.Get("/apps", AuthorizationRequiredHandler(api.AppList))
 	m.Post("/apps", AuthorizationRequiredHandler(api.CreateAppHandler))
 	m.Put("/apps/:name/units", AuthorizationRequiredHandler(api.AddUnitsHandler))
 	m.Del("/apps/:name/units", AuthorizationRequiredHandler(api.RemoveUnitsHandler))
 	m.Put("/apps/:app/:team", AuthorizationRequiredHandler(api.GrantAccessToTeamHandler))
 	m.Del("/apps/:app/:team", AuthorizationRequiredHandler(api.RevokeAccessFromTeamHandler))
+	m.Get("/apps/:name/log/retention", AuthorizationRequiredHandler(api.ListRetentionHandler))
+	m.Put("/apps/:name/log/retention/:source", AuthorizationRequiredHandler(api.SetRetentionHandler))
+	m.Del("/apps/:name/log/retention/:source", AuthorizationRequiredHandler(api.UnsetRetentionHandler))
 	m.Get("/apps/:name/log", AuthorizationRequiredHandler(api.AppLog))
 	m.Post("/apps/:name/log", Handler(api.AddLogHandler))
 
@@ -110,16 +135,14 @@ func main() {
 	m.Del("/teams/:team/:user", AuthorizationRequiredHandler(auth.RemoveUserFromTeam))
 
 	if !*dry {
-		provisioner, err := config.GetString("provisioner")
-		if err != nil {
-			fmt.Printf("Warning: %q didn't declare a provisioner, using default provisioner.\n", configFile)
-			provisioner = "juju"
+		if _, err = config.GetString("provisioner"); err != nil {
+			fmt.Printf("Warning: %q didn't declare a provisioner, using default provisioner.\n", *configFile)
 		}
-		app.Provisioner, err = provision.Get(provisioner)
-		if err != nil {
+		provisioner := app.DefaultProvisioner()
+		if _, err = provision.Get(provisioner); err != nil {
 			fatal(err)
 		}
-		fmt.Printf("Using %q provisioner.\n\n", provisioner)
+		fmt.Printf("Using %q as default provisioner.\n\n", provisioner)
 
 		listen, err := config.GetString("listen")
 		if err != nil {
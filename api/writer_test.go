@@ -23,10 +23,10 @@ func (s *S) TestLogWriter(c *C) {
 	_, err = writer.Write(data)
 	c.Assert(err, IsNil)
 	c.Assert(b.Bytes(), DeepEquals, data)
-	instance := app.App{}
-	err = db.Session.Apps().Find(bson.M{"name": a.Name}).One(&instance)
-	logLen := len(instance.Logs)
-	c.Assert(instance.Logs[logLen-1].Message, Equals, string(data))
+	logs, err := a.LastLogs(1, app.LogFilter{Source: "tsuru"})
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 1)
+	c.Assert(logs[0].Message, Equals, string(data))
 }
 
 func (s *S) TestLogWriterShouldReturnsTheDataSize(c *C) {
@@ -18,9 +18,13 @@ import (
 // insertApp is an implementation for the action interface.
 type insertApp struct{}
 
-// insertApp forward stores the app with "pending" as your state.
+// insertApp forward stores the app with "pending" as your state, recording
+// the provisioner chosen for the app.
 func (a *insertApp) forward(app *App, args ...interface{}) error {
 	app.State = "pending"
+	if app.Provisioner == "" {
+		app.Provisioner = chooseProvisioner(app)
+	}
 	return db.Session.Apps().Insert(app)
 }
 
@@ -62,7 +66,7 @@ func (a *createBucketIam) forward(app *App, args ...interface{}) error {
 			InstanceName: s3InstanceName,
 		})
 	}
-	app.SetEnvsToApp(envVars, false, true)
+	app.SetEnvsToApp(envVars, false, true, envSystemUser)
 	return nil
 }
 
@@ -95,12 +99,16 @@ func (a *provisionApp) forward(app *App, args ...interface{}) error {
 			units = 1
 		}
 	}
-	err := Provisioner.Provision(app)
+	p, err := app.getProvisioner()
+	if err != nil {
+		return err
+	}
+	err = p.Provision(app)
 	if err != nil {
 		return err
 	}
 	if units > 1 {
-		_, err = Provisioner.AddUnits(app, units-1)
+		_, err = p.AddUnits(app, units-1)
 		return err
 	}
 	return nil
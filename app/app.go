@@ -32,9 +32,11 @@ import (
 const (
 	RegenerateApprc = "regenerate-apprc"
 	StartApp        = "start-app"
-)
 
-var Provisioner provision.Provisioner
+	// RegenerateApprcAndStart regenerates the apprc and then restarts the
+	// app, so the restart sees the new environment variables.
+	RegenerateApprcAndStart = "regenerate-apprc-and-start"
+)
 
 func write(w io.Writer, content []byte) error {
 	n, err := w.Write(content)
@@ -48,14 +50,23 @@ func write(w io.Writer, content []byte) error {
 }
 
 type App struct {
-	Env       map[string]bind.EnvVar
-	Framework string
-	Logs      []Applog
-	Name      string
-	State     string
-	Units     []Unit
-	Teams     []string
-	hooks     *conf
+	Env         map[string]bind.EnvVar
+	Framework   string
+	Name        string
+	Provisioner string
+	State       string
+	Units       []Unit
+	Teams       []string
+	hooks       *conf
+	// DisableHealing indicates whether the healer of the collector should
+	// leave the broken units of the app alone (see SetDisableHealing).
+	DisableHealing bool
+	// RestartOnEnvChange indicates whether the app should be restarted
+	// whenever its environment variables change (see saveEnvs).
+	RestartOnEnvChange bool
+	// LogRetention holds the retention policies of the logs of the app, by
+	// source, overriding the ones defined in tsuru.conf (see RetentionPolicy).
+	LogRetention map[string]RetentionPolicy
 }
 
 func (a *App) MarshalJSON() ([]byte, error) {
@@ -65,19 +76,35 @@ func (a *App) MarshalJSON() ([]byte, error) {
 	result["Framework"] = a.Framework
 	result["Teams"] = a.Teams
 	result["Units"] = a.Units
+	result["RestartOnEnvChange"] = a.RestartOnEnvChange
 	result["Repository"] = repository.GetUrl(a.Name)
 	return json.Marshal(&result)
 }
 
+// Applog is an entry in the logs of an app. Entries are stored in the logs
+// collection (see db.Storage.Logs).
+//
+// Unit is the name of the unit that wrote the entry, if it was written by a
+// unit of the app.
 type Applog struct {
+	Id      bson.ObjectId `bson:"_id,omitempty" json:"-"`
 	Date    time.Time
 	Message string
 	Source  string
+	AppName string
+	Unit    string
 }
 
 type conf struct {
-	PreRestart []string `yaml:"pre-restart"`
-	PosRestart []string `yaml:"pos-restart"`
+	PreRestart   []string          `yaml:"pre-restart"`
+	PosRestart   []string          `yaml:"pos-restart"`
+	Build        []hook            `yaml:"build"`
+	PreDeploy    []hook            `yaml:"pre-deploy"`
+	PostDeploy   []hook            `yaml:"post-deploy"`
+	OnUnitAdded  []hook            `yaml:"on-unit-added"`
+	Processes    map[string]string `yaml:"processes"`
+	RestartBatch int               `yaml:"restart-batch"`
+	Healthcheck  healthcheck       `yaml:"healthcheck"`
 }
 
 func (a *App) Get() error {
@@ -143,14 +170,18 @@ func (a *App) unbind() error {
 //       1. Destroy the bucket and S3 credentials
 //       2. Destroy the app unit using juju
 //       3. Execute the unbind for the app
-//       4. Remove the app from the database
+//       4. Remove the app, its logs and its drains from the database
 func (a *App) Destroy() error {
 	err := destroyBucket(a)
 	if err != nil {
 		return err
 	}
 	if len(a.Units) > 0 {
-		err = Provisioner.Destroy(a)
+		p, err := a.getProvisioner()
+		if err != nil {
+			return err
+		}
+		err = p.Destroy(a)
 		if err != nil {
 			return errors.New("Failed to destroy the app: " + err.Error())
 		}
@@ -159,7 +190,13 @@ func (a *App) Destroy() error {
 			return err
 		}
 	}
-	return db.Session.Apps().Remove(bson.M{"name": a.Name})
+	if err = db.Session.Apps().Remove(bson.M{"name": a.Name}); err != nil {
+		return err
+	}
+	if _, err = db.Session.Logs().RemoveAll(bson.M{"appname": a.Name}); err != nil {
+		return err
+	}
+	return a.removeDrains()
 }
 
 // AddUnit adds a new unit to the app (or update an existing unit). It just updates
@@ -177,12 +214,24 @@ func (a *App) AddUnit(u *Unit) {
 }
 
 // AddUnits creates n new units within the provisioner, saves new units in the
-// database and enqueues the apprc serialization.
+// database and enqueues the apprc serialization, followed by the start of the
+// new units.
+//
+// The new units run the default process of the app. Use AddProcessUnits to add
+// units of other process types.
 func (a *App) AddUnits(n uint) error {
+	return a.addUnits(n, "")
+}
+
+func (a *App) addUnits(n uint, process string) error {
 	if n == 0 {
 		return errors.New("Cannot add zero units.")
 	}
-	units, err := Provisioner.AddUnits(a, n)
+	p, err := a.getProvisioner()
+	if err != nil {
+		return err
+	}
+	units, err := p.AddUnits(a, n)
 	if err != nil {
 		return err
 	}
@@ -191,8 +240,7 @@ func (a *App) AddUnits(n uint) error {
 	length := len(a.Units)
 	appUnits := make([]Unit, len(units))
 	a.Units = append(a.Units, appUnits...)
-	messages := make([]queue.Message, len(units)*2)
-	mCount := 0
+	messages := make([]queue.Message, len(units))
 	for i, unit := range units {
 		a.Units[i+length] = Unit{
 			Name:    unit.Name,
@@ -200,11 +248,10 @@ func (a *App) AddUnits(n uint) error {
 			Ip:      unit.Ip,
 			Machine: unit.Machine,
 			State:   provision.StatusPending.String(),
+			Process: process,
 		}
 		qArgs[i+1] = unit.Name
-		messages[mCount] = queue.Message{Action: RegenerateApprc, Args: []string{a.Name, unit.Name}}
-		messages[mCount+1] = queue.Message{Action: StartApp, Args: []string{a.Name, unit.Name}}
-		mCount += 2
+		messages[i] = queue.Message{Action: RegenerateApprcAndStart, Args: []string{a.Name, unit.Name}}
 	}
 	err = db.Session.Apps().Update(bson.M{"name": a.Name}, a)
 	if err != nil {
@@ -242,7 +289,11 @@ func (a *App) RemoveUnits(n uint) error {
 	} else if n > l {
 		return fmt.Errorf("Cannot remove %d units from this app, it has only %d units.", n, l)
 	}
-	indices, err := Provisioner.RemoveUnits(a, n)
+	p, err := a.getProvisioner()
+	if err != nil {
+		return err
+	}
+	indices, err := p.RemoveUnits(a, n)
 	if err != nil {
 		return err
 	}
@@ -250,6 +301,31 @@ func (a *App) RemoveUnits(n uint) error {
 	return db.Session.Apps().Update(bson.M{"name": a.Name}, a)
 }
 
+// RemoveUnit removes the unit with the given name from the provisioner and
+// from the app.
+func (a *App) RemoveUnit(name string) error {
+	index := -1
+	for i, unit := range a.Units {
+		if unit.Name == name {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return fmt.Errorf("App %q does not have a unit named %q.", a.Name, name)
+	}
+	p, err := a.getProvisioner()
+	if err != nil {
+		return err
+	}
+	err = p.RemoveUnit(a, name)
+	if err != nil {
+		return err
+	}
+	a.removeUnits([]int{index})
+	return db.Session.Apps().Update(bson.M{"name": a.Name}, a)
+}
+
 func (a *App) Find(team *auth.Team) (int, bool) {
 	pos := sort.Search(len(a.Teams), func(i int) bool {
 		return a.Teams[i] >= team.Name
@@ -300,7 +376,11 @@ func (a *App) setEnv(env bind.EnvVar) {
 		a.Env = make(map[string]bind.EnvVar)
 	}
 	a.Env[env.Name] = env
-	a.Log(fmt.Sprintf("setting env %s with value %s", env.Name, env.Value), "tsuru")
+	visibility := "public"
+	if !env.Public {
+		visibility = "private"
+	}
+	a.Log(fmt.Sprintf("setting env %s (%s)", env.Name, visibility), "tsuru")
 }
 
 func (a *App) getEnv(name string) (bind.EnvVar, error) {
@@ -371,8 +451,11 @@ func (a *App) loadHooks() error {
 	return nil
 }
 
-func (a *App) runHook(w io.Writer, cmds []string, kind string) error {
-	if len(cmds) == 0 {
+// runHook runs the given hooks in the units of the app (or in the given
+// units), stopping on the first hook that fails. The returned error names the
+// failing hook.
+func (a *App) runHook(w io.Writer, hooks []hook, kind string, units ...string) error {
+	if len(hooks) == 0 {
 		a.Log(fmt.Sprintf("Skipping %s hooks...", kind), "tsuru")
 		return nil
 	}
@@ -381,80 +464,176 @@ func (a *App) runHook(w io.Writer, cmds []string, kind string) error {
 	if err != nil {
 		return err
 	}
-	for _, cmd := range cmds {
-		p, err := deployHookAbsPath(cmd)
+	for _, h := range hooks {
+		p, err := deployHookAbsPath(h.Command)
 		if err != nil {
 			a.Log(fmt.Sprintf("Error obtaining absolute path to hook: %s.", err), "tsuru")
 			continue
 		}
-		err = a.Run(p, w)
-		if err != nil {
-			return err
+		targets := units
+		if h.Once {
+			if targets, err = a.onceTarget(units); err != nil {
+				return err
+			}
+		}
+		if err = a.Run(p, w, targets...); err != nil {
+			msg := fmt.Sprintf("%s hook %q failed: %s", kind, h.Command, err)
+			a.Log(msg, "tsuru")
+			write(w, []byte("\n ---> "+msg+"\n"))
+			return errors.New(msg)
 		}
 	}
-	return err
+	return nil
 }
 
 // preRestart is responsible for running user's pre-restart script.
 //
 // The path to this script can be found at the app.conf file, at the root of user's app repository.
-func (a *App) preRestart(w io.Writer) error {
+func (a *App) preRestart(w io.Writer, units ...string) error {
 	if err := a.loadHooks(); err != nil {
 		return err
 	}
-	return a.runHook(w, a.hooks.PreRestart, "pre-restart")
+	return a.runHook(w, commands(a.hooks.PreRestart), "pre-restart", units...)
 }
 
 // posRestart is responsible for running user's pos-restart script.
 //
 // The path to this script can be found at the app.conf file, at the root of
 // user's app repository.
-func (a *App) posRestart(w io.Writer) error {
+func (a *App) posRestart(w io.Writer, units ...string) error {
 	if err := a.loadHooks(); err != nil {
 		return err
 	}
-	return a.runHook(w, a.hooks.PosRestart, "pos-restart")
+	return a.runHook(w, commands(a.hooks.PosRestart), "pos-restart", units...)
 }
 
 // Run executes the command in app units, sourcing apprc before running the
 // command.
-func (a *App) Run(cmd string, w io.Writer) error {
+//
+// If names of units are given, the command runs only in these units, which
+// requires a provisioner that is a provision.UnitCommander: Run returns
+// ErrUnitCommandNotSupported otherwise. Without names of units, the command
+// runs in all units of the app.
+func (a *App) Run(cmd string, w io.Writer, units ...string) error {
 	a.Log(fmt.Sprintf("running '%s'", cmd), "tsuru")
 	source := "[ -f /home/application/apprc ] && source /home/application/apprc"
 	cd := "[ -d /home/application/current ] && cd /home/application/current"
 	cmd = fmt.Sprintf("%s; %s; %s", source, cd, cmd)
-	return a.run(cmd, w)
+	return a.run(cmd, w, units...)
+}
+
+// running reports whether the app is able to run commands. Partially started
+// apps have some units down, and commands are what the healer and users need
+// to fix them.
+func (a *App) running() bool {
+	return a.State == string(provision.StatusStarted) || a.State == string(provision.StatusPartiallyStarted)
 }
 
-func (a *App) run(cmd string, w io.Writer) error {
-	if a.State != string(provision.StatusStarted) {
+func (a *App) run(cmd string, w io.Writer, units ...string) error {
+	if !a.running() {
 		return fmt.Errorf("App must be started to run commands, but it is %q.", a.State)
 	}
-	return Provisioner.ExecuteCommand(w, w, a, cmd)
+	p, err := a.getProvisioner()
+	if err != nil {
+		return err
+	}
+	if len(units) > 0 {
+		commander, ok := p.(provision.UnitCommander)
+		if !ok {
+			return ErrUnitCommandNotSupported
+		}
+		for _, unit := range units {
+			err = commander.ExecuteCommandOnUnit(w, w, a, unit, cmd)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return p.ExecuteCommand(w, w, a, cmd)
 }
 
 // Command is declared just to satisfy repository.Unit interface.
 func (a *App) Command(stdout, stderr io.Writer, cmdArgs ...string) error {
-	return Provisioner.ExecuteCommand(stdout, stderr, a, cmdArgs[0], cmdArgs[1:]...)
+	p, err := a.getProvisioner()
+	if err != nil {
+		return err
+	}
+	return p.ExecuteCommand(stdout, stderr, a, cmdArgs[0], cmdArgs[1:]...)
 }
 
-// Restart runs the restart hook for the app
-// and returns your output.
-func (a *App) Restart(w io.Writer) error {
-	a.Log("executing hook to restart", "tsuru")
-	err := a.preRestart(w)
+// ErrStopNotSupported is returned by Stop when the provisioner of the app is
+// not able to stop its units.
+var ErrStopNotSupported = errors.New("The provisioner of the app is not able to stop it.")
+
+// Stop stops all units of the app, without destroying them, and marks the app
+// as stopped.
+//
+// The provisioner of the app must be a provision.Stopper, otherwise
+// ErrStopNotSupported is returned.
+func (a *App) Stop(w io.Writer) error {
+	if a.State == string(provision.StatusStopped) {
+		return &ValidationError{Message: "App is already stopped."}
+	}
+	p, err := a.getProvisioner()
 	if err != nil {
 		return err
 	}
-	err = write(w, []byte("\n ---> Restarting your app\n"))
+	stopper, ok := p.(provision.Stopper)
+	if !ok {
+		return ErrStopNotSupported
+	}
+	a.Log("stopping the app", "tsuru")
+	err = write(w, []byte("\n ---> Stopping your app\n"))
+	if err != nil {
+		return err
+	}
+	if err = stopper.Stop(a); err != nil {
+		return err
+	}
+	return a.setState(provision.StatusStopped)
+}
+
+// Start starts the units of an app that was previously stopped.
+//
+// Apps whose provisioner is a provision.Starter are started by the
+// provisioner. Other apps are restarted (see Restart).
+func (a *App) Start(w io.Writer) error {
+	if a.State != string(provision.StatusStopped) {
+		return &ValidationError{Message: fmt.Sprintf("App must be stopped to be started, but it is %q.", a.State)}
+	}
+	a.Log("starting the app", "tsuru")
+	p, err := a.getProvisioner()
 	if err != nil {
 		return err
 	}
-	err = a.run("/var/lib/tsuru/hooks/restart", w)
+	if starter, ok := p.(provision.Starter); ok {
+		err = write(w, []byte("\n ---> Starting your app\n"))
+		if err != nil {
+			return err
+		}
+		err = starter.Start(a)
+	} else {
+		a.State = string(provision.StatusStarted)
+		err = a.Restart(w)
+		if err != nil {
+			a.State = string(provision.StatusStopped)
+		}
+	}
 	if err != nil {
 		return err
 	}
-	return a.posRestart(w)
+	return a.setState(provision.StatusStarted)
+}
+
+// setState changes the state of the app and all its units, and saves the app
+// in the database.
+func (a *App) setState(status provision.Status) error {
+	a.State = string(status)
+	for i := range a.Units {
+		a.Units[i].State = string(status)
+	}
+	return db.Session.Apps().Update(bson.M{"name": a.Name}, a)
 }
 
 // InstallDeps runs the dependencies hook for the app
@@ -503,17 +682,17 @@ func (a *App) ProvisionUnits() []provision.AppUnit {
 // environment variables will be written the the file /home/application/apprc
 // in all units of the app.
 //
-// The wait parameter indicates whether it should wait or not for the write to
-// complete.
+// Values are single quoted in the file, and the whole file is passed to the
+// shell as a single quoted word, so values are never interpreted by the shell.
+// This is the only place where encrypted values are decrypted.
 func (a *App) SerializeEnvVars() error {
 	var buf bytes.Buffer
-	cmd := "cat > /home/application/apprc <<END\n"
-	cmd += fmt.Sprintf("# generated by tsuru at %s\n", time.Now().Format(time.RFC822Z))
-	for k, v := range a.Env {
-		cmd += fmt.Sprintf(`export %s="%s"`+"\n", k, v.Value)
+	apprc, err := a.apprc()
+	if err != nil {
+		return err
 	}
-	cmd += "END\n"
-	err := a.run(cmd, &buf)
+	cmd := "printf '%s' " + shellQuote(apprc) + " > /home/application/apprc"
+	err = a.run(cmd, &buf)
 	if err != nil {
 		output := buf.Bytes()
 		if output == nil {
@@ -530,7 +709,7 @@ func (a *App) SetEnvs(envs []bind.EnvVar, publicOnly bool) error {
 	for i, env := range envs {
 		e[i] = bind.EnvVar(env)
 	}
-	return a.SetEnvsToApp(e, publicOnly, false)
+	return a.SetEnvsToApp(e, publicOnly, false, envSystemUser)
 }
 
 func (a *App) enqueue(msgs ...queue.Message) error {
@@ -549,6 +728,34 @@ func (a *App) enqueue(msgs ...queue.Message) error {
 	return nil
 }
 
+// EnqueueRestart queues the restart of the app, using the StartApp action, so
+// the caller doesn't wait for the restart.
+func (a *App) EnqueueRestart() error {
+	return a.enqueue(queue.Message{Action: StartApp, Args: []string{a.Name}})
+}
+
+// SetRestartOnEnvChange sets the restart policy of the app (see
+// RestartOnEnvChange).
+func (a *App) SetRestartOnEnvChange(restart bool) error {
+	err := db.Session.Apps().Update(bson.M{"name": a.Name}, bson.M{"$set": bson.M{"restartonenvchange": restart}})
+	if err != nil {
+		return err
+	}
+	a.RestartOnEnvChange = restart
+	return nil
+}
+
+// SetDisableHealing sets whether the broken units of the app are left alone by
+// the healer (see DisableHealing).
+func (a *App) SetDisableHealing(disable bool) error {
+	err := db.Session.Apps().Update(bson.M{"name": a.Name}, bson.M{"$set": bson.M{"disablehealing": disable}})
+	if err != nil {
+		return err
+	}
+	a.DisableHealing = disable
+	return nil
+}
+
 // SetEnvsToApp adds environment variables to an app, serializing the resulting
 // list of environment variables in all units of apps. This method can
 // serialize them directly or using a queue.
@@ -559,8 +766,19 @@ func (a *App) enqueue(msgs ...queue.Message) error {
 //
 // If useQueue is true, it will use a queue to write the environment variables
 // in the units of the app.
-func (app *App) SetEnvsToApp(envs []bind.EnvVar, publicOnly, useQueue bool) error {
+//
+// The names of the variables are validated before any of them is set, see
+// ValidateEnvName. Values of private variables are encrypted, when the
+// env-encryption:key setting is present.
+//
+// The change is recorded as a new revision of the environment variables of
+// the app, made by the given user (see EnvRevision).
+func (app *App) SetEnvsToApp(envs []bind.EnvVar, publicOnly, useQueue bool, user string) error {
+	if err := validateEnvs(envs); err != nil {
+		return err
+	}
 	if len(envs) > 0 {
+		old := copyEnvs(app.Env)
 		for _, env := range envs {
 			set := true
 			if publicOnly {
@@ -570,22 +788,23 @@ func (app *App) SetEnvsToApp(envs []bind.EnvVar, publicOnly, useQueue bool) erro
 				}
 			}
 			if set {
+				if !env.Public {
+					value, err := encryptValue(env.Value)
+					if err != nil {
+						return err
+					}
+					env.Value = value
+				}
 				app.setEnv(env)
 			}
 		}
-		if err := db.Session.Apps().Update(bson.M{"name": app.Name}, app); err != nil {
-			return err
-		}
-		if useQueue {
-			return app.enqueue(queue.Message{Action: RegenerateApprc, Args: []string{app.Name}})
-		}
-		app.SerializeEnvVars()
+		return app.saveEnvs(old, user, useQueue)
 	}
 	return nil
 }
 
 func (a *App) UnsetEnvs(envs []string, publicOnly bool) error {
-	return a.UnsetEnvsFromApp(envs, publicOnly, false)
+	return a.UnsetEnvsFromApp(envs, publicOnly, false, envSystemUser)
 }
 
 // UnsetEnvsFromApp removes environment variables from an app, serializing the
@@ -598,8 +817,12 @@ func (a *App) UnsetEnvs(envs []string, publicOnly bool) error {
 //
 // If useQueue is true, it will use a queue to write the environment variables
 // in the units of the app.
-func (app *App) UnsetEnvsFromApp(variableNames []string, publicOnly, useQueue bool) error {
+//
+// The change is recorded as a new revision of the environment variables of
+// the app, made by the given user (see EnvRevision).
+func (app *App) UnsetEnvsFromApp(variableNames []string, publicOnly, useQueue bool, user string) error {
 	if len(variableNames) > 0 {
+		old := copyEnvs(app.Env)
 		for _, name := range variableNames {
 			var unset bool
 			e, err := app.getEnv(name)
@@ -610,16 +833,64 @@ func (app *App) UnsetEnvsFromApp(variableNames []string, publicOnly, useQueue bo
 				delete(app.Env, name)
 			}
 		}
-		if err := db.Session.Apps().Update(bson.M{"name": app.Name}, app); err != nil {
+		return app.saveEnvs(old, user, useQueue)
+	}
+	return nil
+}
+
+// saveEnvs saves the environment variables of the app, records the changes
+// from the given old variables and serializes them in the units of the app,
+// directly or using a queue. Serializing directly is skipped when the app is
+// not running.
+//
+// When the app has RestartOnEnvChange set and the variables have changed, the
+// app is restarted after the variables are serialized: the queue handles both
+// in a single message. Failing to record the changes is logged, and doesn't
+// prevent the serialization.
+func (app *App) saveEnvs(old map[string]bind.EnvVar, user string, useQueue bool) error {
+	if err := db.Session.Apps().Update(bson.M{"name": app.Name}, app); err != nil {
+		return err
+	}
+	changed, err := app.recordEnvRevision(old, user)
+	if err != nil {
+		// The variables are saved already, so they're still serialized,
+		// and the app restarted, without the revision.
+		log.Error("Failed to record the revision of the environment variables.", "app", app.Name, "error", err)
+	}
+	restart := changed && app.RestartOnEnvChange
+	if useQueue {
+		action := RegenerateApprc
+		if restart {
+			action = RegenerateApprcAndStart
+		}
+		return app.enqueue(queue.Message{Action: action, Args: []string{app.Name}})
+	}
+	if app.running() {
+		if err := app.SerializeEnvVars(); err != nil {
 			return err
 		}
-		app.SerializeEnvVars()
+	}
+	if restart {
+		return app.enqueue(queue.Message{Action: StartApp, Args: []string{app.Name}})
 	}
 	return nil
 }
 
+// Log adds a message to the logs of the app, also writing it to the tsuru log.
+// Values of private environment variables, and anything that looks like a
+// secret, are redacted from the message (see log.Redact).
+//
+// The message is also forwarded to the drains of the app, in background.
 func (a *App) Log(message string, source string) error {
-	log.Printf(message)
+	return a.LogUnit(message, source, "")
+}
+
+// LogUnit is like Log, but the entries are attributed to the given unit of
+// the app.
+func (a *App) LogUnit(message, source, unit string) error {
+	message = log.Redact(message, a.privateValues()...)
+	log.Print(message)
+	var logs []Applog
 	messages := strings.Split(message, "\n")
 	for _, msg := range messages {
 		if msg != "" {
@@ -627,11 +898,24 @@ func (a *App) Log(message string, source string) error {
 				Date:    time.Now(),
 				Message: msg,
 				Source:  source,
+				AppName: a.Name,
+				Unit:    unit,
 			}
-			a.Logs = append(a.Logs, l)
+			logs = append(logs, l)
 		}
 	}
-	return db.Session.Apps().Update(bson.M{"name": a.Name}, a)
+	if len(logs) == 0 {
+		return nil
+	}
+	docs := make([]interface{}, len(logs))
+	for i, l := range logs {
+		docs[i] = l
+	}
+	if err := db.Session.Logs().Insert(docs...); err != nil {
+		return err
+	}
+	a.forwardLogs(logs)
+	return nil
 }
 
 type ValidationError struct {
@@ -33,7 +33,6 @@ func (s *S) TestGet(c *C) {
 	c.Assert(err, IsNil)
 	defer db.Session.Apps().Remove(bson.M{"name": newApp.Name})
 	newApp.Env = map[string]bind.EnvVar{}
-	newApp.Logs = []Applog{}
 	err = db.Session.Apps().Update(bson.M{"name": newApp.Name}, &newApp)
 	c.Assert(err, IsNil)
 	myApp := App{Name: "myApp"}
@@ -60,6 +59,9 @@ func (s *S) TestDestroy(c *C) {
 	}
 	err := CreateApp(&a, 1)
 	c.Assert(err, IsNil)
+	a.Log("some message", "tsuru")
+	err = a.AddDrain("syslog://logs.example.com:514")
+	c.Assert(err, IsNil)
 	err = a.Destroy()
 	c.Assert(err, IsNil)
 	err = a.Get()
@@ -67,6 +69,12 @@ func (s *S) TestDestroy(c *C) {
 	qt, err := db.Session.Apps().Find(bson.M{"name": a.Name}).Count()
 	c.Assert(err, IsNil)
 	c.Assert(qt, Equals, 0)
+	qt, err = db.Session.Logs().Find(bson.M{"appname": a.Name}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(qt, Equals, 0)
+	qt, err = db.Session.Drains().Find(bson.M{"appname": a.Name}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(qt, Equals, 0)
 	c.Assert(s.provisioner.FindApp(&a), Equals, -1)
 }
 
@@ -133,6 +141,7 @@ func (s *S) TestCreateApp(c *C) {
 	c.Assert(retrievedApp.Name, Equals, a.Name)
 	c.Assert(retrievedApp.Framework, Equals, a.Framework)
 	c.Assert(retrievedApp.State, Equals, a.State)
+	c.Assert(retrievedApp.Provisioner, Equals, "fake")
 	env := a.InstanceEnv(s3InstanceName)
 	c.Assert(env["TSURU_S3_ENDPOINT"].Value, Equals, s.t.S3Server.URL())
 	c.Assert(env["TSURU_S3_ENDPOINT"].Public, Equals, false)
@@ -289,11 +298,8 @@ func (s *S) TestAddUnits(c *C) {
 		names[i] = unit.Name
 		expected := fmt.Sprintf("%s/%d", app.Name, i+1)
 		c.Assert(unit.Name, Equals, expected)
-		messages := []queue.Message{
-			{Action: RegenerateApprc, Args: []string{app.Name, unit.Name}},
-			{Action: StartApp, Args: []string{app.Name, unit.Name}},
-		}
-		expectedMessages = append(expectedMessages, messages...)
+		message := queue.Message{Action: RegenerateApprcAndStart, Args: []string{app.Name, unit.Name}}
+		expectedMessages = append(expectedMessages, message)
 	}
 	time.Sleep(1e6)
 	c.Assert(server.Messages(), DeepEquals, expectedMessages)
@@ -344,6 +350,59 @@ func (s *S) TestRemoveUnits(c *C) {
 	c.Assert(app.Units[1].Name, Equals, "chemistry/3")
 }
 
+func (s *S) TestRemoveUnit(c *C) {
+	app := App{
+		Name:      "chemistry",
+		Framework: "python",
+		Units: []Unit{
+			{Name: "chemistry/0"},
+			{Name: "chemistry/1"},
+			{Name: "chemistry/2"},
+		},
+	}
+	err := db.Session.Apps().Insert(app)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": app.Name})
+	s.provisioner.Provision(&app)
+	defer s.provisioner.Destroy(&app)
+	s.provisioner.AddUnits(&app, 2)
+	err = app.RemoveUnit("chemistry/1")
+	c.Assert(err, IsNil)
+	units := s.provisioner.GetUnits(&app)
+	c.Assert(units, HasLen, 2)
+	c.Assert(units[0].Name, Equals, "chemistry/0")
+	c.Assert(units[1].Name, Equals, "chemistry/2")
+	err = app.Get()
+	c.Assert(err, IsNil)
+	c.Assert(app.Units, HasLen, 2)
+	c.Assert(app.Units[0].Name, Equals, "chemistry/0")
+	c.Assert(app.Units[1].Name, Equals, "chemistry/2")
+}
+
+func (s *S) TestRemoveUnitUnknownUnit(c *C) {
+	app := App{
+		Name:      "chemistry",
+		Framework: "python",
+		Units:     []Unit{{Name: "chemistry/0"}},
+	}
+	err := app.RemoveUnit("chemistry/1")
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, `App "chemistry" does not have a unit named "chemistry/1".`)
+}
+
+func (s *S) TestRemoveUnitFailureInProvisioner(c *C) {
+	s.provisioner.PrepareFailure("RemoveUnit", errors.New("Cannot remove this unit."))
+	app := App{
+		Name:      "chemistry",
+		Framework: "python",
+		Units:     []Unit{{Name: "chemistry/0"}, {Name: "chemistry/1"}},
+	}
+	err := app.RemoveUnit("chemistry/1")
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "Cannot remove this unit.")
+	c.Assert(app.Units, HasLen, 2)
+}
+
 func (s *S) TestRemoveUnitsInvalidValues(c *C) {
 	var tests = []struct {
 		n        uint
@@ -516,7 +575,7 @@ func (s *S) TestSetEnvRespectsThePublicOnlyFlagKeepPrivateVariablesWhenItsTrue(c
 			Public: true,
 		},
 	}
-	err = a.SetEnvsToApp(envs, true, false)
+	err = a.SetEnvsToApp(envs, true, false, "someone@tsuru.io")
 	c.Assert(err, IsNil)
 	newApp := App{Name: a.Name}
 	err = newApp.Get()
@@ -565,7 +624,7 @@ func (s *S) TestSetEnvRespectsThePublicOnlyFlagOverwrittenAllVariablesWhenItsFal
 			Public: true,
 		},
 	}
-	err = a.SetEnvsToApp(envs, false, false)
+	err = a.SetEnvsToApp(envs, false, false, "someone@tsuru.io")
 	c.Assert(err, IsNil)
 	newApp := App{Name: a.Name}
 	err = newApp.Get()
@@ -607,7 +666,7 @@ func (s *S) TestUnsetEnvRespectsThePublicOnlyFlagKeepPrivateVariablesWhenItsTrue
 	err := db.Session.Apps().Insert(a)
 	c.Assert(err, IsNil)
 	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
-	err = a.UnsetEnvsFromApp([]string{"DATABASE_HOST", "DATABASE_PASSWORD"}, true, false)
+	err = a.UnsetEnvsFromApp([]string{"DATABASE_HOST", "DATABASE_PASSWORD"}, true, false, "someone@tsuru.io")
 	c.Assert(err, IsNil)
 	newApp := App{Name: a.Name}
 	err = newApp.Get()
@@ -644,7 +703,7 @@ func (s *S) TestUnsetEnvRespectsThePublicOnlyFlagUnsettingAllVariablesWhenItsFal
 	err := db.Session.Apps().Insert(a)
 	c.Assert(err, IsNil)
 	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
-	err = a.UnsetEnvsFromApp([]string{"DATABASE_HOST", "DATABASE_PASSWORD"}, false, false)
+	err = a.UnsetEnvsFromApp([]string{"DATABASE_HOST", "DATABASE_PASSWORD"}, false, false, "someone@tsuru.io")
 	c.Assert(err, IsNil)
 	newApp := App{Name: a.Name}
 	err = newApp.Get()
@@ -652,6 +711,129 @@ func (s *S) TestUnsetEnvRespectsThePublicOnlyFlagUnsettingAllVariablesWhenItsFal
 	c.Assert(newApp.Env, DeepEquals, map[string]bind.EnvVar{})
 }
 
+func (s *S) TestSetEnvsToAppRestartsTheAppWhenThePolicyIsSet(c *C) {
+	server := testing.FakeQueueServer{}
+	server.Start("127.0.0.1:0")
+	defer server.Stop()
+	old, err := config.Get("queue-server")
+	if err == nil {
+		defer config.Set("queue-server", old)
+	}
+	config.Set("queue-server", server.Addr())
+	a := App{Name: "restless", RestartOnEnvChange: true}
+	err = db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.EnvRevisions().RemoveAll(bson.M{"app": a.Name})
+	envs := []bind.EnvVar{{Name: "DATABASE_HOST", Value: "localhost", Public: true}}
+	err = a.SetEnvsToApp(envs, true, true, "someone@tsuru.io")
+	c.Assert(err, IsNil)
+	err = a.SetEnvsToApp(envs, true, true, "someone@tsuru.io")
+	c.Assert(err, IsNil)
+	time.Sleep(1e6)
+	expected := []queue.Message{
+		{Action: RegenerateApprcAndStart, Args: []string{a.Name}},
+		{Action: RegenerateApprc, Args: []string{a.Name}},
+	}
+	c.Assert(server.Messages(), DeepEquals, expected)
+}
+
+func (s *S) TestUnsetEnvsFromAppRestartsTheAppWhenThePolicyIsSet(c *C) {
+	server := testing.FakeQueueServer{}
+	server.Start("127.0.0.1:0")
+	defer server.Stop()
+	old, err := config.Get("queue-server")
+	if err == nil {
+		defer config.Set("queue-server", old)
+	}
+	config.Set("queue-server", server.Addr())
+	a := App{
+		Name:               "restless",
+		RestartOnEnvChange: true,
+		State:              string(provision.StatusStarted),
+		Env: map[string]bind.EnvVar{
+			"DATABASE_HOST": {Name: "DATABASE_HOST", Value: "localhost", Public: true},
+		},
+	}
+	err = db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.EnvRevisions().RemoveAll(bson.M{"app": a.Name})
+	s.provisioner.PrepareOutput(nil)
+	err = a.UnsetEnvsFromApp([]string{"DATABASE_HOST"}, true, false, "someone@tsuru.io")
+	c.Assert(err, IsNil)
+	time.Sleep(1e6)
+	c.Assert(server.Messages(), DeepEquals, []queue.Message{{Action: StartApp, Args: []string{a.Name}}})
+}
+
+func (s *S) TestSetEnvsToAppReturnsTheSerializationError(c *C) {
+	a := App{
+		Name:               "restless",
+		RestartOnEnvChange: true,
+		State:              string(provision.StatusStarted),
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.EnvRevisions().RemoveAll(bson.M{"app": a.Name})
+	s.provisioner.PrepareFailure("ExecuteCommand", errors.New("exit status 1"))
+	envs := []bind.EnvVar{{Name: "DATABASE_HOST", Value: "localhost", Public: true}}
+	err = a.SetEnvsToApp(envs, true, false, "someone@tsuru.io")
+	c.Assert(err, ErrorMatches, "^Failed to write env vars: exit status 1.$")
+}
+
+func (s *S) TestSetDisableHealing(c *C) {
+	a := App{Name: "fragile"}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	err = a.SetDisableHealing(true)
+	c.Assert(err, IsNil)
+	c.Assert(a.DisableHealing, Equals, true)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.DisableHealing, Equals, true)
+	err = a.SetDisableHealing(false)
+	c.Assert(err, IsNil)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.DisableHealing, Equals, false)
+}
+
+func (s *S) TestSetRestartOnEnvChange(c *C) {
+	a := App{Name: "restless"}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	err = a.SetRestartOnEnvChange(true)
+	c.Assert(err, IsNil)
+	c.Assert(a.RestartOnEnvChange, Equals, true)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.RestartOnEnvChange, Equals, true)
+	err = a.SetRestartOnEnvChange(false)
+	c.Assert(err, IsNil)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.RestartOnEnvChange, Equals, false)
+}
+
+func (s *S) TestEnqueueRestart(c *C) {
+	server := testing.FakeQueueServer{}
+	server.Start("127.0.0.1:0")
+	defer server.Stop()
+	old, err := config.Get("queue-server")
+	if err == nil {
+		defer config.Set("queue-server", old)
+	}
+	config.Set("queue-server", server.Addr())
+	a := App{Name: "restless"}
+	err = a.EnqueueRestart()
+	c.Assert(err, IsNil)
+	time.Sleep(1e6)
+	c.Assert(server.Messages(), DeepEquals, []queue.Message{{Action: StartApp, Args: []string{a.Name}}})
+}
+
 func (s *S) TestGetEnvironmentVariableFromApp(c *C) {
 	a := App{Name: "whole-lotta-love"}
 	a.setEnv(bind.EnvVar{Name: "PATH", Value: "/"})
@@ -929,6 +1111,205 @@ func (s *S) TestRestart(c *C) {
 	c.Assert(cmds, HasLen, 1)
 }
 
+func (s *S) TestRestartWithRestarter(c *C) {
+	p := testing.NewExtensibleFakeProvisioner()
+	provision.Register("extensible", p)
+	p.PrepareOutput(nil) // loadHooks
+	a := App{
+		Name:        "someApp",
+		Framework:   "django",
+		Provisioner: "extensible",
+		Teams:       []string{s.team.Name},
+		State:       string(provision.StatusStarted),
+	}
+	err := p.Provision(&a)
+	c.Assert(err, IsNil)
+	defer p.Destroy(&a)
+	var b bytes.Buffer
+	err = a.Restart(&b)
+	c.Assert(err, IsNil)
+	c.Assert(p.Restarts(&a), Equals, 1)
+	cmds := p.GetCmds("/var/lib/tsuru/hooks/restart", &a)
+	c.Assert(cmds, HasLen, 0)
+}
+
+func (s *S) TestRestartSpecificUnits(c *C) {
+	p := testing.NewExtensibleFakeProvisioner()
+	provision.Register("extensible", p)
+	p.PrepareOutput(nil) // loadHooks
+	p.PrepareOutput([]byte("restarted"))
+	a := App{
+		Name:        "someApp",
+		Framework:   "django",
+		Provisioner: "extensible",
+		Teams:       []string{s.team.Name},
+		State:       string(provision.StatusStarted),
+	}
+	err := p.Provision(&a)
+	c.Assert(err, IsNil)
+	defer p.Destroy(&a)
+	var b bytes.Buffer
+	err = a.Restart(&b, "someApp/1")
+	c.Assert(err, IsNil)
+	c.Assert(p.Restarts(&a), Equals, 0)
+	cmds := p.GetCmds("/var/lib/tsuru/hooks/restart", &a)
+	c.Assert(cmds, HasLen, 1)
+	c.Assert(cmds[0].Unit, Equals, "someApp/1")
+}
+
+func (s *S) TestRestartSpecificUnitsWithoutUnitCommander(c *C) {
+	s.provisioner.PrepareOutput(nil) // loadHooks
+	s.provisioner.PrepareOutput([]byte("restarted"))
+	a := App{
+		Name:      "someApp",
+		Framework: "django",
+		Teams:     []string{s.team.Name},
+		State:     string(provision.StatusStarted),
+	}
+	var b bytes.Buffer
+	err := a.Restart(&b, "someApp/1")
+	c.Assert(err, IsNil)
+	cmds := s.provisioner.GetCmds("/var/lib/tsuru/hooks/restart", &a)
+	c.Assert(cmds, HasLen, 1)
+	c.Assert(cmds[0].Unit, Equals, "")
+}
+
+func (s *S) TestStopProvisionerIsNotAStopper(c *C) {
+	a := App{
+		Name:      "someApp",
+		Framework: "django",
+		Teams:     []string{s.team.Name},
+		State:     string(provision.StatusStarted),
+		Units:     []Unit{{Name: "someApp/0", State: string(provision.StatusStarted)}},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	var b bytes.Buffer
+	err = a.Stop(&b)
+	c.Assert(err, Equals, ErrStopNotSupported)
+	c.Assert(b.String(), Equals, "")
+	c.Assert(s.provisioner.GetCmds("", &a), HasLen, 0)
+	var stored App
+	err = db.Session.Apps().Find(bson.M{"name": a.Name}).One(&stored)
+	c.Assert(err, IsNil)
+	c.Assert(stored.State, Equals, string(provision.StatusStarted))
+	c.Assert(stored.Units[0].State, Equals, string(provision.StatusStarted))
+}
+
+func (s *S) TestStopWithStopper(c *C) {
+	p := testing.NewExtensibleFakeProvisioner()
+	provision.Register("extensible", p)
+	a := App{
+		Name:        "someApp",
+		Framework:   "django",
+		Provisioner: "extensible",
+		Teams:       []string{s.team.Name},
+		State:       string(provision.StatusStarted),
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	err = p.Provision(&a)
+	c.Assert(err, IsNil)
+	defer p.Destroy(&a)
+	var b bytes.Buffer
+	err = a.Stop(&b)
+	c.Assert(err, IsNil)
+	c.Assert(p.Stops(&a), Equals, 1)
+	c.Assert(p.GetCmds("", &a), HasLen, 0)
+	c.Assert(a.State, Equals, string(provision.StatusStopped))
+	c.Assert(b.String(), Equals, "\n ---> Stopping your app\n")
+}
+
+func (s *S) TestStopAlreadyStopped(c *C) {
+	a := App{Name: "someApp", State: string(provision.StatusStopped)}
+	var b bytes.Buffer
+	err := a.Stop(&b)
+	c.Assert(err, NotNil)
+	e, ok := err.(*ValidationError)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Message, Equals, "App is already stopped.")
+}
+
+func (s *S) TestStart(c *C) {
+	s.provisioner.PrepareOutput(nil) // loadHooks
+	s.provisioner.PrepareOutput([]byte("started"))
+	a := App{
+		Name:      "someApp",
+		Framework: "django",
+		Teams:     []string{s.team.Name},
+		State:     string(provision.StatusStopped),
+		Units:     []Unit{{Name: "someApp/0", State: string(provision.StatusStopped)}},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	var b bytes.Buffer
+	err = a.Start(&b)
+	c.Assert(err, IsNil)
+	cmds := s.provisioner.GetCmds("/var/lib/tsuru/hooks/restart", &a)
+	c.Assert(cmds, HasLen, 1)
+	var stored App
+	err = db.Session.Apps().Find(bson.M{"name": a.Name}).One(&stored)
+	c.Assert(err, IsNil)
+	c.Assert(stored.State, Equals, string(provision.StatusStarted))
+	c.Assert(stored.Units[0].State, Equals, string(provision.StatusStarted))
+}
+
+func (s *S) TestStartFailureKeepsTheAppStopped(c *C) {
+	s.provisioner.PrepareFailure("ExecuteCommand", errors.New("Failed to start."))
+	a := App{
+		Name:      "someApp",
+		Framework: "django",
+		Teams:     []string{s.team.Name},
+		State:     string(provision.StatusStopped),
+		hooks:     &conf{},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	var b bytes.Buffer
+	err = a.Start(&b)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "Failed to start.")
+	c.Assert(a.State, Equals, string(provision.StatusStopped))
+}
+
+func (s *S) TestStartWithStarter(c *C) {
+	p := testing.NewExtensibleFakeProvisioner()
+	provision.Register("extensible", p)
+	a := App{
+		Name:        "someApp",
+		Framework:   "django",
+		Provisioner: "extensible",
+		Teams:       []string{s.team.Name},
+		State:       string(provision.StatusStopped),
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	err = p.Provision(&a)
+	c.Assert(err, IsNil)
+	defer p.Destroy(&a)
+	var b bytes.Buffer
+	err = a.Start(&b)
+	c.Assert(err, IsNil)
+	c.Assert(p.Starts(&a), Equals, 1)
+	c.Assert(p.Restarts(&a), Equals, 0)
+	c.Assert(a.State, Equals, string(provision.StatusStarted))
+}
+
+func (s *S) TestStartNotStopped(c *C) {
+	a := App{Name: "someApp", State: string(provision.StatusStarted)}
+	var b bytes.Buffer
+	err := a.Start(&b)
+	c.Assert(err, NotNil)
+	e, ok := err.(*ValidationError)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Message, Equals, `App must be stopped to be started, but it is "started".`)
+}
+
 func (s *S) TestRestartRunsPreRestartHook(c *C) {
 	s.provisioner.PrepareOutput([]byte("pre-restart-by-restart"))
 	s.provisioner.PrepareOutput([]byte("restart"))
@@ -972,11 +1353,26 @@ func (s *S) TestLog(c *C) {
 	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
 	err = a.Log("last log msg", "tsuru")
 	c.Assert(err, IsNil)
-	var instance App
-	err = db.Session.Apps().Find(bson.M{"name": a.Name}).One(&instance)
-	logLen := len(instance.Logs)
-	c.Assert(instance.Logs[logLen-1].Message, Equals, "last log msg")
-	c.Assert(instance.Logs[logLen-1].Source, Equals, "tsuru")
+	var logs []Applog
+	err = db.Session.Logs().Find(bson.M{"appname": a.Name}).All(&logs)
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 1)
+	c.Assert(logs[0].Message, Equals, "last log msg")
+	c.Assert(logs[0].Source, Equals, "tsuru")
+	c.Assert(logs[0].AppName, Equals, a.Name)
+}
+
+func (s *S) TestLogDoesNotChangeTheApp(c *C) {
+	a := App{Name: "newApp", Framework: "python"}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	a.Framework = "ruby"
+	err = a.Log("last log msg", "tsuru")
+	c.Assert(err, IsNil)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Framework, Equals, "python")
 }
 
 func (s *S) TestLogShouldAddOneRecordByLine(c *C) {
@@ -986,11 +1382,11 @@ func (s *S) TestLogShouldAddOneRecordByLine(c *C) {
 	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
 	err = a.Log("last log msg\nfirst log", "source")
 	c.Assert(err, IsNil)
-	instance := App{}
-	err = db.Session.Apps().Find(bson.M{"name": a.Name}).One(&instance)
-	logLen := len(instance.Logs)
-	c.Assert(instance.Logs[logLen-2].Message, Equals, "last log msg")
-	c.Assert(instance.Logs[logLen-1].Message, Equals, "first log")
+	logs, err := a.LastLogs(0, LogFilter{})
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 2)
+	c.Assert(logs[0].Message, Equals, "last log msg")
+	c.Assert(logs[1].Message, Equals, "first log")
 }
 
 func (s *S) TestLogShouldNotLogBlankLines(c *C) {
@@ -1004,10 +1400,52 @@ func (s *S) TestLogShouldNotLogBlankLines(c *C) {
 	c.Assert(err, IsNil)
 	err = a.Log("", "")
 	c.Assert(err, IsNil)
-	var instance App
-	err = db.Session.Apps().Find(bson.M{"name": a.Name}).One(&instance)
-	logLen := len(instance.Logs)
-	c.Assert(instance.Logs[logLen-1].Message, Not(Equals), "")
+	logs, err := a.LastLogs(0, LogFilter{})
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 1)
+	c.Assert(logs[0].Message, Not(Equals), "")
+}
+
+func (s *S) TestLogRedactsPrivateValues(c *C) {
+	config.Set("env-encryption:key", "some-key")
+	defer config.Unset("env-encryption")
+	encrypted, err := encryptValue("encrypted-secret")
+	c.Assert(err, IsNil)
+	a := App{
+		Name: "newApp",
+		Env: map[string]bind.EnvVar{
+			"DATABASE_PASSWORD": {Name: "DATABASE_PASSWORD", Value: "plain-secret", Public: false},
+			"API_CREDENTIAL":    {Name: "API_CREDENTIAL", Value: encrypted, Public: false},
+			"DATABASE_HOST":     {Name: "DATABASE_HOST", Value: "localhost", Public: true},
+		},
+	}
+	err = db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	var buf bytes.Buffer
+	log.SetLogger(stdlog.New(&buf, "", 0))
+	defer log.SetLogger(nil)
+	err = a.Log("connecting to localhost with plain-secret and encrypted-secret ("+encrypted+")\nTOKEN=abc", "tsuru")
+	c.Assert(err, IsNil)
+	expected := "connecting to localhost with *** and *** (***)"
+	c.Assert(buf.String(), Equals, expected+"\nTOKEN=***\n")
+	logs, err := a.LastLogs(2, LogFilter{})
+	c.Assert(err, IsNil)
+	c.Assert(logs[0].Message, Equals, expected)
+	c.Assert(logs[1].Message, Equals, "TOKEN=***")
+}
+
+func (s *S) TestSetEnvLogsOnlyTheNameAndVisibility(c *C) {
+	a := App{Name: "newApp"}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	a.setEnv(bind.EnvVar{Name: "SOME_VAR", Value: "some-value", Public: true})
+	a.setEnv(bind.EnvVar{Name: "OTHER_VAR", Value: "other-value", Public: false})
+	logs, err := a.LastLogs(2, LogFilter{})
+	c.Assert(err, IsNil)
+	c.Assert(logs[0].Message, Equals, "setting env SOME_VAR (public)")
+	c.Assert(logs[1].Message, Equals, "setting env OTHER_VAR (private)")
 }
 
 func (s *S) TestGetTeams(c *C) {
@@ -1049,6 +1487,7 @@ func (s *S) TestAppMarshalJson(c *C) {
 	expected["Repository"] = repository.GetUrl(app.Name)
 	expected["Teams"] = []interface{}{"team1"}
 	expected["Units"] = interface{}(nil)
+	expected["RestartOnEnvChange"] = false
 	data, err := app.MarshalJSON()
 	c.Assert(err, IsNil)
 	result := make(map[string]interface{})
@@ -1082,6 +1521,41 @@ func (s *S) TestRunWithoutEnv(c *C) {
 	c.Assert(cmds, HasLen, 1)
 }
 
+func (s *S) TestRunOnPartiallyStartedApp(c *C) {
+	s.provisioner.PrepareOutput([]byte("a lot of files"))
+	app := App{Name: "myapp", State: string(provision.StatusPartiallyStarted)}
+	var buf bytes.Buffer
+	err := app.run("ls -lh", &buf)
+	c.Assert(err, IsNil)
+	cmds := s.provisioner.GetCmds("ls -lh", &app)
+	c.Assert(cmds, HasLen, 1)
+}
+
+func (s *S) TestRunOnUnits(c *C) {
+	p := testing.NewExtensibleFakeProvisioner()
+	provision.Register("extensible", p)
+	p.PrepareOutput([]byte("a lot of files"))
+	p.PrepareOutput([]byte(" and more files"))
+	app := App{Name: "myapp", Provisioner: "extensible", State: string(provision.StatusStarted)}
+	var buf bytes.Buffer
+	err := app.run("ls -lh", &buf, "myapp/0", "myapp/2")
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, "a lot of files and more files")
+	cmds := p.GetCmds("ls -lh", &app)
+	c.Assert(cmds, HasLen, 2)
+	c.Assert(cmds[0].Unit, Equals, "myapp/0")
+	c.Assert(cmds[1].Unit, Equals, "myapp/2")
+}
+
+func (s *S) TestRunOnUnitsWithoutUnitCommander(c *C) {
+	app := App{Name: "myapp", State: string(provision.StatusStarted)}
+	var buf bytes.Buffer
+	err := app.run("ls -lh", &buf, "myapp/0")
+	c.Assert(err, Equals, ErrUnitCommandNotSupported)
+	cmds := s.provisioner.GetCmds("ls -lh", &app)
+	c.Assert(cmds, HasLen, 0)
+}
+
 func (s *S) TestCommand(c *C) {
 	s.provisioner.PrepareOutput([]byte("lots of files"))
 	app := App{Name: "myapp", State: string(provision.StatusStarted)}
@@ -1115,8 +1589,8 @@ func (s *S) TestSerializeEnvVars(c *C) {
 	c.Assert(err, IsNil)
 	cmds := s.provisioner.GetCmds("", &app)
 	c.Assert(cmds, HasLen, 1)
-	cmdRegexp := `^cat > /home/application/apprc <<END # generated by tsuru .*`
-	cmdRegexp += ` export http_proxy="http://theirproxy.com:3128/" END $`
+	cmdRegexp := `^printf '%s' '# generated by tsuru .*`
+	cmdRegexp += ` export http_proxy='\\''http://theirproxy.com:3128/'\\'' ' > /home/application/apprc$`
 	cmd := strings.Replace(cmds[0].Cmd, "\n", " ", -1)
 	c.Assert(cmd, Matches, cmdRegexp)
 }
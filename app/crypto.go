@@ -0,0 +1,198 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/api/bind"
+	"github.com/globocom/tsuru/db"
+	"io"
+	"labix.org/v2/mgo/bson"
+	"strings"
+)
+
+// encryptedPrefix marks values of private environment variables that are
+// encrypted. Values without it are stored in plain text, either because they
+// were stored before encryption was enabled, or because no key is configured.
+const encryptedPrefix = "tsuru:aes256:"
+
+// ErrNoEncryptionKey is returned when an encrypted value is found, but there's
+// no encryption key in the configuration file.
+var ErrNoEncryptionKey = errors.New("Found an encrypted environment variable, but the env-encryption:key setting is missing.")
+
+// ErrDecrypt is returned when none of the configured keys is able to decrypt a
+// value.
+var ErrDecrypt = errors.New("Could not decrypt the environment variable: unknown key or corrupted value.")
+
+// envKey holds the keys derived from one of the keys in the configuration
+// file: one for encryption (AES-256, in CTR mode) and one for authentication
+// (HMAC-SHA256).
+type envKey struct {
+	cipher []byte
+	mac    []byte
+}
+
+func deriveKey(secret string) envKey {
+	derive := func(purpose string) []byte {
+		h := hmac.New(sha256.New, []byte(secret))
+		h.Write([]byte(purpose))
+		return h.Sum(nil)
+	}
+	return envKey{cipher: derive("encryption"), mac: derive("authentication")}
+}
+
+// envKeys returns the keys used to encrypt and decrypt private environment
+// variables. The first key, from the env-encryption:key setting, is used to
+// encrypt values. The key in env-encryption:previous-key, if present, is only
+// used to decrypt values, while they're re-encrypted with the new key (see
+// ReencryptEnvs).
+func envKeys() []envKey {
+	var keys []envKey
+	if secret, err := config.GetString("env-encryption:key"); err == nil && secret != "" {
+		keys = append(keys, deriveKey(secret))
+		if previous, err := config.GetString("env-encryption:previous-key"); err == nil && previous != "" {
+			keys = append(keys, deriveKey(previous))
+		}
+	}
+	return keys
+}
+
+func (k envKey) sum(data []byte) []byte {
+	h := hmac.New(sha256.New, k.mac)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// encryptValue encrypts the value of a private environment variable with the
+// current key. When no key is configured, the value is returned unchanged.
+func encryptValue(value string) (string, error) {
+	keys := envKeys()
+	if len(keys) == 0 {
+		return value, nil
+	}
+	block, err := aes.NewCipher(keys[0].cipher)
+	if err != nil {
+		return "", err
+	}
+	data := make([]byte, aes.BlockSize+len(value))
+	iv := data[:aes.BlockSize]
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return "", err
+	}
+	cipher.NewCTR(block, iv).XORKeyStream(data[aes.BlockSize:], []byte(value))
+	data = append(data, keys[0].sum(data)...)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// decryptValue decrypts a value encrypted by encryptValue, trying all
+// configured keys. Values that are not encrypted are returned unchanged.
+func decryptValue(value string) (string, error) {
+	if !strings.HasPrefix(value, encryptedPrefix) {
+		return value, nil
+	}
+	keys := envKeys()
+	if len(keys) == 0 {
+		return "", ErrNoEncryptionKey
+	}
+	data, err := base64.StdEncoding.DecodeString(value[len(encryptedPrefix):])
+	if err != nil || len(data) < aes.BlockSize+sha256.Size {
+		return "", ErrDecrypt
+	}
+	payload, sum := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+	for _, key := range keys {
+		if subtle.ConstantTimeCompare(key.sum(payload), sum) != 1 {
+			continue
+		}
+		block, err := aes.NewCipher(key.cipher)
+		if err != nil {
+			return "", err
+		}
+		plain := make([]byte, len(payload)-aes.BlockSize)
+		cipher.NewCTR(block, payload[:aes.BlockSize]).XORKeyStream(plain, payload[aes.BlockSize:])
+		return string(plain), nil
+	}
+	return "", ErrDecrypt
+}
+
+// envValue returns the plain text value of the given environment variable,
+// decrypting it if needed.
+func envValue(env bind.EnvVar) (string, error) {
+	if env.Public {
+		return env.Value, nil
+	}
+	return decryptValue(env.Value)
+}
+
+// ReencryptEnvs encrypts the private environment variables of all apps with
+// the current key, from the env-encryption:key setting. Values encrypted with
+// the key in env-encryption:previous-key, and values stored in plain text, are
+// re-encrypted, so the previous key may be removed from the configuration
+// once it finishes.
+//
+// Apps are locked while their variables are re-encrypted. Locked apps are
+// skipped, and reported in the returned error, so the operation can be
+// retried later. Progress is reported to w.
+func ReencryptEnvs(w io.Writer, owner string) error {
+	if len(envKeys()) == 0 {
+		return errors.New("The env-encryption:key setting is missing.")
+	}
+	var apps []App
+	if err := db.Session.Apps().Find(nil).All(&apps); err != nil {
+		return err
+	}
+	var failures []string
+	for _, a := range apps {
+		n, err := a.reencryptEnvs(owner)
+		if err != nil {
+			fmt.Fprintf(w, "Failed to re-encrypt the variables of the app %q: %s\n", a.Name, err)
+			failures = append(failures, a.Name)
+			continue
+		}
+		fmt.Fprintf(w, "Re-encrypted %d variables of the app %q.\n", n, a.Name)
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("Failed to re-encrypt the variables of %d apps: %s.", len(failures), strings.Join(failures, ", "))
+	}
+	return nil
+}
+
+func (a *App) reencryptEnvs(owner string) (int, error) {
+	lock, err := AcquireLock(a.Name, owner, "re-encrypt envs")
+	if err != nil {
+		return 0, err
+	}
+	defer lock.Release()
+	if err := a.Get(); err != nil {
+		return 0, err
+	}
+	var n int
+	for name, env := range a.Env {
+		if env.Public {
+			continue
+		}
+		value, err := decryptValue(env.Value)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %s", name, err)
+		}
+		if env.Value, err = encryptValue(value); err != nil {
+			return 0, err
+		}
+		a.Env[name] = env
+		n++
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, db.Session.Apps().Update(bson.M{"name": a.Name}, bson.M{"$set": bson.M{"env": a.Env}})
+}
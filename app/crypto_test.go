@@ -0,0 +1,172 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/api/bind"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/provision"
+	"labix.org/v2/mgo/bson"
+	. "launchpad.net/gocheck"
+	"strings"
+)
+
+func (s *S) TestEncryptValue(c *C) {
+	config.Set("env-encryption:key", "some-key")
+	defer config.Unset("env-encryption")
+	encrypted, err := encryptValue("s3cr3t")
+	c.Assert(err, IsNil)
+	c.Assert(strings.HasPrefix(encrypted, encryptedPrefix), Equals, true)
+	c.Assert(strings.Contains(encrypted, "s3cr3t"), Equals, false)
+	other, err := encryptValue("s3cr3t")
+	c.Assert(err, IsNil)
+	c.Assert(other, Not(Equals), encrypted)
+	value, err := decryptValue(encrypted)
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, "s3cr3t")
+}
+
+func (s *S) TestEncryptValueWithoutKey(c *C) {
+	encrypted, err := encryptValue("s3cr3t")
+	c.Assert(err, IsNil)
+	c.Assert(encrypted, Equals, "s3cr3t")
+}
+
+func (s *S) TestDecryptValueInPlainText(c *C) {
+	value, err := decryptValue("s3cr3t")
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, "s3cr3t")
+}
+
+func (s *S) TestDecryptValueWithoutKey(c *C) {
+	config.Set("env-encryption:key", "some-key")
+	encrypted, err := encryptValue("s3cr3t")
+	config.Unset("env-encryption")
+	c.Assert(err, IsNil)
+	_, err = decryptValue(encrypted)
+	c.Assert(err, Equals, ErrNoEncryptionKey)
+}
+
+func (s *S) TestDecryptValueWithWrongKeyOrCorruptedValue(c *C) {
+	config.Set("env-encryption:key", "some-key")
+	defer config.Unset("env-encryption")
+	encrypted, err := encryptValue("s3cr3t")
+	c.Assert(err, IsNil)
+	corrupted := encrypted[:len(encrypted)-4] + "AAA="
+	_, err = decryptValue(corrupted)
+	c.Assert(err, Equals, ErrDecrypt)
+	_, err = decryptValue(encryptedPrefix + "not base64!")
+	c.Assert(err, Equals, ErrDecrypt)
+	config.Set("env-encryption:key", "other-key")
+	_, err = decryptValue(encrypted)
+	c.Assert(err, Equals, ErrDecrypt)
+}
+
+func (s *S) TestDecryptValueWithPreviousKey(c *C) {
+	config.Set("env-encryption:key", "old-key")
+	defer config.Unset("env-encryption")
+	encrypted, err := encryptValue("s3cr3t")
+	c.Assert(err, IsNil)
+	config.Set("env-encryption:key", "new-key")
+	config.Set("env-encryption:previous-key", "old-key")
+	value, err := decryptValue(encrypted)
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, "s3cr3t")
+}
+
+func (s *S) TestSetEnvsToAppEncryptsPrivateVariables(c *C) {
+	config.Set("env-encryption:key", "some-key")
+	defer config.Unset("env-encryption")
+	s.provisioner.PrepareOutput(nil)
+	a := App{Name: "cryptic", State: string(provision.StatusStarted)}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	envs := []bind.EnvVar{
+		{Name: "SECRET", Value: "s3cr3t", Public: false},
+		{Name: "PUBLIC", Value: "visible", Public: true},
+	}
+	err = a.SetEnvsToApp(envs, false, false, "someone@tsuru.io")
+	c.Assert(err, IsNil)
+	var stored App
+	err = db.Session.Apps().Find(bson.M{"name": a.Name}).One(&stored)
+	c.Assert(err, IsNil)
+	c.Assert(stored.Env["PUBLIC"].Value, Equals, "visible")
+	c.Assert(strings.HasPrefix(stored.Env["SECRET"].Value, encryptedPrefix), Equals, true)
+	cmds := s.provisioner.GetCmds("", &a)
+	c.Assert(cmds, HasLen, 1)
+	c.Assert(cmds[0].Cmd, Matches, `(?s).*export SECRET='\\''s3cr3t'\\''.*`)
+}
+
+func (s *S) TestReencryptEnvs(c *C) {
+	config.Set("env-encryption:key", "old-key")
+	defer config.Unset("env-encryption")
+	encrypted, err := encryptValue("s3cr3t")
+	c.Assert(err, IsNil)
+	apps := []App{
+		{
+			Name: "cryptic",
+			Env: map[string]bind.EnvVar{
+				"SECRET": {Name: "SECRET", Value: encrypted},
+				"PLAIN":  {Name: "PLAIN", Value: "legacy"},
+				"PUBLIC": {Name: "PUBLIC", Value: "visible", Public: true},
+			},
+		},
+		{Name: "plain"},
+	}
+	for _, a := range apps {
+		err = db.Session.Apps().Insert(a)
+		c.Assert(err, IsNil)
+		defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	}
+	config.Set("env-encryption:key", "new-key")
+	config.Set("env-encryption:previous-key", "old-key")
+	var buf bytes.Buffer
+	err = ReencryptEnvs(&buf, "admin@tsuru.io")
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Matches, `(?s).*Re-encrypted 2 variables of the app "cryptic".*`)
+	config.Unset("env-encryption:previous-key")
+	a := App{Name: "cryptic"}
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Env["PUBLIC"].Value, Equals, "visible")
+	for name, expected := range map[string]string{"SECRET": "s3cr3t", "PLAIN": "legacy"} {
+		c.Assert(strings.HasPrefix(a.Env[name].Value, encryptedPrefix), Equals, true)
+		value, err := decryptValue(a.Env[name].Value)
+		c.Assert(err, IsNil)
+		c.Assert(value, Equals, expected)
+	}
+	_, err = GetLock("cryptic")
+	c.Assert(err, NotNil)
+}
+
+func (s *S) TestReencryptEnvsSkipsLockedApps(c *C) {
+	config.Set("env-encryption:key", "some-key")
+	defer config.Unset("env-encryption")
+	a := App{
+		Name: "cryptic",
+		Env:  map[string]bind.EnvVar{"SECRET": {Name: "SECRET", Value: "legacy"}},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	lock, err := AcquireLock(a.Name, "someone@tsuru.io", "deploy")
+	c.Assert(err, IsNil)
+	defer lock.Release()
+	var buf bytes.Buffer
+	err = ReencryptEnvs(&buf, "admin@tsuru.io")
+	c.Assert(err, ErrorMatches, `Failed to re-encrypt the variables of 1 apps: cryptic.`)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Env["SECRET"].Value, Equals, "legacy")
+}
+
+func (s *S) TestReencryptEnvsWithoutKey(c *C) {
+	var buf bytes.Buffer
+	err := ReencryptEnvs(&buf, "admin@tsuru.io")
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,113 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"errors"
+	"fmt"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/provision"
+	"io"
+	"labix.org/v2/mgo/bson"
+	"time"
+)
+
+// archivePath is the path where archives are uploaded to in the units, before
+// being extracted.
+const archivePath = "/home/application/archive.tar.gz"
+
+// ErrArchiveNotSupported is returned by ExtractArchive when the provisioner of
+// the app is not able to upload files to its units.
+var ErrArchiveNotSupported = errors.New("The provisioner of the app does not support deploys of archives.")
+
+// Deploy is the record of a deploy of an app: the update of the code in the
+// units of the app, the installation of its dependencies and the restart.
+type Deploy struct {
+	Id       bson.ObjectId `bson:"_id"`
+	App      string
+	Commit   string
+	User     string
+	Start    time.Time
+	End      time.Time
+	Duration time.Duration
+	Output   string
+	Success  bool
+	Error    string
+}
+
+// NewDeploy starts the record of a deploy of the app, triggered by the given
+// user. The deploy is saved in the database when it finishes.
+func NewDeploy(a *App, user string) *Deploy {
+	return &Deploy{
+		Id:    bson.NewObjectId(),
+		App:   a.Name,
+		User:  user,
+		Start: time.Now(),
+	}
+}
+
+// Finish saves the deploy in the database, along with its output and result.
+// A nil err means that the deploy succeeded.
+func (d *Deploy) Finish(output string, err error) error {
+	d.End = time.Now()
+	d.Duration = d.End.Sub(d.Start)
+	d.Output = output
+	d.Success = err == nil
+	if err != nil {
+		d.Error = err.Error()
+	}
+	return db.Session.Deploys().Insert(d)
+}
+
+// ListDeploys returns the deploys of the app, most recent first. The output
+// of the deploys is omitted.
+func ListDeploys(a *App) ([]Deploy, error) {
+	var deploys []Deploy
+	err := db.Session.Deploys().Find(bson.M{"app": a.Name}).Select(bson.M{"output": 0}).Sort("-start").All(&deploys)
+	return deploys, err
+}
+
+// GetDeploy returns the deploy of the app identified by the given id.
+func GetDeploy(a *App, id string) (*Deploy, error) {
+	if !bson.IsObjectIdHex(id) {
+		return nil, errors.New("Deploy not found.")
+	}
+	var d Deploy
+	err := db.Session.Deploys().Find(bson.M{"_id": bson.ObjectIdHex(id), "app": a.Name}).One(&d)
+	if err != nil {
+		return nil, errors.New("Deploy not found.")
+	}
+	return &d, nil
+}
+
+// ExtractArchive uploads the given gzipped tarball to the units of the app and
+// extracts it, replacing the code in /home/application/current. The code is
+// kept when the archive can't be extracted. The extracted code is not a
+// repository, so the next deploy from git clones the repository again (see
+// repository.CloneOrPull).
+//
+// The provisioner of the app must be a provision.Uploader, otherwise
+// ErrArchiveNotSupported is returned.
+func (a *App) ExtractArchive(archive io.Reader, w io.Writer) error {
+	p, err := a.getProvisioner()
+	if err != nil {
+		return err
+	}
+	uploader, ok := p.(provision.Uploader)
+	if !ok {
+		return ErrArchiveNotSupported
+	}
+	err = uploader.Upload(a, archive, archivePath)
+	if err != nil {
+		return err
+	}
+	// The archive is extracted to a temporary directory, which replaces the
+	// current code only if the extraction succeeds.
+	current := "/home/application/current"
+	tmp := current + ".new"
+	cmd := fmt.Sprintf("rm -rf %s && mkdir -p %s && tar -xzf %s -C %s && rm -rf %s && mv %s %s; status=$?; rm -rf %s %s; exit $status",
+		tmp, tmp, archivePath, tmp, current, tmp, current, archivePath, tmp)
+	return p.ExecuteCommand(w, w, a, cmd)
+}
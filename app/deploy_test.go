@@ -0,0 +1,133 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	"errors"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/provision"
+	"github.com/globocom/tsuru/testing"
+	"labix.org/v2/mgo/bson"
+	. "launchpad.net/gocheck"
+	"strings"
+	"time"
+)
+
+func (s *S) TestNewDeploy(c *C) {
+	a := App{Name: "kyuss"}
+	d := NewDeploy(&a, "josh@kyuss.com")
+	c.Assert(d.Id.Valid(), Equals, true)
+	c.Assert(d.App, Equals, "kyuss")
+	c.Assert(d.User, Equals, "josh@kyuss.com")
+	c.Assert(d.Start.IsZero(), Equals, false)
+}
+
+func (s *S) TestDeployFinish(c *C) {
+	a := App{Name: "kyuss"}
+	d := NewDeploy(&a, "josh@kyuss.com")
+	d.Commit = "8e0f1ab1fc30b7e7aea8e7f6fc5f1a7d9e4ad3d1"
+	err := d.Finish("deployed", nil)
+	c.Assert(err, IsNil)
+	defer db.Session.Deploys().RemoveId(d.Id)
+	var stored Deploy
+	err = db.Session.Deploys().FindId(d.Id).One(&stored)
+	c.Assert(err, IsNil)
+	c.Assert(stored.App, Equals, "kyuss")
+	c.Assert(stored.Commit, Equals, "8e0f1ab1fc30b7e7aea8e7f6fc5f1a7d9e4ad3d1")
+	c.Assert(stored.Output, Equals, "deployed")
+	c.Assert(stored.Success, Equals, true)
+	c.Assert(stored.Error, Equals, "")
+	c.Assert(stored.Duration, Equals, stored.End.Sub(stored.Start))
+}
+
+func (s *S) TestDeployFinishWithError(c *C) {
+	a := App{Name: "kyuss"}
+	d := NewDeploy(&a, "josh@kyuss.com")
+	err := d.Finish("failed", errors.New("exit status 1"))
+	c.Assert(err, IsNil)
+	defer db.Session.Deploys().RemoveId(d.Id)
+	var stored Deploy
+	err = db.Session.Deploys().FindId(d.Id).One(&stored)
+	c.Assert(err, IsNil)
+	c.Assert(stored.Success, Equals, false)
+	c.Assert(stored.Error, Equals, "exit status 1")
+}
+
+func (s *S) TestListDeploys(c *C) {
+	a := App{Name: "kyuss"}
+	now := time.Now()
+	deploys := []Deploy{
+		{Id: bson.NewObjectId(), App: "kyuss", Start: now.Add(-2 * time.Hour), Output: "first"},
+		{Id: bson.NewObjectId(), App: "kyuss", Start: now.Add(-time.Hour), Output: "second"},
+		{Id: bson.NewObjectId(), App: "fu_manchu", Start: now, Output: "other"},
+	}
+	for _, d := range deploys {
+		err := db.Session.Deploys().Insert(d)
+		c.Assert(err, IsNil)
+	}
+	defer db.Session.Deploys().RemoveAll(nil)
+	result, err := ListDeploys(&a)
+	c.Assert(err, IsNil)
+	c.Assert(result, HasLen, 2)
+	c.Assert(result[0].Id, Equals, deploys[1].Id)
+	c.Assert(result[1].Id, Equals, deploys[0].Id)
+	c.Assert(result[0].Output, Equals, "")
+}
+
+func (s *S) TestGetDeploy(c *C) {
+	a := App{Name: "kyuss"}
+	d := Deploy{Id: bson.NewObjectId(), App: "kyuss", Commit: "abc123"}
+	err := db.Session.Deploys().Insert(d)
+	c.Assert(err, IsNil)
+	defer db.Session.Deploys().RemoveId(d.Id)
+	got, err := GetDeploy(&a, d.Id.Hex())
+	c.Assert(err, IsNil)
+	c.Assert(got.Commit, Equals, "abc123")
+}
+
+func (s *S) TestGetDeployFromAnotherApp(c *C) {
+	a := App{Name: "kyuss"}
+	d := Deploy{Id: bson.NewObjectId(), App: "fu_manchu"}
+	err := db.Session.Deploys().Insert(d)
+	c.Assert(err, IsNil)
+	defer db.Session.Deploys().RemoveId(d.Id)
+	_, err = GetDeploy(&a, d.Id.Hex())
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "Deploy not found.")
+}
+
+func (s *S) TestGetDeployInvalidId(c *C) {
+	a := App{Name: "kyuss"}
+	_, err := GetDeploy(&a, "not-an-id")
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "Deploy not found.")
+}
+
+func (s *S) TestExtractArchive(c *C) {
+	p := testing.NewExtensibleFakeProvisioner()
+	provision.Register("extensible", p)
+	p.PrepareOutput([]byte("extracted"))
+	a := App{Name: "kyuss", Provisioner: "extensible", State: string(provision.StatusStarted)}
+	err := p.Provision(&a)
+	c.Assert(err, IsNil)
+	defer p.Destroy(&a)
+	var buf bytes.Buffer
+	err = a.ExtractArchive(strings.NewReader("archive"), &buf)
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, "extracted")
+	c.Assert(string(p.Uploaded(&a, archivePath)), Equals, "archive")
+	cmds := p.GetCmds("", &a)
+	c.Assert(cmds, HasLen, 1)
+	c.Assert(cmds[0].Cmd, Matches, "^rm -rf /home/application/current.new && mkdir -p /home/application/current.new && tar -xzf /home/application/archive.tar.gz -C /home/application/current.new && rm -rf /home/application/current && mv /home/application/current.new /home/application/current;.*")
+}
+
+func (s *S) TestExtractArchiveProvisionerIsNotAnUploader(c *C) {
+	a := App{Name: "kyuss", State: string(provision.StatusStarted)}
+	var buf bytes.Buffer
+	err := a.ExtractArchive(strings.NewReader("archive"), &buf)
+	c.Assert(err, Equals, ErrArchiveNotSupported)
+	c.Assert(s.provisioner.GetCmds("", &a), HasLen, 0)
+}
@@ -0,0 +1,164 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"errors"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/drain"
+	"github.com/globocom/tsuru/log"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"sync"
+	"time"
+)
+
+// drainsTTL is how long forwardLogs caches the drains of an app. Drains added
+// or removed by other tsuru processes are seen after this interval.
+var drainsTTL = 10 * time.Second
+
+// cachedDrains are the URLs of the drains of an app, cached by forwardLogs.
+type cachedDrains struct {
+	urls    []string
+	expires time.Time
+}
+
+var (
+	drainsCache    = make(map[string]cachedDrains)
+	drainsCacheMut sync.Mutex
+)
+
+// ErrDrainExists is returned when adding a drain that the app already has.
+var ErrDrainExists = errors.New("The app already has this drain.")
+
+// ErrDrainNotFound is returned when removing a drain that the app doesn't
+// have.
+var ErrDrainNotFound = errors.New("Drain not found.")
+
+// Drain is an external endpoint to which the logs of an app are forwarded.
+// See the drain package for the supported URLs.
+type Drain struct {
+	AppName string
+	Url     string
+}
+
+// AddDrain adds a drain to the app. Entries logged from now on are forwarded
+// to it.
+func (a *App) AddDrain(url string) error {
+	if err := drain.Validate(url); err != nil {
+		return &ValidationError{Message: err.Error()}
+	}
+	n, err := db.Session.Drains().Find(bson.M{"appname": a.Name, "url": url}).Count()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrDrainExists
+	}
+	if err := db.Session.Drains().Insert(Drain{AppName: a.Name, Url: url}); err != nil {
+		return err
+	}
+	forgetDrains(a.Name)
+	return nil
+}
+
+// Drains returns the drains of the app.
+func (a *App) Drains() ([]Drain, error) {
+	var drains []Drain
+	err := db.Session.Drains().Find(bson.M{"appname": a.Name}).Sort("url").All(&drains)
+	return drains, err
+}
+
+// RemoveDrain removes a drain from the app. Entries already buffered for the
+// drain are still delivered.
+func (a *App) RemoveDrain(url string) error {
+	err := db.Session.Drains().Remove(bson.M{"appname": a.Name, "url": url})
+	if err == mgo.ErrNotFound {
+		return ErrDrainNotFound
+	}
+	if err != nil {
+		return err
+	}
+	forgetDrains(a.Name)
+	stopDrain(url)
+	return nil
+}
+
+func (a *App) removeDrains() error {
+	drains, err := a.Drains()
+	if err != nil {
+		return err
+	}
+	if _, err := db.Session.Drains().RemoveAll(bson.M{"appname": a.Name}); err != nil {
+		return err
+	}
+	forgetDrains(a.Name)
+	for _, d := range drains {
+		stopDrain(d.Url)
+	}
+	return nil
+}
+
+// stopDrain stops forwarding entries to the drain, unless other apps still
+// use it.
+func stopDrain(url string) {
+	n, err := db.Session.Drains().Find(bson.M{"url": url}).Count()
+	if err == nil && n == 0 {
+		drain.Stop(url)
+	}
+}
+
+// drainURLs returns the URLs of the drains of the app, cached for drainsTTL.
+func (a *App) drainURLs() ([]string, error) {
+	drainsCacheMut.Lock()
+	cached, ok := drainsCache[a.Name]
+	drainsCacheMut.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.urls, nil
+	}
+	drains, err := a.Drains()
+	if err != nil {
+		return nil, err
+	}
+	urls := make([]string, len(drains))
+	for i, d := range drains {
+		urls[i] = d.Url
+	}
+	drainsCacheMut.Lock()
+	drainsCache[a.Name] = cachedDrains{urls: urls, expires: time.Now().Add(drainsTTL)}
+	drainsCacheMut.Unlock()
+	return urls, nil
+}
+
+// forgetDrains removes the drains of the app from the cache.
+func forgetDrains(appName string) {
+	drainsCacheMut.Lock()
+	delete(drainsCache, appName)
+	drainsCacheMut.Unlock()
+}
+
+// forwardLogs forwards the given entries to the drains of the app. Failures
+// are written to the tsuru log, they never affect the caller.
+func (a *App) forwardLogs(logs []Applog) {
+	urls, err := a.drainURLs()
+	if err != nil {
+		log.Error("Failed to list the drains of the app.", "app", a.Name, "error", err)
+		return
+	}
+	if len(urls) == 0 {
+		return
+	}
+	entries := make([]drain.Entry, len(logs))
+	for i, l := range logs {
+		entries[i] = drain.Entry{
+			Date:    l.Date,
+			Message: l.Message,
+			Source:  l.Source,
+			AppName: l.AppName,
+			Unit:    l.Unit,
+		}
+	}
+	drain.Forward(urls, entries)
+}
@@ -0,0 +1,138 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"encoding/json"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/drain"
+	"io/ioutil"
+	"labix.org/v2/mgo/bson"
+	. "launchpad.net/gocheck"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+func (s *S) TestAddDrain(c *C) {
+	a := App{Name: "ancient"}
+	err := a.AddDrain("syslog://logs.example.com:514")
+	c.Assert(err, IsNil)
+	var d Drain
+	err = db.Session.Drains().Find(bson.M{"appname": "ancient"}).One(&d)
+	c.Assert(err, IsNil)
+	c.Assert(d, DeepEquals, Drain{AppName: "ancient", Url: "syslog://logs.example.com:514"})
+}
+
+func (s *S) TestAddDrainInvalidUrl(c *C) {
+	a := App{Name: "ancient"}
+	err := a.AddDrain("ftp://logs.example.com")
+	c.Assert(err, NotNil)
+	e, ok := err.(*ValidationError)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Message, Equals, drain.Validate("ftp://logs.example.com").Error())
+	n, err := db.Session.Drains().Find(nil).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+}
+
+func (s *S) TestAddDrainTwice(c *C) {
+	a := App{Name: "ancient"}
+	err := a.AddDrain("syslog://logs.example.com:514")
+	c.Assert(err, IsNil)
+	err = a.AddDrain("syslog://logs.example.com:514")
+	c.Assert(err, Equals, ErrDrainExists)
+	other := App{Name: "modern"}
+	err = other.AddDrain("syslog://logs.example.com:514")
+	c.Assert(err, IsNil)
+}
+
+func (s *S) TestDrains(c *C) {
+	a := App{Name: "ancient"}
+	err := a.AddDrain("syslog://logs.example.com:514")
+	c.Assert(err, IsNil)
+	err = a.AddDrain("https://logs.example.com/drain")
+	c.Assert(err, IsNil)
+	other := App{Name: "modern"}
+	err = other.AddDrain("syslog+udp://logs.example.com:514")
+	c.Assert(err, IsNil)
+	drains, err := a.Drains()
+	c.Assert(err, IsNil)
+	expected := []Drain{
+		{AppName: "ancient", Url: "https://logs.example.com/drain"},
+		{AppName: "ancient", Url: "syslog://logs.example.com:514"},
+	}
+	c.Assert(drains, DeepEquals, expected)
+}
+
+func (s *S) TestRemoveDrain(c *C) {
+	a := App{Name: "ancient"}
+	err := a.AddDrain("syslog://logs.example.com:514")
+	c.Assert(err, IsNil)
+	err = a.RemoveDrain("syslog://logs.example.com:514")
+	c.Assert(err, IsNil)
+	drains, err := a.Drains()
+	c.Assert(err, IsNil)
+	c.Assert(drains, HasLen, 0)
+}
+
+func (s *S) TestRemoveDrainNotFound(c *C) {
+	a := App{Name: "ancient"}
+	err := a.RemoveDrain("syslog://logs.example.com:514")
+	c.Assert(err, Equals, ErrDrainNotFound)
+}
+
+func (s *S) TestLogForwardsToDrains(c *C) {
+	received := make(chan []drain.Entry, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var entries []drain.Entry
+		b, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(b, &entries)
+		received <- entries
+	}))
+	defer server.Close()
+	a := App{Name: "ancient"}
+	// AddDrain rejects local addresses.
+	err := db.Session.Drains().Insert(Drain{AppName: a.Name, Url: server.URL})
+	c.Assert(err, IsNil)
+	forgetDrains(a.Name)
+	defer a.RemoveDrain(server.URL)
+	err = a.Log("first\nsecond", "app")
+	c.Assert(err, IsNil)
+	var entries []drain.Entry
+	for len(entries) < 2 {
+		select {
+		case batch := <-received:
+			entries = append(entries, batch...)
+		case <-time.After(5 * time.Second):
+			c.Fatal("Timed out waiting for the drain to receive the entries.")
+		}
+	}
+	c.Assert(entries, HasLen, 2)
+	c.Assert(entries[0].Message, Equals, "first")
+	c.Assert(entries[0].Source, Equals, "app")
+	c.Assert(entries[0].AppName, Equals, "ancient")
+	c.Assert(entries[1].Message, Equals, "second")
+}
+
+func (s *S) TestDrainURLsAreCached(c *C) {
+	a := App{Name: "ancient"}
+	err := a.AddDrain("syslog://logs.example.com:514")
+	c.Assert(err, IsNil)
+	defer a.removeDrains()
+	urls, err := a.drainURLs()
+	c.Assert(err, IsNil)
+	c.Assert(urls, DeepEquals, []string{"syslog://logs.example.com:514"})
+	err = db.Session.Drains().Insert(Drain{AppName: a.Name, Url: "https://logs.example.com/drain"})
+	c.Assert(err, IsNil)
+	urls, err = a.drainURLs()
+	c.Assert(err, IsNil)
+	c.Assert(urls, DeepEquals, []string{"syslog://logs.example.com:514"})
+	err = a.RemoveDrain("syslog://logs.example.com:514")
+	c.Assert(err, IsNil)
+	urls, err = a.drainURLs()
+	c.Assert(err, IsNil)
+	c.Assert(urls, DeepEquals, []string{"https://logs.example.com/drain"})
+}
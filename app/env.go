@@ -0,0 +1,95 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/globocom/tsuru/api/bind"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+var envNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// InvalidEnvError is returned when the name of an environment variable is not
+// valid. Names must start with a letter or an underscore, followed by letters,
+// digits and underscores.
+type InvalidEnvError struct {
+	Name string
+}
+
+func (e *InvalidEnvError) Error() string {
+	return fmt.Sprintf("Invalid environment variable name: %q. Names must start with a letter or an underscore, followed by letters, digits and underscores.", e.Name)
+}
+
+// ValidateEnvName checks the name of an environment variable, returning an
+// *InvalidEnvError if it's not valid.
+func ValidateEnvName(name string) error {
+	if !envNameRegexp.MatchString(name) {
+		return &InvalidEnvError{Name: name}
+	}
+	return nil
+}
+
+func validateEnvs(envs []bind.EnvVar) error {
+	for _, env := range envs {
+		if err := ValidateEnvName(env.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shellQuote quotes s as a single word for the shell. Nothing is expanded
+// inside single quotes, so the only character that needs care is the single
+// quote itself.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// apprc returns the content of the apprc file of the app: one export
+// statement per environment variable, sorted by name, with private values
+// decrypted. Variables with invalid names are skipped.
+func (a *App) apprc() (string, error) {
+	names := make([]string, 0, len(a.Env))
+	for name := range a.Env {
+		if ValidateEnvName(name) == nil {
+			names = append(names, name)
+		} else {
+			a.Log(fmt.Sprintf("Skipping environment variable with invalid name: %q.", name), "tsuru")
+		}
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# generated by tsuru at %s\n", time.Now().Format(time.RFC822Z))
+	for _, name := range names {
+		value, err := envValue(a.Env[name])
+		if err != nil {
+			return "", fmt.Errorf("Failed to decrypt %s: %s", name, err)
+		}
+		fmt.Fprintf(&buf, "export %s=%s\n", name, shellQuote(value))
+	}
+	return buf.String(), nil
+}
+
+// privateValues returns the values of the private environment variables of
+// the app, both in plain text and as stored, so they can be redacted from
+// logs.
+func (a *App) privateValues() []string {
+	var values []string
+	for _, env := range a.Env {
+		if env.Public {
+			continue
+		}
+		values = append(values, env.Value)
+		if value, err := envValue(env); err == nil && value != env.Value {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -0,0 +1,123 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"github.com/globocom/tsuru/api/bind"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/provision"
+	"io/ioutil"
+	"labix.org/v2/mgo/bson"
+	. "launchpad.net/gocheck"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// hostileValues are values that used to corrupt the apprc file, or to inject
+// shell code in the units of the app.
+var hostileValues = []string{
+	`he said "hi"`,
+	`$HOME and ${PATH} and $(touch /tmp/pwned) and ` + "`touch /tmp/pwned`",
+	"first line\nEND\nrm -rf /\n",
+	`it's`,
+	`'\''`,
+	`back\slash\`,
+	"tab\tand trailing newline\n",
+	"%s %d %%",
+	"",
+}
+
+func (s *S) TestValidateEnvName(c *C) {
+	for _, name := range []string{"DATABASE_HOST", "http_proxy", "_PRIVATE", "EC2_HOST", "a"} {
+		c.Assert(ValidateEnvName(name), IsNil)
+	}
+	for _, name := range []string{"", "2FAST", "FOO-BAR", "A B", "A=1", "A;rm -rf /", "$HOME", "NAME\n"} {
+		err := ValidateEnvName(name)
+		c.Assert(err, NotNil)
+		e, ok := err.(*InvalidEnvError)
+		c.Assert(ok, Equals, true)
+		c.Assert(e.Name, Equals, name)
+	}
+}
+
+func (s *S) TestShellQuote(c *C) {
+	for _, value := range hostileValues {
+		out, err := exec.Command("/bin/sh", "-c", "printf '%s' "+shellQuote(value)).Output()
+		c.Assert(err, IsNil)
+		c.Assert(string(out), Equals, value)
+	}
+}
+
+func (s *S) TestSerializeEnvVarsWithHostileValues(c *C) {
+	dir, err := ioutil.TempDir("", "apprc")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	apprc := path.Join(dir, "apprc")
+	for _, value := range hostileValues {
+		s.provisioner.PrepareOutput(nil)
+		a := App{
+			Name:  "hostile",
+			State: string(provision.StatusStarted),
+			Env: map[string]bind.EnvVar{
+				"HOSTILE": {Name: "HOSTILE", Value: value},
+				"OTHER":   {Name: "OTHER", Value: "other"},
+			},
+		}
+		err := a.SerializeEnvVars()
+		c.Assert(err, IsNil)
+		cmds := s.provisioner.GetCmds("", &a)
+		c.Assert(cmds, HasLen, 1)
+		cmd := strings.Replace(cmds[0].Cmd, "/home/application/apprc", apprc, -1)
+		s.provisioner.Reset()
+		err = exec.Command("/bin/sh", "-c", cmd).Run()
+		c.Assert(err, IsNil)
+		out, err := exec.Command("/bin/sh", "-c", `. `+apprc+` && printf '%s|%s' "$HOSTILE" "$OTHER"`).Output()
+		c.Assert(err, IsNil)
+		c.Assert(string(out), Equals, value+"|other")
+	}
+	_, err = os.Stat("/tmp/pwned")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *S) TestApprcSkipsInvalidNames(c *C) {
+	a := App{
+		Name: "hostile",
+		Env: map[string]bind.EnvVar{
+			"VALID":                 {Name: "VALID", Value: "1"},
+			"A=1; touch /tmp/pwned": {Name: "A=1; touch /tmp/pwned", Value: "2"},
+		},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	content, err := a.apprc()
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(content, "export VALID='1'\n"), Equals, true)
+	c.Assert(strings.Contains(content, "pwned"), Equals, false)
+}
+
+func (s *S) TestSetEnvsToAppRejectsInvalidNames(c *C) {
+	a := App{
+		Name: "hostile",
+		Env:  map[string]bind.EnvVar{},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	envs := []bind.EnvVar{
+		{Name: "VALID", Value: "1", Public: true},
+		{Name: "NOT-VALID", Value: "2", Public: true},
+	}
+	err = a.SetEnvsToApp(envs, true, false, "someone@tsuru.io")
+	c.Assert(err, NotNil)
+	e, ok := err.(*InvalidEnvError)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Name, Equals, "NOT-VALID")
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Env, HasLen, 0)
+}
@@ -0,0 +1,157 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"errors"
+	"github.com/globocom/tsuru/api/bind"
+	"github.com/globocom/tsuru/db"
+	"labix.org/v2/mgo/bson"
+	"sort"
+	"time"
+)
+
+// envSystemUser is the user recorded in revisions made by tsuru itself, like
+// the ones made when binding apps to services.
+const envSystemUser = "tsuru"
+
+// ErrEnvRevisionNotFound is returned when the given revision of the
+// environment variables of an app does not exist.
+var ErrEnvRevisionNotFound = errors.New("Revision not found.")
+
+// EnvRevision is the record of a change in the environment variables of an
+// app. Revisions are numbered per app, starting at 1.
+//
+// Added, Changed and Removed hold the names of the variables affected by the
+// change. Values are never recorded for private variables: Public holds the
+// values of all public variables of the app after the change, which is what
+// RollbackEnvs restores.
+type EnvRevision struct {
+	Id       bson.ObjectId `bson:"_id" json:"-"`
+	App      string
+	Revision int
+	User     string
+	Date     time.Time
+	Added    []string
+	Changed  []string
+	Removed  []string
+	Public   map[string]string
+}
+
+func copyEnvs(envs map[string]bind.EnvVar) map[string]bind.EnvVar {
+	c := make(map[string]bind.EnvVar, len(envs))
+	for name, env := range envs {
+		c[name] = env
+	}
+	return c
+}
+
+func envChanged(prev, cur bind.EnvVar) bool {
+	if prev.Public != cur.Public {
+		return true
+	}
+	prevValue, err := envValue(prev)
+	if err != nil {
+		prevValue = prev.Value
+	}
+	curValue, err := envValue(cur)
+	if err != nil {
+		curValue = cur.Value
+	}
+	return prevValue != curValue
+}
+
+// recordEnvRevision compares the environment variables of the app with the
+// given old ones, and records the changes in a new revision. Nothing is
+// recorded when there are no changes. It reports whether the variables have
+// changed.
+func (a *App) recordEnvRevision(old map[string]bind.EnvVar, user string) (bool, error) {
+	rev := EnvRevision{
+		Id:     bson.NewObjectId(),
+		App:    a.Name,
+		User:   user,
+		Date:   time.Now(),
+		Public: make(map[string]string),
+	}
+	for name, env := range a.Env {
+		if env.Public {
+			rev.Public[name] = env.Value
+		}
+		if prev, ok := old[name]; !ok {
+			rev.Added = append(rev.Added, name)
+		} else if envChanged(prev, env) {
+			rev.Changed = append(rev.Changed, name)
+		}
+	}
+	for name := range old {
+		if _, ok := a.Env[name]; !ok {
+			rev.Removed = append(rev.Removed, name)
+		}
+	}
+	if len(rev.Added)+len(rev.Changed)+len(rev.Removed) == 0 {
+		return false, nil
+	}
+	sort.Strings(rev.Added)
+	sort.Strings(rev.Changed)
+	sort.Strings(rev.Removed)
+	var last EnvRevision
+	if err := db.Session.EnvRevisions().Find(bson.M{"app": a.Name}).Sort("-revision").One(&last); err == nil {
+		rev.Revision = last.Revision + 1
+	} else {
+		rev.Revision = 1
+	}
+	return true, db.Session.EnvRevisions().Insert(rev)
+}
+
+// ListEnvRevisions returns the revisions of the environment variables of the
+// app, most recent first.
+func ListEnvRevisions(a *App) ([]EnvRevision, error) {
+	var revisions []EnvRevision
+	err := db.Session.EnvRevisions().Find(bson.M{"app": a.Name}).Sort("-revision").All(&revisions)
+	return revisions, err
+}
+
+// GetEnvRevision returns the given revision of the environment variables of
+// the app.
+func GetEnvRevision(a *App, revision int) (*EnvRevision, error) {
+	var rev EnvRevision
+	err := db.Session.EnvRevisions().Find(bson.M{"app": a.Name, "revision": revision}).One(&rev)
+	if err != nil {
+		return nil, ErrEnvRevisionNotFound
+	}
+	return &rev, nil
+}
+
+// RollbackEnvs restores the public environment variables of the app to the
+// ones it had in the given revision: variables are set to the values in the
+// revision, and variables that didn't exist are removed. Private variables,
+// exported by services, are left untouched.
+//
+// The rollback is recorded as a new revision.
+func (a *App) RollbackEnvs(revision int, user string) error {
+	rev, err := GetEnvRevision(a, revision)
+	if err != nil {
+		return err
+	}
+	old := copyEnvs(a.Env)
+	for name, env := range old {
+		if _, ok := rev.Public[name]; env.Public && !ok {
+			delete(a.Env, name)
+		}
+	}
+	names := make([]string, 0, len(rev.Public))
+	for name := range rev.Public {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := rev.Public[name]
+		if env, ok := old[name]; ok && (!env.Public || env.Value == value) {
+			continue
+		}
+		a.setEnv(bind.EnvVar{Name: name, Value: value, Public: true})
+	}
+	return a.saveEnvs(old, user, false)
+}
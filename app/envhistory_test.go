@@ -0,0 +1,134 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"github.com/globocom/tsuru/api/bind"
+	"github.com/globocom/tsuru/db"
+	"labix.org/v2/mgo/bson"
+	. "launchpad.net/gocheck"
+)
+
+func (s *S) TestSetEnvsToAppRecordsARevision(c *C) {
+	a := App{
+		Name: "history",
+		Env: map[string]bind.EnvVar{
+			"DATABASE_HOST": {Name: "DATABASE_HOST", Value: "localhost", Public: true},
+			"LOG_LEVEL":     {Name: "LOG_LEVEL", Value: "debug", Public: true},
+		},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.EnvRevisions().RemoveAll(bson.M{"app": a.Name})
+	envs := []bind.EnvVar{
+		{Name: "DATABASE_HOST", Value: "remotehost", Public: true},
+		{Name: "LOG_LEVEL", Value: "debug", Public: true},
+		{Name: "DATABASE_PASSWORD", Value: "s3cr3t", Public: false},
+	}
+	err = a.SetEnvsToApp(envs, false, false, "someone@tsuru.io")
+	c.Assert(err, IsNil)
+	revisions, err := ListEnvRevisions(&a)
+	c.Assert(err, IsNil)
+	c.Assert(revisions, HasLen, 1)
+	rev := revisions[0]
+	c.Assert(rev.App, Equals, "history")
+	c.Assert(rev.Revision, Equals, 1)
+	c.Assert(rev.User, Equals, "someone@tsuru.io")
+	c.Assert(rev.Added, DeepEquals, []string{"DATABASE_PASSWORD"})
+	c.Assert(rev.Changed, DeepEquals, []string{"DATABASE_HOST"})
+	c.Assert(rev.Removed, IsNil)
+	c.Assert(rev.Public, DeepEquals, map[string]string{"DATABASE_HOST": "remotehost", "LOG_LEVEL": "debug"})
+}
+
+func (s *S) TestUnsetEnvsFromAppRecordsARevision(c *C) {
+	a := App{
+		Name: "history",
+		Env: map[string]bind.EnvVar{
+			"DATABASE_HOST": {Name: "DATABASE_HOST", Value: "localhost", Public: true},
+			"LOG_LEVEL":     {Name: "LOG_LEVEL", Value: "debug", Public: true},
+		},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.EnvRevisions().RemoveAll(bson.M{"app": a.Name})
+	err = a.SetEnvsToApp([]bind.EnvVar{{Name: "PORT", Value: "8888", Public: true}}, true, false, "someone@tsuru.io")
+	c.Assert(err, IsNil)
+	err = a.UnsetEnvsFromApp([]string{"LOG_LEVEL", "UNKNOWN"}, true, false, "other@tsuru.io")
+	c.Assert(err, IsNil)
+	err = a.UnsetEnvsFromApp([]string{"UNKNOWN"}, true, false, "other@tsuru.io")
+	c.Assert(err, IsNil)
+	revisions, err := ListEnvRevisions(&a)
+	c.Assert(err, IsNil)
+	c.Assert(revisions, HasLen, 2)
+	c.Assert(revisions[0].Revision, Equals, 2)
+	c.Assert(revisions[0].User, Equals, "other@tsuru.io")
+	c.Assert(revisions[0].Removed, DeepEquals, []string{"LOG_LEVEL"})
+	c.Assert(revisions[0].Public, DeepEquals, map[string]string{"DATABASE_HOST": "localhost", "PORT": "8888"})
+	c.Assert(revisions[1].Revision, Equals, 1)
+	c.Assert(revisions[1].Added, DeepEquals, []string{"PORT"})
+}
+
+func (s *S) TestGetEnvRevision(c *C) {
+	a := App{Name: "history"}
+	rev := EnvRevision{Id: bson.NewObjectId(), App: a.Name, Revision: 3}
+	err := db.Session.EnvRevisions().Insert(rev)
+	c.Assert(err, IsNil)
+	defer db.Session.EnvRevisions().RemoveAll(bson.M{"app": a.Name})
+	got, err := GetEnvRevision(&a, 3)
+	c.Assert(err, IsNil)
+	c.Assert(got.Id, Equals, rev.Id)
+	_, err = GetEnvRevision(&a, 4)
+	c.Assert(err, Equals, ErrEnvRevisionNotFound)
+}
+
+func (s *S) TestRollbackEnvs(c *C) {
+	a := App{
+		Name: "history",
+		Env: map[string]bind.EnvVar{
+			"DATABASE_HOST": {Name: "DATABASE_HOST", Value: "localhost", Public: true},
+		},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	defer db.Session.EnvRevisions().RemoveAll(bson.M{"app": a.Name})
+	envs := []bind.EnvVar{{Name: "LOG_LEVEL", Value: "debug", Public: true}}
+	err = a.SetEnvsToApp(envs, true, false, "someone@tsuru.io")
+	c.Assert(err, IsNil)
+	envs = []bind.EnvVar{
+		{Name: "DATABASE_HOST", Value: "remotehost", Public: true},
+		{Name: "PORT", Value: "8888", Public: true},
+	}
+	err = a.SetEnvsToApp(envs, true, false, "someone@tsuru.io")
+	c.Assert(err, IsNil)
+	envs = []bind.EnvVar{{Name: "MYSQL_PASSWORD", Value: "s3cr3t", Public: false}}
+	err = a.SetEnvsToApp(envs, false, false, "tsuru")
+	c.Assert(err, IsNil)
+	err = a.RollbackEnvs(1, "other@tsuru.io")
+	c.Assert(err, IsNil)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	expected := map[string]bind.EnvVar{
+		"DATABASE_HOST":  {Name: "DATABASE_HOST", Value: "localhost", Public: true},
+		"LOG_LEVEL":      {Name: "LOG_LEVEL", Value: "debug", Public: true},
+		"MYSQL_PASSWORD": {Name: "MYSQL_PASSWORD", Value: "s3cr3t", Public: false},
+	}
+	c.Assert(a.Env, DeepEquals, expected)
+	revisions, err := ListEnvRevisions(&a)
+	c.Assert(err, IsNil)
+	c.Assert(revisions, HasLen, 4)
+	c.Assert(revisions[0].Revision, Equals, 4)
+	c.Assert(revisions[0].User, Equals, "other@tsuru.io")
+	c.Assert(revisions[0].Changed, DeepEquals, []string{"DATABASE_HOST"})
+	c.Assert(revisions[0].Removed, DeepEquals, []string{"PORT"})
+}
+
+func (s *S) TestRollbackEnvsUnknownRevision(c *C) {
+	a := App{Name: "history"}
+	err := a.RollbackEnvs(10, "other@tsuru.io")
+	c.Assert(err, Equals, ErrEnvRevisionNotFound)
+}
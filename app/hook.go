@@ -0,0 +1,136 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"errors"
+	"fmt"
+	"github.com/globocom/tsuru/provision"
+	"io"
+)
+
+// ErrUnitCommandNotSupported is returned when running a command in specific
+// units of an app whose provisioner is not able to do it.
+var ErrUnitCommandNotSupported = errors.New("The provisioner of the app is not able to run commands in specific units.")
+
+// ErrOnceNotSupported is returned when the app declares a hook that should run
+// in a single unit, but the provisioner of the app is not able to run commands
+// in specific units.
+var ErrOnceNotSupported = errors.New("The provisioner of the app is not able to run hooks in a single unit.")
+
+// hook is a command declared in app.conf. Hooks run in all units of the app,
+// unless once is set. Hooks with once set run in a single unit, which is
+// useful for tasks like database migrations.
+//
+// A hook is either a string, the command, or a map:
+//
+//     post-deploy:
+//       - python manage.py collectstatic --noinput
+//       - command: python manage.py migrate
+//         once: true
+type hook struct {
+	Command string
+	Once    bool
+}
+
+// SetYAML implements the goyaml.Setter interface, so hooks may be declared as
+// plain strings.
+func (h *hook) SetYAML(tag string, value interface{}) bool {
+	switch v := value.(type) {
+	case string:
+		h.Command = v
+		return true
+	case map[interface{}]interface{}:
+		cmd, ok := v["command"].(string)
+		if !ok {
+			return false
+		}
+		h.Command = cmd
+		if once, ok := v["once"]; ok {
+			if h.Once, ok = once.(bool); !ok {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// commands converts a list of commands to a list of hooks that run in all
+// units.
+func commands(cmds []string) []hook {
+	if len(cmds) == 0 {
+		return nil
+	}
+	hooks := make([]hook, len(cmds))
+	for i, cmd := range cmds {
+		hooks[i] = hook{Command: cmd}
+	}
+	return hooks
+}
+
+// onceTarget returns the unit where a hook that runs once should run: the
+// first of the given units, or the first unit of the app.
+func (a *App) onceTarget(units []string) ([]string, error) {
+	p, err := a.getProvisioner()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.(provision.UnitCommander); !ok {
+		return nil, ErrOnceNotSupported
+	}
+	if len(units) > 0 {
+		return units[:1], nil
+	}
+	if len(a.Units) == 0 {
+		return nil, fmt.Errorf("App %q has no units.", a.Name)
+	}
+	return []string{a.Units[0].Name}, nil
+}
+
+func (c *conf) stage(name string) ([]hook, error) {
+	switch name {
+	case "build":
+		return c.Build, nil
+	case "pre-deploy":
+		return c.PreDeploy, nil
+	case "post-deploy":
+		return c.PostDeploy, nil
+	case "on-unit-added":
+		return c.OnUnitAdded, nil
+	case "pre-restart":
+		return commands(c.PreRestart), nil
+	case "pos-restart":
+		return commands(c.PosRestart), nil
+	}
+	return nil, fmt.Errorf("Unknown hook stage: %q.", name)
+}
+
+// HasHooks checks whether app.conf declares hooks for the given stage.
+func (a *App) HasHooks(stage string) (bool, error) {
+	if err := a.loadHooks(); err != nil {
+		return false, err
+	}
+	hooks, err := a.hooks.stage(stage)
+	return len(hooks) > 0, err
+}
+
+// RunHooks runs the hooks declared in app.conf for the given stage, which is
+// one of build, pre-deploy, post-deploy, on-unit-added, pre-restart and
+// pos-restart. When names of units are given, the hooks run only in these
+// units.
+//
+// RunHooks stops on the first hook that fails, returning an error that names
+// the hook.
+func (a *App) RunHooks(w io.Writer, stage string, units ...string) error {
+	if err := a.loadHooks(); err != nil {
+		return err
+	}
+	hooks, err := a.hooks.stage(stage)
+	if err != nil {
+		return err
+	}
+	return a.runHook(w, hooks, stage, units...)
+}
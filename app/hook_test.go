@@ -0,0 +1,149 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	"errors"
+	"github.com/globocom/tsuru/provision"
+	. "launchpad.net/gocheck"
+)
+
+func (s *S) TestHookSetYAMLWithString(c *C) {
+	var h hook
+	ok := h.SetYAML("", "python manage.py collectstatic")
+	c.Assert(ok, Equals, true)
+	c.Assert(h, DeepEquals, hook{Command: "python manage.py collectstatic"})
+}
+
+func (s *S) TestHookSetYAMLWithMap(c *C) {
+	var h hook
+	value := map[interface{}]interface{}{"command": "python manage.py migrate", "once": true}
+	ok := h.SetYAML("", value)
+	c.Assert(ok, Equals, true)
+	c.Assert(h, DeepEquals, hook{Command: "python manage.py migrate", Once: true})
+}
+
+func (s *S) TestHookSetYAMLWithInvalidValues(c *C) {
+	values := []interface{}{
+		10,
+		map[interface{}]interface{}{"once": true},
+		map[interface{}]interface{}{"command": "migrate", "once": "yes"},
+	}
+	for _, value := range values {
+		var h hook
+		c.Assert(h.SetYAML("", value), Equals, false)
+	}
+}
+
+func (s *S) TestLoadHooksWithDeployStages(c *C) {
+	output := `build:
+  - python manage.py collectstatic
+pre-deploy:
+  - command: python manage.py migrate
+    once: true
+post-deploy:
+  - deploy/notify.sh
+on-unit-added:
+  - deploy/warm.sh
+`
+	s.provisioner.PrepareOutput([]byte(output))
+	a := App{Name: "something", State: string(provision.StatusStarted)}
+	err := a.loadHooks()
+	c.Assert(err, IsNil)
+	c.Assert(a.hooks.Build, DeepEquals, []hook{{Command: "python manage.py collectstatic"}})
+	c.Assert(a.hooks.PreDeploy, DeepEquals, []hook{{Command: "python manage.py migrate", Once: true}})
+	c.Assert(a.hooks.PostDeploy, DeepEquals, []hook{{Command: "deploy/notify.sh"}})
+	c.Assert(a.hooks.OnUnitAdded, DeepEquals, []hook{{Command: "deploy/warm.sh"}})
+}
+
+func (s *S) TestHasHooks(c *C) {
+	a := App{Name: "something", hooks: &conf{Build: []hook{{Command: "make"}}}}
+	ok, err := a.HasHooks("build")
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, true)
+	ok, err = a.HasHooks("post-deploy")
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, false)
+	_, err = a.HasHooks("after-lunch")
+	c.Assert(err, NotNil)
+}
+
+func (s *S) TestRunHooks(c *C) {
+	s.provisioner.PrepareOutput([]byte("built"))
+	a := App{
+		Name:  "something",
+		State: string(provision.StatusStarted),
+		hooks: &conf{Build: []hook{{Command: "make"}}},
+	}
+	var buf bytes.Buffer
+	err := a.RunHooks(&buf, "build")
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, "\n ---> Running build\nbuilt")
+	cmds := s.provisioner.GetCmds("", &a)
+	c.Assert(cmds, HasLen, 1)
+	c.Assert(cmds[0].Cmd, Matches, ".*; make$")
+}
+
+func (s *S) TestRunHooksNamesTheFailingHook(c *C) {
+	s.provisioner.PrepareOutput([]byte("migrating"))
+	s.provisioner.PrepareFailure("ExecuteCommand", errors.New("exit status 1"))
+	a := App{
+		Name:  "something",
+		State: string(provision.StatusStarted),
+		hooks: &conf{PreDeploy: []hook{{Command: "migrate.sh"}, {Command: "notify.sh"}}},
+	}
+	var buf bytes.Buffer
+	err := a.RunHooks(&buf, "pre-deploy")
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, `pre-deploy hook "migrate.sh" failed: exit status 1`)
+	c.Assert(buf.String(), Matches, `(?s).* ---> pre-deploy hook "migrate.sh" failed: exit status 1\n$`)
+	cmds := s.provisioner.GetCmds("", &a)
+	c.Assert(cmds, HasLen, 1)
+}
+
+func (s *S) TestRunHooksOnceRunsInASingleUnit(c *C) {
+	p := newRollingProvisioner()
+	provision.Register("rolling", p)
+	p.PrepareOutput(nil)
+	p.PrepareOutput(nil)
+	a := App{
+		Name:        "someApp",
+		Provisioner: "rolling",
+		State:       string(provision.StatusStarted),
+		Units: []Unit{
+			{Name: "someApp/0", State: string(provision.StatusStarted)},
+			{Name: "someApp/1", State: string(provision.StatusStarted)},
+		},
+		hooks: &conf{PostDeploy: []hook{{Command: "migrate.sh", Once: true}, {Command: "warm.sh"}}},
+	}
+	var buf bytes.Buffer
+	err := a.RunHooks(&buf, "post-deploy")
+	c.Assert(err, IsNil)
+	cmds := p.GetCmds("", &a)
+	c.Assert(cmds, HasLen, 2)
+	c.Assert(cmds[0].Cmd, Matches, ".*; migrate.sh$")
+	c.Assert(cmds[0].Unit, Equals, "someApp/0")
+	c.Assert(cmds[1].Cmd, Matches, ".*; warm.sh$")
+	c.Assert(cmds[1].Unit, Equals, "")
+}
+
+func (s *S) TestRunHooksOnceWithoutUnitCommander(c *C) {
+	a := App{
+		Name:  "something",
+		State: string(provision.StatusStarted),
+		Units: []Unit{{Name: "something/0"}},
+		hooks: &conf{PreDeploy: []hook{{Command: "migrate.sh", Once: true}}},
+	}
+	var buf bytes.Buffer
+	err := a.RunHooks(&buf, "pre-deploy")
+	c.Assert(err, Equals, ErrOnceNotSupported)
+}
+
+func (s *S) TestCommands(c *C) {
+	c.Assert(commands(nil), IsNil)
+	expected := []hook{{Command: "pre.sh"}, {Command: "ls -lh"}}
+	c.Assert(commands([]string{"pre.sh", "ls -lh"}), DeepEquals, expected)
+}
@@ -0,0 +1,113 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"errors"
+	"fmt"
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/db"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"time"
+)
+
+// defaultLockTimeout is the number of seconds after which a lock expires, if
+// the app-lock:timeout setting is not defined.
+const defaultLockTimeout = 1800
+
+// ErrNotLocked is returned by ForceReleaseLock when the app is not locked.
+var ErrNotLocked = errors.New("App is not locked.")
+
+// AppLock is a lock of an app. Deploys and operations on the units of an app
+// hold the lock of the app while they run, so they never run concurrently.
+//
+// Locks expire after the time defined by the app-lock:timeout setting, so an
+// operation that dies without releasing the lock does not block the app
+// forever.
+type AppLock struct {
+	App         string        `bson:"_id"`
+	Token       bson.ObjectId `bson:"token"`
+	Owner       string
+	Reason      string
+	AcquireDate time.Time
+	Expires     time.Time
+}
+
+// LockedError is returned by AcquireLock when the app is already locked.
+type LockedError struct {
+	Lock AppLock
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("App %q is locked by %s since %s (%s).",
+		e.Lock.App, e.Lock.Owner, e.Lock.AcquireDate.Format(time.RFC822), e.Lock.Reason)
+}
+
+func lockTimeout() time.Duration {
+	timeout, err := config.GetInt("app-lock:timeout")
+	if err != nil || timeout < 1 {
+		timeout = defaultLockTimeout
+	}
+	return time.Duration(timeout) * time.Second
+}
+
+// AcquireLock locks the app on behalf of owner, for the given reason. If the
+// app is already locked, it returns a *LockedError describing the current
+// lock.
+//
+// The returned lock must be released with Release.
+func AcquireLock(appName, owner, reason string) (*AppLock, error) {
+	now := time.Now()
+	locks := db.Session.Locks()
+	locks.Remove(bson.M{"_id": appName, "expires": bson.M{"$lt": now}})
+	lock := AppLock{
+		App:         appName,
+		Token:       bson.NewObjectId(),
+		Owner:       owner,
+		Reason:      reason,
+		AcquireDate: now,
+		Expires:     now.Add(lockTimeout()),
+	}
+	if err := locks.Insert(lock); err != nil {
+		var current AppLock
+		if locks.FindId(appName).One(&current) == nil {
+			return nil, &LockedError{Lock: current}
+		}
+		return nil, err
+	}
+	return &lock, nil
+}
+
+// Release releases the lock. It does nothing if the lock has expired or was
+// forcibly released, and the app was locked again by someone else.
+func (l *AppLock) Release() error {
+	err := db.Session.Locks().Remove(bson.M{"_id": l.App, "token": l.Token})
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// GetLock returns the current lock of the app, or mgo.ErrNotFound if the app
+// is not locked.
+func GetLock(appName string) (*AppLock, error) {
+	var lock AppLock
+	err := db.Session.Locks().Find(bson.M{"_id": appName, "expires": bson.M{"$gte": time.Now()}}).One(&lock)
+	if err != nil {
+		return nil, err
+	}
+	return &lock, nil
+}
+
+// ForceReleaseLock releases the lock of the app, regardless of its owner. It
+// returns ErrNotLocked if the app is not locked.
+func ForceReleaseLock(appName string) error {
+	err := db.Session.Locks().RemoveId(appName)
+	if err == mgo.ErrNotFound {
+		return ErrNotLocked
+	}
+	return err
+}
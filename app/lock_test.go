@@ -0,0 +1,87 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"github.com/globocom/tsuru/db"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	. "launchpad.net/gocheck"
+	"time"
+)
+
+func (s *S) TestAcquireLock(c *C) {
+	lock, err := AcquireLock("kyuss", "josh@kyuss.com", "deploy")
+	c.Assert(err, IsNil)
+	defer lock.Release()
+	c.Assert(lock.App, Equals, "kyuss")
+	c.Assert(lock.Owner, Equals, "josh@kyuss.com")
+	c.Assert(lock.Reason, Equals, "deploy")
+	c.Assert(lock.Token.Valid(), Equals, true)
+	c.Assert(lock.Expires.Sub(lock.AcquireDate), Equals, defaultLockTimeout*time.Second)
+	var stored AppLock
+	err = db.Session.Locks().FindId("kyuss").One(&stored)
+	c.Assert(err, IsNil)
+	c.Assert(stored.Token, Equals, lock.Token)
+	c.Assert(stored.Owner, Equals, "josh@kyuss.com")
+}
+
+func (s *S) TestAcquireLockWhenTheAppIsLocked(c *C) {
+	lock, err := AcquireLock("kyuss", "josh@kyuss.com", "deploy")
+	c.Assert(err, IsNil)
+	defer lock.Release()
+	_, err = AcquireLock("kyuss", "brant@kyuss.com", "add units")
+	c.Assert(err, NotNil)
+	e, ok := err.(*LockedError)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Lock.Owner, Equals, "josh@kyuss.com")
+	c.Assert(e.Lock.Reason, Equals, "deploy")
+	c.Assert(e.Error(), Matches, `^App "kyuss" is locked by josh@kyuss.com since .* \(deploy\)\.$`)
+}
+
+func (s *S) TestAcquireLockWhenTheLockExpired(c *C) {
+	expired := AppLock{
+		App:         "kyuss",
+		Token:       bson.NewObjectId(),
+		Owner:       "josh@kyuss.com",
+		AcquireDate: time.Now().Add(-2 * time.Hour),
+		Expires:     time.Now().Add(-time.Hour),
+	}
+	err := db.Session.Locks().Insert(expired)
+	c.Assert(err, IsNil)
+	lock, err := AcquireLock("kyuss", "brant@kyuss.com", "deploy")
+	c.Assert(err, IsNil)
+	defer lock.Release()
+	c.Assert(lock.Owner, Equals, "brant@kyuss.com")
+}
+
+func (s *S) TestReleaseLock(c *C) {
+	lock, err := AcquireLock("kyuss", "josh@kyuss.com", "deploy")
+	c.Assert(err, IsNil)
+	err = lock.Release()
+	c.Assert(err, IsNil)
+	_, err = GetLock("kyuss")
+	c.Assert(err, Equals, mgo.ErrNotFound)
+}
+
+func (s *S) TestReleaseLockDoesNotReleaseLocksOfOtherOwners(c *C) {
+	lock, err := AcquireLock("kyuss", "josh@kyuss.com", "deploy")
+	c.Assert(err, IsNil)
+	err = ForceReleaseLock("kyuss")
+	c.Assert(err, IsNil)
+	other, err := AcquireLock("kyuss", "brant@kyuss.com", "deploy")
+	c.Assert(err, IsNil)
+	defer other.Release()
+	err = lock.Release()
+	c.Assert(err, IsNil)
+	current, err := GetLock("kyuss")
+	c.Assert(err, IsNil)
+	c.Assert(current.Owner, Equals, "brant@kyuss.com")
+}
+
+func (s *S) TestForceReleaseLockWhenTheAppIsNotLocked(c *C) {
+	err := ForceReleaseLock("kyuss")
+	c.Assert(err, Equals, ErrNotLocked)
+}
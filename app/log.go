@@ -0,0 +1,213 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"fmt"
+	"github.com/globocom/tsuru/db"
+	"io"
+	"labix.org/v2/mgo/bson"
+	"strings"
+	"time"
+)
+
+// migrationBatchSize is the number of log entries inserted at once by
+// MigrateLogs.
+const migrationBatchSize = 1000
+
+// logPollInterval is the interval between two queries for new entries made
+// by FollowLogs.
+var logPollInterval = time.Second
+
+// LogFilter selects entries in the logs of an app. Empty fields match any
+// entry.
+type LogFilter struct {
+	Source string
+	Unit   string
+
+	// Since and Until select entries written in the given interval. Since is
+	// inclusive, Until is exclusive.
+	Since time.Time
+	Until time.Time
+
+	// Message is a regular expression, matched against the message of the
+	// entries.
+	Message string
+}
+
+func (f *LogFilter) query(appName string) bson.M {
+	query := bson.M{"appname": appName}
+	if f.Source != "" {
+		query["source"] = f.Source
+	}
+	if f.Unit != "" {
+		query["unit"] = f.Unit
+	}
+	if f.Message != "" {
+		query["message"] = bson.RegEx{Pattern: f.Message}
+	}
+	date := bson.M{}
+	if !f.Since.IsZero() {
+		date["$gte"] = f.Since
+	}
+	if !f.Until.IsZero() {
+		date["$lt"] = f.Until
+	}
+	if len(date) > 0 {
+		query["date"] = date
+	}
+	return query
+}
+
+// LastLogs returns the last entries in the logs of the app that match the
+// given filter, oldest first. If lines is greater than zero, at most lines
+// entries are returned.
+func (a *App) LastLogs(lines int, filter LogFilter) ([]Applog, error) {
+	query := db.Session.Logs().Find(filter.query(a.Name)).Sort("-date", "-_id")
+	if lines > 0 {
+		query = query.Limit(lines)
+	}
+	var logs []Applog
+	if err := query.All(&logs); err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(logs)-1; i < j; i, j = i+1, j-1 {
+		logs[i], logs[j] = logs[j], logs[i]
+	}
+	return logs, nil
+}
+
+// FollowLogs calls fn with the entries written to the logs of the app after
+// the given ones, as they arrive, until fn returns an error, which is then
+// returned by FollowLogs. Only entries that match the given filter are
+// followed, and its Until field is ignored. If there are no given entries,
+// entries are followed from the Since field of the filter, or from now on if
+// it's zero. Callers that read the last entries before following the logs
+// should set Since to a time taken before reading them, so entries written in
+// the meantime are not lost.
+//
+// The logs are polled every logPollInterval, and fn is called after each
+// poll, even when there are no new entries, so callers can check whether they
+// should keep following.
+func (a *App) FollowLogs(last []Applog, filter LogFilter, fn func([]Applog) error) error {
+	since := filter.Since
+	if since.IsZero() {
+		since = time.Now()
+	}
+	seen := make(map[bson.ObjectId]bool)
+	if n := len(last); n > 0 {
+		since = last[n-1].Date
+		for _, l := range last {
+			if l.Date.Equal(since) {
+				seen[l.Id] = true
+			}
+		}
+	}
+	filter.Until = time.Time{}
+	var err error
+	for err == nil {
+		time.Sleep(logPollInterval)
+		filter.Since = since
+		var logs []Applog
+		if err := db.Session.Logs().Find(filter.query(a.Name)).Sort("date", "_id").All(&logs); err != nil {
+			return err
+		}
+		var fresh []Applog
+		for _, l := range logs {
+			if !seen[l.Id] {
+				fresh = append(fresh, l)
+			}
+		}
+		// Entries are stored with millisecond precision, so entries written
+		// at the same millisecond as the last one are kept, and told apart by
+		// their ids.
+		if n := len(logs); n > 0 && !logs[n-1].Date.Equal(since) {
+			since = logs[n-1].Date
+			seen = make(map[bson.ObjectId]bool)
+		}
+		for _, l := range fresh {
+			if l.Date.Equal(since) {
+				seen[l.Id] = true
+			}
+		}
+		err = fn(fresh)
+	}
+	return err
+}
+
+// MigrateLogs moves the logs stored in the documents of the apps, by previous
+// versions of tsuru, to the logs collection. It's safe to call MigrateLogs
+// more than once: apps are locked during the migration, and their logs are
+// removed from the document after being moved.
+//
+// Apps that are locked are skipped, and reported in the returned error.
+//
+// MigrateLogs also drops the TTL index on the date of the entries, created by
+// previous versions of tsuru, which would remove entries regardless of the
+// retention policies of the apps.
+func MigrateLogs(w io.Writer) error {
+	if err := dropExpirationIndex(); err != nil {
+		return err
+	}
+	var apps []App
+	err := db.Session.Apps().Find(bson.M{"logs": bson.M{"$exists": true}}).Select(bson.M{"name": 1}).All(&apps)
+	if err != nil {
+		return err
+	}
+	var locked []string
+	for _, a := range apps {
+		lock, err := AcquireLock(a.Name, envSystemUser, "logs migration")
+		if err != nil {
+			locked = append(locked, a.Name)
+			continue
+		}
+		n, err := migrateLogs(a.Name)
+		lock.Release()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Migrated %d log entries of the app %q.\n", n, a.Name)
+	}
+	if len(locked) > 0 {
+		return fmt.Errorf("Failed to migrate the logs of %d apps: %s.", len(locked), strings.Join(locked, ", "))
+	}
+	return nil
+}
+
+// dropExpirationIndex drops the TTL index on the date of the entries, doing
+// nothing when the index or the collection doesn't exist.
+func dropExpirationIndex() error {
+	err := db.Session.Logs().DropIndex("date")
+	if err != nil && !strings.Contains(err.Error(), "index not found") && !strings.Contains(err.Error(), "ns not found") {
+		return err
+	}
+	return nil
+}
+
+func migrateLogs(appName string) (int, error) {
+	var doc struct {
+		Logs []Applog
+	}
+	err := db.Session.Apps().Find(bson.M{"name": appName}).Select(bson.M{"logs": 1}).One(&doc)
+	if err != nil {
+		return 0, err
+	}
+	for start := 0; start < len(doc.Logs); start += migrationBatchSize {
+		end := start + migrationBatchSize
+		if end > len(doc.Logs) {
+			end = len(doc.Logs)
+		}
+		batch := make([]interface{}, end-start)
+		for i, l := range doc.Logs[start:end] {
+			l.AppName = appName
+			batch[i] = l
+		}
+		if err := db.Session.Logs().Insert(batch...); err != nil {
+			return 0, err
+		}
+	}
+	err = db.Session.Apps().Update(bson.M{"name": appName}, bson.M{"$unset": bson.M{"logs": 1}})
+	return len(doc.Logs), err
+}
@@ -0,0 +1,302 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	"errors"
+	"github.com/globocom/tsuru/db"
+	"labix.org/v2/mgo/bson"
+	. "launchpad.net/gocheck"
+	"strconv"
+	"time"
+)
+
+func (s *S) TestLastLogs(c *C) {
+	a := App{Name: "ancient"}
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		source := "app"
+		if i%2 == 0 {
+			source = "tsuru"
+		}
+		l := Applog{
+			Date:    now.Add(time.Duration(i) * time.Minute),
+			Message: strconv.Itoa(i),
+			Source:  source,
+			AppName: a.Name,
+		}
+		err := db.Session.Logs().Insert(l)
+		c.Assert(err, IsNil)
+	}
+	err := db.Session.Logs().Insert(Applog{Date: now, Message: "other", Source: "app", AppName: "other"})
+	c.Assert(err, IsNil)
+	logs, err := a.LastLogs(0, LogFilter{})
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 10)
+	c.Assert(logs[0].Message, Equals, "0")
+	c.Assert(logs[9].Message, Equals, "9")
+	logs, err = a.LastLogs(3, LogFilter{})
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 3)
+	c.Assert(logs[0].Message, Equals, "7")
+	c.Assert(logs[2].Message, Equals, "9")
+	logs, err = a.LastLogs(2, LogFilter{Source: "tsuru"})
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 2)
+	c.Assert(logs[0].Message, Equals, "6")
+	c.Assert(logs[1].Message, Equals, "8")
+}
+
+func (s *S) TestLastLogsWithoutLogs(c *C) {
+	a := App{Name: "ancient"}
+	logs, err := a.LastLogs(10, LogFilter{})
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 0)
+}
+
+func (s *S) TestLastLogsFilteringByUnit(c *C) {
+	a := App{Name: "ancient"}
+	err := a.LogUnit("from the first unit", "app", "ancient/0")
+	c.Assert(err, IsNil)
+	err = a.LogUnit("from the second unit", "app", "ancient/1")
+	c.Assert(err, IsNil)
+	err = a.Log("from tsuru", "tsuru")
+	c.Assert(err, IsNil)
+	logs, err := a.LastLogs(0, LogFilter{Unit: "ancient/1"})
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 1)
+	c.Assert(logs[0].Message, Equals, "from the second unit")
+	c.Assert(logs[0].Unit, Equals, "ancient/1")
+}
+
+func (s *S) TestLastLogsFilteringByDate(c *C) {
+	a := App{Name: "ancient"}
+	now := time.Unix(time.Now().Unix(), 0)
+	for i := 0; i < 5; i++ {
+		l := Applog{
+			Date:    now.Add(time.Duration(i) * time.Hour),
+			Message: strconv.Itoa(i),
+			Source:  "app",
+			AppName: a.Name,
+		}
+		err := db.Session.Logs().Insert(l)
+		c.Assert(err, IsNil)
+	}
+	filter := LogFilter{Since: now.Add(time.Hour), Until: now.Add(3 * time.Hour)}
+	logs, err := a.LastLogs(0, filter)
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 2)
+	c.Assert(logs[0].Message, Equals, "1")
+	c.Assert(logs[1].Message, Equals, "2")
+	logs, err = a.LastLogs(0, LogFilter{Since: now.Add(3 * time.Hour)})
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 2)
+	c.Assert(logs[0].Message, Equals, "3")
+}
+
+func (s *S) TestLastLogsFilteringByMessage(c *C) {
+	a := App{Name: "ancient"}
+	err := a.Log("GET /users 200\nGET /admin 500\nPOST /users 201", "app")
+	c.Assert(err, IsNil)
+	logs, err := a.LastLogs(0, LogFilter{Message: "/users"})
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 2)
+	c.Assert(logs[0].Message, Equals, "GET /users 200")
+	c.Assert(logs[1].Message, Equals, "POST /users 201")
+	logs, err = a.LastLogs(0, LogFilter{Message: " 5[0-9]{2}$"})
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 1)
+	c.Assert(logs[0].Message, Equals, "GET /admin 500")
+}
+
+func (s *S) TestFollowLogs(c *C) {
+	old := logPollInterval
+	logPollInterval = 1e6
+	defer func() {
+		logPollInterval = old
+	}()
+	a := App{Name: "ancient"}
+	err := a.Log("first", "tsuru")
+	c.Assert(err, IsNil)
+	last, err := a.LastLogs(0, LogFilter{})
+	c.Assert(err, IsNil)
+	stop := errors.New("stop")
+	var calls int
+	var messages []string
+	err = a.FollowLogs(last, LogFilter{}, func(logs []Applog) error {
+		calls++
+		for _, l := range logs {
+			messages = append(messages, l.Message)
+		}
+		if calls == 1 {
+			a.Log("second\nthird", "app")
+		}
+		if len(messages) >= 2 || calls > 100 {
+			return stop
+		}
+		return nil
+	})
+	c.Assert(err, Equals, stop)
+	c.Assert(messages, DeepEquals, []string{"second", "third"})
+}
+
+func (s *S) TestFollowLogsFilteringBySource(c *C) {
+	old := logPollInterval
+	logPollInterval = 1e6
+	defer func() {
+		logPollInterval = old
+	}()
+	a := App{Name: "ancient"}
+	stop := errors.New("stop")
+	var calls int
+	var messages []string
+	err := a.FollowLogs(nil, LogFilter{Source: "tsuru"}, func(logs []Applog) error {
+		calls++
+		for _, l := range logs {
+			messages = append(messages, l.Message)
+		}
+		if calls == 1 {
+			a.Log("from the app", "app")
+			a.Log("from tsuru", "tsuru")
+		}
+		if len(messages) >= 1 || calls > 100 {
+			return stop
+		}
+		return nil
+	})
+	c.Assert(err, Equals, stop)
+	c.Assert(messages, DeepEquals, []string{"from tsuru"})
+}
+
+func (s *S) TestFollowLogsFilteringByUnit(c *C) {
+	old := logPollInterval
+	logPollInterval = 1e6
+	defer func() {
+		logPollInterval = old
+	}()
+	a := App{Name: "ancient"}
+	stop := errors.New("stop")
+	var calls int
+	var messages []string
+	err := a.FollowLogs(nil, LogFilter{Unit: "ancient/1"}, func(logs []Applog) error {
+		calls++
+		for _, l := range logs {
+			messages = append(messages, l.Message)
+		}
+		if calls == 1 {
+			a.LogUnit("from the first unit", "app", "ancient/0")
+			a.LogUnit("from the second unit", "app", "ancient/1")
+		}
+		if len(messages) >= 1 || calls > 100 {
+			return stop
+		}
+		return nil
+	})
+	c.Assert(err, Equals, stop)
+	c.Assert(messages, DeepEquals, []string{"from the second unit"})
+}
+
+func (s *S) TestFollowLogsSince(c *C) {
+	old := logPollInterval
+	logPollInterval = 1e6
+	defer func() {
+		logPollInterval = old
+	}()
+	a := App{Name: "ancient"}
+	now := time.Now()
+	err := db.Session.Logs().Insert(
+		Applog{Date: now.Add(-time.Hour), Message: "old", Source: "app", AppName: a.Name},
+		Applog{Date: now.Add(-time.Minute), Message: "recent", Source: "app", AppName: a.Name},
+	)
+	c.Assert(err, IsNil)
+	stop := errors.New("stop")
+	var messages []string
+	err = a.FollowLogs(nil, LogFilter{Since: now.Add(-30 * time.Minute)}, func(logs []Applog) error {
+		for _, l := range logs {
+			messages = append(messages, l.Message)
+		}
+		return stop
+	})
+	c.Assert(err, Equals, stop)
+	c.Assert(messages, DeepEquals, []string{"recent"})
+}
+
+func (s *S) TestMigrateLogs(c *C) {
+	now := time.Unix(time.Now().Unix(), 0)
+	old := bson.M{
+		"name": "ancient",
+		"logs": []bson.M{
+			{"date": now, "message": "first", "source": "tsuru"},
+			{"date": now.Add(time.Second), "message": "second", "source": "app"},
+		},
+	}
+	err := db.Session.Apps().Insert(old)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": "ancient"})
+	err = db.Session.Apps().Insert(App{Name: "modern"})
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": "modern"})
+	var buf bytes.Buffer
+	err = MigrateLogs(&buf)
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, "Migrated 2 log entries of the app \"ancient\".\n")
+	a := App{Name: "ancient"}
+	logs, err := a.LastLogs(0, LogFilter{})
+	c.Assert(err, IsNil)
+	expected := []Applog{
+		{Date: now, Message: "first", Source: "tsuru", AppName: "ancient"},
+		{Date: now.Add(time.Second), Message: "second", Source: "app", AppName: "ancient"},
+	}
+	c.Assert(logs, HasLen, 2)
+	for i := range logs {
+		c.Assert(logs[i].Date.Equal(expected[i].Date), Equals, true)
+		c.Assert(logs[i].Id.Valid(), Equals, true)
+		logs[i].Date = expected[i].Date
+		logs[i].Id = ""
+	}
+	c.Assert(logs, DeepEquals, expected)
+	n, err := db.Session.Apps().Find(bson.M{"logs": bson.M{"$exists": true}}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+	buf.Reset()
+	err = MigrateLogs(&buf)
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, "")
+	logs, err = a.LastLogs(0, LogFilter{})
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 2)
+}
+
+func (s *S) TestMigrateLogsSkipsLockedApps(c *C) {
+	old := bson.M{
+		"name": "ancient",
+		"logs": []bson.M{{"date": time.Now(), "message": "first", "source": "tsuru"}},
+	}
+	err := db.Session.Apps().Insert(old)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": "ancient"})
+	lock, err := AcquireLock("ancient", "someone@tsuru.io", "deploy")
+	c.Assert(err, IsNil)
+	defer lock.Release()
+	var buf bytes.Buffer
+	err = MigrateLogs(&buf)
+	c.Assert(err, ErrorMatches, `Failed to migrate the logs of 1 apps: ancient.`)
+	n, err := db.Session.Logs().Find(bson.M{"appname": "ancient"}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+}
+
+func (s *S) TestMigrateLogsDropsExpirationIndex(c *C) {
+	err := db.Session.Logs().EnsureIndexKey("date")
+	c.Assert(err, IsNil)
+	var buf bytes.Buffer
+	err = MigrateLogs(&buf)
+	c.Assert(err, IsNil)
+	err = db.Session.Logs().DropIndex("date")
+	c.Assert(err, NotNil)
+	err = MigrateLogs(&buf)
+	c.Assert(err, IsNil)
+}
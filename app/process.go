@@ -0,0 +1,264 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/globocom/tsuru/provision"
+	"github.com/globocom/tsuru/repository"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// DefaultProcess is the process type of units that don't declare one.
+const DefaultProcess = "web"
+
+const restartHook = "/var/lib/tsuru/hooks/restart"
+
+var processNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// ErrUnknownProcess is returned when adding or removing units of a process
+// type that is not declared by the app.
+var ErrUnknownProcess = errors.New("The app does not declare this process type.")
+
+// ErrProcessNotSupported is returned when adding units of a process type other
+// than the default one to an app whose provisioner is not able to run commands
+// in specific units (see provision.UnitCommander).
+var ErrProcessNotSupported = errors.New("The provisioner of the app is not able to run process types other than the default one.")
+
+// parseProcfile parses the content of a Procfile, returning the commands of
+// the declared process types, by name:
+//
+//     web: gunicorn -b 0.0.0.0:$PORT app:app
+//     worker: celery worker
+//
+// Empty lines and lines starting with # are skipped.
+func parseProcfile(content string) (map[string]string, error) {
+	processes := make(map[string]string)
+	for i, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) < 2 || !processNameRegexp.MatchString(name) {
+			return nil, fmt.Errorf("Invalid Procfile, line %d: %q.", i+1, line)
+		}
+		processes[name] = strings.TrimSpace(parts[1])
+	}
+	return processes, nil
+}
+
+// Processes returns the process types declared by the app, in the processes
+// section of app.conf or, if it's not defined, in the Procfile in the root of
+// the repository of the app. The map is keyed by the name of the process, and
+// values are commands. Apps without a Procfile declare no processes.
+func (a *App) Processes() (map[string]string, error) {
+	if err := a.loadHooks(); err != nil {
+		return nil, err
+	}
+	if len(a.hooks.Processes) > 0 {
+		for name := range a.hooks.Processes {
+			if !processNameRegexp.MatchString(name) {
+				return nil, fmt.Errorf("Invalid process name in app.conf: %q.", name)
+			}
+		}
+		return a.hooks.Processes, nil
+	}
+	uRepo, err := repository.GetPath()
+	if err != nil {
+		return nil, err
+	}
+	units, err := a.procfileUnits()
+	if err != nil {
+		return nil, err
+	}
+	procfile := path.Join(uRepo, "Procfile")
+	cmd := fmt.Sprintf("test ! -f %s || cat %s", procfile, procfile)
+	var buf bytes.Buffer
+	if err := a.run(cmd, &buf, units...); err != nil {
+		return nil, err
+	}
+	return parseProcfile(buf.String())
+}
+
+// procfileUnits returns the unit where the Procfile is read from. The output
+// of commands that run in all units is prefixed by the name of each unit, so
+// the Procfile is read from a single unit, preferably a started one.
+//
+// Provisioners that are not able to run commands in specific units run the
+// command in all units, and no unit is returned.
+func (a *App) procfileUnits() ([]string, error) {
+	p, err := a.getProvisioner()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.(provision.UnitCommander); !ok || len(a.Units) == 0 {
+		return nil, nil
+	}
+	for _, u := range a.Units {
+		if u.State == string(provision.StatusStarted) {
+			return []string{u.Name}, nil
+		}
+	}
+	return []string{a.Units[0].Name}, nil
+}
+
+// checkProcess checks that units of the given process type can be added to
+// the app. Processes that already have units were checked when these units
+// were added, so the Procfile is not read again: this allows the healer to
+// replace units of apps that are not able to run commands.
+func (a *App) checkProcess(process string) error {
+	if process == DefaultProcess {
+		return nil
+	}
+	p, err := a.getProvisioner()
+	if err != nil {
+		return err
+	}
+	if _, ok := p.(provision.UnitCommander); !ok {
+		return ErrProcessNotSupported
+	}
+	for _, u := range a.Units {
+		if u.Process == process {
+			return nil
+		}
+	}
+	processes, err := a.Processes()
+	if err != nil {
+		return err
+	}
+	if _, ok := processes[process]; !ok {
+		return ErrUnknownProcess
+	}
+	return nil
+}
+
+// AddProcessUnits adds n units of the given process type to the app. The
+// process type must be declared by the app (see Processes), except for the
+// default process.
+func (a *App) AddProcessUnits(n uint, process string) error {
+	if err := a.checkProcess(process); err != nil {
+		return err
+	}
+	if process == DefaultProcess {
+		process = ""
+	}
+	return a.addUnits(n, process)
+}
+
+// RemoveProcessUnits removes n units of the given process type from the app.
+// Units that are not started are removed first.
+func (a *App) RemoveProcessUnits(n uint, process string) error {
+	var units []string
+	started := string(provision.StatusStarted)
+	for _, u := range a.Units {
+		if u.ProcessName() == process && u.State != started {
+			units = append(units, u.Name)
+		}
+	}
+	for _, u := range a.Units {
+		if u.ProcessName() == process && u.State == started {
+			units = append(units, u.Name)
+		}
+	}
+	l := uint(len(units))
+	if n == 0 {
+		return errors.New("Cannot remove zero units.")
+	} else if l == 0 {
+		return fmt.Errorf("The app has no units of the process %s.", process)
+	} else if n > l {
+		return fmt.Errorf("Cannot remove %d units of the process %s, the app has only %d.", n, process, l)
+	} else if n == uint(len(a.Units)) {
+		return errors.New("Cannot remove all units from an app.")
+	}
+	for _, name := range units[:n] {
+		if err := a.RemoveUnit(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runRestartHook runs the restart hook in the given units, or in all units of
+// the app. Units running a process type other than the default one don't run
+// the hook: the command declared for the process is started instead (see
+// processCommand). This requires a provisioner that is able to run commands in
+// specific units (see provision.UnitCommander).
+func (a *App) runRestartHook(w io.Writer, units ...string) error {
+	names := units
+	if len(names) == 0 {
+		names = a.unitNames()
+	}
+	processes := make(map[string]string, len(names))
+	for _, name := range names {
+		for _, u := range a.Units {
+			if u.Name == name && u.Process != "" {
+				processes[name] = u.Process
+			}
+		}
+	}
+	if len(processes) == 0 {
+		return a.run(restartHook, w, units...)
+	}
+	p, err := a.getProvisioner()
+	if err != nil {
+		return err
+	}
+	if _, ok := p.(provision.UnitCommander); !ok {
+		return ErrProcessNotSupported
+	}
+	commands, err := a.Processes()
+	if err != nil {
+		return err
+	}
+	dir, err := repository.GetPath()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		cmd := restartHook
+		if process, ok := processes[name]; ok {
+			command, ok := commands[process]
+			if !ok {
+				return fmt.Errorf("The process %s of the unit %s is no longer declared by the app.", process, name)
+			}
+			cmd = processCommand(process, command, dir)
+		}
+		if err := a.run(cmd, w, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasProcessUnits reports whether the app has units of process types other
+// than the default one.
+func (a *App) hasProcessUnits() bool {
+	for _, u := range a.Units {
+		if u.Process != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// processCommand returns the command that starts the given process in a unit,
+// in background, from the directory of the repository and with the
+// environment of the app. The pid of the process is kept in a file, so the
+// instance started before is stopped when the process is restarted.
+func processCommand(process, command, dir string) string {
+	pidfile := path.Join("/home/application", process+".pid")
+	logfile := path.Join("/home/application", process+".log")
+	return fmt.Sprintf(
+		"[ -f %s ] && kill $(cat %s); [ -f /home/application/apprc ] && . /home/application/apprc; cd %s && { nohup sh -c %s >> %s 2>&1 & echo $! > %s; }",
+		pidfile, pidfile, dir, shellQuote(command), logfile, pidfile,
+	)
+}
@@ -0,0 +1,285 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	"errors"
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/provision"
+	"github.com/globocom/tsuru/testing"
+	"labix.org/v2/mgo/bson"
+	. "launchpad.net/gocheck"
+)
+
+func (s *S) TestParseProcfile(c *C) {
+	content := `# processes of the app
+web: gunicorn -b 0.0.0.0:$PORT app:app
+
+worker: celery worker --queues=a,b
+`
+	processes, err := parseProcfile(content)
+	c.Assert(err, IsNil)
+	expected := map[string]string{
+		"web":    "gunicorn -b 0.0.0.0:$PORT app:app",
+		"worker": "celery worker --queues=a,b",
+	}
+	c.Assert(processes, DeepEquals, expected)
+}
+
+func (s *S) TestParseProcfileInvalid(c *C) {
+	for _, content := range []string{"web", "web worker: celery", "$(rm -rf /): ls"} {
+		_, err := parseProcfile(content)
+		c.Assert(err, NotNil)
+	}
+}
+
+func (s *S) TestProcessesFromAppConf(c *C) {
+	a := App{
+		Name:  "something",
+		hooks: &conf{Processes: map[string]string{"worker": "celery worker"}},
+	}
+	processes, err := a.Processes()
+	c.Assert(err, IsNil)
+	c.Assert(processes, DeepEquals, map[string]string{"worker": "celery worker"})
+}
+
+func (s *S) TestProcessesFromProcfile(c *C) {
+	s.provisioner.PrepareOutput([]byte("web: ./server\nclock: ./clock\n"))
+	a := App{Name: "something", State: string(provision.StatusStarted), hooks: &conf{}}
+	processes, err := a.Processes()
+	c.Assert(err, IsNil)
+	c.Assert(processes, DeepEquals, map[string]string{"web": "./server", "clock": "./clock"})
+	cmds := s.provisioner.GetCmds("", &a)
+	c.Assert(cmds, HasLen, 1)
+	c.Assert(cmds[0].Cmd, Matches, `^test ! -f .*/Procfile \|\| cat .*/Procfile$`)
+}
+
+func (s *S) TestProcessesFromProcfileInOneUnit(c *C) {
+	p := testing.NewExtensibleFakeProvisioner()
+	provision.Register("extensible", p)
+	a := App{
+		Name:        "something",
+		Provisioner: "extensible",
+		State:       string(provision.StatusStarted),
+		Units: []Unit{
+			{Name: "something/0", State: string(provision.StatusDown)},
+			{Name: "something/1", State: string(provision.StatusStarted)},
+		},
+		hooks: &conf{},
+	}
+	err := p.Provision(&a)
+	c.Assert(err, IsNil)
+	defer p.Destroy(&a)
+	p.PrepareOutput([]byte("web: ./server\nclock: ./clock\n"))
+	processes, err := a.Processes()
+	c.Assert(err, IsNil)
+	c.Assert(processes, DeepEquals, map[string]string{"web": "./server", "clock": "./clock"})
+	cmds := p.GetCmds("", &a)
+	c.Assert(cmds, HasLen, 1)
+	c.Assert(cmds[0].Unit, Equals, "something/1")
+}
+
+func (s *S) TestProcessesFailure(c *C) {
+	s.provisioner.PrepareOutput([]byte("permission denied"))
+	s.provisioner.PrepareFailure("ExecuteCommand", errors.New("exit status 1"))
+	a := App{Name: "something", State: string(provision.StatusStarted), hooks: &conf{}}
+	processes, err := a.Processes()
+	c.Assert(err, NotNil)
+	c.Assert(processes, IsNil)
+}
+
+func (s *S) TestUnitProcessName(c *C) {
+	u := Unit{Name: "something/0"}
+	c.Assert(u.ProcessName(), Equals, "web")
+	u.Process = "worker"
+	c.Assert(u.ProcessName(), Equals, "worker")
+}
+
+func (s *S) TestAddProcessUnits(c *C) {
+	server := testing.FakeQueueServer{}
+	server.Start("127.0.0.1:0")
+	defer server.Stop()
+	old, err := config.Get("queue-server")
+	if err != nil {
+		defer config.Set("queue-server", old)
+	}
+	config.Set("queue-server", server.Addr())
+	a := App{
+		Name:        "warpaint",
+		Framework:   "python",
+		Provisioner: "rolling",
+		hooks:       &conf{Processes: map[string]string{"worker": "celery worker"}},
+	}
+	err = db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	p := newRollingProvisioner()
+	provision.Register("rolling", p)
+	p.Provision(&a)
+	defer p.Destroy(&a)
+	err = a.AddProcessUnits(2, "worker")
+	c.Assert(err, IsNil)
+	err = a.AddProcessUnits(1, "web")
+	c.Assert(err, IsNil)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Units, HasLen, 3)
+	c.Assert(a.Units[0].Process, Equals, "worker")
+	c.Assert(a.Units[1].Process, Equals, "worker")
+	c.Assert(a.Units[2].Process, Equals, "")
+}
+
+func (s *S) TestAddProcessUnitsUnknownProcess(c *C) {
+	provision.Register("rolling", newRollingProvisioner())
+	a := App{
+		Name:        "warpaint",
+		Framework:   "python",
+		Provisioner: "rolling",
+		hooks:       &conf{Processes: map[string]string{"worker": "celery worker"}},
+	}
+	err := a.AddProcessUnits(2, "clock")
+	c.Assert(err, Equals, ErrUnknownProcess)
+}
+
+func (s *S) TestAddProcessUnitsProvisionerIsNotAUnitCommander(c *C) {
+	a := App{
+		Name:      "warpaint",
+		Framework: "python",
+		hooks:     &conf{Processes: map[string]string{"worker": "celery worker"}},
+	}
+	err := a.AddProcessUnits(2, "worker")
+	c.Assert(err, Equals, ErrProcessNotSupported)
+}
+
+func (s *S) TestAddProcessUnitsOfAProcessWithUnits(c *C) {
+	server := testing.FakeQueueServer{}
+	server.Start("127.0.0.1:0")
+	defer server.Stop()
+	old, err := config.Get("queue-server")
+	if err != nil {
+		defer config.Set("queue-server", old)
+	}
+	config.Set("queue-server", server.Addr())
+	p := newRollingProvisioner()
+	provision.Register("rolling", p)
+	a := App{
+		Name:        "warpaint",
+		Framework:   "python",
+		Provisioner: "rolling",
+		State:       string(provision.StatusError),
+		Units:       []Unit{{Name: "warpaint/0", State: string(provision.StatusError), Process: "worker"}},
+	}
+	err = db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	p.Provision(&a)
+	defer p.Destroy(&a)
+	err = a.AddProcessUnits(1, "worker")
+	c.Assert(err, IsNil)
+	c.Assert(p.GetCmds("", &a), HasLen, 0)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Units, HasLen, 2)
+	c.Assert(a.Units[1].Process, Equals, "worker")
+}
+
+func (s *S) TestRemoveProcessUnits(c *C) {
+	a := App{
+		Name:      "chemistry",
+		Framework: "python",
+		Units: []Unit{
+			{Name: "chemistry/0", State: string(provision.StatusStarted)},
+			{Name: "chemistry/1", State: string(provision.StatusStarted), Process: "worker"},
+			{Name: "chemistry/2", State: string(provision.StatusDown), Process: "worker"},
+		},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	s.provisioner.AddUnits(&a, 2)
+	err = a.RemoveProcessUnits(1, "worker")
+	c.Assert(err, IsNil)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Units, HasLen, 2)
+	c.Assert(a.Units[0].Name, Equals, "chemistry/0")
+	c.Assert(a.Units[1].Name, Equals, "chemistry/1")
+}
+
+func (s *S) TestRemoveProcessUnitsValidation(c *C) {
+	a := App{
+		Name: "chemistry",
+		Units: []Unit{
+			{Name: "chemistry/0"},
+			{Name: "chemistry/1", Process: "worker"},
+		},
+	}
+	err := a.RemoveProcessUnits(0, "worker")
+	c.Assert(err, ErrorMatches, "Cannot remove zero units.")
+	err = a.RemoveProcessUnits(1, "clock")
+	c.Assert(err, ErrorMatches, "The app has no units of the process clock.")
+	err = a.RemoveProcessUnits(2, "worker")
+	c.Assert(err, ErrorMatches, "Cannot remove 2 units of the process worker, the app has only 1.")
+}
+
+func (s *S) TestRestartStartsTheCommandsOfProcessUnits(c *C) {
+	old, _ := config.Get("git:unit-repo")
+	config.Set("git:unit-repo", "/home/application/current")
+	defer config.Set("git:unit-repo", old)
+	p := newRollingProvisioner()
+	provision.Register("rolling", p)
+	p.PrepareOutput(nil)
+	p.PrepareOutput(nil)
+	a := App{
+		Name:        "someApp",
+		Provisioner: "rolling",
+		State:       string(provision.StatusStarted),
+		Units: []Unit{
+			{Name: "someApp/0", State: string(provision.StatusStarted)},
+			{Name: "someApp/1", State: string(provision.StatusStarted), Process: "worker"},
+		},
+		hooks: &conf{
+			RestartBatch: 2,
+			Processes:    map[string]string{"worker": "celery worker"},
+		},
+	}
+	var b bytes.Buffer
+	err := a.Restart(&b)
+	c.Assert(err, IsNil)
+	cmds := p.GetCmds("", &a)
+	c.Assert(cmds, HasLen, 2)
+	c.Assert(cmds[0].Cmd, Equals, "/var/lib/tsuru/hooks/restart")
+	c.Assert(cmds[0].Unit, Equals, "someApp/0")
+	c.Assert(cmds[1].Cmd, Equals, processCommand("worker", "celery worker", "/home/application/current"))
+	c.Assert(cmds[1].Unit, Equals, "someApp/1")
+}
+
+func (s *S) TestRestartProcessUnitsProvisionerIsNotAUnitCommander(c *C) {
+	a := App{
+		Name:  "someApp",
+		State: string(provision.StatusStarted),
+		Units: []Unit{
+			{Name: "someApp/0", State: string(provision.StatusStarted)},
+			{Name: "someApp/1", State: string(provision.StatusStarted), Process: "worker"},
+		},
+		hooks: &conf{Processes: map[string]string{"worker": "celery worker"}},
+	}
+	var b bytes.Buffer
+	err := a.runRestartHook(&b)
+	c.Assert(err, Equals, ErrProcessNotSupported)
+	c.Assert(s.provisioner.GetCmds("", &a), HasLen, 0)
+}
+
+func (s *S) TestProcessCommand(c *C) {
+	cmd := processCommand("worker", "celery worker -l 'info'", "/home/application/current")
+	expected := "[ -f /home/application/worker.pid ] && kill $(cat /home/application/worker.pid); " +
+		"[ -f /home/application/apprc ] && . /home/application/apprc; " +
+		`cd /home/application/current && { nohup sh -c 'celery worker -l '\''info'\''' >> /home/application/worker.log 2>&1 & echo $! > /home/application/worker.pid; }`
+	c.Assert(cmd, Equals, expected)
+}
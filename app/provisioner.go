@@ -0,0 +1,73 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/provision"
+	"sort"
+)
+
+// DefaultProvisioner returns the name of the provisioner used for apps that
+// don't match any override. It's defined by the "provisioner" setting, and
+// defaults to juju.
+func DefaultProvisioner() string {
+	name, err := config.GetString("provisioner")
+	if err != nil {
+		return "juju"
+	}
+	return name
+}
+
+// chooseProvisioner returns the name of the provisioner that should be used
+// for a new app.
+//
+// Team overrides are checked first (provisioners:teams:<team>), followed by
+// framework overrides (provisioners:frameworks:<framework>). If none of them
+// is defined, the default provisioner is used.
+func chooseProvisioner(a *App) string {
+	for _, team := range a.Teams {
+		if name, err := config.GetString("provisioners:teams:" + team); err == nil {
+			return name
+		}
+	}
+	if name, err := config.GetString("provisioners:frameworks:" + a.Framework); err == nil {
+		return name
+	}
+	return DefaultProvisioner()
+}
+
+// ProvisionerName returns the name of the provisioner of the app. Apps that
+// were created before tsuru recorded the provisioner of each app use the
+// default provisioner.
+func (a *App) ProvisionerName() string {
+	if a.Provisioner == "" {
+		return DefaultProvisioner()
+	}
+	return a.Provisioner
+}
+
+func (a *App) getProvisioner() (provision.Provisioner, error) {
+	return provision.Get(a.ProvisionerName())
+}
+
+// ProvisionerNames returns the sorted list of names of provisioners in use by
+// apps, always including the default provisioner.
+func ProvisionerNames() ([]string, error) {
+	var used []string
+	err := db.Session.Apps().Find(nil).Distinct("provisioner", &used)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{DefaultProvisioner()}
+	for _, name := range used {
+		if name != "" && name != names[0] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
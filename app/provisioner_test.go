@@ -0,0 +1,84 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/db"
+	"labix.org/v2/mgo/bson"
+	. "launchpad.net/gocheck"
+)
+
+func (s *S) TestDefaultProvisioner(c *C) {
+	c.Assert(DefaultProvisioner(), Equals, "fake")
+}
+
+func (s *S) TestDefaultProvisionerUndefined(c *C) {
+	old, _ := config.Get("provisioner")
+	defer config.Set("provisioner", old)
+	config.Unset("provisioner")
+	c.Assert(DefaultProvisioner(), Equals, "juju")
+}
+
+func (s *S) TestChooseProvisioner(c *C) {
+	a := App{Name: "blues", Framework: "python", Teams: []string{s.team.Name}}
+	c.Assert(chooseProvisioner(&a), Equals, "fake")
+}
+
+func (s *S) TestChooseProvisionerByFramework(c *C) {
+	config.Set("provisioners:frameworks:python", "local")
+	defer config.Unset("provisioners")
+	a := App{Name: "blues", Framework: "python", Teams: []string{s.team.Name}}
+	c.Assert(chooseProvisioner(&a), Equals, "local")
+	a.Framework = "ruby"
+	c.Assert(chooseProvisioner(&a), Equals, "fake")
+}
+
+func (s *S) TestChooseProvisionerTeamTakesPrecedence(c *C) {
+	config.Set("provisioners:frameworks:python", "local")
+	config.Set("provisioners:teams:"+s.team.Name, "juju")
+	defer config.Unset("provisioners")
+	a := App{Name: "blues", Framework: "python", Teams: []string{"other", s.team.Name}}
+	c.Assert(chooseProvisioner(&a), Equals, "juju")
+}
+
+func (s *S) TestProvisionerName(c *C) {
+	a := App{Name: "blues", Provisioner: "local"}
+	c.Assert(a.ProvisionerName(), Equals, "local")
+}
+
+func (s *S) TestProvisionerNameWithoutProvisioner(c *C) {
+	a := App{Name: "blues"}
+	c.Assert(a.ProvisionerName(), Equals, "fake")
+}
+
+func (s *S) TestGetProvisioner(c *C) {
+	a := App{Name: "blues"}
+	p, err := a.getProvisioner()
+	c.Assert(err, IsNil)
+	c.Assert(p, Equals, s.provisioner)
+}
+
+func (s *S) TestGetProvisionerUnknown(c *C) {
+	a := App{Name: "blues", Provisioner: "unknown"}
+	_, err := a.getProvisioner()
+	c.Assert(err, NotNil)
+}
+
+func (s *S) TestProvisionerNames(c *C) {
+	apps := []App{
+		{Name: "blues", Provisioner: "local"},
+		{Name: "jazz", Provisioner: "fake"},
+		{Name: "rock"},
+	}
+	for _, a := range apps {
+		err := db.Session.Apps().Insert(a)
+		c.Assert(err, IsNil)
+	}
+	defer db.Session.Apps().RemoveAll(bson.M{"name": bson.M{"$in": []string{"blues", "jazz", "rock"}}})
+	names, err := ProvisionerNames()
+	c.Assert(err, IsNil)
+	c.Assert(names, DeepEquals, []string{"fake", "local"})
+}
@@ -0,0 +1,241 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/globocom/tsuru/provision"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const defaultHealthcheckTimeout = 60
+
+// healthcheckInterval is the time between two attempts of checking the health
+// of a unit.
+var healthcheckInterval = time.Second
+
+var healthcheckClient = &http.Client{
+	Transport: &http.Transport{
+		Dial: func(network, addr string) (net.Conn, error) {
+			return net.DialTimeout(network, addr, 5*time.Second)
+		},
+	},
+}
+
+// healthcheck is the health check declared in the app.conf file. A unit is
+// healthy when a GET request to the path returns the expected status (200 by
+// default) and the command exits successfully in the unit. Both are optional.
+//
+// Example:
+//
+//     healthcheck:
+//       path: /healthcheck
+//       port: 8080
+//       status: 200
+//       command: deploy/check.sh
+//       timeout: 60
+type healthcheck struct {
+	Path    string
+	Port    int
+	Status  int
+	Command string
+	// Timeout is the number of seconds to wait for a unit to become
+	// healthy.
+	Timeout int
+}
+
+func (h *healthcheck) enabled() bool {
+	return h.Path != "" || h.Command != ""
+}
+
+func (h *healthcheck) timeout() time.Duration {
+	if h.Timeout < 1 {
+		return defaultHealthcheckTimeout * time.Second
+	}
+	return time.Duration(h.Timeout) * time.Second
+}
+
+func (h *healthcheck) url(ip string) string {
+	port := h.Port
+	if port == 0 {
+		port = 80
+	}
+	path := h.Path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return fmt.Sprintf("http://%s:%d%s", ip, port, path)
+}
+
+func (h *healthcheck) checkHTTP(ip string) error {
+	if ip == "" {
+		return fmt.Errorf("the unit has no IP address")
+	}
+	status := h.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	url := h.url(ip)
+	resp, err := healthcheckClient.Get(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != status {
+		return fmt.Errorf("GET %s returned status %d, expected %d", url, resp.StatusCode, status)
+	}
+	return nil
+}
+
+// checkUnit runs the health check of the app once against the given unit.
+func (a *App) checkUnit(name string) error {
+	h := &a.hooks.Healthcheck
+	if h.Path != "" {
+		var ip string
+		for _, u := range a.Units {
+			if u.Name == name {
+				ip = u.Ip
+				break
+			}
+		}
+		if err := h.checkHTTP(ip); err != nil {
+			return err
+		}
+	}
+	if h.Command != "" {
+		var buf bytes.Buffer
+		if err := a.Run(h.Command, &buf, name); err != nil {
+			return fmt.Errorf("%q failed: %s", h.Command, err)
+		}
+	}
+	return nil
+}
+
+// waitHealthy waits for each of the given units to pass the health check
+// declared in app.conf, giving up on the first unit that does not pass it
+// before the timeout.
+func (a *App) waitHealthy(w io.Writer, units ...string) error {
+	if !a.hooks.Healthcheck.enabled() {
+		return nil
+	}
+	for _, name := range units {
+		err := write(w, []byte(fmt.Sprintf("\n ---> Waiting for %s to pass the health check\n", name)))
+		if err != nil {
+			return err
+		}
+		deadline := time.Now().Add(a.hooks.Healthcheck.timeout())
+		for err = a.checkUnit(name); err != nil; err = a.checkUnit(name) {
+			if time.Now().After(deadline) {
+				a.Log(fmt.Sprintf("Unit %q failed the health check: %s", name, err), "tsuru")
+				return fmt.Errorf("Unit %q failed the health check: %s", name, err)
+			}
+			time.Sleep(healthcheckInterval)
+		}
+	}
+	return nil
+}
+
+func (a *App) unitNames() []string {
+	names := make([]string, len(a.Units))
+	for i, u := range a.Units {
+		names[i] = u.Name
+	}
+	return names
+}
+
+// Restart runs the restart hook for the app
+// and returns your output.
+//
+// When the provisioner of the app is able to run commands in specific units
+// (see provision.UnitCommander), the units are restarted in batches (see
+// rollingRestart). Apps whose provisioner is a provision.Restarter are
+// restarted by the provisioner instead of the restart hook, unless they have
+// units of process types other than the default one. When names of units
+// are given, only these units are restarted, unless the provisioner is not able
+// to run commands in specific units: then all units of the app are restarted.
+//
+// If app.conf declares a health check, Restart waits for the restarted units
+// to pass it.
+func (a *App) Restart(w io.Writer, units ...string) error {
+	a.Log("executing hook to restart", "tsuru")
+	p, err := a.getProvisioner()
+	if err != nil {
+		return err
+	}
+	if err = a.loadHooks(); err != nil {
+		return err
+	}
+	_, restarter := p.(provision.Restarter)
+	_, commander := p.(provision.UnitCommander)
+	if !commander {
+		units = nil
+	}
+	if len(units) == 0 && len(a.Units) > 0 && commander && (!restarter || a.hasProcessUnits()) {
+		return a.rollingRestart(w, p)
+	}
+	err = a.restart(w, p, "\n ---> Restarting your app\n", units...)
+	if err != nil {
+		return err
+	}
+	if len(units) == 0 {
+		units = a.unitNames()
+	}
+	return a.waitHealthy(w, units...)
+}
+
+func (a *App) restart(w io.Writer, p provision.Provisioner, msg string, units ...string) error {
+	err := a.preRestart(w, units...)
+	if err != nil {
+		return err
+	}
+	err = write(w, []byte(msg))
+	if err != nil {
+		return err
+	}
+	if restarter, ok := p.(provision.Restarter); ok && len(units) == 0 && !a.hasProcessUnits() {
+		err = restarter.Restart(a)
+	} else {
+		err = a.runRestartHook(w, units...)
+	}
+	if err != nil {
+		return err
+	}
+	return a.posRestart(w, units...)
+}
+
+// rollingRestart restarts the units of the app in batches, whose size is
+// defined by the restart-batch setting in app.conf (one unit at a time by
+// default). After restarting a batch, it waits for the units of the batch to
+// pass the health check before moving on to the next one, so a release that
+// does not come up does not take the whole app down.
+func (a *App) rollingRestart(w io.Writer, p provision.Provisioner) error {
+	size := a.hooks.RestartBatch
+	if size < 1 {
+		size = 1
+	}
+	names := a.unitNames()
+	for i := 0; i < len(names); i += size {
+		end := i + size
+		if end > len(names) {
+			end = len(names)
+		}
+		batch := names[i:end]
+		msg := fmt.Sprintf("\n ---> Restarting %s\n", strings.Join(batch, ", "))
+		err := a.restart(w, p, msg, batch...)
+		if err == nil {
+			err = a.waitHealthy(w, batch...)
+		}
+		if err != nil {
+			remaining := len(names) - end
+			return fmt.Errorf("Rolling restart aborted: %s. %d of %d units were not restarted.", err, remaining, len(names))
+		}
+	}
+	return nil
+}
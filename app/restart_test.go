@@ -0,0 +1,217 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	"github.com/globocom/tsuru/provision"
+	"github.com/globocom/tsuru/testing"
+	"io"
+	. "launchpad.net/gocheck"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rollingProvisioner is able to run commands in specific units, but is not a
+// provision.Restarter, so apps using it are restarted in batches.
+type rollingProvisioner struct {
+	*testing.FakeProvisioner
+	commander *testing.ExtensibleFakeProvisioner
+}
+
+func newRollingProvisioner() *rollingProvisioner {
+	p := testing.NewExtensibleFakeProvisioner()
+	return &rollingProvisioner{FakeProvisioner: p.FakeProvisioner, commander: p}
+}
+
+func (p *rollingProvisioner) ExecuteCommandOnUnit(stdout, stderr io.Writer, app provision.App, unit string, cmd string, args ...string) error {
+	return p.commander.ExecuteCommandOnUnit(stdout, stderr, app, unit, cmd, args...)
+}
+
+func healthcheckServer(status int) (*httptest.Server, int) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	n, _ := strconv.Atoi(port)
+	return server, n
+}
+
+func (s *S) TestRollingRestart(c *C) {
+	p := newRollingProvisioner()
+	provision.Register("rolling", p)
+	for i := 0; i < 3; i++ {
+		p.PrepareOutput([]byte("restarted"))
+	}
+	a := App{
+		Name:        "someApp",
+		Provisioner: "rolling",
+		State:       string(provision.StatusStarted),
+		Units: []Unit{
+			{Name: "someApp/0", State: string(provision.StatusStarted)},
+			{Name: "someApp/1", State: string(provision.StatusStarted)},
+			{Name: "someApp/2", State: string(provision.StatusStarted)},
+		},
+		hooks: &conf{RestartBatch: 2},
+	}
+	var b bytes.Buffer
+	err := a.Restart(&b)
+	c.Assert(err, IsNil)
+	result := strings.Replace(b.String(), "\n", "#", -1)
+	c.Assert(result, Matches, "^# ---> Restarting someApp/0, someApp/1#restartedrestarted# ---> Restarting someApp/2#restarted$")
+	cmds := p.GetCmds("/var/lib/tsuru/hooks/restart", &a)
+	c.Assert(cmds, HasLen, 3)
+	c.Assert(cmds[0].Unit, Equals, "someApp/0")
+	c.Assert(cmds[1].Unit, Equals, "someApp/1")
+	c.Assert(cmds[2].Unit, Equals, "someApp/2")
+}
+
+func (s *S) TestRollingRestartWaitsForTheHealthcheck(c *C) {
+	p := newRollingProvisioner()
+	provision.Register("rolling", p)
+	for i := 0; i < 4; i++ {
+		p.PrepareOutput(nil)
+	}
+	a := App{
+		Name:        "someApp",
+		Provisioner: "rolling",
+		State:       string(provision.StatusStarted),
+		Units: []Unit{
+			{Name: "someApp/0", State: string(provision.StatusStarted)},
+			{Name: "someApp/1", State: string(provision.StatusStarted)},
+		},
+		hooks: &conf{Healthcheck: healthcheck{Command: "deploy/check.sh"}},
+	}
+	var b bytes.Buffer
+	err := a.Restart(&b)
+	c.Assert(err, IsNil)
+	cmds := p.GetCmds("", &a)
+	c.Assert(cmds, HasLen, 4)
+	c.Assert(cmds[0].Cmd, Equals, "/var/lib/tsuru/hooks/restart")
+	c.Assert(cmds[0].Unit, Equals, "someApp/0")
+	c.Assert(cmds[1].Cmd, Matches, ".*; deploy/check.sh$")
+	c.Assert(cmds[1].Unit, Equals, "someApp/0")
+	c.Assert(cmds[2].Cmd, Equals, "/var/lib/tsuru/hooks/restart")
+	c.Assert(cmds[2].Unit, Equals, "someApp/1")
+	c.Assert(cmds[3].Cmd, Matches, ".*; deploy/check.sh$")
+	c.Assert(cmds[3].Unit, Equals, "someApp/1")
+	c.Assert(b.String(), Matches, "(?s).* ---> Waiting for someApp/0 to pass the health check.*")
+}
+
+func (s *S) TestRollingRestartAbortsWhenAUnitFailsTheHealthcheck(c *C) {
+	old := healthcheckInterval
+	healthcheckInterval = 1e8
+	defer func() { healthcheckInterval = old }()
+	server, port := healthcheckServer(http.StatusInternalServerError)
+	defer server.Close()
+	p := newRollingProvisioner()
+	provision.Register("rolling", p)
+	p.PrepareOutput(nil)
+	a := App{
+		Name:        "someApp",
+		Provisioner: "rolling",
+		State:       string(provision.StatusStarted),
+		Units: []Unit{
+			{Name: "someApp/0", Ip: "127.0.0.1", State: string(provision.StatusStarted)},
+			{Name: "someApp/1", Ip: "127.0.0.1", State: string(provision.StatusStarted)},
+		},
+		hooks: &conf{Healthcheck: healthcheck{Path: "/healthcheck", Port: port, Timeout: 1}},
+	}
+	var b bytes.Buffer
+	err := a.Restart(&b)
+	c.Assert(err, NotNil)
+	expected := `^Rolling restart aborted: Unit "someApp/0" failed the health check: ` +
+		`GET http://127.0.0.1:\d+/healthcheck returned status 500, expected 200\. ` +
+		`1 of 2 units were not restarted\.$`
+	c.Assert(err.Error(), Matches, expected)
+	cmds := p.GetCmds("/var/lib/tsuru/hooks/restart", &a)
+	c.Assert(cmds, HasLen, 1)
+	c.Assert(cmds[0].Unit, Equals, "someApp/0")
+}
+
+func (s *S) TestRestartWaitsForTheHealthcheckOfAllUnits(c *C) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/status" {
+			requests++
+		}
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	n, _ := strconv.Atoi(port)
+	s.provisioner.PrepareOutput([]byte("restarted"))
+	a := App{
+		Name:  "someApp",
+		State: string(provision.StatusStarted),
+		Units: []Unit{
+			{Name: "someApp/0", Ip: "127.0.0.1", State: string(provision.StatusStarted)},
+			{Name: "someApp/1", Ip: "127.0.0.1", State: string(provision.StatusStarted)},
+		},
+		hooks: &conf{Healthcheck: healthcheck{Path: "status", Port: n}},
+	}
+	var b bytes.Buffer
+	err := a.Restart(&b)
+	c.Assert(err, IsNil)
+	c.Assert(requests, Equals, 2)
+	result := strings.Replace(b.String(), "\n", "#", -1)
+	expected := "^# ---> Restarting your app#restarted" +
+		"# ---> Waiting for someApp/0 to pass the health check#" +
+		"# ---> Waiting for someApp/1 to pass the health check#$"
+	c.Assert(result, Matches, expected)
+}
+
+func (s *S) TestRestartWithoutHealthcheck(c *C) {
+	s.provisioner.PrepareOutput([]byte("restarted"))
+	a := App{
+		Name:  "someApp",
+		State: string(provision.StatusStarted),
+		Units: []Unit{{Name: "someApp/0", State: string(provision.StatusStarted)}},
+		hooks: &conf{},
+	}
+	var b bytes.Buffer
+	err := a.Restart(&b)
+	c.Assert(err, IsNil)
+	c.Assert(b.String(), Equals, "\n ---> Restarting your app\nrestarted")
+}
+
+func (s *S) TestHealthcheckURL(c *C) {
+	h := healthcheck{Path: "healthcheck"}
+	c.Assert(h.url("10.10.10.10"), Equals, "http://10.10.10.10:80/healthcheck")
+	h = healthcheck{Path: "/status", Port: 8080}
+	c.Assert(h.url("10.10.10.10"), Equals, "http://10.10.10.10:8080/status")
+}
+
+func (s *S) TestHealthcheckTimeout(c *C) {
+	h := healthcheck{}
+	c.Assert(h.timeout(), Equals, 60*time.Second)
+	h.Timeout = 10
+	c.Assert(h.timeout(), Equals, 10*time.Second)
+}
+
+func (s *S) TestHealthcheckEnabled(c *C) {
+	c.Assert((&healthcheck{}).enabled(), Equals, false)
+	c.Assert((&healthcheck{Path: "/"}).enabled(), Equals, true)
+	c.Assert((&healthcheck{Command: "true"}).enabled(), Equals, true)
+}
+
+func (s *S) TestHealthcheckCheckHTTPWithoutIp(c *C) {
+	h := healthcheck{Path: "/"}
+	err := h.checkHTTP("")
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "the unit has no IP address")
+}
+
+func (s *S) TestHealthcheckCheckHTTPExpectedStatus(c *C) {
+	server, port := healthcheckServer(http.StatusNoContent)
+	defer server.Close()
+	h := healthcheck{Path: "/", Port: port, Status: http.StatusNoContent}
+	c.Assert(h.checkHTTP("127.0.0.1"), IsNil)
+	h.Status = 0
+	c.Assert(h.checkHTTP("127.0.0.1"), NotNil)
+}
@@ -0,0 +1,191 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"errors"
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/db"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultLogMaxAge is the number of days log entries are kept for, when no
+// retention policy is defined in tsuru.conf.
+const defaultLogMaxAge = 30
+
+// ErrRetentionPolicyNotFound is returned when removing a retention policy that
+// the app doesn't have.
+var ErrRetentionPolicyNotFound = errors.New("The app doesn't have a retention policy for this source.")
+
+// RetentionPolicy defines for how long, and how many, entries from a source
+// are kept in the logs of an app. MaxAge is in days. Zero values mean no
+// limit.
+//
+// Policies are defined by source in tsuru.conf, and apps may override them
+// (see App.LogRetention):
+//
+//     log:
+//       retention:
+//         tsuru:
+//           max-age: 365
+//         app:
+//           max-age: 7
+//           max-count: 10000
+//         default:
+//           max-age: 30
+//
+// The default policy applies to sources that don't have a policy. Without a
+// default policy, entries are kept for 30 days.
+type RetentionPolicy struct {
+	MaxAge   int
+	MaxCount int
+}
+
+// globalRetentionPolicy returns the retention policy defined in tsuru.conf for
+// the given source.
+func globalRetentionPolicy(source string) RetentionPolicy {
+	for _, key := range []string{source, "default"} {
+		prefix := "log:retention:" + key
+		maxAge, ageErr := config.GetInt(prefix + ":max-age")
+		maxCount, countErr := config.GetInt(prefix + ":max-count")
+		if ageErr == nil || countErr == nil {
+			return RetentionPolicy{MaxAge: maxAge, MaxCount: maxCount}
+		}
+	}
+	return RetentionPolicy{MaxAge: defaultLogMaxAge}
+}
+
+// SourceRetentionPolicy is the retention policy of a source in the logs of an
+// app. Custom indicates whether the policy was set for the app.
+type SourceRetentionPolicy struct {
+	Source string
+	RetentionPolicy
+	Custom bool
+}
+
+// RetentionPolicy returns the retention policy of the given source in the
+// logs of the app.
+func (a *App) RetentionPolicy(source string) SourceRetentionPolicy {
+	if p, ok := a.LogRetention[source]; ok {
+		return SourceRetentionPolicy{Source: source, RetentionPolicy: p, Custom: true}
+	}
+	return SourceRetentionPolicy{Source: source, RetentionPolicy: globalRetentionPolicy(source)}
+}
+
+// RetentionPolicies returns the retention policies of the sources found in
+// the logs of the app, and of the sources with policies set for the app,
+// sorted by source.
+func (a *App) RetentionPolicies() ([]SourceRetentionPolicy, error) {
+	var sources []string
+	if err := db.Session.Logs().Find(bson.M{"appname": a.Name}).Distinct("source", &sources); err != nil {
+		return nil, err
+	}
+	for source := range a.LogRetention {
+		found := false
+		for _, s := range sources {
+			found = found || s == source
+		}
+		if !found {
+			sources = append(sources, source)
+		}
+	}
+	sort.Strings(sources)
+	policies := make([]SourceRetentionPolicy, len(sources))
+	for i, source := range sources {
+		policies[i] = a.RetentionPolicy(source)
+	}
+	return policies, nil
+}
+
+// SetRetentionPolicy sets the retention policy of the given source in the
+// logs of the app, overriding the one defined in tsuru.conf.
+func (a *App) SetRetentionPolicy(source string, p RetentionPolicy) error {
+	if source == "" || strings.ContainsAny(source, ".$") {
+		return &ValidationError{Message: "Invalid source name."}
+	}
+	if p.MaxAge < 0 || p.MaxCount < 0 {
+		return &ValidationError{Message: "The maximum age and count must not be negative."}
+	}
+	err := db.Session.Apps().Update(bson.M{"name": a.Name}, bson.M{"$set": bson.M{"logretention." + source: p}})
+	if err != nil {
+		return err
+	}
+	if a.LogRetention == nil {
+		a.LogRetention = make(map[string]RetentionPolicy)
+	}
+	a.LogRetention[source] = p
+	return nil
+}
+
+// UnsetRetentionPolicy removes the retention policy set for the given source
+// in the logs of the app, which goes back to the one defined in tsuru.conf.
+func (a *App) UnsetRetentionPolicy(source string) error {
+	if _, ok := a.LogRetention[source]; !ok {
+		return ErrRetentionPolicyNotFound
+	}
+	err := db.Session.Apps().Update(bson.M{"name": a.Name}, bson.M{"$unset": bson.M{"logretention." + source: 1}})
+	if err != nil {
+		return err
+	}
+	delete(a.LogRetention, source)
+	return nil
+}
+
+// PurgeLogs removes from the logs of the app the entries that are older, or
+// beyond the count, allowed by the retention policies of their sources. It
+// returns the number of entries removed.
+func (a *App) PurgeLogs() (int, error) {
+	var sources []string
+	if err := db.Session.Logs().Find(bson.M{"appname": a.Name}).Distinct("source", &sources); err != nil {
+		return 0, err
+	}
+	var purged int
+	for _, source := range sources {
+		n, err := a.purgeSource(source, a.RetentionPolicy(source).RetentionPolicy)
+		purged += n
+		if err != nil {
+			return purged, err
+		}
+	}
+	return purged, nil
+}
+
+func (a *App) purgeSource(source string, p RetentionPolicy) (int, error) {
+	var purged int
+	if p.MaxAge > 0 {
+		limit := time.Now().Add(-time.Duration(p.MaxAge) * 24 * time.Hour)
+		info, err := db.Session.Logs().RemoveAll(bson.M{"appname": a.Name, "source": source, "date": bson.M{"$lt": limit}})
+		if err != nil {
+			return purged, err
+		}
+		purged += info.Removed
+	}
+	if p.MaxCount > 0 {
+		// Finds the newest entry beyond the count, and removes it along
+		// with the older ones.
+		var last Applog
+		err := db.Session.Logs().Find(bson.M{"appname": a.Name, "source": source}).Sort("-date", "-_id").Skip(p.MaxCount).One(&last)
+		if err == mgo.ErrNotFound {
+			return purged, nil
+		}
+		if err != nil {
+			return purged, err
+		}
+		older := []bson.M{
+			{"date": bson.M{"$lt": last.Date}},
+			{"date": last.Date, "_id": bson.M{"$lte": last.Id}},
+		}
+		info, err := db.Session.Logs().RemoveAll(bson.M{"appname": a.Name, "source": source, "$or": older})
+		if err != nil {
+			return purged, err
+		}
+		purged += info.Removed
+	}
+	return purged, nil
+}
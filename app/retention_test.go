@@ -0,0 +1,181 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/db"
+	"labix.org/v2/mgo/bson"
+	. "launchpad.net/gocheck"
+	"strconv"
+	"time"
+)
+
+func (s *S) TestRetentionPolicyDefault(c *C) {
+	a := App{Name: "ancient"}
+	p := a.RetentionPolicy("app")
+	c.Assert(p, DeepEquals, SourceRetentionPolicy{Source: "app", RetentionPolicy: RetentionPolicy{MaxAge: 30}})
+}
+
+func (s *S) TestRetentionPolicyFromConfig(c *C) {
+	config.Set("log:retention:tsuru:max-age", 365)
+	config.Set("log:retention:default:max-count", 1000)
+	defer config.Unset("log:retention")
+	a := App{Name: "ancient"}
+	c.Assert(a.RetentionPolicy("tsuru").RetentionPolicy, DeepEquals, RetentionPolicy{MaxAge: 365})
+	c.Assert(a.RetentionPolicy("app").RetentionPolicy, DeepEquals, RetentionPolicy{MaxCount: 1000})
+}
+
+func (s *S) TestRetentionPolicyOverriddenByApp(c *C) {
+	config.Set("log:retention:app:max-age", 7)
+	defer config.Unset("log:retention")
+	a := App{
+		Name:         "ancient",
+		LogRetention: map[string]RetentionPolicy{"app": {MaxCount: 50}},
+	}
+	p := a.RetentionPolicy("app")
+	c.Assert(p, DeepEquals, SourceRetentionPolicy{Source: "app", RetentionPolicy: RetentionPolicy{MaxCount: 50}, Custom: true})
+}
+
+func (s *S) TestRetentionPolicies(c *C) {
+	a := App{
+		Name:         "ancient",
+		LogRetention: map[string]RetentionPolicy{"tsuru": {MaxAge: 365}, "worker": {MaxCount: 10}},
+	}
+	err := a.Log("started", "tsuru")
+	c.Assert(err, IsNil)
+	err = a.Log("listening", "app")
+	c.Assert(err, IsNil)
+	policies, err := a.RetentionPolicies()
+	c.Assert(err, IsNil)
+	expected := []SourceRetentionPolicy{
+		{Source: "app", RetentionPolicy: RetentionPolicy{MaxAge: 30}},
+		{Source: "tsuru", RetentionPolicy: RetentionPolicy{MaxAge: 365}, Custom: true},
+		{Source: "worker", RetentionPolicy: RetentionPolicy{MaxCount: 10}, Custom: true},
+	}
+	c.Assert(policies, DeepEquals, expected)
+}
+
+func (s *S) TestSetRetentionPolicy(c *C) {
+	a := App{Name: "ancient"}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	err = a.SetRetentionPolicy("app", RetentionPolicy{MaxAge: 7, MaxCount: 100})
+	c.Assert(err, IsNil)
+	c.Assert(a.LogRetention, DeepEquals, map[string]RetentionPolicy{"app": {MaxAge: 7, MaxCount: 100}})
+	var stored App
+	err = db.Session.Apps().Find(bson.M{"name": a.Name}).One(&stored)
+	c.Assert(err, IsNil)
+	c.Assert(stored.LogRetention, DeepEquals, a.LogRetention)
+}
+
+func (s *S) TestSetRetentionPolicyValidation(c *C) {
+	a := App{Name: "ancient"}
+	for _, source := range []string{"", "app.web", "$app"} {
+		err := a.SetRetentionPolicy(source, RetentionPolicy{MaxAge: 7})
+		_, ok := err.(*ValidationError)
+		c.Check(ok, Equals, true, Commentf("source %q", source))
+	}
+	err := a.SetRetentionPolicy("app", RetentionPolicy{MaxAge: -1})
+	_, ok := err.(*ValidationError)
+	c.Assert(ok, Equals, true)
+	c.Assert(a.LogRetention, HasLen, 0)
+}
+
+func (s *S) TestUnsetRetentionPolicy(c *C) {
+	a := App{
+		Name:         "ancient",
+		LogRetention: map[string]RetentionPolicy{"app": {MaxAge: 7}, "tsuru": {MaxAge: 365}},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	err = a.UnsetRetentionPolicy("app")
+	c.Assert(err, IsNil)
+	c.Assert(a.LogRetention, DeepEquals, map[string]RetentionPolicy{"tsuru": {MaxAge: 365}})
+	var stored App
+	err = db.Session.Apps().Find(bson.M{"name": a.Name}).One(&stored)
+	c.Assert(err, IsNil)
+	c.Assert(stored.LogRetention, DeepEquals, a.LogRetention)
+}
+
+func (s *S) TestUnsetRetentionPolicyNotFound(c *C) {
+	a := App{Name: "ancient"}
+	err := a.UnsetRetentionPolicy("app")
+	c.Assert(err, Equals, ErrRetentionPolicyNotFound)
+}
+
+func (s *S) TestPurgeLogsByAge(c *C) {
+	config.Set("log:retention:tsuru:max-age", 365)
+	defer config.Unset("log:retention")
+	a := App{Name: "ancient"}
+	now := time.Now()
+	entries := []interface{}{
+		Applog{Date: now.Add(-40 * 24 * time.Hour), Message: "old", Source: "app", AppName: a.Name},
+		Applog{Date: now.Add(-time.Hour), Message: "recent", Source: "app", AppName: a.Name},
+		Applog{Date: now.Add(-40 * 24 * time.Hour), Message: "old", Source: "tsuru", AppName: a.Name},
+		Applog{Date: now.Add(-40 * 24 * time.Hour), Message: "old", Source: "app", AppName: "other"},
+	}
+	err := db.Session.Logs().Insert(entries...)
+	c.Assert(err, IsNil)
+	n, err := a.PurgeLogs()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+	logs, err := a.LastLogs(0, LogFilter{})
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 2)
+	c.Assert(logs[0].Source, Equals, "tsuru")
+	c.Assert(logs[1].Message, Equals, "recent")
+	n, err = db.Session.Logs().Find(bson.M{"appname": "other"}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+}
+
+func (s *S) TestPurgeLogsByCount(c *C) {
+	a := App{
+		Name:         "ancient",
+		LogRetention: map[string]RetentionPolicy{"app": {MaxCount: 3}},
+	}
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		// Two entries at each date, to make sure entries at the same date
+		// are told apart.
+		for _, source := range []string{"app", "tsuru"} {
+			l := Applog{
+				Date:    now.Add(time.Duration(i/2) * time.Minute),
+				Message: strconv.Itoa(i),
+				Source:  source,
+				AppName: a.Name,
+			}
+			err := db.Session.Logs().Insert(l)
+			c.Assert(err, IsNil)
+		}
+	}
+	n, err := a.PurgeLogs()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 2)
+	logs, err := a.LastLogs(0, LogFilter{Source: "app"})
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 3)
+	c.Assert(logs[0].Message, Equals, "2")
+	c.Assert(logs[2].Message, Equals, "4")
+	logs, err = a.LastLogs(0, LogFilter{Source: "tsuru"})
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 5)
+}
+
+func (s *S) TestPurgeLogsUnlimited(c *C) {
+	a := App{
+		Name:         "ancient",
+		LogRetention: map[string]RetentionPolicy{"app": {}},
+	}
+	l := Applog{Date: time.Now().Add(-400 * 24 * time.Hour), Message: "old", Source: "app", AppName: a.Name}
+	err := db.Session.Logs().Insert(l)
+	c.Assert(err, IsNil)
+	n, err := a.PurgeLogs()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+}
@@ -197,8 +197,14 @@ func createBucket(app *App) (*s3Env, error) {
 func destroyBucket(app *App) error {
 	appName := strings.ToLower(app.Name)
 	env := app.InstanceEnv(s3InstanceName)
-	accessKeyId := env["TSURU_S3_ACCESS_KEY_ID"].Value
-	bucketName := env["TSURU_S3_BUCKET"].Value
+	accessKeyId, err := envValue(env["TSURU_S3_ACCESS_KEY_ID"])
+	if err != nil {
+		return err
+	}
+	bucketName, err := envValue(env["TSURU_S3_BUCKET"])
+	if err != nil {
+		return err
+	}
 	policyName := fmt.Sprintf("app-%s-bucket", appName)
 	s3Endpoint := getS3Endpoint()
 	iamEndpoint := getIAMEndpoint()
@@ -212,6 +218,6 @@ func destroyBucket(app *App) error {
 	if _, err := iamEndpoint.DeleteAccessKey(accessKeyId, appName); err != nil {
 		return err
 	}
-	_, err := iamEndpoint.DeleteUser(appName)
+	_, err = iamEndpoint.DeleteUser(appName)
 	return err
 }
@@ -10,6 +10,7 @@ import (
 	"github.com/globocom/tsuru/api/auth"
 	"github.com/globocom/tsuru/db"
 	fsTesting "github.com/globocom/tsuru/fs/testing"
+	"github.com/globocom/tsuru/provision"
 	tsuruTesting "github.com/globocom/tsuru/testing"
 	"io"
 	"labix.org/v2/mgo/bson"
@@ -85,7 +86,7 @@ func (s *S) SetUpSuite(c *C) {
 	s.t.StartAmzS3AndIAM(c)
 	s.t.SetGitConfs(c)
 	s.provisioner = tsuruTesting.NewFakeProvisioner()
-	Provisioner = s.provisioner
+	provision.Register("fake", s.provisioner)
 }
 
 func (s *S) TearDownSuite(c *C) {
@@ -99,6 +100,8 @@ func (s *S) TearDownSuite(c *C) {
 func (s *S) TearDownTest(c *C) {
 	s.t.RollbackGitConfs(c)
 	s.provisioner.Reset()
+	db.Session.Logs().RemoveAll(nil)
+	db.Session.Drains().RemoveAll(nil)
 }
 
 func (s *S) getTestData(p ...string) io.ReadCloser {
@@ -14,6 +14,10 @@ type Unit struct {
 	Machine int
 	Ip      string
 	State   string
+	// Process is the process type that runs in the unit, as declared in
+	// the Procfile of the app. Units without a process type run the
+	// default process (see DefaultProcess).
+	Process string
 	app     *App
 }
 
@@ -32,3 +36,12 @@ func (u *Unit) GetIp() string {
 func (u *Unit) GetStatus() provision.Status {
 	return provision.Status(u.State)
 }
+
+// ProcessName returns the process type of the unit, which defaults to
+// DefaultProcess.
+func (u *Unit) ProcessName() string {
+	if u.Process == "" {
+		return DefaultProcess
+	}
+	return u.Process
+}
@@ -6,6 +6,7 @@ package tsuru
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/globocom/tsuru/cmd"
 	"io"
@@ -13,12 +14,19 @@ import (
 	"launchpad.net/gnuflag"
 	"net/http"
 	"strings"
+	"time"
 )
 
 var AppName = gnuflag.String("app", "", "App name for running app related commands.")
 var AssumeYes = gnuflag.Bool("assume-yes", false, "Don't ask for confirmation on operations.")
 var LogLines = gnuflag.Int("lines", 10, "The number of log lines to display")
 var LogSource = gnuflag.String("source", "", "The log from the given source")
+var LogFollow = gnuflag.Bool("follow", false, "Keep showing the log entries as they are written")
+var LogUnit = gnuflag.String("unit", "", "The log from the given unit")
+var LogSince = gnuflag.String("since", "", "The log written since the given date, or duration ago")
+var LogUntil = gnuflag.String("until", "", "The log written until the given date, or duration ago")
+var LogFilter = gnuflag.String("filter", "", "The log entries containing the given text")
+var LogRegex = gnuflag.Bool("regex", false, "Use the text given to --filter as a regular expression")
 
 type AppInfo struct {
 	GuessingCommand
@@ -61,9 +69,10 @@ func (c *AppInfo) Run(context *cmd.Context, client cmd.Doer) error {
 }
 
 type unit struct {
-	Name  string
-	Ip    string
-	State string
+	Name    string
+	Ip      string
+	State   string
+	Process string
 }
 
 type app struct {
@@ -84,9 +93,13 @@ Teams: %s
 `
 	teams := strings.Join(a.Teams, ", ")
 	units := cmd.NewTable()
-	units.Headers = cmd.Row([]string{"Unit", "Ip", "State"})
+	units.Headers = cmd.Row([]string{"Unit", "Process", "Ip", "State"})
 	for _, unit := range a.Units {
-		units.AddRow(cmd.Row([]string{unit.Name, unit.Ip, unit.State}))
+		process := unit.Process
+		if process == "" {
+			process = "web"
+		}
+		units.AddRow(cmd.Row([]string{unit.Name, process, unit.Ip, unit.State}))
 	}
 	args := []interface{}{a.Name, a.State, a.Repository, a.Framework, teams}
 	if len(a.Units) > 0 {
@@ -269,3 +282,230 @@ If you don't provide the app name, tsuru will try to guess it.`,
 		MinArgs: 0,
 	}
 }
+
+type AppStop struct {
+	GuessingCommand
+}
+
+func (c *AppStop) Run(context *cmd.Context, client cmd.Doer) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	url := cmd.GetUrl(fmt.Sprintf("/apps/%s/stop", appName))
+	request, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, err = io.Copy(context.Stdout, response.Body)
+	return err
+}
+
+func (c *AppStop) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "stop",
+		Usage: "stop [--app appname]",
+		Desc: `stops an app, without removing its units.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+}
+
+type AppStart struct {
+	GuessingCommand
+}
+
+func (c *AppStart) Run(context *cmd.Context, client cmd.Doer) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	url := cmd.GetUrl(fmt.Sprintf("/apps/%s/start", appName))
+	request, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, err = io.Copy(context.Stdout, response.Body)
+	return err
+}
+
+func (c *AppStart) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "start",
+		Usage: "start [--app appname]",
+		Desc: `starts an app that was stopped.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+}
+
+type deploy struct {
+	Id       string
+	Commit   string
+	User     string
+	Start    time.Time
+	Duration time.Duration
+	Success  bool
+}
+
+type DeployList struct {
+	GuessingCommand
+}
+
+func (c *DeployList) Run(context *cmd.Context, client cmd.Doer) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	url := cmd.GetUrl(fmt.Sprintf("/apps/%s/deploys", appName))
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	defer response.Body.Close()
+	result, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	return c.Show(result, context)
+}
+
+func (c *DeployList) Show(result []byte, context *cmd.Context) error {
+	var deploys []deploy
+	err := json.Unmarshal(result, &deploys)
+	if err != nil {
+		return err
+	}
+	table := cmd.NewTable()
+	table.Headers = cmd.Row([]string{"Id", "Commit", "User", "Start", "Duration", "Result"})
+	for _, d := range deploys {
+		status := "failed"
+		if d.Success {
+			status = "succeeded"
+		}
+		start := d.Start.Format("2006-01-02 15:04:05")
+		table.AddRow(cmd.Row([]string{d.Id, d.Commit, d.User, start, d.Duration.String(), status}))
+	}
+	context.Stdout.Write(table.Bytes())
+	return nil
+}
+
+func (c *DeployList) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "deploy-list",
+		Usage: "deploy-list [--app appname]",
+		Desc: `list the deploys of an app, most recent first.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+}
+
+type Rollback struct {
+	GuessingCommand
+}
+
+func (c *Rollback) Run(context *cmd.Context, client cmd.Doer) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	url := cmd.GetUrl(fmt.Sprintf("/apps/%s/rollback/%s", appName, context.Args[0]))
+	request, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		return err
+	}
+	return runDeploy(request, context, client)
+}
+
+func (c *Rollback) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "rollback",
+		Usage: "rollback <deploy-id> [--app appname]",
+		Desc: `rolls an app back to the code of a previous deploy (see deploy-list).
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 1,
+	}
+}
+
+type AppHealing struct {
+	GuessingCommand
+}
+
+func (c *AppHealing) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "app-healing",
+		Usage: "app-healing <on|off> [--app appname]",
+		Desc: `set whether the broken units of an app are replaced automatically.
+
+Healing is on by default. When it's off, units that stay down or in error are
+left alone, so you can inspect them.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 1,
+	}
+}
+
+func (c *AppHealing) Run(context *cmd.Context, client cmd.Doer) error {
+	policy := context.Args[0]
+	if policy != "on" && policy != "off" {
+		return errors.New(`The healing policy must be either "on" or "off".`)
+	}
+	_, err := requestAppUrl("PUT", "healing", c.GuessingCommand, strings.NewReader(policy), client)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "healing successfully turned %s\n", policy)
+	return nil
+}
+
+// AppUnlock forcibly releases the lock of an app, held by a deploy or an
+// operation on its units. It's an admin command.
+type AppUnlock struct{}
+
+func (c *AppUnlock) Run(context *cmd.Context, client cmd.Doer) error {
+	appName := context.Args[0]
+	url := cmd.GetUrl(fmt.Sprintf("/apps/%s/lock", appName))
+	request, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return err
+	}
+	_, err = client.Do(request)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, `App "%s" was unlocked.`+"\n", appName)
+	return nil
+}
+
+func (c *AppUnlock) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "app-unlock",
+		Usage: "app-unlock <appname>",
+		Desc: `releases the lock of an app.
+
+Deploys and operations on the units of an app lock the app while they run. Use
+this command to release the lock of an operation that will not finish.`,
+		MinArgs: 1,
+	}
+}
@@ -7,6 +7,7 @@ package tsuru
 import (
 	"bytes"
 	"github.com/globocom/tsuru/cmd"
+	"io/ioutil"
 	. "launchpad.net/gocheck"
 	"net/http"
 )
@@ -14,20 +15,20 @@ import (
 func (s *S) TestAppInfo(c *C) {
 	*AppName = "app1"
 	var stdout, stderr bytes.Buffer
-	result := `{"Name":"app1","Framework":"php","Repository":"git@git.com:php.git","State":"dead", "Units":[{"Ip":"10.10.10.10","Name":"app1/0","State":"started"}, {"Ip":"9.9.9.9","Name":"app1/1","State":"started"}, {"Ip":"","Name":"app1/2","State":"pending"}],"Teams":["tsuruteam","crane"]}`
+	result := `{"Name":"app1","Framework":"php","Repository":"git@git.com:php.git","State":"dead", "Units":[{"Ip":"10.10.10.10","Name":"app1/0","State":"started"}, {"Ip":"9.9.9.9","Name":"app1/1","State":"started","Process":"worker"}, {"Ip":"","Name":"app1/2","State":"pending"}],"Teams":["tsuruteam","crane"]}`
 	expected := `Application: app1
 State: dead
 Repository: git@git.com:php.git
 Platform: php
 Teams: tsuruteam, crane
 Units:
-+--------+-------------+---------+
-| Unit   | Ip          | State   |
-+--------+-------------+---------+
-| app1/0 | 10.10.10.10 | started |
-| app1/1 | 9.9.9.9     | started |
-| app1/2 |             | pending |
-+--------+-------------+---------+
++--------+---------+-------------+---------+
+| Unit   | Process | Ip          | State   |
++--------+---------+-------------+---------+
+| app1/0 | web     | 10.10.10.10 | started |
+| app1/1 | worker  | 9.9.9.9     | started |
+| app1/2 | web     |             | pending |
++--------+---------+-------------+---------+
 
 `
 	context := cmd.Context{
@@ -72,12 +73,12 @@ Repository: git@git.com:php.git
 Platform: ruby
 Teams: tsuruteam, crane
 Units:
-+----------+-------------+---------+
-| Unit     | Ip          | State   |
-+----------+-------------+---------+
-| secret/0 | 10.10.10.10 | started |
-| secret/1 | 9.9.9.9     | pending |
-+----------+-------------+---------+
++----------+---------+-------------+---------+
+| Unit     | Process | Ip          | State   |
++----------+---------+-------------+---------+
+| secret/0 | web     | 10.10.10.10 | started |
+| secret/1 | web     | 9.9.9.9     | pending |
++----------+---------+-------------+---------+
 
 `
 	context := cmd.Context{
@@ -306,3 +307,382 @@ func (s *S) TestAppRestartIsACommand(c *C) {
 func (s *S) TestAppRestartIsAnInfoer(c *C) {
 	var _ cmd.Infoer = &AppRestart{}
 }
+
+func (s *S) TestAppStop(c *C) {
+	*AppName = "handful_of_nothing"
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{
+			msg:    "Stopped",
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			called = true
+			return req.URL.Path == "/apps/handful_of_nothing/stop" && req.Method == "POST"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&AppStop{}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+	c.Assert(stdout.String(), Equals, "Stopped")
+}
+
+func (s *S) TestAppStopWithoutTheFlag(c *C) {
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{
+			msg:    "Stopped",
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			called = true
+			return req.URL.Path == "/apps/motorbreath/stop" && req.Method == "POST"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fake := &FakeGuesser{name: "motorbreath"}
+	err := (&AppStop{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+	c.Assert(stdout.String(), Equals, "Stopped")
+}
+
+func (s *S) TestAppStopInfo(c *C) {
+	expected := &cmd.Info{
+		Name:  "stop",
+		Usage: "stop [--app appname]",
+		Desc: `stops an app, without removing its units.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+	c.Assert((&AppStop{}).Info(), DeepEquals, expected)
+}
+
+func (s *S) TestAppStopIsACommand(c *C) {
+	var _ cmd.Command = &AppStop{}
+}
+
+func (s *S) TestAppStopIsAnInfoer(c *C) {
+	var _ cmd.Infoer = &AppStop{}
+}
+
+func (s *S) TestAppStart(c *C) {
+	*AppName = "handful_of_nothing"
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{
+			msg:    "Started",
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			called = true
+			return req.URL.Path == "/apps/handful_of_nothing/start" && req.Method == "POST"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&AppStart{}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+	c.Assert(stdout.String(), Equals, "Started")
+}
+
+func (s *S) TestAppStartWithoutTheFlag(c *C) {
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{
+			msg:    "Started",
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			called = true
+			return req.URL.Path == "/apps/motorbreath/start" && req.Method == "POST"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fake := &FakeGuesser{name: "motorbreath"}
+	err := (&AppStart{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+	c.Assert(stdout.String(), Equals, "Started")
+}
+
+func (s *S) TestAppStartInfo(c *C) {
+	expected := &cmd.Info{
+		Name:  "start",
+		Usage: "start [--app appname]",
+		Desc: `starts an app that was stopped.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+	c.Assert((&AppStart{}).Info(), DeepEquals, expected)
+}
+
+func (s *S) TestAppStartIsACommand(c *C) {
+	var _ cmd.Command = &AppStart{}
+}
+
+func (s *S) TestAppStartIsAnInfoer(c *C) {
+	var _ cmd.Infoer = &AppStart{}
+}
+
+func (s *S) TestDeployList(c *C) {
+	*AppName = "handful_of_nothing"
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	result := `[{"Id":"5107ab6a9d9f5d1ec8000001","App":"handful_of_nothing","Commit":"8e0f1ab","User":"gopher@golang.org","Start":"2013-01-29T10:30:00Z","Duration":2500000000,"Success":true},` +
+		`{"Id":"5107ab6a9d9f5d1ec8000000","App":"handful_of_nothing","Commit":"","User":"","Start":"2013-01-28T09:00:00Z","Duration":1000000000,"Success":false}]`
+	expected := `+--------------------------+---------+-------------------+---------------------+----------+-----------+
+| Id                       | Commit  | User              | Start               | Duration | Result    |
++--------------------------+---------+-------------------+---------------------+----------+-----------+
+| 5107ab6a9d9f5d1ec8000001 | 8e0f1ab | gopher@golang.org | 2013-01-29 10:30:00 | 2.5s     | succeeded |
+| 5107ab6a9d9f5d1ec8000000 |         |                   | 2013-01-28 09:00:00 | 1s       | failed    |
++--------------------------+---------+-------------------+---------------------+----------+-----------+
+`
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{
+			msg:    result,
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			called = true
+			return req.URL.Path == "/apps/handful_of_nothing/deploys" && req.Method == "GET"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&DeployList{}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+	c.Assert(stdout.String(), Equals, expected)
+}
+
+func (s *S) TestDeployListWithoutDeploys(c *C) {
+	*AppName = "handful_of_nothing"
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	client := cmd.NewClient(&http.Client{Transport: &transport{msg: "", status: http.StatusNoContent}}, nil, manager)
+	err := (&DeployList{}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(stdout.String(), Equals, "")
+}
+
+func (s *S) TestDeployListInfo(c *C) {
+	expected := &cmd.Info{
+		Name:  "deploy-list",
+		Usage: "deploy-list [--app appname]",
+		Desc: `list the deploys of an app, most recent first.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+	c.Assert((&DeployList{}).Info(), DeepEquals, expected)
+}
+
+func (s *S) TestDeployListIsACommand(c *C) {
+	var _ cmd.Command = &DeployList{}
+}
+
+func (s *S) TestRollback(c *C) {
+	*AppName = "handful_of_nothing"
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{
+		Args:   []string{"5107ab6a9d9f5d1ec8000000"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{
+			msg:    "Rolled back",
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			called = true
+			return req.URL.Path == "/apps/handful_of_nothing/rollback/5107ab6a9d9f5d1ec8000000" && req.Method == "POST"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&Rollback{}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+	c.Assert(stdout.String(), Equals, "Rolled back")
+}
+
+func (s *S) TestRollbackRendersTheProgress(c *C) {
+	*AppName = "handful_of_nothing"
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Args:   []string{"5107ab6a9d9f5d1ec8000000"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &streamTransport{msg: `{"type":"phase-start","phase":"checkout","message":"Rolling back to 8e0f1ab"}
+{"type":"phase-end","phase":"checkout","status":"success"}
+{"type":"status","status":"success"}
+`}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&Rollback{}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(trans.req.Header.Get("Accept"), Equals, "application/x-json-stream")
+	c.Assert(stdout.String(), Equals, "[checkout] Rolling back to 8e0f1ab\n[checkout] ok\nDeploy done!\n")
+}
+
+func (s *S) TestRollbackInfo(c *C) {
+	expected := &cmd.Info{
+		Name:  "rollback",
+		Usage: "rollback <deploy-id> [--app appname]",
+		Desc: `rolls an app back to the code of a previous deploy (see deploy-list).
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 1,
+	}
+	c.Assert((&Rollback{}).Info(), DeepEquals, expected)
+}
+
+func (s *S) TestRollbackIsACommand(c *C) {
+	var _ cmd.Command = &Rollback{}
+}
+
+func (s *S) TestAppHealingInfo(c *C) {
+	expected := &cmd.Info{
+		Name:  "app-healing",
+		Usage: "app-healing <on|off> [--app appname]",
+		Desc: `set whether the broken units of an app are replaced automatically.
+
+Healing is on by default. When it's off, units that stay down or in error are
+left alone, so you can inspect them.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 1,
+	}
+	c.Assert((&AppHealing{}).Info(), DeepEquals, expected)
+}
+
+func (s *S) TestAppHealingRun(c *C) {
+	var stdout, stderr bytes.Buffer
+	var body string
+	context := cmd.Context{
+		Args:   []string{"off"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{
+			msg:    "",
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			b, _ := ioutil.ReadAll(req.Body)
+			body = string(b)
+			return req.URL.Path == "/apps/fragile/healing" && req.Method == "PUT"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fake := &FakeGuesser{name: "fragile"}
+	err := (&AppHealing{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(body, Equals, "off")
+	c.Assert(stdout.String(), Equals, "healing successfully turned off\n")
+}
+
+func (s *S) TestAppHealingRunInvalidPolicy(c *C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Args:   []string{"sometimes"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	client := cmd.NewClient(&http.Client{Transport: &transport{msg: "", status: http.StatusOK}}, nil, manager)
+	fake := &FakeGuesser{name: "fragile"}
+	err := (&AppHealing{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, ErrorMatches, `The healing policy must be either "on" or "off".`)
+}
+
+func (s *S) TestAppHealingIsACommand(c *C) {
+	var _ cmd.Command = &AppHealing{}
+}
+
+func (s *S) TestAppUnlock(c *C) {
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{
+		Args:   []string{"handful_of_nothing"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{
+			msg:    "",
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			called = true
+			return req.URL.Path == "/apps/handful_of_nothing/lock" && req.Method == "DELETE"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&AppUnlock{}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+	c.Assert(stdout.String(), Equals, `App "handful_of_nothing" was unlocked.`+"\n")
+}
+
+func (s *S) TestAppUnlockInfo(c *C) {
+	expected := &cmd.Info{
+		Name:  "app-unlock",
+		Usage: "app-unlock <appname>",
+		Desc: `releases the lock of an app.
+
+Deploys and operations on the units of an app lock the app while they run. Use
+this command to release the lock of an operation that will not finish.`,
+		MinArgs: 1,
+	}
+	c.Assert((&AppUnlock{}).Info(), DeepEquals, expected)
+}
+
+func (s *S) TestAppUnlockIsACommand(c *C) {
+	var _ cmd.Command = &AppUnlock{}
+}
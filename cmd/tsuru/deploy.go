@@ -0,0 +1,310 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/globocom/tsuru/cmd"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ignoreFile = ".tsuruignore"
+	jsonStream = "application/x-json-stream"
+)
+
+type AppDeploy struct {
+	GuessingCommand
+}
+
+func (c *AppDeploy) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "app-deploy",
+		Usage: "app-deploy <directory> [--app appname]",
+		Desc: `deploys the code in the given directory, instead of the code in the git repository of the app.
+
+Files matching the patterns in the .tsuruignore file of the directory are not deployed.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 1,
+	}
+}
+
+func (c *AppDeploy) Run(context *cmd.Context, client cmd.Doer) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	archive, err := pack(context.Args[0])
+	if err != nil {
+		return err
+	}
+	url := cmd.GetUrl(fmt.Sprintf("/apps/%s/deploy", appName))
+	request, err := http.NewRequest("POST", url, archive)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-gzip")
+	return runDeploy(request, context, client)
+}
+
+// runDeploy sends a request that triggers a deploy, asking for the JSON
+// progress protocol, and renders the progress of the deploy.
+func runDeploy(request *http.Request, context *cmd.Context, client cmd.Doer) error {
+	request.Header.Set("Accept", jsonStream)
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.Header.Get("Content-Type") != jsonStream {
+		_, err = io.Copy(context.Stdout, response.Body)
+		return err
+	}
+	return renderProgress(response.Body, context.Stdout)
+}
+
+type deployEvent struct {
+	Type    string
+	Phase   string
+	Message string
+	Unit    string
+	Status  string
+	Output  string
+	Error   string
+}
+
+// progressRenderer writes the output of a deploy line by line, prefixing each
+// line with the phase of the deploy.
+type progressRenderer struct {
+	w       io.Writer
+	partial string
+}
+
+func (r *progressRenderer) line(phase, line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	if phase != "" {
+		line = "[" + phase + "] " + line
+	}
+	fmt.Fprintln(r.w, line)
+}
+
+func (r *progressRenderer) output(phase, data string) {
+	lines := strings.Split(r.partial+data, "\n")
+	r.partial = lines[len(lines)-1]
+	for _, line := range lines[:len(lines)-1] {
+		r.line(phase, line)
+	}
+}
+
+func (r *progressRenderer) flush(phase string) {
+	if r.partial != "" {
+		r.line(phase, r.partial)
+		r.partial = ""
+	}
+}
+
+// renderProgress renders the events of the JSON deploy progress protocol,
+// returning an error if the deploy fails.
+func renderProgress(stream io.Reader, w io.Writer) error {
+	r := progressRenderer{w: w}
+	decoder := json.NewDecoder(stream)
+	var failure error
+	for {
+		var ev deployEvent
+		err := decoder.Decode(&ev)
+		if err == io.EOF {
+			return errors.New("The deploy finished without reporting its status.")
+		} else if err != nil {
+			return err
+		}
+		switch ev.Type {
+		case "phase-start":
+			msg := ev.Message
+			if msg == "" {
+				msg = "started"
+			}
+			r.line(ev.Phase, msg)
+		case "phase-end":
+			r.flush(ev.Phase)
+			if ev.Status == "success" {
+				r.line(ev.Phase, "ok")
+			} else {
+				r.line(ev.Phase, "failed")
+			}
+		case "unit":
+			r.line(ev.Phase, fmt.Sprintf("unit %s is %s", ev.Unit, ev.Status))
+		case "output":
+			r.output(ev.Phase, ev.Output)
+		case "error":
+			r.flush(ev.Phase)
+			if ev.Phase == "" {
+				failure = fmt.Errorf("Deploy failed: %s", ev.Error)
+			} else {
+				failure = fmt.Errorf("Deploy failed in the %s phase: %s", ev.Phase, ev.Error)
+			}
+		case "status":
+			r.flush("")
+			if ev.Status == "success" {
+				fmt.Fprintln(w, "Deploy done!")
+				return nil
+			}
+			if failure == nil {
+				failure = errors.New("Deploy failed.")
+			}
+			return failure
+		}
+	}
+}
+
+// ignoreList is the list of patterns of a .tsuruignore file.
+//
+// Patterns are matched using filepath.Match. Patterns containing a slash are
+// matched against the path of the file, relative to the deployed directory,
+// other patterns are matched against the name of the file, at any depth. A
+// trailing slash restricts the pattern to directories. Empty lines and lines
+// starting with # are skipped.
+type ignoreList []string
+
+func readIgnoreList(dir string) (ignoreList, error) {
+	f, err := os.Open(filepath.Join(dir, ignoreFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var list ignoreList
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			list = append(list, line)
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// ignores checks whether the file in the given path, relative to the deployed
+// directory, matches any of the patterns in the list.
+func (l ignoreList) ignores(path string, isDir bool) bool {
+	for _, pattern := range l {
+		if strings.HasSuffix(pattern, "/") {
+			if !isDir {
+				continue
+			}
+			pattern = pattern[:len(pattern)-1]
+		}
+		name := filepath.Base(path)
+		if strings.Contains(pattern, "/") {
+			name = path
+			if strings.HasPrefix(pattern, "/") {
+				pattern = pattern[1:]
+			}
+		}
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// pack creates a gzipped tarball with the content of the directory, skipping
+// files listed in its .tsuruignore file.
+func pack(dir string) (*bytes.Buffer, error) {
+	ignore, err := readIgnoreList(dir)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzipWriter)
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if ignore.ignores(rel, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		return addFile(tarWriter, path, rel, info)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = tarWriter.Close(); err != nil {
+		return nil, err
+	}
+	if err = gzipWriter.Close(); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+func addFile(w *tar.Writer, path, name string, info os.FileInfo) error {
+	header := tar.Header{
+		Name:    name,
+		Mode:    int64(info.Mode().Perm()),
+		ModTime: info.ModTime(),
+	}
+	switch {
+	case info.IsDir():
+		header.Name += "/"
+		header.Typeflag = tar.TypeDir
+	case info.Mode()&os.ModeSymlink != 0:
+		link, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		header.Typeflag = tar.TypeSymlink
+		header.Linkname = link
+	case info.Mode()&os.ModeType == 0:
+		header.Typeflag = tar.TypeReg
+		header.Size = info.Size()
+	default:
+		return nil
+	}
+	if err := w.WriteHeader(&header); err != nil {
+		return err
+	}
+	if header.Typeflag != tar.TypeReg {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
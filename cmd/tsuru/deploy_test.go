@@ -0,0 +1,245 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"github.com/globocom/tsuru/cmd"
+	"io"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+func createDeployDir(c *C, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "tsuru-deploy")
+	c.Assert(err, IsNil)
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		c.Assert(err, IsNil)
+		err = ioutil.WriteFile(path, []byte(content), 0644)
+		c.Assert(err, IsNil)
+	}
+	return dir
+}
+
+func archiveFiles(c *C, archive io.Reader) map[string]string {
+	gzipReader, err := gzip.NewReader(archive)
+	c.Assert(err, IsNil)
+	tarReader := tar.NewReader(gzipReader)
+	files := make(map[string]string)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, IsNil)
+		content, err := ioutil.ReadAll(tarReader)
+		c.Assert(err, IsNil)
+		files[header.Name] = string(content)
+	}
+	return files
+}
+
+func fileNames(files map[string]string) []string {
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *S) TestPack(c *C) {
+	dir := createDeployDir(c, map[string]string{
+		"Procfile":       "web: ./app",
+		"static/app.css": "body {}",
+	})
+	defer os.RemoveAll(dir)
+	archive, err := pack(dir)
+	c.Assert(err, IsNil)
+	files := archiveFiles(c, archive)
+	c.Assert(fileNames(files), DeepEquals, []string{"Procfile", "static/", "static/app.css"})
+	c.Assert(files["Procfile"], Equals, "web: ./app")
+	c.Assert(files["static/app.css"], Equals, "body {}")
+}
+
+func (s *S) TestPackHonorsTsuruIgnore(c *C) {
+	dir := createDeployDir(c, map[string]string{
+		".tsuruignore":      "# comments are skipped\n\n*.pyc\nbuild/\n/docs/*.txt\n",
+		"app.py":            "print 'hi'",
+		"app.pyc":           "bytecode",
+		"lib/util.pyc":      "bytecode",
+		"build/output":      "artifact",
+		"docs/readme.txt":   "docs",
+		"docs/index.html":   "docs",
+		"lib/docs/todo.txt": "todo",
+	})
+	defer os.RemoveAll(dir)
+	archive, err := pack(dir)
+	c.Assert(err, IsNil)
+	files := archiveFiles(c, archive)
+	expected := []string{".tsuruignore", "app.py", "docs/", "docs/index.html", "lib/", "lib/docs/", "lib/docs/todo.txt"}
+	c.Assert(fileNames(files), DeepEquals, expected)
+}
+
+func (s *S) TestIgnoreListIgnores(c *C) {
+	l := ignoreList{"*.log", "tmp/", "/config/local.yml"}
+	c.Assert(l.ignores("app.log", false), Equals, true)
+	c.Assert(l.ignores("logs/app.log", false), Equals, true)
+	c.Assert(l.ignores("tmp", true), Equals, true)
+	c.Assert(l.ignores("tmp", false), Equals, false)
+	c.Assert(l.ignores("config/local.yml", false), Equals, true)
+	c.Assert(l.ignores("other/config/local.yml", false), Equals, false)
+	c.Assert(l.ignores("app.py", false), Equals, false)
+}
+
+func (s *S) TestReadIgnoreListWithoutFile(c *C) {
+	dir := createDeployDir(c, nil)
+	defer os.RemoveAll(dir)
+	l, err := readIgnoreList(dir)
+	c.Assert(err, IsNil)
+	c.Assert(l, HasLen, 0)
+}
+
+func (s *S) TestAppDeploy(c *C) {
+	*AppName = "handful_of_nothing"
+	dir := createDeployDir(c, map[string]string{"Procfile": "web: ./app"})
+	defer os.RemoveAll(dir)
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{
+		Args:   []string{dir},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{
+			msg:    "Deploy done!",
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			called = true
+			if req.URL.Path != "/apps/handful_of_nothing/deploy" || req.Method != "POST" {
+				return false
+			}
+			files := archiveFiles(c, req.Body)
+			return req.Header.Get("Content-Type") == "application/x-gzip" && files["Procfile"] == "web: ./app"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&AppDeploy{}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+	c.Assert(stdout.String(), Equals, "Deploy done!")
+}
+
+func (s *S) TestAppDeployInfo(c *C) {
+	info := (&AppDeploy{}).Info()
+	c.Assert(info.Name, Equals, "app-deploy")
+	c.Assert(info.Usage, Equals, "app-deploy <directory> [--app appname]")
+	c.Assert(info.MinArgs, Equals, 1)
+}
+
+func (s *S) TestAppDeployIsACommand(c *C) {
+	var _ cmd.Command = &AppDeploy{}
+}
+
+type streamTransport struct {
+	msg string
+	req *http.Request
+}
+
+func (t *streamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.req = req
+	resp := &http.Response{
+		Body:       ioutil.NopCloser(bytes.NewBufferString(t.msg)),
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/x-json-stream"}},
+	}
+	return resp, nil
+}
+
+func (s *S) TestRenderProgress(c *C) {
+	stream := `{"type":"output","output":"\n ---> Tsuru receiving push\n"}
+{"type":"phase-start","phase":"upload","message":"Uploading your code to your machines"}
+{"type":"output","phase":"upload","output":"extracting "}
+{"type":"output","phase":"upload","output":"files\ndone"}
+{"type":"phase-end","phase":"upload","status":"success"}
+{"type":"phase-start","phase":"restart"}
+{"type":"unit","phase":"restart","unit":"myapp/0","status":"started"}
+{"type":"phase-end","phase":"restart","status":"success"}
+{"type":"status","status":"success"}
+`
+	expected := ` ---> Tsuru receiving push
+[upload] Uploading your code to your machines
+[upload] extracting files
+[upload] done
+[upload] ok
+[restart] started
+[restart] unit myapp/0 is started
+[restart] ok
+Deploy done!
+`
+	var buf bytes.Buffer
+	err := renderProgress(bytes.NewBufferString(stream), &buf)
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, expected)
+}
+
+func (s *S) TestRenderProgressFailure(c *C) {
+	stream := `{"type":"phase-start","phase":"dependencies","message":"Installing dependencies"}
+{"type":"output","phase":"dependencies","output":"No matching distribution\n"}
+{"type":"phase-end","phase":"dependencies","status":"failure"}
+{"type":"error","phase":"dependencies","error":"exit status 1"}
+{"type":"status","status":"failure"}
+`
+	expected := `[dependencies] Installing dependencies
+[dependencies] No matching distribution
+[dependencies] failed
+`
+	var buf bytes.Buffer
+	err := renderProgress(bytes.NewBufferString(stream), &buf)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "Deploy failed in the dependencies phase: exit status 1")
+	c.Assert(buf.String(), Equals, expected)
+}
+
+func (s *S) TestRenderProgressWithoutStatus(c *C) {
+	stream := `{"type":"phase-start","phase":"clone","message":"Cloning your code in your machines"}
+`
+	var buf bytes.Buffer
+	err := renderProgress(bytes.NewBufferString(stream), &buf)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "The deploy finished without reporting its status.")
+}
+
+func (s *S) TestAppDeployRendersTheProgress(c *C) {
+	*AppName = "handful_of_nothing"
+	dir := createDeployDir(c, map[string]string{"Procfile": "web: ./app"})
+	defer os.RemoveAll(dir)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Args:   []string{dir},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &streamTransport{msg: `{"type":"error","phase":"upload","error":"No space left on device"}
+{"type":"status","status":"failure"}
+`}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&AppDeploy{}).Run(&context, client)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "Deploy failed in the upload phase: No space left on device")
+	c.Assert(trans.req.Header.Get("Accept"), Equals, "application/x-json-stream")
+}
@@ -11,9 +11,23 @@ import (
 	"github.com/globocom/tsuru/cmd"
 	"github.com/globocom/tsuru/cmd/tsuru"
 	"io/ioutil"
+	"launchpad.net/gnuflag"
 	"net/http"
+	"net/url"
 )
 
+var ProcessName = gnuflag.String("process", "", "The process type of the units.")
+
+// unitsUrl returns the url for adding or removing units of the app, of the
+// process type given in the --process flag.
+func unitsUrl(appName string) string {
+	u := cmd.GetUrl(fmt.Sprintf("/apps/%s/units", appName))
+	if ProcessName != nil && *ProcessName != "" {
+		u += "?process=" + url.QueryEscape(*ProcessName)
+	}
+	return u
+}
+
 type AppCreate struct{}
 
 func (c *AppCreate) Run(context *cmd.Context, client cmd.Doer) error {
@@ -103,9 +117,12 @@ type UnitAdd struct {
 
 func (c *UnitAdd) Info() *cmd.Info {
 	return &cmd.Info{
-		Name:    "unit-add",
-		Usage:   "unit-add <# of units> [--app appname]",
-		Desc:    "add new units to an app.",
+		Name:  "unit-add",
+		Usage: "unit-add <# of units> [--app appname] [--process process]",
+		Desc: `add new units to an app.
+
+The new units run the given process type, declared in the Procfile of the app
+(web by default).`,
 		MinArgs: 1,
 	}
 }
@@ -115,8 +132,7 @@ func (c *UnitAdd) Run(context *cmd.Context, client cmd.Doer) error {
 	if err != nil {
 		return err
 	}
-	url := cmd.GetUrl(fmt.Sprintf("/apps/%s/units", appName))
-	request, err := http.NewRequest("PUT", url, bytes.NewBufferString(context.Args[0]))
+	request, err := http.NewRequest("PUT", unitsUrl(appName), bytes.NewBufferString(context.Args[0]))
 	if err != nil {
 		return err
 	}
@@ -134,9 +150,11 @@ type UnitRemove struct {
 
 func (c *UnitRemove) Info() *cmd.Info {
 	return &cmd.Info{
-		Name:    "unit-remove",
-		Usage:   "unit-remove <# of units> [--app appname]",
-		Desc:    "remove units from an app.",
+		Name:  "unit-remove",
+		Usage: "unit-remove <# of units> [--app appname] [--process process]",
+		Desc: `remove units from an app.
+
+When a process type is given, only units running it are removed.`,
 		MinArgs: 1,
 	}
 }
@@ -146,9 +164,8 @@ func (c *UnitRemove) Run(context *cmd.Context, client cmd.Doer) error {
 	if err != nil {
 		return err
 	}
-	url := cmd.GetUrl(fmt.Sprintf("/apps/%s/units", appName))
 	body := bytes.NewBufferString(context.Args[0])
-	request, err := http.NewRequest("DELETE", url, body)
+	request, err := http.NewRequest("DELETE", unitsUrl(appName), body)
 	if err != nil {
 		return err
 	}
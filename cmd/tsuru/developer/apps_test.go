@@ -195,6 +195,33 @@ func (s *S) TestUnitAdd(c *C) {
 	c.Assert(stdout.String(), Equals, expected)
 }
 
+func (s *S) TestUnitAddWithProcess(c *C) {
+	*tsuru.AppName = "radio"
+	*ProcessName = "worker"
+	defer func() { *ProcessName = "" }()
+	var stdout, stderr bytes.Buffer
+	var called bool
+	context := cmd.Context{
+		Args:   []string{"2"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{
+			msg:    "",
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			called = true
+			return req.URL.Path == "/apps/radio/units" && req.URL.Query().Get("process") == "worker"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&UnitAdd{}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+}
+
 func (s *S) TestUnitAddFailure(c *C) {
 	*tsuru.AppName = "radio"
 	var stdout, stderr bytes.Buffer
@@ -212,9 +239,12 @@ func (s *S) TestUnitAddFailure(c *C) {
 
 func (s *S) TestUnitAddInfo(c *C) {
 	expected := &cmd.Info{
-		Name:    "unit-add",
-		Usage:   "unit-add <# of units> [--app appname]",
-		Desc:    "add new units to an app.",
+		Name:  "unit-add",
+		Usage: "unit-add <# of units> [--app appname] [--process process]",
+		Desc: `add new units to an app.
+
+The new units run the given process type, declared in the Procfile of the app
+(web by default).`,
 		MinArgs: 1,
 	}
 	c.Assert((&UnitAdd{}).Info(), DeepEquals, expected)
@@ -259,6 +289,34 @@ func (s *S) TestUnitRemove(c *C) {
 	c.Assert(stdout.String(), Equals, expected)
 }
 
+func (s *S) TestUnitRemoveWithProcess(c *C) {
+	*tsuru.AppName = "vapor"
+	*ProcessName = "worker"
+	defer func() { *ProcessName = "" }()
+	var stdout, stderr bytes.Buffer
+	var called bool
+	context := cmd.Context{
+		Args:   []string{"1"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{
+			msg:    "",
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			called = true
+			return req.URL.Path == "/apps/vapor/units" && req.Method == "DELETE" &&
+				req.URL.Query().Get("process") == "worker"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&UnitRemove{}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+}
+
 func (s *S) TestUnitRemoveFailure(c *C) {
 	*tsuru.AppName = "opticon"
 	var stdout, stderr bytes.Buffer
@@ -278,9 +336,11 @@ func (s *S) TestUnitRemoveFailure(c *C) {
 
 func (s *S) TestUnitRemoveInfo(c *C) {
 	expected := cmd.Info{
-		Name:    "unit-remove",
-		Usage:   "unit-remove <# of units> [--app appname]",
-		Desc:    "remove units from an app.",
+		Name:  "unit-remove",
+		Usage: "unit-remove <# of units> [--app appname] [--process process]",
+		Desc: `remove units from an app.
+
+When a process type is given, only units running it are removed.`,
 		MinArgs: 1,
 	}
 	c.Assert((&UnitRemove{}).Info(), DeepEquals, &expected)
@@ -29,9 +29,24 @@ func buildManager(name string) *cmd.Manager {
 	m.Register(&tsuru.AppGrant{})
 	m.Register(&tsuru.AppRevoke{})
 	m.Register(&tsuru.AppRestart{})
+	m.Register(&tsuru.AppStop{})
+	m.Register(&tsuru.AppStart{})
+	m.Register(&tsuru.DeployList{})
+	m.Register(&tsuru.Rollback{})
+	m.Register(&tsuru.AppHealing{})
+	m.Register(&tsuru.AppDeploy{})
 	m.Register(&tsuru.EnvGet{})
 	m.Register(&tsuru.EnvSet{})
 	m.Register(&tsuru.EnvUnset{})
+	m.Register(&tsuru.EnvHistory{})
+	m.Register(&tsuru.EnvRollback{})
+	m.Register(&tsuru.EnvRestartPolicy{})
+	m.Register(&tsuru.DrainAdd{})
+	m.Register(&tsuru.DrainList{})
+	m.Register(&tsuru.DrainRemove{})
+	m.Register(&tsuru.LogRetention{})
+	m.Register(&tsuru.LogRetentionSet{})
+	m.Register(&tsuru.LogRetentionUnset{})
 	m.Register(&KeyAdd{})
 	m.Register(&KeyRemove{})
 	m.Register(&tsuru.ServiceList{})
@@ -76,6 +76,41 @@ func (s *S) TestAppRestartIsRegistered(c *C) {
 	c.Assert(restart, FitsTypeOf, &tsuru.AppRestart{})
 }
 
+func (s *S) TestAppStopIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	stop, ok := manager.Commands["stop"]
+	c.Assert(ok, Equals, true)
+	c.Assert(stop, FitsTypeOf, &tsuru.AppStop{})
+}
+
+func (s *S) TestAppStartIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	start, ok := manager.Commands["start"]
+	c.Assert(ok, Equals, true)
+	c.Assert(start, FitsTypeOf, &tsuru.AppStart{})
+}
+
+func (s *S) TestDeployListIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	list, ok := manager.Commands["deploy-list"]
+	c.Assert(ok, Equals, true)
+	c.Assert(list, FitsTypeOf, &tsuru.DeployList{})
+}
+
+func (s *S) TestRollbackIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	rollback, ok := manager.Commands["rollback"]
+	c.Assert(ok, Equals, true)
+	c.Assert(rollback, FitsTypeOf, &tsuru.Rollback{})
+}
+
+func (s *S) TestAppDeployIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	deploy, ok := manager.Commands["app-deploy"]
+	c.Assert(ok, Equals, true)
+	c.Assert(deploy, FitsTypeOf, &tsuru.AppDeploy{})
+}
+
 func (s *S) TestEnvGetIsRegistered(c *C) {
 	manager := buildManager("tsuru")
 	get, ok := manager.Commands["env-get"]
@@ -97,6 +132,76 @@ func (s *S) TestEnvUnsetIsRegistered(c *C) {
 	c.Assert(unset, FitsTypeOf, &tsuru.EnvUnset{})
 }
 
+func (s *S) TestEnvHistoryIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	history, ok := manager.Commands["env-history"]
+	c.Assert(ok, Equals, true)
+	c.Assert(history, FitsTypeOf, &tsuru.EnvHistory{})
+}
+
+func (s *S) TestEnvRollbackIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	rollback, ok := manager.Commands["env-rollback"]
+	c.Assert(ok, Equals, true)
+	c.Assert(rollback, FitsTypeOf, &tsuru.EnvRollback{})
+}
+
+func (s *S) TestAppHealingIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	healing, ok := manager.Commands["app-healing"]
+	c.Assert(ok, Equals, true)
+	c.Assert(healing, FitsTypeOf, &tsuru.AppHealing{})
+}
+
+func (s *S) TestEnvRestartPolicyIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	policy, ok := manager.Commands["env-restart-policy"]
+	c.Assert(ok, Equals, true)
+	c.Assert(policy, FitsTypeOf, &tsuru.EnvRestartPolicy{})
+}
+
+func (s *S) TestDrainAddIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	add, ok := manager.Commands["drain-add"]
+	c.Assert(ok, Equals, true)
+	c.Assert(add, FitsTypeOf, &tsuru.DrainAdd{})
+}
+
+func (s *S) TestDrainListIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	list, ok := manager.Commands["drain-list"]
+	c.Assert(ok, Equals, true)
+	c.Assert(list, FitsTypeOf, &tsuru.DrainList{})
+}
+
+func (s *S) TestDrainRemoveIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	remove, ok := manager.Commands["drain-remove"]
+	c.Assert(ok, Equals, true)
+	c.Assert(remove, FitsTypeOf, &tsuru.DrainRemove{})
+}
+
+func (s *S) TestLogRetentionIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	retention, ok := manager.Commands["log-retention"]
+	c.Assert(ok, Equals, true)
+	c.Assert(retention, FitsTypeOf, &tsuru.LogRetention{})
+}
+
+func (s *S) TestLogRetentionSetIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	set, ok := manager.Commands["log-retention-set"]
+	c.Assert(ok, Equals, true)
+	c.Assert(set, FitsTypeOf, &tsuru.LogRetentionSet{})
+}
+
+func (s *S) TestLogRetentionUnsetIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	unset, ok := manager.Commands["log-retention-unset"]
+	c.Assert(ok, Equals, true)
+	c.Assert(unset, FitsTypeOf, &tsuru.LogRetentionUnset{})
+}
+
 func (s *S) TestKeyAddIsRegistered(c *C) {
 	manager := buildManager("tsuru")
 	add, ok := manager.Commands["key-add"]
@@ -0,0 +1,112 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/globocom/tsuru/cmd"
+	"net/http"
+	"strings"
+)
+
+const drainUrls = `Supported URLs are:
+
+    syslog://host:port        syslog over TCP
+    syslog+udp://host:port    syslog over UDP
+    http://host/path          HTTP POST of JSON batches (https is also supported)`
+
+type DrainAdd struct {
+	GuessingCommand
+}
+
+func (c *DrainAdd) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "drain-add",
+		Usage: "drain-add <url> [--app appname]",
+		Desc: `adds a drain to an app, forwarding its logs to the given URL.
+
+` + drainUrls + `
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 1,
+	}
+}
+
+func (c *DrainAdd) Run(context *cmd.Context, client cmd.Doer) error {
+	_, err := requestAppUrl("POST", "drains", c.GuessingCommand, strings.NewReader(context.Args[0]), client)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(context.Stdout, "drain successfully added\n")
+	return nil
+}
+
+type DrainList struct {
+	GuessingCommand
+}
+
+func (c *DrainList) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "drain-list",
+		Usage: "drain-list [--app appname]",
+		Desc: `lists the drains of an app.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+}
+
+func (c *DrainList) Run(context *cmd.Context, client cmd.Doer) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	url := cmd.GetUrl(fmt.Sprintf("/apps/%s/drains", appName))
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	defer response.Body.Close()
+	var drains []struct{ Url string }
+	if err := json.NewDecoder(response.Body).Decode(&drains); err != nil {
+		return err
+	}
+	for _, d := range drains {
+		fmt.Fprintln(context.Stdout, d.Url)
+	}
+	return nil
+}
+
+type DrainRemove struct {
+	GuessingCommand
+}
+
+func (c *DrainRemove) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "drain-remove",
+		Usage: "drain-remove <url> [--app appname]",
+		Desc: `removes a drain from an app.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 1,
+	}
+}
+
+func (c *DrainRemove) Run(context *cmd.Context, client cmd.Doer) error {
+	_, err := requestAppUrl("DELETE", "drains", c.GuessingCommand, strings.NewReader(context.Args[0]), client)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(context.Stdout, "drain successfully removed\n")
+	return nil
+}
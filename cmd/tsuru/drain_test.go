@@ -0,0 +1,164 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"bytes"
+	"github.com/globocom/tsuru/cmd"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"net/http"
+)
+
+func (s *S) TestDrainAddInfo(c *C) {
+	expected := &cmd.Info{
+		Name:  "drain-add",
+		Usage: "drain-add <url> [--app appname]",
+		Desc: `adds a drain to an app, forwarding its logs to the given URL.
+
+Supported URLs are:
+
+    syslog://host:port        syslog over TCP
+    syslog+udp://host:port    syslog over UDP
+    http://host/path          HTTP POST of JSON batches (https is also supported)
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 1,
+	}
+	c.Assert((&DrainAdd{}).Info(), DeepEquals, expected)
+}
+
+func (s *S) TestDrainAddRun(c *C) {
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{
+		Args:   []string{"syslog://logs.example.com:514"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{
+			msg:    "",
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			called = true
+			body, _ := ioutil.ReadAll(req.Body)
+			return req.URL.Path == "/apps/someapp/drains" && req.Method == "POST" &&
+				string(body) == "syslog://logs.example.com:514"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := DrainAdd{GuessingCommand{G: &FakeGuesser{name: "someapp"}}}
+	err := command.Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+	c.Assert(stdout.String(), Equals, "drain successfully added\n")
+}
+
+func (s *S) TestDrainAddIsACommand(c *C) {
+	var _ cmd.Command = &DrainAdd{}
+}
+
+func (s *S) TestDrainListInfo(c *C) {
+	expected := &cmd.Info{
+		Name:  "drain-list",
+		Usage: "drain-list [--app appname]",
+		Desc: `lists the drains of an app.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+	c.Assert((&DrainList{}).Info(), DeepEquals, expected)
+}
+
+func (s *S) TestDrainListRun(c *C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	result := `[{"AppName":"someapp","Url":"https://logs.example.com/drain"},{"AppName":"someapp","Url":"syslog://logs.example.com:514"}]`
+	trans := &conditionalTransport{
+		transport{
+			msg:    result,
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			return req.URL.Path == "/apps/someapp/drains" && req.Method == "GET"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := DrainList{GuessingCommand{G: &FakeGuesser{name: "someapp"}}}
+	err := command.Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(stdout.String(), Equals, "https://logs.example.com/drain\nsyslog://logs.example.com:514\n")
+}
+
+func (s *S) TestDrainListRunWithoutDrains(c *C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &transport{msg: "", status: http.StatusNoContent}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := DrainList{GuessingCommand{G: &FakeGuesser{name: "someapp"}}}
+	err := command.Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(stdout.String(), Equals, "")
+}
+
+func (s *S) TestDrainListIsACommand(c *C) {
+	var _ cmd.Command = &DrainList{}
+}
+
+func (s *S) TestDrainRemoveInfo(c *C) {
+	expected := &cmd.Info{
+		Name:  "drain-remove",
+		Usage: "drain-remove <url> [--app appname]",
+		Desc: `removes a drain from an app.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 1,
+	}
+	c.Assert((&DrainRemove{}).Info(), DeepEquals, expected)
+}
+
+func (s *S) TestDrainRemoveRun(c *C) {
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{
+		Args:   []string{"syslog://logs.example.com:514"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{
+			msg:    "",
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			called = true
+			body, _ := ioutil.ReadAll(req.Body)
+			return req.URL.Path == "/apps/someapp/drains" && req.Method == "DELETE" &&
+				string(body) == "syslog://logs.example.com:514"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := DrainRemove{GuessingCommand{G: &FakeGuesser{name: "someapp"}}}
+	err := command.Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+	c.Assert(stdout.String(), Equals, "drain successfully removed\n")
+}
+
+func (s *S) TestDrainRemoveIsACommand(c *C) {
+	var _ cmd.Command = &DrainRemove{}
+}
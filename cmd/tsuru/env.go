@@ -5,13 +5,33 @@
 package tsuru
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/globocom/tsuru/cmd"
+	"github.com/globocom/tsuru/dotenv"
+	"io"
 	"io/ioutil"
+	"launchpad.net/gnuflag"
 	"net/http"
 	"strings"
+	"time"
 )
 
+var EnvFile = gnuflag.String("file", "", "A file with environment variables, in the dotenv format.")
+var EnvExport = gnuflag.Bool("export", false, "Display environment variables in the dotenv format.")
+var EnvRestart = gnuflag.Bool("restart", false, "Restart the app after changing its environment variables.")
+
+// restartQuery returns the query string that asks tsuru to restart the app
+// after changing its environment variables, when --restart is given.
+func restartQuery() string {
+	if *EnvRestart {
+		return "?restart=true"
+	}
+	return ""
+}
+
 type EnvGet struct {
 	GuessingCommand
 }
@@ -19,16 +39,23 @@ type EnvGet struct {
 func (c *EnvGet) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:  "env-get",
-		Usage: "env-get [--app appname] [ENVIRONMENT_VARIABLE1] [ENVIRONMENT_VARIABLE2] ...",
+		Usage: "env-get [--app appname] [--export] [ENVIRONMENT_VARIABLE1] [ENVIRONMENT_VARIABLE2] ...",
 		Desc: `retrieve environment variables for an app.
 
+With --export, variables are displayed in the dotenv format, suitable for
+env-set --file. Values of private variables are not exported.
+
 If you don't provide the app name, tsuru will try to guess it.`,
 		MinArgs: 0,
 	}
 }
 
 func (c *EnvGet) Run(context *cmd.Context, client cmd.Doer) error {
-	b, err := requestEnvUrl("GET", c.GuessingCommand, context.Args, client)
+	path := "env"
+	if *EnvExport {
+		path = "env/dotenv"
+	}
+	b, err := requestAppUrl("GET", path, c.GuessingCommand, strings.NewReader(strings.Join(context.Args, " ")), client)
 	if err != nil {
 		return err
 	}
@@ -43,16 +70,30 @@ type EnvSet struct {
 func (c *EnvSet) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:  "env-set",
-		Usage: "env-set <NAME=value> [NAME=value] ... [--app appname]",
+		Usage: "env-set <NAME=value> [NAME=value] ... [--app appname] [--file filename] [--restart]",
 		Desc: `set environment variables for an app.
 
+With --file, variables are read from the given file, in the dotenv format (see
+env-get --export). Use - to read them from the standard input. All variables
+in the file are set at once.
+
+With --restart, the app is restarted after the variables are set. The restart
+happens in background, env-set doesn't wait for it.
+
 If you don't provide the app name, tsuru will try to guess it.`,
-		MinArgs: 1,
+		MinArgs: 0,
 	}
 }
 
 func (c *EnvSet) Run(context *cmd.Context, client cmd.Doer) error {
-	_, err := requestEnvUrl("POST", c.GuessingCommand, context.Args, client)
+	var err error
+	if *EnvFile != "" {
+		err = c.setFromFile(*EnvFile, context, client)
+	} else if len(context.Args) == 0 {
+		return errors.New("You must provide the environment variables, or a file with --file.")
+	} else {
+		_, err = requestAppUrl("POST", "env"+restartQuery(), c.GuessingCommand, strings.NewReader(strings.Join(context.Args, " ")), client)
+	}
 	if err != nil {
 		return err
 	}
@@ -60,6 +101,26 @@ func (c *EnvSet) Run(context *cmd.Context, client cmd.Doer) error {
 	return nil
 }
 
+func (c *EnvSet) setFromFile(name string, context *cmd.Context, client cmd.Doer) error {
+	var (
+		content []byte
+		err     error
+	)
+	if name == "-" {
+		content, err = ioutil.ReadAll(context.Stdin)
+	} else {
+		content, err = ioutil.ReadFile(name)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := dotenv.Parse(bytes.NewReader(content)); err != nil {
+		return fmt.Errorf("Invalid file %s, %s.", name, err)
+	}
+	_, err = requestAppUrl("POST", "env/dotenv"+restartQuery(), c.GuessingCommand, bytes.NewReader(content), client)
+	return err
+}
+
 type EnvUnset struct {
 	GuessingCommand
 }
@@ -67,16 +128,19 @@ type EnvUnset struct {
 func (c *EnvUnset) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:  "env-unset",
-		Usage: "env-unset <ENVIRONMENT_VARIABLE1> [ENVIRONMENT_VARIABLE2] ... [ENVIRONMENT_VARIABLEN] [--app appname]",
+		Usage: "env-unset <ENVIRONMENT_VARIABLE1> [ENVIRONMENT_VARIABLE2] ... [ENVIRONMENT_VARIABLEN] [--app appname] [--restart]",
 		Desc: `unset environment variables for an app.
 
+With --restart, the app is restarted after the variables are unset. The
+restart happens in background, env-unset doesn't wait for it.
+
 If you don't provide the app name, tsuru will try to guess it.`,
 		MinArgs: 1,
 	}
 }
 
 func (c *EnvUnset) Run(context *cmd.Context, client cmd.Doer) error {
-	_, err := requestEnvUrl("DELETE", c.GuessingCommand, context.Args, client)
+	_, err := requestAppUrl("DELETE", "env"+restartQuery(), c.GuessingCommand, strings.NewReader(strings.Join(context.Args, " ")), client)
 	if err != nil {
 		return err
 	}
@@ -84,14 +148,186 @@ func (c *EnvUnset) Run(context *cmd.Context, client cmd.Doer) error {
 	return nil
 }
 
-func requestEnvUrl(method string, g GuessingCommand, args []string, client cmd.Doer) (string, error) {
+type envRevision struct {
+	Revision int
+	User     string
+	Date     time.Time
+	Added    []string
+	Changed  []string
+	Removed  []string
+	Public   map[string]string
+}
+
+// describe returns the given names, along with their values in the revision.
+// Private variables are identified, but their values are not available.
+func (r *envRevision) describe(names []string) string {
+	described := make([]string, len(names))
+	for i, name := range names {
+		if value, ok := r.Public[name]; ok {
+			described[i] = name + "=" + value
+		} else {
+			described[i] = name + " (private)"
+		}
+	}
+	return strings.Join(described, ", ")
+}
+
+type EnvHistory struct {
+	GuessingCommand
+}
+
+func (c *EnvHistory) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "env-history",
+		Usage: "env-history [--app appname]",
+		Desc: `list the changes in the environment variables of an app, most recent first.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+}
+
+func (c *EnvHistory) Run(context *cmd.Context, client cmd.Doer) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	url := cmd.GetUrl(fmt.Sprintf("/apps/%s/env/history", appName))
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	defer response.Body.Close()
+	var revisions []envRevision
+	if err := json.NewDecoder(response.Body).Decode(&revisions); err != nil {
+		return err
+	}
+	table := cmd.NewTable()
+	table.Headers = cmd.Row([]string{"Revision", "Date", "User", "Added", "Changed", "Removed"})
+	for _, r := range revisions {
+		date := r.Date.Format("2006-01-02 15:04:05")
+		removed := strings.Join(r.Removed, ", ")
+		table.AddRow(cmd.Row([]string{fmt.Sprint(r.Revision), date, r.User, r.describe(r.Added), r.describe(r.Changed), removed}))
+	}
+	context.Stdout.Write(table.Bytes())
+	return nil
+}
+
+type EnvRollback struct {
+	GuessingCommand
+}
+
+func (c *EnvRollback) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "env-rollback",
+		Usage: "env-rollback <revision> [--app appname]",
+		Desc: `restores the environment variables of an app to a previous revision (see env-history).
+
+Only public variables are restored, private variables are kept as they are.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 1,
+	}
+}
+
+func (c *EnvRollback) Run(context *cmd.Context, client cmd.Doer) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	url := cmd.GetUrl(fmt.Sprintf("/apps/%s/env/rollback/%s", appName, context.Args[0]))
+	request, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		return err
+	}
+	_, err = client.Do(request)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "variable(s) successfully rolled back to revision %s\n", context.Args[0])
+	return nil
+}
+
+type EnvRestartPolicy struct {
+	GuessingCommand
+}
+
+func (c *EnvRestartPolicy) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "env-restart-policy",
+		Usage: "env-restart-policy <on|off> [--app appname]",
+		Desc: `set whether an app is restarted whenever its environment variables change.
+
+When the policy is on, the app is restarted after env-set, env-unset,
+env-rollback, bind and unbind. The restart happens in background.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 1,
+	}
+}
+
+func (c *EnvRestartPolicy) Run(context *cmd.Context, client cmd.Doer) error {
+	policy := context.Args[0]
+	if policy != "on" && policy != "off" {
+		return errors.New(`The restart policy must be either "on" or "off".`)
+	}
+	_, err := requestAppUrl("PUT", "env/restart-policy", c.GuessingCommand, strings.NewReader(policy), client)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "restart policy successfully set to %s\n", policy)
+	return nil
+}
+
+// EnvReencrypt re-encrypts the private environment variables of all apps with
+// the current key. It's an admin command.
+type EnvReencrypt struct{}
+
+func (c *EnvReencrypt) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "env-reencrypt",
+		Usage: "env-reencrypt",
+		Desc: `re-encrypts the private environment variables of all apps.
+
+To rotate the encryption key, move the current key from env-encryption:key to
+env-encryption:previous-key in tsuru.conf, set the new key in
+env-encryption:key, restart tsuru and run this command. Once it finishes, the
+previous key may be removed.`,
+		MinArgs: 0,
+	}
+}
+
+func (c *EnvReencrypt) Run(context *cmd.Context, client cmd.Doer) error {
+	url := cmd.GetUrl("/envs/reencrypt")
+	request, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, err = io.Copy(context.Stdout, response.Body)
+	return err
+}
+
+// requestAppUrl sends a request to the given path under the URL of the app,
+// returning the body of the response. Responses whose status is not 2xx are
+// returned as errors.
+func requestAppUrl(method, path string, g GuessingCommand, body io.Reader, client cmd.Doer) (string, error) {
 	appName, err := g.Guess()
 	if err != nil {
 		return "", err
 	}
-	varsStr := strings.Join(args, " ")
-	url := cmd.GetUrl(fmt.Sprintf("/apps/%s/env", appName))
-	body := strings.NewReader(varsStr)
+	url := cmd.GetUrl(fmt.Sprintf("/apps/%s/%s", appName, path))
 	request, err := http.NewRequest(method, url, body)
 	if err != nil {
 		return "", err
@@ -100,9 +336,16 @@ func requestEnvUrl(method string, g GuessingCommand, args []string, client cmd.D
 	if err != nil {
 		return "", err
 	}
+	defer r.Body.Close()
 	b, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		return "", err
 	}
+	if r.StatusCode < 200 || r.StatusCode > 299 {
+		if len(b) == 0 {
+			return "", fmt.Errorf("Unexpected response from tsuru server: %d %s.", r.StatusCode, http.StatusText(r.StatusCode))
+		}
+		return "", errors.New(string(b))
+	}
 	return string(b), nil
 }
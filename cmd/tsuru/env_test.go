@@ -7,8 +7,10 @@ package tsuru
 import (
 	"bytes"
 	"github.com/globocom/tsuru/cmd"
+	"io/ioutil"
 	. "launchpad.net/gocheck"
 	"net/http"
+	"strings"
 )
 
 func (s *S) TestEnvGetInfo(c *C) {
@@ -16,9 +18,12 @@ func (s *S) TestEnvGetInfo(c *C) {
 	i := e.Info()
 	desc := `retrieve environment variables for an app.
 
+With --export, variables are displayed in the dotenv format, suitable for
+env-set --file. Values of private variables are not exported.
+
 If you don't provide the app name, tsuru will try to guess it.`
 	c.Assert(i.Name, Equals, "env-get")
-	c.Assert(i.Usage, Equals, "env-get [--app appname] [ENVIRONMENT_VARIABLE1] [ENVIRONMENT_VARIABLE2] ...")
+	c.Assert(i.Usage, Equals, "env-get [--app appname] [--export] [ENVIRONMENT_VARIABLE1] [ENVIRONMENT_VARIABLE2] ...")
 	c.Assert(i.Desc, Equals, desc)
 	c.Assert(i.MinArgs, Equals, 0)
 }
@@ -79,16 +84,49 @@ func (s *S) TestEnvGetWithoutTheFlag(c *C) {
 	c.Assert(stdout.String(), Equals, result)
 }
 
+func (s *S) TestEnvGetRunWithExport(c *C) {
+	*EnvExport = true
+	defer func() { *EnvExport = false }()
+	var stdout, stderr bytes.Buffer
+	result := "DATABASE_HOST=somehost\n# DATABASE_PASSWORD=*** (private variable)\n"
+	context := cmd.Context{
+		Args:   []string{},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{
+			msg:    result,
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			return req.URL.Path == "/apps/seek/env/dotenv" && req.Method == "GET"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fake := &FakeGuesser{name: "seek"}
+	err := (&EnvGet{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(stdout.String(), Equals, result)
+}
+
 func (s *S) TestEnvSetInfo(c *C) {
 	e := EnvSet{}
 	i := e.Info()
 	desc := `set environment variables for an app.
 
+With --file, variables are read from the given file, in the dotenv format (see
+env-get --export). Use - to read them from the standard input. All variables
+in the file are set at once.
+
+With --restart, the app is restarted after the variables are set. The restart
+happens in background, env-set doesn't wait for it.
+
 If you don't provide the app name, tsuru will try to guess it.`
 	c.Assert(i.Name, Equals, "env-set")
-	c.Assert(i.Usage, Equals, "env-set <NAME=value> [NAME=value] ... [--app appname]")
+	c.Assert(i.Usage, Equals, "env-set <NAME=value> [NAME=value] ... [--app appname] [--file filename] [--restart]")
 	c.Assert(i.Desc, Equals, desc)
-	c.Assert(i.MinArgs, Equals, 1)
+	c.Assert(i.MinArgs, Equals, 0)
 }
 
 func (s *S) TestEnvSetRun(c *C) {
@@ -145,18 +183,136 @@ func (s *S) TestEnvSetWithoutFlag(c *C) {
 	c.Assert(stdout.String(), Equals, result)
 }
 
+func (s *S) TestEnvSetRunWithFile(c *C) {
+	*EnvFile = "testdata/app.env"
+	defer func() { *EnvFile = "" }()
+	var stdout, stderr bytes.Buffer
+	var body string
+	context := cmd.Context{
+		Args:   []string{},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{
+			msg:    "",
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			b, _ := ioutil.ReadAll(req.Body)
+			body = string(b)
+			return req.URL.Path == "/apps/seek/env/dotenv" && req.Method == "POST"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fake := &FakeGuesser{name: "seek"}
+	err := (&EnvSet{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(stdout.String(), Equals, "variable(s) successfully exported\n")
+	content, err := ioutil.ReadFile("testdata/app.env")
+	c.Assert(err, IsNil)
+	c.Assert(body, Equals, string(content))
+}
+
+func (s *S) TestEnvSetRunWithFileFromStdin(c *C) {
+	*EnvFile = "-"
+	defer func() { *EnvFile = "" }()
+	var stdout, stderr bytes.Buffer
+	var body string
+	content := "DATABASE_HOST=somehost\n"
+	context := cmd.Context{
+		Args:   []string{},
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Stdin:  strings.NewReader(content),
+	}
+	trans := &conditionalTransport{
+		transport{
+			msg:    "",
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			b, _ := ioutil.ReadAll(req.Body)
+			body = string(b)
+			return req.URL.Path == "/apps/seek/env/dotenv" && req.Method == "POST"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fake := &FakeGuesser{name: "seek"}
+	err := (&EnvSet{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(body, Equals, content)
+}
+
+func (s *S) TestEnvSetRunWithInvalidFile(c *C) {
+	*EnvFile = "-"
+	defer func() { *EnvFile = "" }()
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Args:   []string{},
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Stdin:  strings.NewReader("DATABASE_HOST=somehost\nDATABASE_USER\n"),
+	}
+	client := cmd.NewClient(&http.Client{Transport: &transport{msg: "", status: http.StatusOK}}, nil, manager)
+	fake := &FakeGuesser{name: "seek"}
+	err := (&EnvSet{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, ErrorMatches, "Invalid file -, line 2: expected NAME=value.")
+}
+
+func (s *S) TestEnvSetRunWithoutVariables(c *C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Args:   []string{},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	client := cmd.NewClient(&http.Client{Transport: &transport{msg: "", status: http.StatusOK}}, nil, manager)
+	fake := &FakeGuesser{name: "seek"}
+	err := (&EnvSet{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, ErrorMatches, "You must provide the environment variables, or a file with --file.")
+}
+
 func (s *S) TestEnvUnsetInfo(c *C) {
 	e := EnvUnset{}
 	i := e.Info()
 	desc := `unset environment variables for an app.
 
+With --restart, the app is restarted after the variables are unset. The
+restart happens in background, env-unset doesn't wait for it.
+
 If you don't provide the app name, tsuru will try to guess it.`
 	c.Assert(i.Name, Equals, "env-unset")
-	c.Assert(i.Usage, Equals, "env-unset <ENVIRONMENT_VARIABLE1> [ENVIRONMENT_VARIABLE2] ... [ENVIRONMENT_VARIABLEN] [--app appname]")
+	c.Assert(i.Usage, Equals, "env-unset <ENVIRONMENT_VARIABLE1> [ENVIRONMENT_VARIABLE2] ... [ENVIRONMENT_VARIABLEN] [--app appname] [--restart]")
 	c.Assert(i.Desc, Equals, desc)
 	c.Assert(i.MinArgs, Equals, 1)
 }
 
+func (s *S) TestEnvSetRunWithRestart(c *C) {
+	*EnvRestart = true
+	defer func() { *EnvRestart = false }()
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Args:   []string{"DATABASE_HOST=somehost"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{
+			msg:    "",
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			return req.URL.Path == "/apps/seek/env" && req.URL.RawQuery == "restart=true" && req.Method == "POST"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fake := &FakeGuesser{name: "seek"}
+	err := (&EnvSet{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(stdout.String(), Equals, "variable(s) successfully exported\n")
+}
+
 func (s *S) TestEnvUnsetRun(c *C) {
 	*AppName = "someapp"
 	var stdout, stderr bytes.Buffer
@@ -195,12 +351,233 @@ func (s *S) TestEnvUnsetWithoutFlag(c *C) {
 	c.Assert(stdout.String(), Equals, result)
 }
 
-func (s *S) TestRequestEnvUrl(c *C) {
+func (s *S) TestRequestAppUrl(c *C) {
 	*AppName = "someapp"
 	result := "DATABASE_HOST=somehost"
 	client := cmd.NewClient(&http.Client{Transport: &transport{msg: result, status: http.StatusOK}}, nil, manager)
-	args := []string{"DATABASE_HOST"}
-	b, err := requestEnvUrl("GET", GuessingCommand{G: &FakeGuesser{name: "someapp"}}, args, client)
+	b, err := requestAppUrl("GET", "env", GuessingCommand{G: &FakeGuesser{name: "someapp"}}, strings.NewReader("DATABASE_HOST"), client)
 	c.Assert(err, IsNil)
 	c.Assert(b, Equals, result)
 }
+
+func (s *S) TestRequestAppUrlUnexpectedStatus(c *C) {
+	client := cmd.NewClient(&http.Client{Transport: &transport{msg: "", status: http.StatusMultipleChoices}}, nil, manager)
+	_, err := requestAppUrl("DELETE", "drains", GuessingCommand{G: &FakeGuesser{name: "someapp"}}, strings.NewReader("syslog://logs.example.com:514"), client)
+	c.Assert(err, ErrorMatches, `^Unexpected response from tsuru server: 300 Multiple Choices\.$`)
+}
+
+func (s *S) TestEnvReencryptInfo(c *C) {
+	expected := &cmd.Info{
+		Name:  "env-reencrypt",
+		Usage: "env-reencrypt",
+		Desc: `re-encrypts the private environment variables of all apps.
+
+To rotate the encryption key, move the current key from env-encryption:key to
+env-encryption:previous-key in tsuru.conf, set the new key in
+env-encryption:key, restart tsuru and run this command. Once it finishes, the
+previous key may be removed.`,
+		MinArgs: 0,
+	}
+	c.Assert((&EnvReencrypt{}).Info(), DeepEquals, expected)
+}
+
+func (s *S) TestEnvReencryptRun(c *C) {
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	result := `Re-encrypted 2 variables of the app "someapp".` + "\n"
+	trans := &conditionalTransport{
+		transport{
+			msg:    result,
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			called = true
+			return req.URL.Path == "/envs/reencrypt" && req.Method == "POST"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&EnvReencrypt{}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+	c.Assert(stdout.String(), Equals, result)
+}
+
+func (s *S) TestEnvReencryptIsACommand(c *C) {
+	var _ cmd.Command = &EnvReencrypt{}
+}
+
+func (s *S) TestEnvHistoryInfo(c *C) {
+	expected := &cmd.Info{
+		Name:  "env-history",
+		Usage: "env-history [--app appname]",
+		Desc: `list the changes in the environment variables of an app, most recent first.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+	c.Assert((&EnvHistory{}).Info(), DeepEquals, expected)
+}
+
+func (s *S) TestEnvHistoryRun(c *C) {
+	*AppName = "someapp"
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	result := `[{"Revision":2,"User":"someone@tsuru.io","Date":"2013-02-01T10:00:00Z","Added":null,"Changed":["PORT"],"Removed":["DEBUG"],"Public":{"PORT":"8888"}},
+{"Revision":1,"User":"tsuru","Date":"2013-01-31T09:00:00Z","Added":["MYSQL_HOST","PORT"],"Changed":null,"Removed":null,"Public":{"PORT":"80"}}]`
+	expected := `+----------+---------------------+------------------+-------------------------------+-----------+---------+
+| Revision | Date                | User             | Added                         | Changed   | Removed |
++----------+---------------------+------------------+-------------------------------+-----------+---------+
+| 2        | 2013-02-01 10:00:00 | someone@tsuru.io |                               | PORT=8888 | DEBUG   |
+| 1        | 2013-01-31 09:00:00 | tsuru            | MYSQL_HOST (private), PORT=80 |           |         |
++----------+---------------------+------------------+-------------------------------+-----------+---------+
+`
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{
+			msg:    result,
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			called = true
+			return req.URL.Path == "/apps/someapp/env/history" && req.Method == "GET"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&EnvHistory{}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+	c.Assert(stdout.String(), Equals, expected)
+}
+
+func (s *S) TestEnvHistoryRunWithoutRevisions(c *C) {
+	*AppName = "someapp"
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	client := cmd.NewClient(&http.Client{Transport: &transport{msg: "", status: http.StatusNoContent}}, nil, manager)
+	err := (&EnvHistory{}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(stdout.String(), Equals, "")
+}
+
+func (s *S) TestEnvHistoryIsACommand(c *C) {
+	var _ cmd.Command = &EnvHistory{}
+}
+
+func (s *S) TestEnvRollbackInfo(c *C) {
+	expected := &cmd.Info{
+		Name:  "env-rollback",
+		Usage: "env-rollback <revision> [--app appname]",
+		Desc: `restores the environment variables of an app to a previous revision (see env-history).
+
+Only public variables are restored, private variables are kept as they are.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 1,
+	}
+	c.Assert((&EnvRollback{}).Info(), DeepEquals, expected)
+}
+
+func (s *S) TestEnvRollbackRun(c *C) {
+	*AppName = "someapp"
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{
+		Args:   []string{"3"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{
+			msg:    "",
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			called = true
+			return req.URL.Path == "/apps/someapp/env/rollback/3" && req.Method == "POST"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&EnvRollback{}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+	c.Assert(stdout.String(), Equals, "variable(s) successfully rolled back to revision 3\n")
+}
+
+func (s *S) TestEnvRollbackIsACommand(c *C) {
+	var _ cmd.Command = &EnvRollback{}
+}
+
+func (s *S) TestEnvRestartPolicyInfo(c *C) {
+	expected := &cmd.Info{
+		Name:  "env-restart-policy",
+		Usage: "env-restart-policy <on|off> [--app appname]",
+		Desc: `set whether an app is restarted whenever its environment variables change.
+
+When the policy is on, the app is restarted after env-set, env-unset,
+env-rollback, bind and unbind. The restart happens in background.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 1,
+	}
+	c.Assert((&EnvRestartPolicy{}).Info(), DeepEquals, expected)
+}
+
+func (s *S) TestEnvRestartPolicyRun(c *C) {
+	var stdout, stderr bytes.Buffer
+	var body string
+	context := cmd.Context{
+		Args:   []string{"on"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{
+			msg:    "",
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			b, _ := ioutil.ReadAll(req.Body)
+			body = string(b)
+			return req.URL.Path == "/apps/seek/env/restart-policy" && req.Method == "PUT"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fake := &FakeGuesser{name: "seek"}
+	err := (&EnvRestartPolicy{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(body, Equals, "on")
+	c.Assert(stdout.String(), Equals, "restart policy successfully set to on\n")
+}
+
+func (s *S) TestEnvRestartPolicyRunInvalidPolicy(c *C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Args:   []string{"sometimes"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	client := cmd.NewClient(&http.Client{Transport: &transport{msg: "", status: http.StatusOK}}, nil, manager)
+	fake := &FakeGuesser{name: "seek"}
+	err := (&EnvRestartPolicy{GuessingCommand{G: fake}}).Run(&context, client)
+	c.Assert(err, ErrorMatches, `The restart policy must be either "on" or "off".`)
+}
+
+func (s *S) TestEnvRestartPolicyIsACommand(c *C) {
+	var _ cmd.Command = &EnvRestartPolicy{}
+}
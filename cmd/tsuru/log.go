@@ -8,11 +8,22 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/globocom/tsuru/cmd"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 )
 
+// logReconnectInterval is the time tsuru log --follow waits before each
+// attempt to reconnect to the server, and logMaxReconnects is the number of
+// attempts made before giving up.
+var (
+	logReconnectInterval = 5 * time.Second
+	logMaxReconnects     = 5
+)
+
 type AppLog struct {
 	GuessingCommand
 }
@@ -20,9 +31,20 @@ type AppLog struct {
 func (c *AppLog) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:  "log",
-		Usage: "log [--app appname] [--lines numberOfLines] [--source source]",
+		Usage: "log [--app appname] [--lines numberOfLines] [--source source] [--unit unit] [--since date] [--until date] [--filter text [--regex]] [--follow]",
 		Desc: `show logs for an app.
 
+--source and --unit select the entries written by the given source or unit.
+--since and --until select the entries written in the given interval, and
+accept dates in the RFC 3339 format (like 2012-06-20T11:17:22-03:00) or
+durations (like 1h30m, meaning that long ago). --filter selects the entries
+whose messages contain the given text, or match the given regular expression
+with --regex.
+
+With --follow, new entries are displayed as they are written, until the
+command is interrupted. If the connection to the server is lost, tsuru tries
+to reconnect.
+
 If you don't provide the app name, tsuru will try to guess it. The default number of lines is 10.`,
 		MinArgs: 0,
 	}
@@ -39,15 +61,15 @@ func (c *AppLog) Run(context *cmd.Context, client cmd.Doer) error {
 	if err != nil {
 		return err
 	}
-	url := cmd.GetUrl(fmt.Sprintf("/apps/%s/log?lines=%d", appName, *LogLines))
-	if LogSource != nil && *LogSource != "" {
-		url = fmt.Sprintf("%s&source=%s", url, *LogSource)
-	}
-	request, err := http.NewRequest("GET", url, nil)
+	query, err := logQuery(time.Now())
 	if err != nil {
 		return err
 	}
-	response, err := client.Do(request)
+	if LogFollow != nil && *LogFollow {
+		query.Set("follow", "1")
+		return c.follow(appName, query, context, client)
+	}
+	response, err := requestLogs(logUrl(appName, query), client)
 	if err != nil {
 		return err
 	}
@@ -62,10 +84,125 @@ func (c *AppLog) Run(context *cmd.Context, client cmd.Doer) error {
 		return err
 	}
 	for _, l := range logs {
-		date := l.Date.Format("2006-01-02 15:04:05")
-		prefix := fmt.Sprintf("%s [%s]:", date, l.Source)
-		msg := fmt.Sprintf("%s %s\n", cmd.Colorfy(prefix, "blue", "", ""), l.Message)
-		context.Stdout.Write([]byte(msg))
+		writeLog(context.Stdout, l)
+	}
+	return err
+}
+
+// logQuery returns the query string that selects the entries asked for in the
+// command line.
+func logQuery(now time.Time) (url.Values, error) {
+	query := url.Values{}
+	query.Set("lines", strconv.Itoa(*LogLines))
+	if LogSource != nil && *LogSource != "" {
+		query.Set("source", *LogSource)
+	}
+	if *LogUnit != "" {
+		query.Set("unit", *LogUnit)
+	}
+	dates := []struct {
+		name  string
+		value string
+	}{
+		{"since", *LogSince},
+		{"until", *LogUntil},
+	}
+	for _, d := range dates {
+		if d.value == "" {
+			continue
+		}
+		date, err := parseLogDate(d.value, now)
+		if err != nil {
+			return nil, err
+		}
+		query.Set(d.name, date.Format(time.RFC3339))
+	}
+	if *LogFilter != "" {
+		query.Set("filter", *LogFilter)
+		if *LogRegex {
+			query.Set("regex", "1")
+		}
+	}
+	return query, nil
+}
+
+// parseLogDate parses the dates given to --since and --until: either dates in
+// the RFC 3339 format, or durations, like 1h30m, meaning that long ago.
+func parseLogDate(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	date, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return date, fmt.Errorf("Invalid date %q, use the RFC 3339 format (like 2012-06-20T11:17:22-03:00) or a duration (like 1h30m).", value)
+	}
+	return date, nil
+}
+
+func logUrl(appName string, query url.Values) string {
+	return cmd.GetUrl(fmt.Sprintf("/apps/%s/log?%s", appName, query.Encode()))
+}
+
+// follow displays the entries streamed by the server, reconnecting whenever
+// the stream ends. When reconnecting, it asks for the entries written since
+// the last one displayed.
+func (c *AppLog) follow(appName string, query url.Values, context *cmd.Context, client cmd.Doer) error {
+	response, err := requestLogs(logUrl(appName, query), client)
+	var last time.Time
+	for err == nil {
+		last = streamLogs(context.Stdout, response.Body, last)
+		if !last.IsZero() {
+			query.Set("since", last.Format(time.RFC3339Nano))
+			query.Set("lines", "0")
+		}
+		fmt.Fprintln(context.Stderr, "Lost connection to tsuru server, reconnecting...")
+		response, err = reconnectLogs(logUrl(appName, query), client)
 	}
 	return err
 }
+
+// reconnectLogs requests the logs again, up to logMaxReconnects times.
+func reconnectLogs(url string, client cmd.Doer) (*http.Response, error) {
+	for i := 0; i < logMaxReconnects; i++ {
+		time.Sleep(logReconnectInterval)
+		if response, err := requestLogs(url, client); err == nil {
+			return response, nil
+		}
+	}
+	return nil, fmt.Errorf("Failed to reconnect to tsuru server after %d attempts.", logMaxReconnects)
+}
+
+func requestLogs(url string, client cmd.Doer) (*http.Response, error) {
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(request)
+}
+
+// streamLogs writes the entries read from body until it ends, and returns the
+// date of the last entry written. The first entries in body, up to the given
+// date, are skipped, as they were written before the stream was reconnected.
+func streamLogs(w io.Writer, body io.ReadCloser, last time.Time) time.Time {
+	defer body.Close()
+	since := last
+	decoder := json.NewDecoder(body)
+	var logs []log
+	for first := true; decoder.Decode(&logs) == nil; first = false {
+		for _, l := range logs {
+			if first && !l.Date.After(since) {
+				continue
+			}
+			writeLog(w, l)
+			last = l.Date
+		}
+	}
+	return last
+}
+
+func writeLog(w io.Writer, l log) {
+	date := l.Date.Format("2006-01-02 15:04:05")
+	prefix := fmt.Sprintf("%s [%s]:", date, l.Source)
+	msg := fmt.Sprintf("%s %s\n", cmd.Colorfy(prefix, "blue", "", ""), l.Message)
+	w.Write([]byte(msg))
+}
@@ -6,10 +6,13 @@ package tsuru
 
 import (
 	"bytes"
+	"errors"
 	"github.com/globocom/tsuru/cmd"
+	"io/ioutil"
 	. "launchpad.net/gocheck"
 	"net/http"
 	"strings"
+	"time"
 )
 
 func (s *S) TestAppLog(c *C) {
@@ -76,9 +79,20 @@ func (s *S) TestAppLogShouldReturnNilIfHasNoContent(c *C) {
 func (s *S) TestAppLogInfo(c *C) {
 	expected := &cmd.Info{
 		Name:  "log",
-		Usage: "log [--app appname] [--lines numberOfLines] [--source source]",
+		Usage: "log [--app appname] [--lines numberOfLines] [--source source] [--unit unit] [--since date] [--until date] [--filter text [--regex]] [--follow]",
 		Desc: `show logs for an app.
 
+--source and --unit select the entries written by the given source or unit.
+--since and --until select the entries written in the given interval, and
+accept dates in the RFC 3339 format (like 2012-06-20T11:17:22-03:00) or
+durations (like 1h30m, meaning that long ago). --filter selects the entries
+whose messages contain the given text, or match the given regular expression
+with --regex.
+
+With --follow, new entries are displayed as they are written, until the
+command is interrupted. If the connection to the server is lost, tsuru tries
+to reconnect.
+
 If you don't provide the app name, tsuru will try to guess it. The default number of lines is 10.`,
 		MinArgs: 0,
 	}
@@ -113,3 +127,188 @@ func (s *S) TestAppLogBySource(c *C) {
 	got = strings.Replace(got, "-0300 -0300", "-0300 BRT", -1)
 	c.Assert(got, Equals, expected)
 }
+
+// reconnectingTransport answers each request with the next of the given bodies, and
+// fails once they are over, like a server that went down.
+type reconnectingTransport struct {
+	bodies   []string
+	requests []*http.Request
+}
+
+func (t *reconnectingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests = append(t.requests, req)
+	if len(t.bodies) == 0 {
+		return nil, errors.New("connection refused")
+	}
+	body := t.bodies[0]
+	t.bodies = t.bodies[1:]
+	return &http.Response{Body: ioutil.NopCloser(strings.NewReader(body)), StatusCode: http.StatusOK}, nil
+}
+
+func (s *S) TestAppLogFollow(c *C) {
+	*LogFollow = true
+	old := logReconnectInterval
+	logReconnectInterval = 0
+	defer func() {
+		*LogFollow = false
+		logReconnectInterval = old
+	}()
+	var stdout, stderr bytes.Buffer
+	first := `[{"Source":"tsuru","Date":"2012-06-20T11:17:22-03:00","Message":"creating app lost"}]` + "\n\n"
+	first += `[{"Source":"app","Date":"2012-06-20T11:17:23-03:00","Message":"app lost successfully created"}]` + "\n"
+	second := `[{"Source":"app","Date":"2012-06-20T11:17:23-03:00","Message":"app lost successfully created"},`
+	second += `{"Source":"app","Date":"2012-06-20T11:17:24-03:00","Message":"app lost started"}]` + "\n"
+	expected := cmd.Colorfy("2012-06-20 11:17:22 [tsuru]:", "blue", "", "") + " creating app lost\n"
+	expected += cmd.Colorfy("2012-06-20 11:17:23 [app]:", "blue", "", "") + " app lost successfully created\n"
+	expected += cmd.Colorfy("2012-06-20 11:17:24 [app]:", "blue", "", "") + " app lost started\n"
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	fake := &FakeGuesser{name: "hitthelights"}
+	command := AppLog{GuessingCommand{G: fake}}
+	trans := &reconnectingTransport{bodies: []string{first, second}}
+	client := cmd.NewClient(&http.Client{Transport: trans}, &context, manager)
+	err := command.Run(&context, client)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "Failed to reconnect to tsuru server after 5 attempts.")
+	c.Assert(stdout.String(), Equals, expected)
+	reconnecting := "Lost connection to tsuru server, reconnecting...\n"
+	c.Assert(stderr.String(), Equals, reconnecting+reconnecting)
+	c.Assert(trans.requests, HasLen, 7)
+	for _, req := range trans.requests {
+		c.Assert(req.URL.Path, Equals, "/apps/hitthelights/log")
+		c.Assert(req.URL.Query().Get("follow"), Equals, "1")
+	}
+	c.Assert(trans.requests[0].URL.Query().Get("since"), Equals, "")
+	c.Assert(trans.requests[1].URL.Query().Get("since"), Equals, "2012-06-20T11:17:23-03:00")
+	c.Assert(trans.requests[1].URL.Query().Get("lines"), Equals, "0")
+	c.Assert(trans.requests[2].URL.Query().Get("since"), Equals, "2012-06-20T11:17:24-03:00")
+}
+
+func (s *S) TestAppLogFollowFailingToConnect(c *C) {
+	*LogFollow = true
+	defer func() {
+		*LogFollow = false
+	}()
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	fake := &FakeGuesser{name: "hitthelights"}
+	command := AppLog{GuessingCommand{G: fake}}
+	trans := &reconnectingTransport{}
+	client := cmd.NewClient(&http.Client{Transport: trans}, &context, manager)
+	err := command.Run(&context, client)
+	c.Assert(err, NotNil)
+	c.Assert(trans.requests, HasLen, 1)
+	c.Assert(stderr.String(), Equals, "")
+}
+
+func (s *S) TestAppLogWithFilters(c *C) {
+	*LogUnit = "hitthelights/1"
+	*LogSince = "2012-06-20T11:00:00-03:00"
+	*LogUntil = "2012-06-20T12:00:00-03:00"
+	*LogFilter = "GET /users?page=1"
+	defer func() {
+		*LogUnit = ""
+		*LogSince = ""
+		*LogUntil = ""
+		*LogFilter = ""
+	}()
+	var stdout, stderr bytes.Buffer
+	result := `[{"Source":"app","Date":"2012-06-20T11:17:22.75-03:00","Message":"GET /users?page=1 200","Unit":"hitthelights/1"}]`
+	expected := cmd.Colorfy("2012-06-20 11:17:22 [app]:", "blue", "", "") + " GET /users?page=1 200\n"
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	fake := &FakeGuesser{name: "hitthelights"}
+	command := AppLog{GuessingCommand{G: fake}}
+	trans := &conditionalTransport{
+		transport{
+			msg:    result,
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			query := req.URL.Query()
+			return query.Get("unit") == "hitthelights/1" &&
+				query.Get("since") == "2012-06-20T11:00:00-03:00" &&
+				query.Get("until") == "2012-06-20T12:00:00-03:00" &&
+				query.Get("filter") == "GET /users?page=1" &&
+				query.Get("regex") == ""
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := command.Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(stdout.String(), Equals, expected)
+}
+
+func (s *S) TestAppLogWithRegex(c *C) {
+	*LogFilter = " 5[0-9]+$"
+	*LogRegex = true
+	defer func() {
+		*LogFilter = ""
+		*LogRegex = false
+	}()
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	fake := &FakeGuesser{name: "hitthelights"}
+	command := AppLog{GuessingCommand{G: fake}}
+	trans := &conditionalTransport{
+		transport{
+			msg:    "[]",
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			query := req.URL.Query()
+			return query.Get("filter") == " 5[0-9]+$" && query.Get("regex") == "1"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := command.Run(&context, client)
+	c.Assert(err, IsNil)
+}
+
+func (s *S) TestAppLogWithInvalidDate(c *C) {
+	*LogSince = "yesterday"
+	defer func() {
+		*LogSince = ""
+	}()
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	fake := &FakeGuesser{name: "hitthelights"}
+	command := AppLog{GuessingCommand{G: fake}}
+	client := cmd.NewClient(&http.Client{Transport: &transport{msg: "[]", status: http.StatusOK}}, nil, manager)
+	err := command.Run(&context, client)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, `Invalid date "yesterday", use the RFC 3339 format (like 2012-06-20T11:17:22-03:00) or a duration (like 1h30m).`)
+}
+
+func (s *S) TestParseLogDate(c *C) {
+	now := time.Date(2012, time.June, 20, 14, 17, 22, 0, time.UTC)
+	var tests = []struct {
+		value    string
+		expected time.Time
+	}{
+		{"1h30m", time.Date(2012, time.June, 20, 12, 47, 22, 0, time.UTC)},
+		{"10s", time.Date(2012, time.June, 20, 14, 17, 12, 0, time.UTC)},
+		{"2012-06-20T11:00:00-03:00", time.Date(2012, time.June, 20, 14, 0, 0, 0, time.UTC)},
+		{"2012-06-20T14:00:00Z", time.Date(2012, time.June, 20, 14, 0, 0, 0, time.UTC)},
+	}
+	for _, t := range tests {
+		date, err := parseLogDate(t.value, now)
+		c.Check(err, IsNil)
+		c.Check(date.Equal(t.expected), Equals, true, Commentf("%s: %s", t.value, date))
+	}
+	_, err := parseLogDate("2012-06-20", now)
+	c.Assert(err, NotNil)
+}
@@ -19,6 +19,8 @@ const (
 func buildManager(name string) *cmd.Manager {
 	m := cmd.BuildBaseManager(name, version, header)
 	m.Register(&tsuru.AppList{})
+	m.Register(&tsuru.AppUnlock{})
+	m.Register(&tsuru.EnvReencrypt{})
 	return m
 }
 
@@ -17,6 +17,20 @@ func (s *S) TestAppListIsRegistered(c *C) {
 	c.Assert(list, FitsTypeOf, &tsuru.AppList{})
 }
 
+func (s *S) TestAppUnlockIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	unlock, ok := manager.Commands["app-unlock"]
+	c.Assert(ok, Equals, true)
+	c.Assert(unlock, FitsTypeOf, &tsuru.AppUnlock{})
+}
+
+func (s *S) TestEnvReencryptIsRegistered(c *C) {
+	manager := buildManager("tsuru")
+	reencrypt, ok := manager.Commands["env-reencrypt"]
+	c.Assert(ok, Equals, true)
+	c.Assert(reencrypt, FitsTypeOf, &tsuru.EnvReencrypt{})
+}
+
 func (s *S) TestCommandsFromBaseManagerAreRegistered(c *C) {
 	baseManager := cmd.BuildBaseManager("tsuru", version, header)
 	manager := buildManager("tsuru")
@@ -0,0 +1,148 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/globocom/tsuru/cmd"
+	"net/http"
+	"strconv"
+)
+
+type retentionPolicy struct {
+	Source   string
+	MaxAge   int
+	MaxCount int
+	Custom   bool
+}
+
+func (p *retentionPolicy) row() cmd.Row {
+	maxAge, maxCount := "unlimited", "unlimited"
+	if p.MaxAge > 0 {
+		maxAge = fmt.Sprintf("%d days", p.MaxAge)
+	}
+	if p.MaxCount > 0 {
+		maxCount = strconv.Itoa(p.MaxCount)
+	}
+	custom := "no"
+	if p.Custom {
+		custom = "yes"
+	}
+	return cmd.Row([]string{p.Source, maxAge, maxCount, custom})
+}
+
+type LogRetention struct {
+	GuessingCommand
+}
+
+func (c *LogRetention) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "log-retention",
+		Usage: "log-retention [--app appname]",
+		Desc: `lists the retention policies of the logs of an app, by source.
+
+Older entries, and entries beyond the maximum count, are periodically removed.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+}
+
+func (c *LogRetention) Run(context *cmd.Context, client cmd.Doer) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	url := cmd.GetUrl(fmt.Sprintf("/apps/%s/log/retention", appName))
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	defer response.Body.Close()
+	var policies []retentionPolicy
+	if err := json.NewDecoder(response.Body).Decode(&policies); err != nil {
+		return err
+	}
+	table := cmd.NewTable()
+	table.Headers = cmd.Row([]string{"Source", "Max age", "Max count", "Set for the app"})
+	for _, p := range policies {
+		table.AddRow(p.row())
+	}
+	context.Stdout.Write(table.Bytes())
+	return nil
+}
+
+type LogRetentionSet struct {
+	GuessingCommand
+}
+
+func (c *LogRetentionSet) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "log-retention-set",
+		Usage: "log-retention-set <source> <max-age> <max-count> [--app appname]",
+		Desc: `sets the retention policy of a source in the logs of an app.
+
+Entries older than max-age days, and entries beyond the last max-count ones,
+are periodically removed. Use 0 for no limit.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 3,
+	}
+}
+
+func (c *LogRetentionSet) Run(context *cmd.Context, client cmd.Doer) error {
+	maxAge, err := strconv.Atoi(context.Args[1])
+	if err != nil || maxAge < 0 {
+		return fmt.Errorf("Invalid maximum age %q, it must be a number of days.", context.Args[1])
+	}
+	maxCount, err := strconv.Atoi(context.Args[2])
+	if err != nil || maxCount < 0 {
+		return fmt.Errorf("Invalid maximum count %q.", context.Args[2])
+	}
+	body, err := json.Marshal(map[string]int{"MaxAge": maxAge, "MaxCount": maxCount})
+	if err != nil {
+		return err
+	}
+	_, err = requestAppUrl("PUT", "log/retention/"+context.Args[0], c.GuessingCommand, bytes.NewReader(body), client)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(context.Stdout, "retention policy successfully set\n")
+	return nil
+}
+
+type LogRetentionUnset struct {
+	GuessingCommand
+}
+
+func (c *LogRetentionUnset) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "log-retention-unset",
+		Usage: "log-retention-unset <source> [--app appname]",
+		Desc: `removes the retention policy of a source set for an app, which goes back to
+the policy defined in tsuru server.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 1,
+	}
+}
+
+func (c *LogRetentionUnset) Run(context *cmd.Context, client cmd.Doer) error {
+	_, err := requestAppUrl("DELETE", "log/retention/"+context.Args[0], c.GuessingCommand, nil, client)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(context.Stdout, "retention policy successfully removed\n")
+	return nil
+}
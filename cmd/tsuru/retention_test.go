@@ -0,0 +1,188 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"bytes"
+	"github.com/globocom/tsuru/cmd"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"net/http"
+)
+
+func (s *S) TestLogRetentionInfo(c *C) {
+	expected := &cmd.Info{
+		Name:  "log-retention",
+		Usage: "log-retention [--app appname]",
+		Desc: `lists the retention policies of the logs of an app, by source.
+
+Older entries, and entries beyond the maximum count, are periodically removed.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+	c.Assert((&LogRetention{}).Info(), DeepEquals, expected)
+}
+
+func (s *S) TestLogRetentionRun(c *C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	result := `[{"Source":"app","MaxAge":7,"MaxCount":1000,"Custom":true},{"Source":"tsuru","MaxAge":30,"MaxCount":0,"Custom":false}]`
+	trans := &conditionalTransport{
+		transport{
+			msg:    result,
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			return req.URL.Path == "/apps/someapp/log/retention" && req.Method == "GET"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := LogRetention{GuessingCommand{G: &FakeGuesser{name: "someapp"}}}
+	err := command.Run(&context, client)
+	c.Assert(err, IsNil)
+	expected := `+--------+---------+-----------+-----------------+
+| Source | Max age | Max count | Set for the app |
++--------+---------+-----------+-----------------+
+| app    | 7 days  | 1000      | yes             |
+| tsuru  | 30 days | unlimited | no              |
++--------+---------+-----------+-----------------+
+`
+	c.Assert(stdout.String(), Equals, expected)
+}
+
+func (s *S) TestLogRetentionRunWithoutLogs(c *C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &transport{msg: "", status: http.StatusNoContent}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := LogRetention{GuessingCommand{G: &FakeGuesser{name: "someapp"}}}
+	err := command.Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(stdout.String(), Equals, "")
+}
+
+func (s *S) TestLogRetentionIsACommand(c *C) {
+	var _ cmd.Command = &LogRetention{}
+}
+
+func (s *S) TestLogRetentionSetInfo(c *C) {
+	expected := &cmd.Info{
+		Name:  "log-retention-set",
+		Usage: "log-retention-set <source> <max-age> <max-count> [--app appname]",
+		Desc: `sets the retention policy of a source in the logs of an app.
+
+Entries older than max-age days, and entries beyond the last max-count ones,
+are periodically removed. Use 0 for no limit.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 3,
+	}
+	c.Assert((&LogRetentionSet{}).Info(), DeepEquals, expected)
+}
+
+func (s *S) TestLogRetentionSetRun(c *C) {
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{
+		Args:   []string{"app", "7", "0"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{
+			msg:    "",
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			called = true
+			body, _ := ioutil.ReadAll(req.Body)
+			return req.URL.Path == "/apps/someapp/log/retention/app" && req.Method == "PUT" &&
+				string(body) == `{"MaxAge":7,"MaxCount":0}`
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := LogRetentionSet{GuessingCommand{G: &FakeGuesser{name: "someapp"}}}
+	err := command.Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+	c.Assert(stdout.String(), Equals, "retention policy successfully set\n")
+}
+
+func (s *S) TestLogRetentionSetRunInvalidValues(c *C) {
+	var stdout, stderr bytes.Buffer
+	trans := &transport{msg: "", status: http.StatusOK}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := LogRetentionSet{GuessingCommand{G: &FakeGuesser{name: "someapp"}}}
+	context := cmd.Context{
+		Args:   []string{"app", "a week", "0"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	err := command.Run(&context, client)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, `Invalid maximum age "a week", it must be a number of days.`)
+	context.Args = []string{"app", "7", "-1"}
+	err = command.Run(&context, client)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, `Invalid maximum count "-1".`)
+}
+
+func (s *S) TestLogRetentionSetIsACommand(c *C) {
+	var _ cmd.Command = &LogRetentionSet{}
+}
+
+func (s *S) TestLogRetentionUnsetInfo(c *C) {
+	expected := &cmd.Info{
+		Name:  "log-retention-unset",
+		Usage: "log-retention-unset <source> [--app appname]",
+		Desc: `removes the retention policy of a source set for an app, which goes back to
+the policy defined in tsuru server.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 1,
+	}
+	c.Assert((&LogRetentionUnset{}).Info(), DeepEquals, expected)
+}
+
+func (s *S) TestLogRetentionUnsetRun(c *C) {
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{
+		Args:   []string{"app"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{
+			msg:    "",
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			called = true
+			return req.URL.Path == "/apps/someapp/log/retention/app" && req.Method == "DELETE"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := LogRetentionUnset{GuessingCommand{G: &FakeGuesser{name: "someapp"}}}
+	err := command.Run(&context, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+	c.Assert(stdout.String(), Equals, "retention policy successfully removed\n")
+}
+
+func (s *S) TestLogRetentionUnsetIsACommand(c *C) {
+	var _ cmd.Command = &LogRetentionUnset{}
+}
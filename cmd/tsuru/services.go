@@ -96,7 +96,7 @@ func (sb *ServiceBind) Run(ctx *cmd.Context, client cmd.Doer) error {
 		return err
 	}
 	instanceName := ctx.Args[0]
-	url := cmd.GetUrl("/services/instances/" + instanceName + "/" + appName)
+	url := cmd.GetUrl("/services/instances/" + instanceName + "/" + appName + restartQuery())
 	request, err := http.NewRequest("PUT", url, nil)
 	if err != nil {
 		return err
@@ -132,9 +132,12 @@ For more details, please check the documentation for the service, using service-
 func (sb *ServiceBind) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:  "bind",
-		Usage: "bind <instancename> [--app appname]",
+		Usage: "bind <instancename> [--app appname] [--restart]",
 		Desc: `bind a service instance to an app
 
+With --restart, the app is restarted after the instance is bound, so it sees
+the new environment variables. The restart happens in background.
+
 If you don't provide the app name, tsuru will try to guess it.`,
 		MinArgs: 1,
 	}
@@ -150,7 +153,7 @@ func (su *ServiceUnbind) Run(ctx *cmd.Context, client cmd.Doer) error {
 		return err
 	}
 	instanceName := ctx.Args[0]
-	url := cmd.GetUrl("/services/instances/" + instanceName + "/" + appName)
+	url := cmd.GetUrl("/services/instances/" + instanceName + "/" + appName + restartQuery())
 	request, err := http.NewRequest("DELETE", url, nil)
 	if err != nil {
 		return err
@@ -173,9 +176,12 @@ func (su *ServiceUnbind) Run(ctx *cmd.Context, client cmd.Doer) error {
 func (su *ServiceUnbind) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:  "unbind",
-		Usage: "unbind <instancename> [--app appname]",
+		Usage: "unbind <instancename> [--app appname] [--restart]",
 		Desc: `unbind a service instance from an app
 
+With --restart, the app is restarted after the instance is unbound. The
+restart happens in background.
+
 If you don't provide the app name, tsuru will try to guess it.`,
 		MinArgs: 1,
 	}
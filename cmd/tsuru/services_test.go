@@ -182,9 +182,12 @@ func (s *S) TestServiceBindWithRequestFailure(c *C) {
 func (s *S) TestServiceBindInfo(c *C) {
 	expected := &cmd.Info{
 		Name:  "bind",
-		Usage: "bind <instancename> [--app appname]",
+		Usage: "bind <instancename> [--app appname] [--restart]",
 		Desc: `bind a service instance to an app
 
+With --restart, the app is restarted after the instance is bound, so it sees
+the new environment variables. The restart happens in background.
+
 If you don't provide the app name, tsuru will try to guess it.`,
 		MinArgs: 1,
 	}
@@ -225,6 +228,33 @@ func (s *S) TestServiceUnbind(c *C) {
 	c.Assert(stdout.String(), Equals, "Instance hand successfully unbinded from the app pocket.\n")
 }
 
+func (s *S) TestServiceUnbindWithRestart(c *C) {
+	*AppName = "pocket"
+	*EnvRestart = true
+	defer func() { *EnvRestart = false }()
+	var stdout, stderr bytes.Buffer
+	var called bool
+	ctx := cmd.Context{
+		Args:   []string{"hand"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &conditionalTransport{
+		transport{
+			msg:    "",
+			status: http.StatusOK,
+		},
+		func(req *http.Request) bool {
+			called = true
+			return req.Method == "DELETE" && req.URL.Path == "/services/instances/hand/pocket" && req.URL.RawQuery == "restart=true"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&ServiceUnbind{}).Run(&ctx, client)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+}
+
 func (s *S) TestServiceUnbindWithoutFlag(c *C) {
 	var stdout, stderr bytes.Buffer
 	var called bool
@@ -272,9 +302,12 @@ func (s *S) TestServiceUnbindWithRequestFailure(c *C) {
 func (s *S) TestServiceUnbindInfo(c *C) {
 	expected := &cmd.Info{
 		Name:  "unbind",
-		Usage: "unbind <instancename> [--app appname]",
+		Usage: "unbind <instancename> [--app appname] [--restart]",
 		Desc: `unbind a service instance from an app
 
+With --restart, the app is restarted after the instance is unbound. The
+restart happens in background.
+
 If you don't provide the app name, tsuru will try to guess it.`,
 		MinArgs: 1,
 	}
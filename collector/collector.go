@@ -12,23 +12,152 @@ import (
 	"labix.org/v2/mgo/bson"
 )
 
+// update saves the status of units reported by the provisioner in the apps
+// they belong to, computing the state of each app from the status of all its
+// units. Units that are no longer reported by the provisioner are removed from
+// the app.
+//
+// Each app is read and saved once. Apps without any unit in the given list are
+// left untouched, see pruneUnits.
 func update(units []provision.Unit) {
 	log.Print("updating status from provisioner")
+	var names []string
+	appUnits := make(map[string][]provision.Unit)
 	for _, unit := range units {
-		a := app.App{Name: unit.AppName}
+		if _, ok := appUnits[unit.AppName]; !ok {
+			names = append(names, unit.AppName)
+		}
+		appUnits[unit.AppName] = append(appUnits[unit.AppName], unit)
+	}
+	for _, name := range names {
+		a := app.App{Name: name}
 		err := a.Get()
 		if err != nil {
-			log.Printf("collector: app %s not found. Skipping.\n", unit.AppName)
+			log.Printf("collector: app %s not found. Skipping.\n", name)
 			continue
 		}
-		u := app.Unit{}
-		u.Name = unit.Name
-		u.Type = unit.Type
-		u.Machine = unit.Machine
-		u.Ip = unit.Ip
-		u.State = string(unit.Status)
-		a.State = string(unit.Status)
-		a.AddUnit(&u)
+		a.Units = mergeUnits(a.Units, appUnits[name])
+		if a.State != string(provision.StatusStopped) {
+			a.State = appState(appUnits[name])
+		}
 		db.Session.Apps().Update(bson.M{"name": a.Name}, a)
 	}
 }
+
+// pruneUnits removes the units of the apps provisioned by the given
+// provisioner that have none of their units in the given list, which is the
+// complete status reported by the provisioner. update leaves these apps
+// untouched, so their units would never be removed otherwise.
+func pruneUnits(provisioner string, units []provision.Unit) {
+	reported := make(map[string]bool)
+	for _, unit := range units {
+		reported[unit.AppName] = true
+	}
+	var apps []app.App
+	query := bson.M{"units.0": bson.M{"$exists": true}}
+	err := db.Session.Apps().Find(query).Select(bson.M{"name": 1, "provisioner": 1, "state": 1}).All(&apps)
+	if err != nil {
+		log.Error("Failed to list apps for pruning units.", "error", err)
+		return
+	}
+	for _, a := range apps {
+		if reported[a.Name] || a.ProvisionerName() != provisioner {
+			continue
+		}
+		fields := bson.M{"units": []app.Unit{}}
+		if a.State != string(provision.StatusStopped) {
+			fields["state"] = appState(nil)
+		}
+		err = db.Session.Apps().Update(bson.M{"name": a.Name}, bson.M{"$set": fields})
+		if err != nil {
+			log.Error("Failed to prune units.", "app", a.Name, "error", err)
+		}
+	}
+}
+
+// mergeUnits returns the list of units of an app after a status collection,
+// keeping the order and the process type of units that were already known.
+func mergeUnits(current []app.Unit, reported []provision.Unit) []app.Unit {
+	byName := make(map[string]provision.Unit, len(reported))
+	for _, unit := range reported {
+		byName[unit.Name] = unit
+	}
+	units := make([]app.Unit, 0, len(reported))
+	for _, unit := range current {
+		if u, ok := byName[unit.Name]; ok {
+			merged := newUnit(u)
+			merged.Process = unit.Process
+			units = append(units, merged)
+			delete(byName, unit.Name)
+		}
+	}
+	for _, unit := range reported {
+		if _, ok := byName[unit.Name]; ok {
+			units = append(units, newUnit(unit))
+			delete(byName, unit.Name)
+		}
+	}
+	return units
+}
+
+func newUnit(unit provision.Unit) app.Unit {
+	return app.Unit{
+		Name:    unit.Name,
+		Type:    unit.Type,
+		Machine: unit.Machine,
+		Ip:      unit.Ip,
+		State:   string(unit.Status),
+	}
+}
+
+// appState computes the state of an app from the status of its units:
+//
+//   - if any unit is in error, the app is in error;
+//   - if all units share the same status, it is the state of the app;
+//   - if some units are started, the app is partially started;
+//   - if some units are being created or installed, the app is pending;
+//   - otherwise, the app is down.
+func appState(units []provision.Unit) string {
+	if len(units) == 0 {
+		return string(provision.StatusPending)
+	}
+	counts := make(map[provision.Status]int)
+	for _, unit := range units {
+		counts[unit.Status]++
+	}
+	switch {
+	case counts[provision.StatusError] > 0:
+		return string(provision.StatusError)
+	case len(counts) == 1:
+		return string(units[0].Status)
+	case counts[provision.StatusStarted] > 0:
+		return string(provision.StatusPartiallyStarted)
+	case counts[provision.StatusPending]+counts[provision.StatusCreating]+counts[provision.StatusInstalling] > 0:
+		return string(provision.StatusPending)
+	}
+	return string(provision.StatusDown)
+}
+
+// filterUnits returns the units that belong to apps provisioned by the given
+// provisioner. Units of unknown apps are dropped, and each unknown app is
+// reported once.
+func filterUnits(provisioner string, units []provision.Unit) []provision.Unit {
+	var result []provision.Unit
+	names := make(map[string]string)
+	for _, unit := range units {
+		name, ok := names[unit.AppName]
+		if !ok {
+			a := app.App{Name: unit.AppName}
+			if err := a.Get(); err == nil {
+				name = a.ProvisionerName()
+			} else {
+				log.Warn("Ignoring units of an unknown app.", "app", unit.AppName, "provisioner", provisioner)
+			}
+			names[unit.AppName] = name
+		}
+		if name == provisioner {
+			result = append(result, unit)
+		}
+	}
+	return result
+}
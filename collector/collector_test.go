@@ -93,6 +93,18 @@ func (s *S) TestUpdateWithDownMachine(c *C) {
 	c.Assert(a.State, Equals, string(provision.StatusPending))
 }
 
+func (s *S) TestUpdateDoesNotChangeTheStateOfStoppedApps(c *C) {
+	a := &app.App{Name: "umaappqq", State: string(provision.StatusStopped)}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	update(getOutput())
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.State, Equals, string(provision.StatusStopped))
+	c.Assert(a.Units[0].Name, Equals, "i-00000zz8")
+}
+
 func (s *S) TestUpdateTwice(c *C) {
 	a := getApp(c)
 	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
@@ -156,3 +168,165 @@ func (s *S) TestUpdateWithMultipleApps(c *C) {
 		c.Assert(a.Units[0].Ip, Equals, appDict["ip"])
 	}
 }
+
+func (s *S) TestUpdateRemovesUnitsNotReportedByTheProvisioner(c *C) {
+	a := app.App{
+		Name: "umaappqq",
+		Units: []app.Unit{
+			{Name: "i-00000zz7", State: string(provision.StatusStarted)},
+			{Name: "i-00000zz8", State: string(provision.StatusPending)},
+		},
+	}
+	err := db.Session.Apps().Insert(&a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	update(getOutput())
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Units, HasLen, 1)
+	c.Assert(a.Units[0].Name, Equals, "i-00000zz8")
+	c.Assert(a.Units[0].State, Equals, string(provision.StatusStarted))
+}
+
+func (s *S) TestPruneUnits(c *C) {
+	apps := []app.App{
+		{Name: "umaappqq", State: "started", Units: []app.Unit{{Name: "i-00000zz8"}}},
+		{Name: "gone", State: "started", Units: []app.Unit{{Name: "i-00000zz1"}}},
+		{Name: "stopped", State: "stopped", Units: []app.Unit{{Name: "i-00000zz2"}}},
+		{Name: "elsewhere", State: "started", Provisioner: "other", Units: []app.Unit{{Name: "i-00000zz3"}}},
+	}
+	for _, a := range apps {
+		err := db.Session.Apps().Insert(a)
+		c.Assert(err, IsNil)
+	}
+	pruneUnits("fake", getOutput())
+	var expected = []struct {
+		name  string
+		state string
+		units int
+	}{
+		{"umaappqq", "started", 1},
+		{"gone", string(provision.StatusPending), 0},
+		{"stopped", "stopped", 0},
+		{"elsewhere", "started", 1},
+	}
+	for _, e := range expected {
+		a := app.App{Name: e.name}
+		err := a.Get()
+		c.Assert(err, IsNil)
+		c.Check(a.State, Equals, e.state, Commentf("app %s", e.name))
+		c.Check(a.Units, HasLen, e.units, Commentf("app %s", e.name))
+	}
+}
+
+func (s *S) TestUpdateComputesTheStateFromAllUnits(c *C) {
+	a := getApp(c)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	out := append(getOutput(), provision.Unit{
+		Name:    "i-00000zz9",
+		AppName: "umaappqq",
+		Type:    "python",
+		Machine: 2,
+		Ip:      "192.168.0.12",
+		Status:  provision.StatusDown,
+	})
+	update(out)
+	err := a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.State, Equals, string(provision.StatusPartiallyStarted))
+	out[0], out[1] = out[1], out[0]
+	update(out)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.State, Equals, string(provision.StatusPartiallyStarted))
+	c.Assert(a.Units[0].Name, Equals, "i-00000zz8")
+	c.Assert(a.Units[1].Name, Equals, "i-00000zz9")
+}
+
+func (s *S) TestMergeUnits(c *C) {
+	current := []app.Unit{
+		{Name: "app/0", State: "started"},
+		{Name: "app/1", State: "started"},
+		{Name: "app/2", State: "pending"},
+	}
+	reported := []provision.Unit{
+		{Name: "app/3", Ip: "10.10.10.3", Status: provision.StatusPending},
+		{Name: "app/2", Ip: "10.10.10.2", Status: provision.StatusStarted},
+		{Name: "app/0", Ip: "10.10.10.0", Status: provision.StatusDown},
+	}
+	expected := []app.Unit{
+		{Name: "app/0", Ip: "10.10.10.0", State: "down"},
+		{Name: "app/2", Ip: "10.10.10.2", State: "started"},
+		{Name: "app/3", Ip: "10.10.10.3", State: "pending"},
+	}
+	c.Assert(mergeUnits(current, reported), DeepEquals, expected)
+}
+
+func (s *S) TestMergeUnitsKeepsTheProcessType(c *C) {
+	current := []app.Unit{
+		{Name: "app/0", State: "started"},
+		{Name: "app/1", State: "started", Process: "worker"},
+	}
+	reported := []provision.Unit{
+		{Name: "app/0", Ip: "10.10.10.0", Status: provision.StatusStarted},
+		{Name: "app/1", Ip: "10.10.10.1", Status: provision.StatusDown},
+	}
+	expected := []app.Unit{
+		{Name: "app/0", Ip: "10.10.10.0", State: "started"},
+		{Name: "app/1", Ip: "10.10.10.1", State: "down", Process: "worker"},
+	}
+	c.Assert(mergeUnits(current, reported), DeepEquals, expected)
+}
+
+func (s *S) TestAppState(c *C) {
+	var tests = []struct {
+		input    []provision.Status
+		expected provision.Status
+	}{
+		{nil, provision.StatusPending},
+		{[]provision.Status{provision.StatusStarted}, provision.StatusStarted},
+		{[]provision.Status{provision.StatusStarted, provision.StatusStarted}, provision.StatusStarted},
+		{[]provision.Status{provision.StatusDown, provision.StatusDown}, provision.StatusDown},
+		{[]provision.Status{provision.StatusInstalling, provision.StatusInstalling}, provision.StatusInstalling},
+		{[]provision.Status{provision.StatusStarted, provision.StatusError}, provision.StatusError},
+		{[]provision.Status{provision.StatusDown, provision.StatusError}, provision.StatusError},
+		{[]provision.Status{provision.StatusStarted, provision.StatusDown}, provision.StatusPartiallyStarted},
+		{[]provision.Status{provision.StatusPending, provision.StatusStarted}, provision.StatusPartiallyStarted},
+		{[]provision.Status{provision.StatusPending, provision.StatusDown}, provision.StatusPending},
+		{[]provision.Status{provision.StatusCreating, provision.StatusInstalling}, provision.StatusPending},
+		{[]provision.Status{provision.StatusDown, provision.Status("unknown")}, provision.StatusDown},
+	}
+	for _, t := range tests {
+		units := make([]provision.Unit, len(t.input))
+		for i, status := range t.input {
+			units[i] = provision.Unit{Status: status}
+		}
+		if got := appState(units); got != string(t.expected) {
+			c.Errorf("appState(%v): want %q. Got %q.", t.input, t.expected, got)
+		}
+	}
+}
+
+func (s *S) TestFilterUnits(c *C) {
+	apps := []app.App{
+		{Name: "blues", Provisioner: "local"},
+		{Name: "jazz", Provisioner: "fake"},
+		{Name: "rock"},
+	}
+	for _, a := range apps {
+		err := db.Session.Apps().Insert(a)
+		c.Assert(err, IsNil)
+	}
+	defer db.Session.Apps().RemoveAll(bson.M{"name": bson.M{"$in": []string{"blues", "jazz", "rock"}}})
+	units := []provision.Unit{
+		{Name: "blues/0", AppName: "blues"},
+		{Name: "jazz/0", AppName: "jazz"},
+		{Name: "rock/0", AppName: "rock"},
+		{Name: "rock/1", AppName: "rock"},
+		{Name: "pop/0", AppName: "pop"},
+	}
+	expected := []provision.Unit{units[1], units[2], units[3]}
+	c.Assert(filterUnits("fake", units), DeepEquals, expected)
+	expected = []provision.Unit{units[0]}
+	c.Assert(filterUnits("local", units), DeepEquals, expected)
+}
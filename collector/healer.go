@@ -0,0 +1,120 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/app"
+	"github.com/globocom/tsuru/log"
+	"github.com/globocom/tsuru/provision"
+	"time"
+)
+
+const (
+	healerSource       = "tsuru-healer"
+	defaultGracePeriod = 5 * time.Minute
+)
+
+// gracePeriod returns how long a unit may stay in error or down state before
+// being replaced. It's defined by the "healer:grace-period" setting, in
+// seconds.
+func gracePeriod() time.Duration {
+	seconds, err := config.GetInt("healer:grace-period")
+	if err != nil {
+		return defaultGracePeriod
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// healer replaces units that stay in error or down state for longer than the
+// grace period. Each provisioner has its own healer, that remembers when each
+// unit was first reported as failing.
+//
+// Apps that are stopped or that have DisableHealing set are never healed.
+type healer struct {
+	failing map[string]time.Time
+}
+
+func newHealer() *healer {
+	return &healer{failing: make(map[string]time.Time)}
+}
+
+func (h *healer) heal(units []provision.Unit) {
+	now := time.Now()
+	grace := gracePeriod()
+	failing := make(map[string]time.Time)
+	var broken []provision.Unit
+	for _, unit := range units {
+		if unit.Status != provision.StatusError && unit.Status != provision.StatusDown {
+			continue
+		}
+		since, ok := h.failing[unit.Name]
+		if !ok {
+			since = now
+		}
+		if now.Sub(since) >= grace {
+			broken = append(broken, unit)
+		} else {
+			failing[unit.Name] = since
+		}
+	}
+	h.failing = failing
+	for _, unit := range broken {
+		err := replaceUnit(unit)
+		if _, ok := err.(*app.LockedError); ok {
+			// The app is busy, the unit is healed in the next run.
+			log.Warn("Not healing unit.", "unit", unit.Name, "error", err)
+			h.failing[unit.Name] = now.Add(-grace)
+		} else if err != nil {
+			log.Error("Failed to heal unit.", "unit", unit.Name, "error", err)
+		}
+	}
+}
+
+// replaceUnit adds a new unit to the app and removes the broken one. Adding
+// the unit enqueues the messages that regenerate the apprc and start the new
+// unit. The app is locked while its units are replaced, and replaceUnit
+// returns an *app.LockedError if someone else holds the lock.
+//
+// The app is loaded after acquiring the lock, so that changes made while it
+// was locked, like disabling healing or removing the unit, are respected.
+func replaceUnit(unit provision.Unit) error {
+	lock, err := app.AcquireLock(unit.AppName, healerSource, "replace unit "+unit.Name)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	a := app.App{Name: unit.AppName}
+	if err := a.Get(); err != nil {
+		return fmt.Errorf("app %s not found", unit.AppName)
+	}
+	if a.DisableHealing || a.State == string(provision.StatusStopped) {
+		return nil
+	}
+	process := ""
+	for _, u := range a.Units {
+		if u.Name == unit.Name {
+			process = u.ProcessName()
+			break
+		}
+	}
+	if process == "" {
+		// The unit was removed from the app in the meantime.
+		return nil
+	}
+	a.Log(fmt.Sprintf("Unit %s is %s, replacing it.", unit.Name, unit.Status), healerSource)
+	if err := a.AddProcessUnits(1, process); err != nil {
+		a.Log(fmt.Sprintf("Failed to add a new unit: %s.", err), healerSource)
+		return err
+	}
+	a.Log(fmt.Sprintf("Added unit %s.", a.Units[len(a.Units)-1].Name), healerSource)
+	if err := a.RemoveUnit(unit.Name); err != nil {
+		a.Log(fmt.Sprintf("Failed to remove unit %s: %s.", unit.Name, err), healerSource)
+		return err
+	}
+	a.Log(fmt.Sprintf("Removed unit %s.", unit.Name), healerSource)
+	return nil
+}
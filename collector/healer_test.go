@@ -0,0 +1,264 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/app"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/provision"
+	"github.com/globocom/tsuru/queue"
+	ttesting "github.com/globocom/tsuru/testing"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	. "launchpad.net/gocheck"
+	"strings"
+	"time"
+)
+
+func (s *S) TestGracePeriod(c *C) {
+	config.Set("healer:grace-period", 30)
+	defer config.Unset("healer")
+	c.Assert(gracePeriod(), Equals, 30*time.Second)
+}
+
+func (s *S) TestGracePeriodDefaultValue(c *C) {
+	c.Assert(gracePeriod(), Equals, defaultGracePeriod)
+}
+
+func (s *S) TestHealWaitsForTheGracePeriod(c *C) {
+	h := newHealer()
+	units := []provision.Unit{
+		{Name: "bubbles/0", AppName: "bubbles", Status: provision.StatusStarted},
+		{Name: "bubbles/1", AppName: "bubbles", Status: provision.StatusDown},
+		{Name: "bubbles/2", AppName: "bubbles", Status: provision.StatusError},
+	}
+	h.heal(units)
+	c.Assert(h.failing, HasLen, 2)
+	first := h.failing["bubbles/1"]
+	c.Assert(first.IsZero(), Equals, false)
+	h.heal(units)
+	c.Assert(h.failing["bubbles/1"], Equals, first)
+}
+
+func (s *S) TestHealForgetsUnitsThatAreBack(c *C) {
+	h := newHealer()
+	h.failing["bubbles/1"] = time.Now()
+	units := []provision.Unit{
+		{Name: "bubbles/1", AppName: "bubbles", Status: provision.StatusStarted},
+	}
+	h.heal(units)
+	c.Assert(h.failing, HasLen, 0)
+}
+
+func (s *S) TestHealReplacesBrokenUnits(c *C) {
+	server := ttesting.FakeQueueServer{}
+	server.Start("127.0.0.1:0")
+	defer server.Stop()
+	config.Set("queue-server", server.Addr())
+	defer config.Set("queue-server", "127.0.0.1:0")
+	a := app.App{
+		Name:      "bubbles",
+		Framework: "python",
+		State:     string(provision.StatusStarted),
+		Units: []app.Unit{
+			{Name: "bubbles/0", State: string(provision.StatusDown)},
+		},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	err = s.provisioner.Provision(&a)
+	c.Assert(err, IsNil)
+	defer s.provisioner.Destroy(&a)
+	h := newHealer()
+	h.failing["bubbles/0"] = time.Now().Add(-defaultGracePeriod)
+	units := []provision.Unit{
+		{Name: "bubbles/0", AppName: "bubbles", Status: provision.StatusDown},
+	}
+	h.heal(units)
+	c.Assert(h.failing, HasLen, 0)
+	provisioned := s.provisioner.GetUnits(&a)
+	c.Assert(provisioned, HasLen, 1)
+	c.Assert(provisioned[0].Name, Equals, "bubbles/1")
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Units, HasLen, 1)
+	c.Assert(a.Units[0].Name, Equals, "bubbles/1")
+	expected := []string{
+		"Unit bubbles/0 is down, replacing it.",
+		"Added unit bubbles/1.",
+		"Removed unit bubbles/0.",
+	}
+	healerLogs, err := a.LastLogs(0, app.LogFilter{Source: "tsuru-healer"})
+	c.Assert(err, IsNil)
+	var logs []string
+	for _, l := range healerLogs {
+		logs = append(logs, l.Message)
+	}
+	c.Assert(logs, DeepEquals, expected)
+	time.Sleep(1e6)
+	messages := []queue.Message{
+		{Action: app.RegenerateApprcAndStart, Args: []string{a.Name, "bubbles/1"}},
+	}
+	c.Assert(server.Messages(), DeepEquals, messages)
+}
+
+func (s *S) TestHealAppInErrorState(c *C) {
+	handler := MessageHandler{}
+	err := handler.start()
+	c.Assert(err, IsNil)
+	defer handler.stop()
+	config.Set("queue-server", handler.server.Addr())
+	defer config.Set("queue-server", "127.0.0.1:0")
+	a := app.App{
+		Name:      "bubbles",
+		Framework: "python",
+		State:     string(provision.StatusError),
+		Units: []app.Unit{
+			{Name: "bubbles/0", State: string(provision.StatusError)},
+		},
+	}
+	err = db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	err = s.provisioner.Provision(&a)
+	c.Assert(err, IsNil)
+	defer s.provisioner.Destroy(&a)
+	h := newHealer()
+	h.failing["bubbles/0"] = time.Now().Add(-defaultGracePeriod)
+	h.heal([]provision.Unit{{Name: "bubbles/0", AppName: "bubbles", Status: provision.StatusError}})
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Units, HasLen, 1)
+	c.Assert(a.Units[0].Name, Equals, "bubbles/1")
+	// The messages of the new unit wait for the app to recover.
+	time.Sleep(5e8)
+	c.Assert(s.provisioner.GetCmds("", &a), HasLen, 0)
+	s.provisioner.PrepareOutput(nil) // apprc
+	s.provisioner.PrepareOutput(nil) // loadHooks
+	s.provisioner.PrepareOutput(nil) // restart
+	update([]provision.Unit{{Name: "bubbles/1", AppName: "bubbles", Status: provision.StatusStarted}})
+	time.Sleep(2e9)
+	// The apprc is written before the restart.
+	cmds := s.provisioner.GetCmds("", &a)
+	c.Assert(cmds, HasLen, 3)
+	c.Assert(strings.HasSuffix(cmds[0].Cmd, "> /home/application/apprc"), Equals, true)
+	c.Assert(cmds[2].Cmd, Equals, "/var/lib/tsuru/hooks/restart")
+}
+
+func (s *S) TestHealSkipsAppsWithHealingDisabled(c *C) {
+	a := app.App{
+		Name:           "bubbles",
+		Framework:      "python",
+		State:          string(provision.StatusStarted),
+		DisableHealing: true,
+		Units: []app.Unit{
+			{Name: "bubbles/0", State: string(provision.StatusError)},
+		},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	h := newHealer()
+	h.failing["bubbles/0"] = time.Now().Add(-defaultGracePeriod)
+	units := []provision.Unit{
+		{Name: "bubbles/0", AppName: "bubbles", Status: provision.StatusError},
+	}
+	h.heal(units)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Units, HasLen, 1)
+	c.Assert(a.Units[0].Name, Equals, "bubbles/0")
+	logs, err := a.LastLogs(0, app.LogFilter{})
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 0)
+}
+
+func (s *S) TestHealSkipsStoppedApps(c *C) {
+	a := app.App{
+		Name:      "bubbles",
+		Framework: "python",
+		State:     string(provision.StatusStopped),
+		Units: []app.Unit{
+			{Name: "bubbles/0", State: string(provision.StatusDown)},
+		},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	h := newHealer()
+	h.failing["bubbles/0"] = time.Now().Add(-defaultGracePeriod)
+	units := []provision.Unit{
+		{Name: "bubbles/0", AppName: "bubbles", Status: provision.StatusDown},
+	}
+	h.heal(units)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Units, HasLen, 1)
+	logs, err := a.LastLogs(0, app.LogFilter{})
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 0)
+}
+
+func (s *S) TestHealSkipsUnitsRemovedFromApp(c *C) {
+	a := app.App{
+		Name:      "bubbles",
+		Framework: "python",
+		State:     string(provision.StatusStarted),
+		Units: []app.Unit{
+			{Name: "bubbles/1", State: string(provision.StatusStarted)},
+		},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	h := newHealer()
+	h.failing["bubbles/0"] = time.Now().Add(-defaultGracePeriod)
+	units := []provision.Unit{
+		{Name: "bubbles/0", AppName: "bubbles", Status: provision.StatusDown},
+	}
+	h.heal(units)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Units, HasLen, 1)
+	c.Assert(a.Units[0].Name, Equals, "bubbles/1")
+	logs, err := a.LastLogs(0, app.LogFilter{})
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 0)
+	_, err = app.GetLock(a.Name)
+	c.Assert(err, Equals, mgo.ErrNotFound)
+}
+
+func (s *S) TestHealSkipsLockedApps(c *C) {
+	a := app.App{
+		Name:      "bubbles",
+		Framework: "python",
+		State:     string(provision.StatusStarted),
+		Units: []app.Unit{
+			{Name: "bubbles/0", State: string(provision.StatusError)},
+		},
+	}
+	err := db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	lock, err := app.AcquireLock(a.Name, "someone@tsuru.io", "deploy")
+	c.Assert(err, IsNil)
+	defer lock.Release()
+	h := newHealer()
+	h.failing["bubbles/0"] = time.Now().Add(-defaultGracePeriod)
+	units := []provision.Unit{
+		{Name: "bubbles/0", AppName: "bubbles", Status: provision.StatusError},
+	}
+	h.heal(units)
+	_, ok := h.failing["bubbles/0"]
+	c.Assert(ok, Equals, true)
+	err = a.Get()
+	c.Assert(err, IsNil)
+	c.Assert(a.Units, HasLen, 1)
+	logs, err := a.LastLogs(0, app.LogFilter{})
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 0)
+}
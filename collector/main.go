@@ -13,22 +13,53 @@ import (
 	"github.com/globocom/tsuru/log"
 	"github.com/globocom/tsuru/provision"
 	_ "github.com/globocom/tsuru/provision/juju"
+	_ "github.com/globocom/tsuru/provision/local"
 	stdlog "log"
-	"log/syslog"
 	"os"
 	"time"
 )
 
 func jujuCollect(ticker <-chan time.Time) {
+	healers := make(map[string]*healer)
 	for _ = range ticker {
-		units, err := app.Provisioner.CollectStatus()
+		names, err := app.ProvisionerNames()
 		if err != nil {
-			log.Printf("Failed to collect status within the provisioner: %s.", err)
+			log.Error("Failed to list provisioners in use.", "error", err)
+			continue
+		}
+		for _, name := range names {
+			h, ok := healers[name]
+			if !ok {
+				h = newHealer()
+				healers[name] = h
+			}
+			collect(name, h)
 		}
-		update(units)
 	}
 }
 
+// collect updates the status of the apps provisioned by the given
+// provisioner, and replaces their broken units.
+func collect(provisioner string, h *healer) {
+	p, err := provision.Get(provisioner)
+	if err != nil {
+		log.Error("Failed to collect status.", "provisioner", provisioner, "error", err)
+		return
+	}
+	units, err := p.CollectStatus()
+	if err != nil {
+		log.Error("Failed to collect status.", "provisioner", provisioner, "error", err)
+	}
+	units = filterUnits(provisioner, units)
+	update(units)
+	// A failed collection may be incomplete, so units are pruned only after
+	// successful ones.
+	if err == nil {
+		pruneUnits(provisioner, units)
+	}
+	h.heal(units)
+}
+
 func fatal(err error) {
 	fmt.Fprintln(os.Stderr, err)
 	log.Fatal(err)
@@ -39,11 +70,11 @@ func main() {
 		configFile string
 		dry        bool
 	)
-	logger, err := syslog.NewLogger(syslog.LOG_INFO, stdlog.LstdFlags)
+	writer, err := log.NewSyslogWriter("", log.TextFormat)
 	if err != nil {
 		stdlog.Fatal(err)
 	}
-	log.SetLogger(logger)
+	log.SetWriters(writer)
 	flag.StringVar(&configFile, "config", "/etc/tsuru/tsuru.conf", "tsuru config file")
 	flag.BoolVar(&dry, "dry", false, "dry-run: does not start the agent neither the queue (for testing purposes)")
 	flag.Parse()
@@ -51,6 +82,9 @@ func main() {
 	if err != nil {
 		fatal(err)
 	}
+	if err = log.ReadConfig(); err != nil {
+		fatal(err)
+	}
 	connString, err := config.GetString("database:url")
 	if err != nil {
 		fatal(err)
@@ -66,18 +100,19 @@ func main() {
 	defer db.Session.Close()
 	fmt.Printf("Connected to MongoDB server at %s.\n", connString)
 	fmt.Printf("Using the database %q.\n\n", dbName)
+	if err = app.MigrateLogs(os.Stdout); err != nil {
+		fmt.Printf("Warning: %s\n", err)
+	}
 
 	if !dry {
-		provisioner, err := config.GetString("provisioner")
-		if err != nil {
+		if _, err = config.GetString("provisioner"); err != nil {
 			fmt.Printf("Warning: %q didn't declare a provisioner, using default provisioner.\n", configFile)
-			provisioner = "juju"
 		}
-		app.Provisioner, err = provision.Get(provisioner)
-		if err != nil {
+		provisioner := app.DefaultProvisioner()
+		if _, err = provision.Get(provisioner); err != nil {
 			fatal(err)
 		}
-		fmt.Printf("Using %q provisioner.\n\n", provisioner)
+		fmt.Printf("Using %q as default provisioner.\n\n", provisioner)
 
 		handler := MessageHandler{}
 		err = handler.start()
@@ -86,6 +121,7 @@ func main() {
 		}
 		fmt.Printf("Queue server listening at %s.\n", handler.server.Addr())
 		defer handler.stop()
+		go purgeLogs(time.Tick(time.Hour))
 		ticker := time.Tick(time.Minute)
 		fmt.Println("tsuru collector agent started...")
 		jujuCollect(ticker)
@@ -58,6 +58,8 @@ func (s *S) TestJujuCollect(c *C) {
 	var apps []app.App
 	err := db.Session.Apps().Find(bson.M{"name": bson.M{"$in": []string{"as_i_rise", "the_infanta"}}}).Sort("name").All(&apps)
 	c.Assert(err, IsNil)
-	c.Assert(apps[0].Units[1].Ip, Equals, "10.10.10.1")
-	c.Assert(apps[1].Units[1].Ip, Equals, "10.10.10.2")
+	c.Assert(apps[0].Units, HasLen, 1)
+	c.Assert(apps[0].Units[0].Ip, Equals, "10.10.10.1")
+	c.Assert(apps[1].Units, HasLen, 1)
+	c.Assert(apps[1].Units[0].Ip, Equals, "10.10.10.2")
 }
@@ -0,0 +1,43 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"github.com/globocom/tsuru/app"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/log"
+	"labix.org/v2/mgo/bson"
+	"time"
+)
+
+func purgeLogs(ticker <-chan time.Time) {
+	for _ = range ticker {
+		purge()
+	}
+}
+
+// purge removes the log entries that are beyond the retention policies of the
+// apps (see app.RetentionPolicy), and returns how many entries were removed.
+func purge() int {
+	var apps []app.App
+	err := db.Session.Apps().Find(nil).Select(bson.M{"name": 1, "logretention": 1}).All(&apps)
+	if err != nil {
+		log.Error("Failed to list apps for purging logs.", "error", err)
+		return 0
+	}
+	var total int
+	for _, a := range apps {
+		n, err := a.PurgeLogs()
+		if err != nil {
+			log.Error("Failed to purge the logs of the app.", "app", a.Name, "error", err)
+		}
+		if n > 0 {
+			log.Info("Purged log entries of the app.", "app", a.Name, "entries", n)
+		}
+		total += n
+	}
+	log.Info("Purged log entries.", "apps", len(apps), "entries", total)
+	return total
+}
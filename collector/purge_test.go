@@ -0,0 +1,41 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"github.com/globocom/tsuru/app"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/log"
+	. "launchpad.net/gocheck"
+	stdlog "log"
+	"time"
+)
+
+func (s *S) TestPurge(c *C) {
+	old := time.Now().Add(-60 * 24 * time.Hour)
+	err := db.Session.Apps().Insert(
+		app.App{Name: "ancient"},
+		app.App{Name: "modern", LogRetention: map[string]app.RetentionPolicy{"app": {MaxAge: 90}}},
+	)
+	c.Assert(err, IsNil)
+	err = db.Session.Logs().Insert(
+		app.Applog{Date: old, Message: "old", Source: "app", AppName: "ancient"},
+		app.Applog{Date: old, Message: "old", Source: "tsuru", AppName: "ancient"},
+		app.Applog{Date: time.Now(), Message: "recent", Source: "app", AppName: "ancient"},
+		app.Applog{Date: old, Message: "old", Source: "app", AppName: "modern"},
+	)
+	c.Assert(err, IsNil)
+	var buf bytes.Buffer
+	log.SetLogger(stdlog.New(&buf, "", 0))
+	defer log.SetLogger(nil)
+	n := purge()
+	c.Assert(n, Equals, 2)
+	c.Assert(buf.String(), Matches, `(?s).*Purged log entries of the app\. app=ancient entries=2\n.*`)
+	c.Assert(buf.String(), Matches, `(?s).*Purged log entries\. apps=2 entries=2\n.*`)
+	count, err := db.Session.Logs().Find(nil).Count()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, 2)
+}
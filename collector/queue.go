@@ -18,6 +18,9 @@ import (
 
 const MaxVisits = 50
 
+// queueSource is the owner of the locks acquired when handling messages.
+const queueSource = "tsuru-queue"
+
 type MessageHandler struct {
 	closed int32
 	server *queue.Server
@@ -50,6 +53,13 @@ func (h *MessageHandler) handleMessages() {
 	}
 }
 
+// ensureAppIsStarted loads the app of the message, checking that the app, and
+// the units given in the message, are started. Partially started apps are
+// accepted, as long as the given units are started.
+//
+// Messages of apps that are not started are put back in the queue, except
+// for stopped apps: apps in error or down are expected to recover, for
+// example when the healer replaces their broken units.
 func (h *MessageHandler) ensureAppIsStarted(msg queue.Message) (app.App, error) {
 	a := app.App{Name: msg.Args[0]}
 	err := a.Get()
@@ -57,15 +67,18 @@ func (h *MessageHandler) ensureAppIsStarted(msg queue.Message) (app.App, error)
 		return a, fmt.Errorf("Error handling %q: app %q does not exist.", msg.Action, a.Name)
 	}
 	units := h.getUnits(&a, msg.Args[1:])
-	if a.State != "started" || !units.Started() {
+	started := a.State == string(provision.StatusStarted) || a.State == string(provision.StatusPartiallyStarted)
+	if !started || !units.Started() {
 		format := "Error handling %q for the app %q:"
 		switch a.State {
 		case "error":
 			format += " the app is in %q state."
-		case "down":
+		case "down", "stopped":
 			format += " the app is %s."
 		default:
 			format += ` The status of the app and all units should be "started" (the app is %q).`
+		}
+		if a.State != string(provision.StatusStopped) {
 			time.Sleep(time.Duration(msg.Visits+1) * time.Second)
 			h.server.PutBack(msg)
 		}
@@ -80,35 +93,68 @@ func (h *MessageHandler) handle(msg queue.Message) {
 		return
 	}
 	switch msg.Action {
-	case app.RegenerateApprc:
+	case app.RegenerateApprc, app.RegenerateApprcAndStart:
 		if len(msg.Args) < 1 {
 			log.Printf("Error handling %q: this action requires at least 1 argument.", msg.Action)
 			return
 		}
-		app, err := h.ensureAppIsStarted(msg)
+		a, err := h.ensureAppIsStarted(msg)
 		if err != nil {
 			log.Print(err)
 			return
 		}
-		app.SerializeEnvVars()
+		if err := a.SerializeEnvVars(); err != nil {
+			log.Printf("Error handling %q: %s", msg.Action, err)
+			return
+		}
+		if msg.Action == app.RegenerateApprcAndStart {
+			h.startApp(&a, queue.Message{Action: app.StartApp, Args: msg.Args, Visits: msg.Visits})
+		}
 	case app.StartApp:
 		if len(msg.Args) < 1 {
 			log.Printf("Error handling %q: this action requires at least 1 argument.", msg.Action)
+			return
 		}
-		app, err := h.ensureAppIsStarted(msg)
+		a, err := h.ensureAppIsStarted(msg)
 		if err != nil {
 			log.Print(err)
 			return
 		}
-		err = app.Restart(ioutil.Discard)
-		if err != nil {
-			log.Printf("Error handling %q. App failed to start:\n%s.", msg.Action, err)
-		}
+		h.startApp(&a, msg)
 	default:
 		log.Printf("Error handling %q: invalid action.", msg.Action)
 	}
 }
 
+// startApp restarts the app, or the units given in the message, running the
+// on-unit-added hooks in new units. The restart waits for deploys, rollbacks
+// and other operations that lock the app, putting the message back in the
+// queue.
+func (h *MessageHandler) startApp(a *app.App, msg queue.Message) {
+	lock, err := app.AcquireLock(a.Name, queueSource, "restart")
+	if _, ok := err.(*app.LockedError); ok {
+		log.Printf("Error handling %q: %s Trying again later...", msg.Action, err)
+		time.Sleep(time.Duration(msg.Visits+1) * time.Second)
+		h.server.PutBack(msg)
+		return
+	} else if err != nil {
+		log.Printf("Error handling %q: %s.", msg.Action, err)
+		return
+	}
+	defer lock.Release()
+	err = a.Restart(ioutil.Discard, msg.Args[1:]...)
+	if err != nil {
+		log.Printf("Error handling %q. App failed to start:\n%s.", msg.Action, err)
+		return
+	}
+	if len(msg.Args) > 1 {
+		err = a.RunHooks(ioutil.Discard, "on-unit-added", msg.Args[1:]...)
+		if err != nil {
+			log.Printf("Error handling %q: %s.", msg.Action, err)
+		}
+	}
+}
+
 func (h *MessageHandler) stop() error {
 	atomic.StoreInt32(&h.closed, 1)
 	return h.server.Close()
@@ -12,6 +12,8 @@ import (
 	"github.com/globocom/tsuru/log"
 	"github.com/globocom/tsuru/provision"
 	"github.com/globocom/tsuru/queue"
+	ttesting "github.com/globocom/tsuru/testing"
+	"labix.org/v2/mgo"
 	"labix.org/v2/mgo/bson"
 	. "launchpad.net/gocheck"
 	stdlog "log"
@@ -53,8 +55,8 @@ func (s *S) TestHandleMessages(c *C) {
 	cmds := s.provisioner.GetCmds("", &a)
 	c.Assert(cmds, HasLen, 1)
 	output := strings.Replace(cmds[0].Cmd, "\n", " ", -1)
-	outputRegexp := `^cat > /home/application/apprc <<END # generated by tsuru.*`
-	outputRegexp += `export http_proxy="http://myproxy.com:3128/" END $`
+	outputRegexp := `^printf '%s' '# generated by tsuru .*`
+	outputRegexp += ` export http_proxy='\\''http://myproxy.com:3128/'\\'' ' > /home/application/apprc$`
 	c.Assert(output, Matches, outputRegexp)
 }
 
@@ -102,11 +104,40 @@ func (s *S) TestHandleMessageWithSpecificUnit(c *C) {
 	cmds := s.provisioner.GetCmds("", &a)
 	c.Assert(cmds, HasLen, 1)
 	output := strings.Replace(cmds[0].Cmd, "\n", " ", -1)
-	outputRegexp := `^cat > /home/application/apprc <<END # generated by tsuru.*`
-	outputRegexp += `export http_proxy="http://myproxy.com:3128/" END $`
+	outputRegexp := `^printf '%s' '# generated by tsuru .*`
+	outputRegexp += ` export http_proxy='\\''http://myproxy.com:3128/'\\'' ' > /home/application/apprc$`
 	c.Assert(output, Matches, outputRegexp)
 }
 
+func (s *S) TestHandleRegenerateApprcAndStartMessage(c *C) {
+	s.provisioner.PrepareOutput(nil) // apprc
+	s.provisioner.PrepareOutput(nil) // loadHooks
+	s.provisioner.PrepareOutput([]byte("started"))
+	handler := MessageHandler{}
+	err := handler.start()
+	c.Assert(err, IsNil)
+	defer handler.stop()
+	a := app.App{
+		Name:  "nemesis",
+		Units: []app.Unit{{Name: "nemesis/0", State: "started", Machine: 19}},
+		Env: map[string]bind.EnvVar{
+			"http_proxy": {Name: "http_proxy", Value: "http://myproxy.com:3128/", Public: true},
+		},
+		State: string(provision.StatusStarted),
+	}
+	err = db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	messages, _, err := queue.Dial(handler.server.Addr())
+	c.Assert(err, IsNil)
+	messages <- queue.Message{Action: app.RegenerateApprcAndStart, Args: []string{a.Name}}
+	time.Sleep(1e9)
+	cmds := s.provisioner.GetCmds("", &a)
+	c.Assert(cmds, HasLen, 3)
+	c.Assert(cmds[0].Cmd, Matches, `(?s)^printf .* > /home/application/apprc$`)
+	c.Assert(cmds[2].Cmd, Equals, "/var/lib/tsuru/hooks/restart")
+}
+
 func (s *S) TestHandleMessageErrors(c *C) {
 	var data = []struct {
 		action      string
@@ -171,6 +202,12 @@ func (s *S) TestHandleMessageErrors(c *C) {
 			expectedLog: `Error handling "regenerate-apprc" for the app "territories":` +
 				` the app is down.`,
 		},
+		{
+			action: app.StartApp,
+			args:   []string{"subdivisions"},
+			expectedLog: `Error handling "start-app" for the app "subdivisions":` +
+				` the app is stopped.`,
+		},
 	}
 	var buf bytes.Buffer
 	a := app.App{Name: "nemesis", State: "pending"}
@@ -196,6 +233,10 @@ func (s *S) TestHandleMessageErrors(c *C) {
 	err = db.Session.Apps().Insert(a)
 	c.Assert(err, IsNil)
 	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	a = app.App{Name: "subdivisions", State: "stopped"}
+	err = db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
 	log.SetLogger(stdlog.New(&buf, "", 0))
 	handler := MessageHandler{}
 	handler.start()
@@ -255,6 +296,80 @@ func (s *S) TestHandleRestartAppMessage(c *C) {
 	c.Assert(cmds, HasLen, 1)
 }
 
+func (s *S) TestHandleRestartAppMessageWithSpecificUnit(c *C) {
+	p := ttesting.NewExtensibleFakeProvisioner()
+	provision.Register("extensible", p)
+	p.PrepareOutput(nil) // loadHooks
+	p.PrepareOutput([]byte("started"))
+	handler := MessageHandler{}
+	err := handler.start()
+	c.Assert(err, IsNil)
+	defer handler.stop()
+	a := app.App{
+		Name:        "nemesis",
+		Provisioner: "extensible",
+		Units: []app.Unit{
+			{
+				Name:    "nemesis/0",
+				State:   "started",
+				Machine: 19,
+			},
+			{
+				Name:    "nemesis/1",
+				State:   "started",
+				Machine: 20,
+			},
+		},
+		State: string(provision.StatusStarted),
+	}
+	err = db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	messages, _, err := queue.Dial(handler.server.Addr())
+	c.Assert(err, IsNil)
+	messages <- queue.Message{Action: app.StartApp, Args: []string{a.Name, "nemesis/1"}}
+	time.Sleep(1e9)
+	cmds := p.GetCmds("/var/lib/tsuru/hooks/restart", &a)
+	c.Assert(cmds, HasLen, 1)
+	c.Assert(cmds[0].Unit, Equals, "nemesis/1")
+}
+
+func (s *S) TestHandleRestartAppMessageLockedApp(c *C) {
+	s.provisioner.PrepareOutput([]byte("started"))
+	handler := MessageHandler{}
+	err := handler.start()
+	c.Assert(err, IsNil)
+	defer handler.stop()
+	a := app.App{
+		Name: "nemesis",
+		Units: []app.Unit{
+			{
+				Name:    "i-00800",
+				State:   "started",
+				Machine: 19,
+			},
+		},
+		State: string(provision.StatusStarted),
+	}
+	err = db.Session.Apps().Insert(a)
+	c.Assert(err, IsNil)
+	defer db.Session.Apps().Remove(bson.M{"name": a.Name})
+	lock, err := app.AcquireLock(a.Name, "someone@tsuru.io", "deploy")
+	c.Assert(err, IsNil)
+	messages, _, err := queue.Dial(handler.server.Addr())
+	c.Assert(err, IsNil)
+	messages <- queue.Message{Action: app.StartApp, Args: []string{a.Name}}
+	time.Sleep(5e8)
+	cmds := s.provisioner.GetCmds("/var/lib/tsuru/hooks/restart", &a)
+	c.Assert(cmds, HasLen, 0)
+	lock.Release()
+	time.Sleep(2e9)
+	cmds = s.provisioner.GetCmds("/var/lib/tsuru/hooks/restart", &a)
+	c.Assert(cmds, HasLen, 1)
+	_, err = app.GetLock(a.Name)
+	c.Assert(err, Equals, mgo.ErrNotFound)
+}
+
 func (s *S) TestUnitListStarted(c *C) {
 	var tests = []struct {
 		input    []app.Unit
@@ -6,8 +6,8 @@ package main
 
 import (
 	"github.com/globocom/config"
-	"github.com/globocom/tsuru/app"
 	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/provision"
 	ttesting "github.com/globocom/tsuru/testing"
 	"labix.org/v2/mgo"
 	. "launchpad.net/gocheck"
@@ -30,7 +30,7 @@ func (s *S) SetUpSuite(c *C) {
 	db.Session, err = db.Open("127.0.0.1:27017", "tsuru_collector_test")
 	c.Assert(err, IsNil)
 	s.provisioner = ttesting.NewFakeProvisioner()
-	app.Provisioner = s.provisioner
+	provision.Register("fake", s.provisioner)
 	err = config.ReadConfigFile("../etc/tsuru.conf")
 	c.Assert(err, IsNil)
 	config.Set("queue-server", "127.0.0.1:0")
@@ -44,5 +44,7 @@ func (s *S) TearDownSuite(c *C) {
 func (s *S) TearDownTest(c *C) {
 	_, err := db.Session.Apps().RemoveAll(nil)
 	c.Assert(err, IsNil)
+	_, err = db.Session.Logs().RemoveAll(nil)
+	c.Assert(err, IsNil)
 	s.provisioner.Reset()
 }
@@ -81,6 +81,49 @@ func (s *Storage) Apps() *mgo.Collection {
 	return c
 }
 
+// Deploys returns the deploys collection from MongoDB.
+func (s *Storage) Deploys() *mgo.Collection {
+	appIndex := mgo.Index{Key: []string{"app"}}
+	c := s.getCollection("deploys")
+	c.EnsureIndex(appIndex)
+	return c
+}
+
+// Drains returns the drains collection from MongoDB.
+func (s *Storage) Drains() *mgo.Collection {
+	drainIndex := mgo.Index{Key: []string{"appname", "url"}, Unique: true}
+	c := s.getCollection("drains")
+	c.EnsureIndex(drainIndex)
+	return c
+}
+
+// EnvRevisions returns the env_revisions collection from MongoDB.
+func (s *Storage) EnvRevisions() *mgo.Collection {
+	revisionIndex := mgo.Index{Key: []string{"app", "revision"}, Unique: true}
+	c := s.getCollection("env_revisions")
+	c.EnsureIndex(revisionIndex)
+	return c
+}
+
+// Locks returns the locks collection from MongoDB.
+func (s *Storage) Locks() *mgo.Collection {
+	return s.getCollection("locks")
+}
+
+// Logs returns the logs collection from MongoDB.
+//
+// Entries are indexed by app and date, and by app, source and date. Old
+// entries are removed by the collector, according to the retention policies
+// of the apps.
+func (s *Storage) Logs() *mgo.Collection {
+	appIndex := mgo.Index{Key: []string{"appname", "date"}}
+	sourceIndex := mgo.Index{Key: []string{"appname", "source", "date"}}
+	c := s.getCollection("logs")
+	c.EnsureIndex(appIndex)
+	c.EnsureIndex(sourceIndex)
+	return c
+}
+
 // Services returns the services collection from MongoDB.
 func (s *Storage) Services() *mgo.Collection {
 	c := s.getCollection("services")
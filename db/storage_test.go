@@ -106,6 +106,63 @@ func (s *S) TestMethodAppsShouldReturnAppsCollectionWithUniqueIndexForName(c *C)
 	c.Assert(apps, HasUniqueIndex, []string{"name"})
 }
 
+func (s *S) TestMethodDeploysShouldReturnDeploysCollection(c *C) {
+	deploys := s.storage.Deploys()
+	deploysc := s.storage.getCollection("deploys")
+	c.Assert(deploys, DeepEquals, deploysc)
+}
+
+func (s *S) TestMethodDrainsShouldReturnDrainsCollection(c *C) {
+	drains := s.storage.Drains()
+	drainsc := s.storage.getCollection("drains")
+	c.Assert(drains, DeepEquals, drainsc)
+}
+
+func (s *S) TestMethodDrainsShouldReturnDrainsCollectionWithUniqueIndexForAppAndUrl(c *C) {
+	drains := s.storage.Drains()
+	c.Assert(drains, HasUniqueIndex, []string{"appname", "url"})
+}
+
+func (s *S) TestMethodEnvRevisionsShouldReturnEnvRevisionsCollection(c *C) {
+	revisions := s.storage.EnvRevisions()
+	revisionsc := s.storage.getCollection("env_revisions")
+	c.Assert(revisions, DeepEquals, revisionsc)
+}
+
+func (s *S) TestMethodEnvRevisionsShouldReturnEnvRevisionsCollectionWithUniqueIndexForAppAndRevision(c *C) {
+	revisions := s.storage.EnvRevisions()
+	c.Assert(revisions, HasUniqueIndex, []string{"app", "revision"})
+}
+
+func (s *S) TestMethodLocksShouldReturnLocksCollection(c *C) {
+	locks := s.storage.Locks()
+	locksc := s.storage.getCollection("locks")
+	c.Assert(locks, DeepEquals, locksc)
+}
+
+func (s *S) TestMethodLogsShouldReturnLogsCollection(c *C) {
+	logs := s.storage.Logs()
+	logsc := s.storage.getCollection("logs")
+	c.Assert(logs, DeepEquals, logsc)
+}
+
+func (s *S) TestMethodLogsShouldReturnLogsCollectionWithIndexes(c *C) {
+	logs := s.storage.Logs()
+	indexes, err := logs.Indexes()
+	c.Assert(err, IsNil)
+	var appIndex, sourceIndex bool
+	for _, index := range indexes {
+		if reflect.DeepEqual(index.Key, []string{"appname", "date"}) {
+			appIndex = true
+		}
+		if reflect.DeepEqual(index.Key, []string{"appname", "source", "date"}) {
+			sourceIndex = true
+		}
+	}
+	c.Assert(appIndex, Equals, true)
+	c.Assert(sourceIndex, Equals, true)
+}
+
 func (s *S) TestMethodServicesShouldReturnServicesCollection(c *C) {
 	services := s.storage.Services()
 	servicesc := s.storage.getCollection("services")
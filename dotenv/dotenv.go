@@ -0,0 +1,230 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package dotenv reads and writes environment variables in the dotenv format,
+// used by .env files:
+//
+//     # comments and blank lines are ignored
+//     DATABASE_HOST=localhost
+//     export DATABASE_USER=root
+//     GREETING="Hello, \"world\"!\nSecond line."
+//     MOTD='single quoted values are
+//     taken literally, and may span lines'
+//
+// Unquoted values are trimmed, and may be followed by a comment. Double quoted
+// values support the escape sequences \n, \r, \t, \", \$ and \\, and may span
+// lines. Variables are not expanded.
+package dotenv
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Var is an environment variable.
+type Var struct {
+	Name  string
+	Value string
+}
+
+// SyntaxError is returned by Parse when the content is not valid.
+type SyntaxError struct {
+	Line    int
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+var nameRegexp = regexp.MustCompile(`^[^\s=#'"]+$`)
+
+// Parse parses the given dotenv content, returning the variables in the order
+// they're declared.
+func Parse(r io.Reader) ([]Var, error) {
+	content, err := readAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var vars []Var
+	p := parser{content: content, line: 1}
+	for {
+		p.skipBlank()
+		if p.eof() {
+			break
+		}
+		v, err := p.parseVar()
+		if err != nil {
+			return nil, err
+		}
+		vars = append(vars, v)
+	}
+	return vars, nil
+}
+
+func readAll(r io.Reader) (string, error) {
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r); err != nil {
+		return "", err
+	}
+	return strings.Replace(buf.String(), "\r\n", "\n", -1), nil
+}
+
+type parser struct {
+	content string
+	pos     int
+	line    int
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.content)
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{Line: p.line, Message: fmt.Sprintf(format, args...)}
+}
+
+// skipBlank skips blank lines, comments and leading spaces.
+func (p *parser) skipBlank() {
+	for !p.eof() {
+		switch p.content[p.pos] {
+		case '\n':
+			p.line++
+			p.pos++
+		case ' ', '\t':
+			p.pos++
+		case '#':
+			p.skipLine()
+		default:
+			return
+		}
+	}
+}
+
+func (p *parser) skipLine() {
+	for !p.eof() && p.content[p.pos] != '\n' {
+		p.pos++
+	}
+}
+
+func (p *parser) parseVar() (Var, error) {
+	rest := p.content[p.pos:]
+	if strings.HasPrefix(rest, "export ") || strings.HasPrefix(rest, "export\t") {
+		p.pos += len("export")
+		for !p.eof() && (p.content[p.pos] == ' ' || p.content[p.pos] == '\t') {
+			p.pos++
+		}
+	}
+	end := strings.IndexAny(p.content[p.pos:], "=\n")
+	if end < 0 || p.content[p.pos+end] != '=' {
+		return Var{}, p.errorf("expected NAME=value")
+	}
+	name := strings.TrimSpace(p.content[p.pos : p.pos+end])
+	if !nameRegexp.MatchString(name) {
+		return Var{}, p.errorf("invalid variable name: %q", name)
+	}
+	p.pos += end + 1
+	for !p.eof() && (p.content[p.pos] == ' ' || p.content[p.pos] == '\t') {
+		p.pos++
+	}
+	var (
+		value string
+		err   error
+	)
+	if !p.eof() && (p.content[p.pos] == '"' || p.content[p.pos] == '\'') {
+		value, err = p.parseQuoted(p.content[p.pos])
+		if err != nil {
+			return Var{}, err
+		}
+		p.skipTrailing()
+		if !p.eof() && p.content[p.pos] != '\n' {
+			return Var{}, p.errorf("unexpected characters after the value of %s", name)
+		}
+	} else {
+		value = p.parseUnquoted()
+	}
+	return Var{Name: name, Value: value}, nil
+}
+
+func (p *parser) parseUnquoted() string {
+	start := p.pos
+	p.skipLine()
+	value := p.content[start:p.pos]
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = value[:i]
+	}
+	if i := strings.Index(value, "\t#"); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(value)
+}
+
+// skipTrailing skips spaces and comments after a quoted value.
+func (p *parser) skipTrailing() {
+	for !p.eof() && (p.content[p.pos] == ' ' || p.content[p.pos] == '\t') {
+		p.pos++
+	}
+	if !p.eof() && p.content[p.pos] == '#' {
+		p.skipLine()
+	}
+}
+
+var escapes = map[byte]byte{'n': '\n', 'r': '\r', 't': '\t', '"': '"', '$': '$', '\\': '\\'}
+
+func (p *parser) parseQuoted(quote byte) (string, error) {
+	start := p.line
+	p.pos++
+	var buf bytes.Buffer
+	for !p.eof() {
+		c := p.content[p.pos]
+		p.pos++
+		switch {
+		case c == quote:
+			return buf.String(), nil
+		case c == '\\' && quote == '"' && !p.eof():
+			next := p.content[p.pos]
+			if e, ok := escapes[next]; ok {
+				buf.WriteByte(e)
+				p.pos++
+				continue
+			}
+			buf.WriteByte(c)
+		case c == '\n':
+			p.line++
+			buf.WriteByte(c)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	p.line = start
+	return "", p.errorf("unterminated quoted value")
+}
+
+var safeValueRegexp = regexp.MustCompile(`^[a-zA-Z0-9_./:@%+,=-]*$`)
+
+// Quote returns the value in dotenv syntax: values containing only safe
+// characters are left as they are, other values are double quoted and
+// escaped, so that multiline values fit in a single line.
+func Quote(value string) string {
+	if safeValueRegexp.MatchString(value) {
+		return value
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return `"` + r.Replace(value) + `"`
+}
+
+// Write writes the given variables to w, one per line, in dotenv syntax.
+func Write(w io.Writer, vars []Var) error {
+	bw := bufio.NewWriter(w)
+	for _, v := range vars {
+		if _, err := fmt.Fprintf(bw, "%s=%s\n", v.Name, Quote(v.Value)); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,113 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dotenv
+
+import (
+	"bytes"
+	. "launchpad.net/gocheck"
+	"strings"
+	"testing"
+)
+
+type S struct{}
+
+var _ = Suite(&S{})
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+func (s *S) TestParse(c *C) {
+	content := `# database settings
+DATABASE_HOST=localhost
+export DATABASE_USER = root # the user
+DATABASE_URL=mysql://root@localhost/app?a=b#frag
+
+EMPTY=
+GREETING="Hello, \"world\"!\nSecond line. \$HOME \\ \q"
+MOTD='single quoted values are
+taken literally: \n $HOME "'
+MULTILINE="first
+second"   # comment
+lower_case=1
+`
+	vars, err := Parse(strings.NewReader(content))
+	c.Assert(err, IsNil)
+	expected := []Var{
+		{Name: "DATABASE_HOST", Value: "localhost"},
+		{Name: "DATABASE_USER", Value: "root"},
+		{Name: "DATABASE_URL", Value: "mysql://root@localhost/app?a=b#frag"},
+		{Name: "EMPTY", Value: ""},
+		{Name: "GREETING", Value: "Hello, \"world\"!\nSecond line. $HOME \\ \\q"},
+		{Name: "MOTD", Value: "single quoted values are\ntaken literally: \\n $HOME \""},
+		{Name: "MULTILINE", Value: "first\nsecond"},
+		{Name: "lower_case", Value: "1"},
+	}
+	c.Assert(vars, DeepEquals, expected)
+}
+
+func (s *S) TestParseWindowsLineEndings(c *C) {
+	vars, err := Parse(strings.NewReader("A=1\r\nB=\"2\"\r\n"))
+	c.Assert(err, IsNil)
+	c.Assert(vars, DeepEquals, []Var{{Name: "A", Value: "1"}, {Name: "B", Value: "2"}})
+}
+
+func (s *S) TestParseEmpty(c *C) {
+	vars, err := Parse(strings.NewReader("\n# nothing here\n"))
+	c.Assert(err, IsNil)
+	c.Assert(vars, HasLen, 0)
+}
+
+func (s *S) TestParseErrors(c *C) {
+	var tests = []struct {
+		content string
+		message string
+	}{
+		{"A=1\nJUST_A_NAME\n", "line 2: expected NAME=value"},
+		{"A=1\n=1\n", `line 2: invalid variable name: ""`},
+		{"A B=1", `line 1: invalid variable name: "A B"`},
+		{"A=1\nB=\"unterminated\nC=3\n", "line 2: unterminated quoted value"},
+		{"A='one' two", "line 1: unexpected characters after the value of A"},
+	}
+	for _, t := range tests {
+		_, err := Parse(strings.NewReader(t.content))
+		c.Check(err, ErrorMatches, t.message)
+		_, ok := err.(*SyntaxError)
+		c.Check(ok, Equals, true)
+	}
+}
+
+func (s *S) TestQuote(c *C) {
+	var tests = []struct {
+		value    string
+		expected string
+	}{
+		{"localhost", "localhost"},
+		{"mysql://root@localhost:3306/app", "mysql://root@localhost:3306/app"},
+		{"", ""},
+		{"two words", `"two words"`},
+		{"first\nsecond", `"first\nsecond"`},
+		{`say "hi" to $USER \o/`, `"say \"hi\" to \$USER \\o/"`},
+	}
+	for _, t := range tests {
+		c.Check(Quote(t.value), Equals, t.expected)
+	}
+}
+
+func (s *S) TestWriteAndParse(c *C) {
+	vars := []Var{
+		{Name: "DATABASE_HOST", Value: "localhost"},
+		{Name: "MULTILINE", Value: "first line\n\tsecond line\r\n"},
+		{Name: "QUOTES", Value: `'single' "double" \back $dollar #hash`},
+		{Name: "EMPTY", Value: ""},
+	}
+	var buf bytes.Buffer
+	err := Write(&buf, vars)
+	c.Assert(err, IsNil)
+	c.Assert(strings.Count(buf.String(), "\n"), Equals, len(vars))
+	parsed, err := Parse(&buf)
+	c.Assert(err, IsNil)
+	c.Assert(parsed, DeepEquals, vars)
+}
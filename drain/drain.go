@@ -0,0 +1,255 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package drain forwards log entries of apps to external endpoints, called
+// drains.
+//
+// Drains are identified by URLs, and the following schemes are supported:
+//
+//     syslog://host:port        syslog messages (RFC 5424) sent over TCP
+//     syslog+udp://host:port    syslog messages (RFC 5424) sent over UDP
+//     http://host/path          batches of entries, sent as JSON arrays with
+//     https://host/path         HTTP POST requests
+//
+// Entries are delivered in background, so Forward never blocks. Each drain has
+// its own buffer, whose size is defined by the log:drains:buffer-size setting
+// (1000 entries by default). Entries are dropped when the buffer is full.
+// Failed deliveries are retried up to log:drains:max-retries times (5 by
+// default), waiting longer after each attempt, before the entries are dropped.
+package drain
+
+import (
+	"fmt"
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBufferSize = 1000
+	defaultMaxRetries = 5
+
+	// batchSize is the maximum number of entries delivered at once.
+	batchSize = 100
+)
+
+// retryInterval is the time waited before retrying a failed delivery for the
+// first time. It doubles after each attempt.
+var retryInterval = time.Second
+
+var (
+	forwarders = make(map[string]*forwarder)
+	mut        sync.Mutex
+)
+
+// Entry is a log entry of an app.
+type Entry struct {
+	Date    time.Time
+	Message string
+	Source  string
+	AppName string
+	Unit    string
+}
+
+// sender delivers entries to a drain.
+type sender interface {
+	send(entries []Entry) error
+	close() error
+}
+
+// Validate returns an error if the given URL is not a valid drain URL.
+//
+// Drains can't point to loopback, link-local or unspecified addresses, which
+// would let apps reach services of tsuru hosts, like the metadata service of
+// cloud providers (169.254.169.254). Host names are resolved, and rejected if
+// any of their addresses is one of these.
+func Validate(rawurl string) error {
+	u, err := parse(rawurl)
+	if err != nil {
+		return err
+	}
+	host := u.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		ips, _ = net.LookupIP(host)
+	}
+	for _, ip := range ips {
+		if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+			return fmt.Errorf("Invalid drain URL %q: drains can't point to local addresses.", rawurl)
+		}
+	}
+	return nil
+}
+
+func parse(rawurl string) (*url.URL, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid drain URL %q.", rawurl)
+	}
+	switch u.Scheme {
+	case "syslog", "syslog+udp":
+		if _, _, err := net.SplitHostPort(u.Host); err != nil {
+			return nil, fmt.Errorf("Invalid drain URL %q: syslog drains must have a host and a port.", rawurl)
+		}
+	case "http", "https":
+		if u.Host == "" {
+			return nil, fmt.Errorf("Invalid drain URL %q: missing host.", rawurl)
+		}
+	default:
+		return nil, fmt.Errorf("Invalid drain URL %q: the scheme must be syslog, syslog+udp, http or https.", rawurl)
+	}
+	return u, nil
+}
+
+func dial(u *url.URL) (sender, error) {
+	switch u.Scheme {
+	case "syslog":
+		return dialSyslog("tcp", u.Host)
+	case "syslog+udp":
+		return dialSyslog("udp", u.Host)
+	}
+	return &httpSender{url: u.String()}, nil
+}
+
+// Forward delivers the given entries to the given drains, in background.
+// Invalid URLs are ignored.
+func Forward(urls []string, entries []Entry) {
+	mut.Lock()
+	defer mut.Unlock()
+	for _, rawurl := range urls {
+		f, ok := forwarders[rawurl]
+		if !ok {
+			u, err := parse(rawurl)
+			if err != nil {
+				continue
+			}
+			f = newForwarder(u)
+			forwarders[rawurl] = f
+			go f.run()
+		}
+		for _, e := range entries {
+			f.enqueue(e)
+		}
+	}
+}
+
+// Stop stops forwarding entries to the given drain. Entries already buffered
+// are still delivered.
+func Stop(rawurl string) {
+	mut.Lock()
+	defer mut.Unlock()
+	if f, ok := forwarders[rawurl]; ok {
+		delete(forwarders, rawurl)
+		close(f.entries)
+	}
+}
+
+// forwarder buffers the entries sent to a drain, and delivers them in
+// background.
+type forwarder struct {
+	url        *url.URL
+	entries    chan Entry
+	maxRetries int
+	sender     sender
+
+	// dropped is the number of entries dropped because the buffer was full,
+	// since the last delivery.
+	dropped    int
+	droppedMut sync.Mutex
+}
+
+func newForwarder(u *url.URL) *forwarder {
+	size, err := config.GetInt("log:drains:buffer-size")
+	if err != nil || size < 1 {
+		size = defaultBufferSize
+	}
+	retries, err := config.GetInt("log:drains:max-retries")
+	if err != nil || retries < 0 {
+		retries = defaultMaxRetries
+	}
+	return &forwarder{url: u, entries: make(chan Entry, size), maxRetries: retries}
+}
+
+// name identifies the drain in tsuru logs, without the credentials that may
+// be part of its URL.
+func (f *forwarder) name() string {
+	return f.url.Scheme + "://" + f.url.Host
+}
+
+// enqueue adds the entry to the buffer, or drops it if the buffer is full.
+func (f *forwarder) enqueue(e Entry) {
+	select {
+	case f.entries <- e:
+	default:
+		f.droppedMut.Lock()
+		f.dropped++
+		f.droppedMut.Unlock()
+	}
+}
+
+// run delivers the buffered entries until the forwarder is stopped.
+func (f *forwarder) run() {
+	for e := range f.entries {
+		f.deliver(f.collect(e))
+	}
+	if f.sender != nil {
+		f.sender.close()
+	}
+}
+
+// collect returns a batch with the given entry, followed by the entries
+// already buffered, up to batchSize entries.
+func (f *forwarder) collect(first Entry) []Entry {
+	batch := []Entry{first}
+	for len(batch) < batchSize {
+		select {
+		case e, ok := <-f.entries:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, e)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+func (f *forwarder) deliver(entries []Entry) {
+	f.droppedMut.Lock()
+	dropped := f.dropped
+	f.dropped = 0
+	f.droppedMut.Unlock()
+	if dropped > 0 {
+		log.Warn("The buffer of the drain is full, log entries were dropped.", "drain", f.name(), "dropped", dropped)
+	}
+	var err error
+	interval := retryInterval
+	for i := 0; i <= f.maxRetries; i++ {
+		if i > 0 {
+			time.Sleep(interval)
+			interval *= 2
+		}
+		if f.sender == nil {
+			if f.sender, err = dial(f.url); err != nil {
+				f.sender = nil
+				continue
+			}
+		}
+		if err = f.sender.send(entries); err == nil {
+			return
+		}
+		f.sender.close()
+		f.sender = nil
+	}
+	log.Error("Failed to deliver log entries.", "drain", f.name(), "entries", len(entries), "error", err)
+}
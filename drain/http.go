@@ -0,0 +1,60 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package drain
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// httpTimeout is the timeout for delivering entries to HTTP drains, from
+// connecting to them to reading the response.
+var httpTimeout = 10 * time.Second
+
+// httpClient opens a connection for each request, so the deadline set when
+// connecting applies to a single request.
+var httpClient = &http.Client{
+	Transport: &http.Transport{Dial: dialHTTP, DisableKeepAlives: true},
+}
+
+func dialHTTP(network, addr string) (net.Conn, error) {
+	conn, err := net.DialTimeout(network, addr, httpTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(httpTimeout))
+	return conn, nil
+}
+
+// httpSender sends entries to an HTTP endpoint, as a JSON array in the body
+// of a POST request. Any response with a status other than 2xx is considered
+// a failure.
+type httpSender struct {
+	url string
+}
+
+func (s *httpSender) send(entries []Entry) error {
+	body, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	response, err := httpClient.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("the drain responded with status %d", response.StatusCode)
+	}
+	return nil
+}
+
+func (s *httpSender) close() error {
+	return nil
+}
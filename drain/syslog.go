@@ -0,0 +1,91 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package drain
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// syslogPriority is the priority of the messages sent to syslog drains:
+// facility user (1) and severity informational (6).
+const syslogPriority = 1*8 + 6
+
+// syslogTimeout is the timeout for connecting to syslog drains and writing to
+// them.
+const syslogTimeout = 10 * time.Second
+
+// syslogSender sends entries to a syslog server, as described in RFC 5424.
+//
+// Over TCP, messages are framed with octet counting (RFC 6587). Over UDP,
+// each message is sent in its own datagram (RFC 5426).
+type syslogSender struct {
+	network  string
+	conn     net.Conn
+	hostname string
+}
+
+func dialSyslog(network, addr string) (*syslogSender, error) {
+	conn, err := net.DialTimeout(network, addr, syslogTimeout)
+	if err != nil {
+		return nil, err
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = ""
+	}
+	return &syslogSender{network: network, conn: conn, hostname: hostname}, nil
+}
+
+func (s *syslogSender) send(entries []Entry) error {
+	s.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+	if s.network == "udp" {
+		for _, e := range entries {
+			if _, err := s.conn.Write(formatSyslog(e, s.hostname)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, e := range entries {
+		msg := formatSyslog(e, s.hostname)
+		fmt.Fprintf(&buf, "%d ", len(msg))
+		buf.Write(msg)
+	}
+	_, err := s.conn.Write(buf.Bytes())
+	return err
+}
+
+func (s *syslogSender) close() error {
+	return s.conn.Close()
+}
+
+// formatSyslog formats the entry as a syslog message. The app name is used as
+// APP-NAME, and the source of the entry as PROCID.
+func formatSyslog(e Entry, hostname string) []byte {
+	date := e.Date.UTC().Format("2006-01-02T15:04:05.000000Z07:00")
+	msg := fmt.Sprintf("<%d>1 %s %s %s %s - - %s", syslogPriority, date,
+		syslogField(hostname, 255), syslogField(e.AppName, 48), syslogField(e.Source, 128), e.Message)
+	return []byte(msg)
+}
+
+// syslogField returns the given value as a syslog header field: printable
+// ASCII characters, up to max characters, with "-" standing for empty values.
+func syslogField(value string, max int) string {
+	field := make([]byte, 0, len(value))
+	for i := 0; i < len(value) && len(field) < max; i++ {
+		if c := value[i]; c > 32 && c < 127 {
+			field = append(field, c)
+		}
+	}
+	if len(field) == 0 {
+		return "-"
+	}
+	return string(field)
+}
@@ -0,0 +1,80 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package drain
+
+import (
+	"io"
+	. "launchpad.net/gocheck"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+func (s *S) TestFormatSyslog(c *C) {
+	date := time.Date(2012, time.June, 20, 11, 17, 22, 750000000, time.FixedZone("BRT", -3*3600))
+	e := Entry{Date: date, Message: "listening on port 8888", Source: "app", AppName: "myapp"}
+	msg := formatSyslog(e, "tsuru.example.com")
+	c.Assert(string(msg), Equals, "<14>1 2012-06-20T14:17:22.750000Z tsuru.example.com myapp app - - listening on port 8888")
+}
+
+func (s *S) TestFormatSyslogEmptyFields(c *C) {
+	date := time.Date(2012, time.June, 20, 14, 17, 22, 0, time.UTC)
+	msg := formatSyslog(Entry{Date: date, Message: "hello"}, "")
+	c.Assert(string(msg), Equals, "<14>1 2012-06-20T14:17:22.000000Z - - - - - hello")
+}
+
+func (s *S) TestSyslogField(c *C) {
+	c.Assert(syslogField("my app", 48), Equals, "myapp")
+	c.Assert(syslogField("abcdef", 3), Equals, "abc")
+	c.Assert(syslogField(" ", 3), Equals, "-")
+}
+
+func (s *S) TestForwardSyslogTCP(c *C) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer listener.Close()
+	url := "syslog://" + listener.Addr().String()
+	defer Stop(url)
+	date := time.Date(2012, time.June, 20, 14, 17, 22, 0, time.UTC)
+	Forward([]string{url}, []Entry{
+		{Date: date, Message: "hello", Source: "app", AppName: "myapp"},
+		{Date: date, Message: "world", Source: "app", AppName: "myapp"},
+	})
+	conn, err := listener.Accept()
+	c.Assert(err, IsNil)
+	defer conn.Close()
+	hostname, _ := os.Hostname()
+	first := "<14>1 2012-06-20T14:17:22.000000Z " + syslogField(hostname, 255) + " myapp app - - hello"
+	second := "<14>1 2012-06-20T14:17:22.000000Z " + syslogField(hostname, 255) + " myapp app - - world"
+	expected := strconv.Itoa(len(first)) + " " + first + strconv.Itoa(len(second)) + " " + second
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, len(expected))
+	_, err = io.ReadFull(conn, buf)
+	c.Assert(err, IsNil)
+	c.Assert(string(buf), Equals, expected)
+}
+
+func (s *S) TestForwardSyslogUDP(c *C) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer conn.Close()
+	url := "syslog+udp://" + conn.LocalAddr().String()
+	defer Stop(url)
+	date := time.Date(2012, time.June, 20, 14, 17, 22, 0, time.UTC)
+	Forward([]string{url}, []Entry{
+		{Date: date, Message: "hello", Source: "app", AppName: "myapp"},
+		{Date: date, Message: "world", Source: "app", AppName: "myapp"},
+	})
+	hostname, _ := os.Hostname()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	for _, message := range []string{"hello", "world"} {
+		n, _, err := conn.ReadFrom(buf)
+		c.Assert(err, IsNil)
+		expected := "<14>1 2012-06-20T14:17:22.000000Z " + syslogField(hostname, 255) + " myapp app - - " + message
+		c.Assert(string(buf[:n]), Equals, expected)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"github.com/globocom/config"
+	"os"
+)
+
+// ReadConfig configures DefaultTarget from the log section of tsuru.conf:
+//
+//     log:
+//       level: warn
+//       format: json
+//       targets:
+//         - syslog
+//         - stderr
+//         - /var/log/tsuru/api.log
+//
+// The level is the minimum level of the entries written (debug, info, warn or
+// error), and the format is either text or json. Targets are syslog, stderr,
+// or paths of files, to which entries are appended. All settings are
+// optional: by default, entries of level info and above are sent to syslog,
+// as text.
+//
+// DefaultTarget is left unchanged when the settings are invalid.
+func ReadConfig() error {
+	level := InfoLevel
+	if name, err := config.GetString("log:level"); err == nil {
+		if level, err = ParseLevel(name); err != nil {
+			return err
+		}
+	}
+	format := TextFormat
+	if name, err := config.GetString("log:format"); err == nil {
+		if format, err = ParseFormat(name); err != nil {
+			return err
+		}
+	}
+	targets, err := config.GetList("log:targets")
+	if err != nil || len(targets) == 0 {
+		targets = []string{"syslog"}
+	}
+	var writers []Writer
+	for _, target := range targets {
+		w, err := newWriter(target, format)
+		if err != nil {
+			closeWriters(writers)
+			return err
+		}
+		writers = append(writers, w)
+	}
+	DefaultTarget.SetLevel(level)
+	DefaultTarget.SetWriters(writers...)
+	return nil
+}
+
+func newWriter(target string, format Format) (Writer, error) {
+	switch target {
+	case "syslog":
+		return NewSyslogWriter("", format)
+	case "stderr":
+		return NewStreamWriter(os.Stderr, format), nil
+	}
+	return NewFileWriter(target, format)
+}
@@ -0,0 +1,67 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"github.com/globocom/config"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+	"path"
+)
+
+func (s *S) TestReadConfig(c *C) {
+	dir, err := ioutil.TempDir("", "log")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	first, second := path.Join(dir, "api.log"), path.Join(dir, "tsuru.log")
+	config.Set("log:level", "warn")
+	config.Set("log:format", "json")
+	config.Set("log:targets", []interface{}{first, second})
+	defer config.Unset("log:level")
+	defer config.Unset("log:format")
+	defer config.Unset("log:targets")
+	defer DefaultTarget.SetLevel(InfoLevel)
+	defer SetLogger(nil)
+	err = ReadConfig()
+	c.Assert(err, IsNil)
+	c.Assert(DefaultTarget.level, Equals, WarnLevel)
+	c.Assert(DefaultTarget.writers, HasLen, 2)
+	Info("ignored")
+	Warn("written")
+	for _, p := range []string{first, second} {
+		content, err := ioutil.ReadFile(p)
+		c.Assert(err, IsNil)
+		c.Assert(string(content), Matches, `\{[^\n]*"level":"warn","message":"written"\}\n`)
+	}
+}
+
+func (s *S) TestReadConfigStderr(c *C) {
+	config.Set("log:targets", []interface{}{"stderr"})
+	defer config.Unset("log:targets")
+	defer SetLogger(nil)
+	err := ReadConfig()
+	c.Assert(err, IsNil)
+	c.Assert(DefaultTarget.writers, DeepEquals, []Writer{NewStreamWriter(os.Stderr, TextFormat)})
+}
+
+func (s *S) TestReadConfigInvalidLevel(c *C) {
+	config.Set("log:level", "critical")
+	defer config.Unset("log:level")
+	SetLogger(nil)
+	err := ReadConfig()
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, `Invalid log level "critical", it must be debug, info, warn or error.`)
+	c.Assert(DefaultTarget.writers, HasLen, 0)
+}
+
+func (s *S) TestReadConfigInvalidTarget(c *C) {
+	config.Set("log:targets", []interface{}{"stderr", "/nonexistent/dir/tsuru.log"})
+	defer config.Unset("log:targets")
+	SetLogger(nil)
+	err := ReadConfig()
+	c.Assert(err, NotNil)
+	c.Assert(DefaultTarget.writers, HasLen, 0)
+}
@@ -0,0 +1,105 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format defines how entries are written by writers.
+type Format int
+
+const (
+	// TextFormat writes entries as lines of text, with their fields as
+	// key=value pairs after the message:
+	//
+	//     2012-06-20T11:17:22-03:00 ERROR Failed to deliver log entries. drain=syslog://logs.example.com:514 error="connection refused"
+	TextFormat Format = iota
+
+	// JSONFormat writes entries as JSON objects, with the time, level,
+	// message and fields keys:
+	//
+	//     {"time":"2012-06-20T11:17:22-03:00","level":"error","message":"Failed to deliver log entries.","fields":{"drain":"syslog://logs.example.com:514","error":"connection refused"}}
+	JSONFormat
+)
+
+// ParseFormat returns the format with the given name (text or json),
+// ignoring case.
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "text":
+		return TextFormat, nil
+	case "json":
+		return JSONFormat, nil
+	}
+	return TextFormat, fmt.Errorf("Invalid log format %q, it must be text or json.", name)
+}
+
+// format returns the entry in the given format, without the trailing newline.
+// When header is false, the time and the level of the entry are omitted from
+// the text format, for destinations that record them on their own, like
+// syslog.
+func (f Format) format(e *Entry, header bool) []byte {
+	if f == JSONFormat {
+		return formatJSON(e)
+	}
+	var buf bytes.Buffer
+	if header {
+		buf.WriteString(e.Time.Format(time.RFC3339))
+		buf.WriteString(" ")
+		buf.WriteString(strings.ToUpper(e.Level.String()))
+		buf.WriteString(" ")
+	}
+	buf.WriteString(e.Message)
+	for _, field := range e.Fields {
+		buf.WriteString(" ")
+		buf.WriteString(field.Key)
+		buf.WriteString("=")
+		buf.WriteString(textValue(field.Value))
+	}
+	return buf.Bytes()
+}
+
+// textValue quotes values that would be ambiguous in the text format.
+func textValue(v interface{}) string {
+	s := fmt.Sprint(v)
+	if s == "" || strings.IndexAny(s, " \t\r\n\"=") > -1 {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+type jsonEntry struct {
+	Time    string                 `json:"time"`
+	Level   string                 `json:"level"`
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+func formatJSON(e *Entry) []byte {
+	entry := jsonEntry{
+		Time:    e.Time.Format(time.RFC3339Nano),
+		Level:   e.Level.String(),
+		Message: e.Message,
+	}
+	if len(e.Fields) > 0 {
+		entry.Fields = make(map[string]interface{}, len(e.Fields))
+		for _, field := range e.Fields {
+			entry.Fields[field.Key] = field.Value
+		}
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		// Field values are always strings, numbers or booleans (see
+		// redactValue), so this never happens in practice.
+		return []byte(strconv.Quote(e.Message))
+	}
+	return b
+}
@@ -0,0 +1,58 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	. "launchpad.net/gocheck"
+	"time"
+)
+
+func (s *S) TestFormatText(c *C) {
+	e := Entry{
+		Time:    time.Date(2012, 6, 20, 11, 17, 22, 0, time.UTC),
+		Level:   ErrorLevel,
+		Message: "Failed to deliver log entries.",
+		Fields:  []Field{{"drain", "syslog://logs.example.com:514"}, {"error", "connection refused"}, {"entries", 10}},
+	}
+	expected := `2012-06-20T11:17:22Z ERROR Failed to deliver log entries. drain=syslog://logs.example.com:514 error="connection refused" entries=10`
+	c.Assert(string(TextFormat.format(&e, true)), Equals, expected)
+	expected = `Failed to deliver log entries. drain=syslog://logs.example.com:514 error="connection refused" entries=10`
+	c.Assert(string(TextFormat.format(&e, false)), Equals, expected)
+}
+
+func (s *S) TestFormatTextQuotesValues(c *C) {
+	e := Entry{Level: InfoLevel, Message: "set", Fields: []Field{{"empty", ""}, {"equals", "a=b"}, {"quote", `say "hi"`}}}
+	c.Assert(string(TextFormat.format(&e, false)), Equals, `set empty="" equals="a=b" quote="say \"hi\""`)
+}
+
+func (s *S) TestFormatJSON(c *C) {
+	e := Entry{
+		Time:    time.Date(2012, 6, 20, 11, 17, 22, 0, time.UTC),
+		Level:   WarnLevel,
+		Message: "Unit down.",
+		Fields:  []Field{{"unit", "myapp/0"}, {"healing", true}},
+	}
+	expected := `{"time":"2012-06-20T11:17:22Z","level":"warn","message":"Unit down.","fields":{"healing":true,"unit":"myapp/0"}}`
+	c.Assert(string(JSONFormat.format(&e, true)), Equals, expected)
+	c.Assert(string(JSONFormat.format(&e, false)), Equals, expected)
+}
+
+func (s *S) TestFormatJSONWithoutFields(c *C) {
+	e := Entry{Time: time.Date(2012, 6, 20, 11, 17, 22, 0, time.UTC), Level: InfoLevel, Message: "Started."}
+	expected := `{"time":"2012-06-20T11:17:22Z","level":"info","message":"Started."}`
+	c.Assert(string(JSONFormat.format(&e, true)), Equals, expected)
+}
+
+func (s *S) TestParseFormat(c *C) {
+	format, err := ParseFormat("text")
+	c.Assert(err, IsNil)
+	c.Assert(format, Equals, TextFormat)
+	format, err = ParseFormat("JSON")
+	c.Assert(err, IsNil)
+	c.Assert(format, Equals, JSONFormat)
+	_, err = ParseFormat("xml")
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, `Invalid log format "xml", it must be text or json.`)
+}
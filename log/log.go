@@ -7,76 +7,249 @@
 // It abstracts the logger from the standard log package, allowing the
 // developer to patck the logging target, changing this to a file, or syslog,
 // for example.
+//
+// Entries have a level (debug, info, warn or error), and may carry fields,
+// given as key-value pairs:
+//
+//     log.Error("Failed to deliver log entries.", "drain", name, "error", err)
+//
+// A target writes entries to any number of writers (see Writer), and drops
+// entries below its minimum level. Print, Printf and the other functions
+// inherited from the standard log package write entries of level info.
+//
+// Messages and fields are redacted before being written to the target, see
+// Redact.
 package log
 
 import (
+	"fmt"
 	"log"
+	"os"
+	"regexp"
+	"strings"
 	"sync"
+	"time"
 )
 
+// Level is the severity of a log entry.
+type Level int
+
+const (
+	DebugLevel Level = iota - 1
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+var levelNames = map[Level]string{
+	DebugLevel: "debug",
+	InfoLevel:  "info",
+	WarnLevel:  "warn",
+	ErrorLevel: "error",
+}
+
+func (l Level) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+// ParseLevel returns the level with the given name (debug, info, warn or
+// error), ignoring case.
+func ParseLevel(name string) (Level, error) {
+	lower := strings.ToLower(name)
+	if lower == "warning" {
+		return WarnLevel, nil
+	}
+	for level, n := range levelNames {
+		if n == lower {
+			return level, nil
+		}
+	}
+	return InfoLevel, fmt.Errorf("Invalid log level %q, it must be debug, info, warn or error.", name)
+}
+
+// Field is a key-value pair attached to a log entry.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Entry is a message written to a target.
+type Entry struct {
+	Time    time.Time
+	Level   Level
+	Message string
+	Fields  []Field
+}
+
+var secretKey = regexp.MustCompile(`^` + secretName + `$`)
+
+// newEntry builds a redacted entry. kv holds the fields, as key-value pairs.
+func newEntry(level Level, message string, kv []interface{}) *Entry {
+	e := Entry{Time: time.Now(), Level: level, Message: Redact(message)}
+	for i := 0; i < len(kv); i += 2 {
+		f := Field{Key: fmt.Sprint(kv[i]), Value: "(MISSING)"}
+		if i+1 < len(kv) {
+			f.Value = redactValue(kv[i+1])
+		}
+		if secretKey.MatchString(f.Key) {
+			f.Value = Mask
+		}
+		e.Fields = append(e.Fields, f)
+	}
+	return &e
+}
+
+// redactValue keeps numbers and booleans as they are, and converts anything
+// else to a redacted string.
+func redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return v
+	case string:
+		return Redact(v)
+	case error:
+		return Redact(v.Error())
+	}
+	return Redact(fmt.Sprint(v))
+}
+
 // Target is the current target for the log package.
 type Target struct {
-	logger *log.Logger
-	mut    sync.RWMutex
+	writers []Writer
+	level   Level
+	mut     sync.RWMutex
 }
 
-// SetLogger defines a new logger for the current target.
+// SetLogger defines a new logger for the current target, replacing its
+// writers. Entries are written to the logger as text, prefixed by their level
+// unless it's info, so messages from Print and Printf are written unchanged.
 //
 // See the builtin log package for more details.
 func (t *Target) SetLogger(l *log.Logger) {
+	if l == nil {
+		t.SetWriters()
+	} else {
+		t.SetWriters(&loggerWriter{logger: l})
+	}
+}
+
+// SetWriters replaces the writers of the target. Previous writers that
+// implement io.Closer, like the ones returned by NewFileWriter, are closed.
+func (t *Target) SetWriters(writers ...Writer) {
 	t.mut.Lock()
 	defer t.mut.Unlock()
-	t.logger = l
+	closeWriters(t.writers)
+	t.writers = writers
 }
 
-// Fatal is equivalent to Print() followed by os.Exit(1).
-func (t *Target) Fatal(v ...interface{}) {
+// SetLevel defines the minimum level of the entries written by the target.
+// It's InfoLevel by default.
+func (t *Target) SetLevel(level Level) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	t.level = level
+}
+
+// Log writes an entry with the given level, message and fields to the
+// writers of the target, unless the level is below the minimum level of the
+// target. kv holds the fields, as key-value pairs.
+//
+// It returns whether the entry was written to any writer. Failures of each
+// writer are ignored, as there's nowhere else to report them.
+func (t *Target) Log(level Level, message string, kv ...interface{}) bool {
 	defer t.mut.RUnlock()
 	t.mut.RLock()
-	if t.logger != nil {
-		t.logger.Fatal(v...)
+	if level < t.level || len(t.writers) == 0 {
+		return false
+	}
+	e := newEntry(level, message, kv)
+	for _, w := range t.writers {
+		w.Write(e)
+	}
+	return true
+}
+
+// Debug writes an entry of level debug. See Log for details.
+func (t *Target) Debug(message string, kv ...interface{}) {
+	t.Log(DebugLevel, message, kv...)
+}
+
+// Info writes an entry of level info. See Log for details.
+func (t *Target) Info(message string, kv ...interface{}) {
+	t.Log(InfoLevel, message, kv...)
+}
+
+// Warn writes an entry of level warn. See Log for details.
+func (t *Target) Warn(message string, kv ...interface{}) {
+	t.Log(WarnLevel, message, kv...)
+}
+
+// Error writes an entry of level error. See Log for details.
+func (t *Target) Error(message string, kv ...interface{}) {
+	t.Log(ErrorLevel, message, kv...)
+}
+
+// Fatal is equivalent to Error() followed by os.Exit(1). It does nothing when
+// the target has no writers.
+func (t *Target) Fatal(v ...interface{}) {
+	if t.Log(ErrorLevel, fmt.Sprint(v...)) {
+		os.Exit(1)
 	}
 }
 
 // Print is similar to fmt.Print, writing the given values to the Target
-// logger.
+// logger, as an entry of level info.
 func (t *Target) Print(v ...interface{}) {
-	defer t.mut.RUnlock()
-	t.mut.RLock()
-	if t.logger != nil {
-		t.logger.Print(v...)
-	}
+	t.Log(InfoLevel, fmt.Sprint(v...))
 }
 
 // Printf is similar to fmt.Printf, writing the formatted string to the Target
-// logger.
+// logger, as an entry of level info.
 func (t *Target) Printf(format string, v ...interface{}) {
-	defer t.mut.RUnlock()
-	t.mut.RLock()
-	if t.logger != nil {
-		t.logger.Printf(format, v...)
-	}
+	t.Log(InfoLevel, fmt.Sprintf(format, v...))
 }
 
-// Panic is equivalent to Print() followed by panic().
+// Panic is equivalent to Error() followed by panic(). It does nothing when
+// the target has no writers.
 func (t *Target) Panic(v ...interface{}) {
-	defer t.mut.RUnlock()
-	t.mut.RLock()
-	if t.logger != nil {
-		t.logger.Panic(v...)
+	message := fmt.Sprint(v...)
+	if t.Log(ErrorLevel, message) {
+		panic(Redact(message))
 	}
 }
 
 func (t *Target) Panicf(format string, v ...interface{}) {
-	defer t.mut.RUnlock()
-	t.mut.RLock()
-	if t.logger != nil {
-		t.logger.Panicf(format, v...)
+	message := fmt.Sprintf(format, v...)
+	if t.Log(ErrorLevel, message) {
+		panic(Redact(message))
 	}
 }
 
 var DefaultTarget *Target = new(Target)
 
+// Debug is a wrapper for DefaultTarget.Debug.
+func Debug(message string, kv ...interface{}) {
+	DefaultTarget.Debug(message, kv...)
+}
+
+// Info is a wrapper for DefaultTarget.Info.
+func Info(message string, kv ...interface{}) {
+	DefaultTarget.Info(message, kv...)
+}
+
+// Warn is a wrapper for DefaultTarget.Warn.
+func Warn(message string, kv ...interface{}) {
+	DefaultTarget.Warn(message, kv...)
+}
+
+// Error is a wrapper for DefaultTarget.Error.
+func Error(message string, kv ...interface{}) {
+	DefaultTarget.Error(message, kv...)
+}
+
 // Fatal is a wrapper for DefaultTarget.Fatal.
 func Fatal(v ...interface{}) {
 	DefaultTarget.Fatal(v...)
@@ -106,3 +279,13 @@ func Panicf(format string, v ...interface{}) {
 func SetLogger(logger *log.Logger) {
 	DefaultTarget.SetLogger(logger)
 }
+
+// SetWriters is a wrapper for DefaultTarget.SetWriters.
+func SetWriters(writers ...Writer) {
+	DefaultTarget.SetWriters(writers...)
+}
+
+// SetLevel is a wrapper for DefaultTarget.SetLevel.
+func SetLevel(level Level) {
+	DefaultTarget.SetLevel(level)
+}
@@ -0,0 +1,120 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"io"
+	"log"
+	"log/syslog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Writer writes log entries to a destination, like syslog or a file.
+type Writer interface {
+	Write(e *Entry) error
+}
+
+// streamWriter writes entries to an io.Writer, one per line.
+type streamWriter struct {
+	w      io.Writer
+	format Format
+	mut    sync.Mutex
+}
+
+// NewStreamWriter returns a Writer that writes entries to w, one per line, in
+// the given format. Use it with os.Stderr to log to the standard error.
+func NewStreamWriter(w io.Writer, format Format) Writer {
+	return &streamWriter{w: w, format: format}
+}
+
+func (w *streamWriter) Write(e *Entry) error {
+	line := append(w.format.format(e, true), '\n')
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	_, err := w.w.Write(line)
+	return err
+}
+
+// fileWriter is a streamWriter that owns the file it writes to.
+type fileWriter struct {
+	streamWriter
+	file *os.File
+}
+
+// NewFileWriter returns a Writer that appends entries to the file at the
+// given path, in the given format, creating it if it doesn't exist. The
+// returned Writer is also an io.Closer.
+func NewFileWriter(path string, format Format) (Writer, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileWriter{streamWriter: streamWriter{w: file, format: format}, file: file}, nil
+}
+
+func (w *fileWriter) Close() error {
+	return w.file.Close()
+}
+
+// syslogWriter writes entries to syslog, with the priority matching their
+// levels.
+type syslogWriter struct {
+	w      *syslog.Writer
+	format Format
+}
+
+// NewSyslogWriter returns a Writer that sends entries to the local syslog
+// daemon, in the given format, with the given tag. If tag is empty, the name
+// of the program is used. The returned Writer is also an io.Closer.
+func NewSyslogWriter(tag string, format Format) (Writer, error) {
+	w, err := syslog.New(syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogWriter{w: w, format: format}, nil
+}
+
+func (w *syslogWriter) Write(e *Entry) error {
+	message := string(w.format.format(e, false))
+	switch e.Level {
+	case DebugLevel:
+		return w.w.Debug(message)
+	case WarnLevel:
+		return w.w.Warning(message)
+	case ErrorLevel:
+		return w.w.Err(message)
+	}
+	return w.w.Info(message)
+}
+
+func (w *syslogWriter) Close() error {
+	return w.w.Close()
+}
+
+// loggerWriter writes entries to a logger from the standard log package (see
+// Target.SetLogger).
+type loggerWriter struct {
+	logger *log.Logger
+}
+
+func (w *loggerWriter) Write(e *Entry) error {
+	message := string(TextFormat.format(e, false))
+	if e.Level != InfoLevel {
+		message = strings.ToUpper(e.Level.String()) + " " + message
+	}
+	w.logger.Print(message)
+	return nil
+}
+
+// closeWriters closes the given writers that implement io.Closer.
+func closeWriters(writers []Writer) {
+	for _, w := range writers {
+		if c, ok := w.(io.Closer); ok {
+			c.Close()
+		}
+	}
+}
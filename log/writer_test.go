@@ -0,0 +1,60 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"io"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+	"path"
+	"time"
+)
+
+func (s *S) TestFileWriter(c *C) {
+	dir, err := ioutil.TempDir("", "log")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	p := path.Join(dir, "tsuru.log")
+	err = ioutil.WriteFile(p, []byte("previous\n"), 0644)
+	c.Assert(err, IsNil)
+	w, err := NewFileWriter(p, JSONFormat)
+	c.Assert(err, IsNil)
+	e := Entry{Time: time.Date(2012, 6, 20, 11, 17, 22, 0, time.UTC), Level: InfoLevel, Message: "Started."}
+	err = w.Write(&e)
+	c.Assert(err, IsNil)
+	err = w.(io.Closer).Close()
+	c.Assert(err, IsNil)
+	content, err := ioutil.ReadFile(p)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "previous\n"+`{"time":"2012-06-20T11:17:22Z","level":"info","message":"Started."}`+"\n")
+}
+
+func (s *S) TestFileWriterInvalidPath(c *C) {
+	_, err := NewFileWriter("/nonexistent/dir/tsuru.log", TextFormat)
+	c.Assert(err, NotNil)
+}
+
+type closingWriter struct {
+	closed bool
+}
+
+func (w *closingWriter) Write(e *Entry) error {
+	return nil
+}
+
+func (w *closingWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func (s *S) TestSetWritersClosesPreviousWriters(c *C) {
+	var w closingWriter
+	t := &Target{}
+	t.SetWriters(&w)
+	c.Assert(w.closed, Equals, false)
+	t.SetWriters()
+	c.Assert(w.closed, Equals, true)
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/globocom/tsuru/provision"
 	"github.com/globocom/tsuru/repository"
 	"io"
+	"io/ioutil"
 	"launchpad.net/goyaml"
 	"os/exec"
 	"regexp"
@@ -217,6 +218,40 @@ func (p *JujuProvisioner) ExecuteCommand(stdout, stderr io.Writer, app provision
 	return nil
 }
 
+// ExecuteCommandOnUnit runs the command in the machine of the given unit of
+// the app.
+func (p *JujuProvisioner) ExecuteCommandOnUnit(stdout, stderr io.Writer, app provision.App, unit string, cmd string, args ...string) error {
+	for _, u := range app.ProvisionUnits() {
+		if u.GetName() != unit {
+			continue
+		}
+		cmdargs := []string{"ssh", "-o", "StrictHostKeyChecking no", "-q", strconv.Itoa(u.GetMachine()), cmd}
+		cmdargs = append(cmdargs, args...)
+		return runCmd(true, stdout, stderr, cmdargs...)
+	}
+	return fmt.Errorf("App %q does not have a unit named %q.", app.GetName(), unit)
+}
+
+// Upload copies the content of r to the given path in the machine of each
+// unit of the app.
+func (p *JujuProvisioner) Upload(app provision.App, r io.Reader, path string) error {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	for _, unit := range app.ProvisionUnits() {
+		var buf bytes.Buffer
+		cmd := exec.Command("juju", "ssh", "-o", "StrictHostKeyChecking no", "-q", strconv.Itoa(unit.GetMachine()), "cat > "+path)
+		cmd.Stdin = bytes.NewReader(content)
+		cmd.Stdout = &Writer{&buf}
+		cmd.Stderr = &Writer{&buf}
+		if err := cmd.Run(); err != nil {
+			return &provision.Error{Reason: buf.String(), Err: err}
+		}
+	}
+	return nil
+}
+
 func (p *JujuProvisioner) CollectStatus() ([]provision.Unit, error) {
 	output, err := execWithTimeout(30e9, "juju", "status")
 	if err != nil {
@@ -12,6 +12,7 @@ import (
 	"github.com/globocom/tsuru/repository"
 	. "launchpad.net/gocheck"
 	"reflect"
+	"strings"
 	"time"
 )
 
@@ -300,6 +301,70 @@ func (s *S) TestExecuteCommandOneUnit(c *C) {
 	c.Assert(buf.String(), Equals, output+"\n")
 }
 
+func (s *S) TestExecuteCommandOnUnit(c *C) {
+	var buf bytes.Buffer
+	tmpdir, err := commandmocker.Add("juju", "$*")
+	c.Assert(err, IsNil)
+	defer commandmocker.Remove(tmpdir)
+	app := NewFakeApp("almah", "static", 3)
+	p := JujuProvisioner{}
+	err = p.ExecuteCommandOnUnit(&buf, &buf, app, "almah/1", "ls", "-lh")
+	c.Assert(err, IsNil)
+	output := "ssh -o StrictHostKeyChecking no -q 2 ls -lh"
+	c.Assert(commandmocker.Ran(tmpdir), Equals, true)
+	c.Assert(commandmocker.Output(tmpdir), Equals, output)
+	c.Assert(buf.String(), Equals, output)
+}
+
+func (s *S) TestExecuteCommandOnUnknownUnit(c *C) {
+	var buf bytes.Buffer
+	tmpdir, err := commandmocker.Add("juju", "$*")
+	c.Assert(err, IsNil)
+	defer commandmocker.Remove(tmpdir)
+	app := NewFakeApp("almah", "static", 1)
+	p := JujuProvisioner{}
+	err = p.ExecuteCommandOnUnit(&buf, &buf, app, "almah/7", "ls")
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, `App "almah" does not have a unit named "almah/7".`)
+	c.Assert(commandmocker.Ran(tmpdir), Equals, false)
+}
+
+func (s *S) TestJujuProvisionerIsAUnitCommander(c *C) {
+	var _ provision.UnitCommander = &JujuProvisioner{}
+}
+
+func (s *S) TestUpload(c *C) {
+	tmpdir, err := commandmocker.Add("juju", "$*")
+	c.Assert(err, IsNil)
+	defer commandmocker.Remove(tmpdir)
+	app := NewFakeApp("almah", "static", 2)
+	p := JujuProvisioner{}
+	err = p.Upload(app, strings.NewReader("archive"), "/home/application/archive.tar.gz")
+	c.Assert(err, IsNil)
+	cmdOutput := "ssh -o StrictHostKeyChecking no -q 1 cat > /home/application/archive.tar.gz" +
+		"ssh -o StrictHostKeyChecking no -q 2 cat > /home/application/archive.tar.gz"
+	c.Assert(commandmocker.Ran(tmpdir), Equals, true)
+	c.Assert(commandmocker.Output(tmpdir), Equals, cmdOutput)
+}
+
+func (s *S) TestUploadFailure(c *C) {
+	tmpdir, err := commandmocker.Error("juju", "No space left on device", 1)
+	c.Assert(err, IsNil)
+	defer commandmocker.Remove(tmpdir)
+	app := NewFakeApp("almah", "static", 1)
+	p := JujuProvisioner{}
+	err = p.Upload(app, strings.NewReader("archive"), "/home/application/archive.tar.gz")
+	c.Assert(err, NotNil)
+	pErr, ok := err.(*provision.Error)
+	c.Assert(ok, Equals, true)
+	c.Assert(pErr.Reason, Equals, "No space left on device")
+	c.Assert(pErr.Err.Error(), Equals, "exit status 1")
+}
+
+func (s *S) TestJujuProvisionerIsAnUploader(c *C) {
+	var _ provision.Uploader = &JujuProvisioner{}
+}
+
 func (s *S) TestExecuteCommandUnitDown(c *C) {
 	var buf bytes.Buffer
 	tmpdir, err := commandmocker.Add("juju", "$*")
@@ -0,0 +1,30 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package local provides a provisioner that runs app units as supervised
+// processes in the local machine. It's useful for running tsuru in a laptop
+// or in a continuous integration server, where juju is not available.
+//
+// Each unit is a working directory and a child process that runs the start
+// hook of the unit, restarting it whenever it exits. The provisioner uses two
+// settings from the configuration file:
+//
+//   - local:path: directory where units are created (defaults to
+//     /var/lib/tsuru/local)
+//   - local:command: command executed and supervised in each unit (defaults to
+//     /var/lib/tsuru/hooks/start)
+//
+// In order to use the provisioner, import the package and set "provisioner"
+// to "local" in tsuru.conf:
+//
+//     import (
+//         "github.com/globocom/tsuru/provision"
+//         _ "github.com/globocom/tsuru/provision/local"
+//     )
+//     // ...
+//     func main() {
+//         provisioner, err := provision.Get("local")
+//         // Use provisioner.
+//     }
+package local
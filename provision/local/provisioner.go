@@ -0,0 +1,450 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"errors"
+	"fmt"
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/provision"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	defaultPath    = "/var/lib/tsuru/local"
+	defaultCommand = "/var/lib/tsuru/hooks/start"
+
+	// Interval, in seconds, between two executions of the unit command.
+	respawnInterval = 1
+)
+
+// LocalProvisioner is an implementation for the Provisioner interface that
+// keeps units as directories and processes in the local machine. For more
+// details on how a provisioner work, check the documentation of the provision
+// package.
+type LocalProvisioner struct{}
+
+func (p *LocalProvisioner) Provision(app provision.App) error {
+	dir := appPath(app.GetName())
+	if _, err := os.Stat(dir); err == nil {
+		return &provision.Error{Reason: "App already provisioned."}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return &provision.Error{Reason: "Failed to create app directory.", Err: err}
+	}
+	framework := []byte(app.GetFramework())
+	if err := ioutil.WriteFile(path.Join(dir, "framework"), framework, 0644); err != nil {
+		os.RemoveAll(dir)
+		return &provision.Error{Reason: "Failed to create app directory.", Err: err}
+	}
+	if _, err := startUnit(app.GetName(), app.GetFramework(), 0); err != nil {
+		app.Log("Failed to start unit: "+err.Error(), "tsuru")
+		// Leaves nothing behind, so provisioning the app may be retried.
+		stopUnit(app.GetName(), 0)
+		os.RemoveAll(dir)
+		return &provision.Error{Reason: "Failed to start unit.", Err: err}
+	}
+	return nil
+}
+
+func (p *LocalProvisioner) Destroy(app provision.App) error {
+	name := app.GetName()
+	indices, err := unitIndices(name)
+	if err != nil {
+		return &provision.Error{Reason: "App is not provisioned.", Err: err}
+	}
+	for _, n := range indices {
+		if err := stopUnit(name, n); err != nil {
+			msg := fmt.Sprintf("Failed to destroy unit %s: %s", unitName(name, n), err)
+			app.Log(msg, "tsuru")
+			return &provision.Error{Reason: msg, Err: err}
+		}
+	}
+	return os.RemoveAll(appPath(name))
+}
+
+func (p *LocalProvisioner) AddUnits(app provision.App, n uint) ([]provision.Unit, error) {
+	if n < 1 {
+		return nil, errors.New("Cannot add zero units.")
+	}
+	name := app.GetName()
+	indices, err := unitIndices(name)
+	if err != nil {
+		return nil, &provision.Error{Reason: "App is not provisioned.", Err: err}
+	}
+	next := 0
+	if len(indices) > 0 {
+		next = indices[len(indices)-1] + 1
+	}
+	units := make([]provision.Unit, n)
+	for i := range units {
+		units[i], err = startUnit(name, app.GetFramework(), next+i)
+		if err != nil {
+			return nil, &provision.Error{Reason: "Failed to start unit.", Err: err}
+		}
+	}
+	return units, nil
+}
+
+func (p *LocalProvisioner) RemoveUnit(app provision.App, name string) error {
+	n, err := unitIndex(app.GetName(), name)
+	if err != nil {
+		return err
+	}
+	return stopUnit(app.GetName(), n)
+}
+
+func (p *LocalProvisioner) RemoveUnits(app provision.App, n uint) ([]int, error) {
+	units := app.ProvisionUnits()
+	length := uint(len(units))
+	if length == n {
+		return nil, errors.New("You can't remove all units from an app.")
+	} else if length < n {
+		return nil, fmt.Errorf("You can't remove %d units from this app because it has only %d units.", n, length)
+	}
+	result := make([]int, n)
+	for i := range result {
+		if err := p.RemoveUnit(app, units[i].GetName()); err != nil {
+			return nil, err
+		}
+		result[i] = i
+	}
+	return result, nil
+}
+
+func (p *LocalProvisioner) ExecuteCommand(stdout, stderr io.Writer, app provision.App, cmd string, args ...string) error {
+	command := unitCommandLine(cmd, args)
+	units := app.ProvisionUnits()
+	length := len(units)
+	for i, unit := range units {
+		if length > 1 {
+			if i > 0 {
+				fmt.Fprintln(stdout)
+			}
+			fmt.Fprintf(stdout, "Output from unit %q:\n\n", unit.GetName())
+			if status := unit.GetStatus(); status != provision.StatusStarted {
+				fmt.Fprintf(stdout, "Unit state is %q, it must be %q for running commands.\n",
+					status, provision.StatusStarted)
+				continue
+			}
+		}
+		n, err := unitIndex(app.GetName(), unit.GetName())
+		if err != nil {
+			return err
+		}
+		err = runInUnit(stdout, stderr, app.GetName(), n, command)
+		fmt.Fprintln(stdout)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *LocalProvisioner) ExecuteCommandOnUnit(stdout, stderr io.Writer, app provision.App, unit string, cmd string, args ...string) error {
+	n, err := unitIndex(app.GetName(), unit)
+	if err != nil {
+		return err
+	}
+	command := unitCommandLine(cmd, args)
+	return runInUnit(stdout, stderr, app.GetName(), n, command)
+}
+
+// Upload copies the content of r to the given path. Units share the
+// filesystem of the local machine, so the file is written only once.
+func (p *LocalProvisioner) Upload(app provision.App, r io.Reader, filePath string) error {
+	if _, err := unitIndices(app.GetName()); err != nil {
+		return &provision.Error{Reason: "App is not provisioned.", Err: err}
+	}
+	if err := os.MkdirAll(path.Dir(filePath), 0755); err != nil {
+		return &provision.Error{Reason: "Failed to create directory.", Err: err}
+	}
+	f, err := os.Create(filePath)
+	if err != nil {
+		return &provision.Error{Reason: "Failed to create file.", Err: err}
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		return &provision.Error{Reason: "Failed to write file.", Err: err}
+	}
+	return nil
+}
+
+// Restart kills the supervisor of each unit of the app and starts it again.
+func (p *LocalProvisioner) Restart(app provision.App) error {
+	name := app.GetName()
+	indices, err := unitIndices(name)
+	if err != nil {
+		return &provision.Error{Reason: "App is not provisioned.", Err: err}
+	}
+	for _, n := range indices {
+		if err := killUnit(name, n); err != nil {
+			return &provision.Error{Reason: "Failed to stop unit.", Err: err}
+		}
+		if _, err := startUnit(name, app.GetFramework(), n); err != nil {
+			app.Log("Failed to start unit: "+err.Error(), "tsuru")
+			return &provision.Error{Reason: "Failed to start unit.", Err: err}
+		}
+	}
+	return nil
+}
+
+// Stop kills the supervisor of each unit of the app, keeping the working
+// directories of the units.
+func (p *LocalProvisioner) Stop(app provision.App) error {
+	name := app.GetName()
+	indices, err := unitIndices(name)
+	if err != nil {
+		return &provision.Error{Reason: "App is not provisioned.", Err: err}
+	}
+	for _, n := range indices {
+		if err := killUnit(name, n); err != nil {
+			return &provision.Error{Reason: "Failed to stop unit.", Err: err}
+		}
+	}
+	return nil
+}
+
+// Start starts the supervisor of each unit of the app that is not running.
+func (p *LocalProvisioner) Start(app provision.App) error {
+	name := app.GetName()
+	indices, err := unitIndices(name)
+	if err != nil {
+		return &provision.Error{Reason: "App is not provisioned.", Err: err}
+	}
+	for _, n := range indices {
+		if unitIsRunning(name, n) {
+			continue
+		}
+		if _, err := startUnit(name, app.GetFramework(), n); err != nil {
+			app.Log("Failed to start unit: "+err.Error(), "tsuru")
+			return &provision.Error{Reason: "Failed to start unit.", Err: err}
+		}
+	}
+	return nil
+}
+
+func (p *LocalProvisioner) CollectStatus() ([]provision.Unit, error) {
+	infos, err := ioutil.ReadDir(rootPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, &provision.Error{Reason: "Failed to read units directory.", Err: err}
+	}
+	var units []provision.Unit
+	for _, info := range infos {
+		if !info.IsDir() {
+			continue
+		}
+		appName := info.Name()
+		framework, _ := ioutil.ReadFile(path.Join(appPath(appName), "framework"))
+		indices, err := unitIndices(appName)
+		if err != nil {
+			continue
+		}
+		for _, n := range indices {
+			unit := provision.Unit{
+				Name:    unitName(appName, n),
+				AppName: appName,
+				Type:    string(framework),
+				Machine: n,
+				Ip:      "127.0.0.1",
+				Status:  provision.StatusDown,
+			}
+			if unitIsRunning(appName, n) {
+				unit.Status = provision.StatusStarted
+			}
+			units = append(units, unit)
+		}
+	}
+	return units, nil
+}
+
+func init() {
+	provision.Register("local", &LocalProvisioner{})
+}
+
+func rootPath() string {
+	p, err := config.GetString("local:path")
+	if err != nil {
+		return defaultPath
+	}
+	return p
+}
+
+func unitCommand() string {
+	cmd, err := config.GetString("local:command")
+	if err != nil {
+		return defaultCommand
+	}
+	return cmd
+}
+
+func appPath(appName string) string {
+	return path.Join(rootPath(), appName)
+}
+
+func unitPath(appName string, n int) string {
+	return path.Join(appPath(appName), strconv.Itoa(n))
+}
+
+func unitName(appName string, n int) string {
+	return fmt.Sprintf("%s/%d", appName, n)
+}
+
+// unitIndex returns the index of the unit in the app, validating that the unit
+// exists in the local machine.
+func unitIndex(appName, name string) (int, error) {
+	notFound := fmt.Errorf("App %q does not have a unit named %q.", appName, name)
+	parts := strings.Split(name, "/")
+	if len(parts) != 2 || parts[0] != appName {
+		return -1, notFound
+	}
+	n, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return -1, notFound
+	}
+	if _, err := os.Stat(unitPath(appName, n)); err != nil {
+		return -1, notFound
+	}
+	return n, nil
+}
+
+// unitIndices returns the sorted list of indices of units of the given app.
+func unitIndices(appName string) ([]int, error) {
+	infos, err := ioutil.ReadDir(appPath(appName))
+	if err != nil {
+		return nil, err
+	}
+	var indices []int
+	for _, info := range infos {
+		if !info.IsDir() {
+			continue
+		}
+		if n, err := strconv.Atoi(info.Name()); err == nil {
+			indices = append(indices, n)
+		}
+	}
+	sort.Ints(indices)
+	return indices, nil
+}
+
+func unitEnv(appName string, n int) []string {
+	return append(os.Environ(),
+		"HOME="+unitPath(appName, n),
+		"TSURU_APPNAME="+appName,
+		"TSURU_UNIT="+unitName(appName, n),
+	)
+}
+
+// startUnit creates the working directory of the unit and starts the process
+// that supervises the unit command.
+//
+// The supervisor runs in its own process group, so stopUnit is able to kill
+// the supervisor and the unit command at once.
+func startUnit(appName, framework string, n int) (provision.Unit, error) {
+	unit := provision.Unit{
+		Name:    unitName(appName, n),
+		AppName: appName,
+		Type:    framework,
+		Machine: n,
+		Ip:      "127.0.0.1",
+		Status:  provision.StatusStarted,
+	}
+	dir := unitPath(appName, n)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return unit, err
+	}
+	logFile, err := os.OpenFile(path.Join(dir, "log"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return unit, err
+	}
+	script := fmt.Sprintf("while true; do %s; sleep %d; done", unitCommand(), respawnInterval)
+	cmd := exec.Command("/bin/sh", "-c", script)
+	cmd.Dir = dir
+	cmd.Env = unitEnv(appName, n)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err = cmd.Start(); err != nil {
+		logFile.Close()
+		return unit, err
+	}
+	go func() {
+		cmd.Wait()
+		logFile.Close()
+	}()
+	pid := []byte(strconv.Itoa(cmd.Process.Pid))
+	if err = ioutil.WriteFile(path.Join(dir, "pid"), pid, 0644); err != nil {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+		return unit, err
+	}
+	return unit, nil
+}
+
+func unitPid(appName string, n int) (int, error) {
+	b, err := ioutil.ReadFile(path.Join(unitPath(appName, n), "pid"))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(b)))
+}
+
+func unitIsRunning(appName string, n int) bool {
+	pid, err := unitPid(appName, n)
+	if err != nil {
+		return false
+	}
+	return syscall.Kill(pid, 0) == nil
+}
+
+// killUnit kills the supervisor of the unit, along with the unit command.
+func killUnit(appName string, n int) error {
+	if pid, err := unitPid(appName, n); err == nil {
+		err = syscall.Kill(-pid, syscall.SIGTERM)
+		if err != nil && err != syscall.ESRCH {
+			return err
+		}
+	}
+	return nil
+}
+
+// stopUnit kills the supervisor of the unit, and removes its working
+// directory.
+func stopUnit(appName string, n int) error {
+	if err := killUnit(appName, n); err != nil {
+		return err
+	}
+	return os.RemoveAll(unitPath(appName, n))
+}
+
+// unitCommandLine returns the command line for running cmd in a unit. cmd is
+// given to the shell as is, and each argument is quoted, so it's passed to
+// the command unchanged.
+func unitCommandLine(cmd string, args []string) string {
+	words := []string{cmd}
+	for _, arg := range args {
+		words = append(words, "'"+strings.Replace(arg, "'", `'\''`, -1)+"'")
+	}
+	return strings.Join(words, " ")
+}
+
+func runInUnit(stdout, stderr io.Writer, appName string, n int, command string) error {
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Dir = unitPath(appName, n)
+	cmd.Env = unitEnv(appName, n)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	return cmd.Run()
+}
@@ -0,0 +1,436 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"bytes"
+	"github.com/globocom/tsuru/provision"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func (s *S) TestShouldBeRegistered(c *C) {
+	p, err := provision.Get("local")
+	c.Assert(err, IsNil)
+	c.Assert(p, FitsTypeOf, &LocalProvisioner{})
+}
+
+func (s *S) TestProvision(c *C) {
+	app := NewFakeApp("trace", "python", 0)
+	p := LocalProvisioner{}
+	err := p.Provision(app)
+	c.Assert(err, IsNil)
+	defer p.Destroy(app)
+	framework, err := ioutil.ReadFile(path.Join(s.tmpdir, "trace", "framework"))
+	c.Assert(err, IsNil)
+	c.Assert(string(framework), Equals, "python")
+	_, err = os.Stat(path.Join(s.tmpdir, "trace", "0", "pid"))
+	c.Assert(err, IsNil)
+	c.Assert(unitIsRunning("trace", 0), Equals, true)
+}
+
+func (s *S) TestProvisionAlreadyProvisioned(c *C) {
+	app := NewFakeApp("trace", "python", 0)
+	p := LocalProvisioner{}
+	err := p.Provision(app)
+	c.Assert(err, IsNil)
+	defer p.Destroy(app)
+	err = p.Provision(app)
+	c.Assert(err, NotNil)
+	pErr, ok := err.(*provision.Error)
+	c.Assert(ok, Equals, true)
+	c.Assert(pErr.Reason, Equals, "App already provisioned.")
+}
+
+func (s *S) TestDestroy(c *C) {
+	app := NewFakeApp("cribcaged", "python", 0)
+	p := LocalProvisioner{}
+	err := p.Provision(app)
+	c.Assert(err, IsNil)
+	pid, err := unitPid("cribcaged", 0)
+	c.Assert(err, IsNil)
+	err = p.Destroy(app)
+	c.Assert(err, IsNil)
+	_, err = os.Stat(path.Join(s.tmpdir, "cribcaged"))
+	c.Assert(os.IsNotExist(err), Equals, true)
+	// The supervisor is reaped by startUnit, so the test only checks that
+	// the process is gone.
+	proc, err := os.FindProcess(pid)
+	c.Assert(err, IsNil)
+	for i := 0; i < 100 && proc.Signal(syscall.Signal(0)) == nil; i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	c.Assert(proc.Signal(syscall.Signal(0)), NotNil)
+}
+
+func (s *S) TestDestroyNotProvisioned(c *C) {
+	app := NewFakeApp("cribcaged", "python", 0)
+	p := LocalProvisioner{}
+	err := p.Destroy(app)
+	c.Assert(err, NotNil)
+	pErr, ok := err.(*provision.Error)
+	c.Assert(ok, Equals, true)
+	c.Assert(pErr.Reason, Equals, "App is not provisioned.")
+}
+
+func (s *S) TestAddUnits(c *C) {
+	app := NewFakeApp("resist", "rush", 0)
+	p := LocalProvisioner{}
+	err := p.Provision(app)
+	c.Assert(err, IsNil)
+	defer p.Destroy(app)
+	units, err := p.AddUnits(app, 2)
+	c.Assert(err, IsNil)
+	c.Assert(units, HasLen, 2)
+	c.Assert(units[0].Name, Equals, "resist/1")
+	c.Assert(units[0].Machine, Equals, 1)
+	c.Assert(units[1].Name, Equals, "resist/2")
+	c.Assert(units[1].Ip, Equals, "127.0.0.1")
+	c.Assert(unitIsRunning("resist", 1), Equals, true)
+	c.Assert(unitIsRunning("resist", 2), Equals, true)
+}
+
+func (s *S) TestAddZeroUnits(c *C) {
+	p := LocalProvisioner{}
+	units, err := p.AddUnits(nil, 0)
+	c.Assert(units, IsNil)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "Cannot add zero units.")
+}
+
+func (s *S) TestAddUnitsNotProvisioned(c *C) {
+	app := NewFakeApp("resist", "rush", 0)
+	p := LocalProvisioner{}
+	units, err := p.AddUnits(app, 1)
+	c.Assert(units, IsNil)
+	c.Assert(err, NotNil)
+}
+
+func (s *S) TestRemoveUnit(c *C) {
+	app := NewFakeApp("two", "rush", 2)
+	p := LocalProvisioner{}
+	err := p.Provision(app)
+	c.Assert(err, IsNil)
+	defer p.Destroy(app)
+	_, err = p.AddUnits(app, 1)
+	c.Assert(err, IsNil)
+	err = p.RemoveUnit(app, "two/1")
+	c.Assert(err, IsNil)
+	_, err = os.Stat(path.Join(s.tmpdir, "two", "1"))
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *S) TestRemoveUnknownUnit(c *C) {
+	app := NewFakeApp("tears", "rush", 2)
+	p := LocalProvisioner{}
+	err := p.Provision(app)
+	c.Assert(err, IsNil)
+	defer p.Destroy(app)
+	err = p.RemoveUnit(app, "tears/2")
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, `App "tears" does not have a unit named "tears/2".`)
+}
+
+func (s *S) TestRemoveUnits(c *C) {
+	app := NewFakeApp("xanadu", "rush", 3)
+	p := LocalProvisioner{}
+	err := p.Provision(app)
+	c.Assert(err, IsNil)
+	defer p.Destroy(app)
+	_, err = p.AddUnits(app, 2)
+	c.Assert(err, IsNil)
+	removed, err := p.RemoveUnits(app, 2)
+	c.Assert(err, IsNil)
+	c.Assert(removed, DeepEquals, []int{0, 1})
+	indices, err := unitIndices("xanadu")
+	c.Assert(err, IsNil)
+	c.Assert(indices, DeepEquals, []int{2})
+}
+
+func (s *S) TestRemoveUnitsInvalidValues(c *C) {
+	app := NewFakeApp("closer", "rush", 2)
+	p := LocalProvisioner{}
+	_, err := p.RemoveUnits(app, 2)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "You can't remove all units from an app.")
+	_, err = p.RemoveUnits(app, 3)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "You can't remove 3 units from this app because it has only 2 units.")
+}
+
+func (s *S) TestExecuteCommandOneUnit(c *C) {
+	var buf bytes.Buffer
+	app := NewFakeApp("almah", "static", 1)
+	p := LocalProvisioner{}
+	err := p.Provision(app)
+	c.Assert(err, IsNil)
+	defer p.Destroy(app)
+	err = p.ExecuteCommand(&buf, &buf, app, "echo $TSURU_UNIT && pwd")
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, "almah/0\n"+path.Join(s.tmpdir, "almah", "0")+"\n\n")
+}
+
+func (s *S) TestExecuteCommandQuotesArguments(c *C) {
+	var buf bytes.Buffer
+	app := NewFakeApp("almah", "static", 1)
+	p := LocalProvisioner{}
+	err := p.Provision(app)
+	c.Assert(err, IsNil)
+	defer p.Destroy(app)
+	err = p.ExecuteCommand(&buf, &buf, app, "echo", "$TSURU_UNIT", "it's; exit 2")
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, "$TSURU_UNIT it's; exit 2\n\n")
+}
+
+func (s *S) TestExecuteCommandUnitDown(c *C) {
+	var buf bytes.Buffer
+	app := NewFakeApp("almah", "static", 3)
+	app.units[1].(*FakeUnit).status = provision.StatusDown
+	p := LocalProvisioner{}
+	err := p.Provision(app)
+	c.Assert(err, IsNil)
+	defer p.Destroy(app)
+	_, err = p.AddUnits(app, 2)
+	c.Assert(err, IsNil)
+	err = p.ExecuteCommand(&buf, &buf, app, "echo $TSURU_UNIT")
+	c.Assert(err, IsNil)
+	expected := `Output from unit "almah/0":
+
+almah/0
+
+
+Output from unit "almah/1":
+
+Unit state is "down", it must be "started" for running commands.
+
+Output from unit "almah/2":
+
+almah/2
+
+`
+	c.Assert(buf.String(), Equals, expected)
+}
+
+func (s *S) TestExecuteCommandFailure(c *C) {
+	var buf bytes.Buffer
+	app := NewFakeApp("frases", "static", 1)
+	p := LocalProvisioner{}
+	err := p.Provision(app)
+	c.Assert(err, IsNil)
+	defer p.Destroy(app)
+	err = p.ExecuteCommand(&buf, &buf, app, "echo failed && exit 2")
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "exit status 2")
+	c.Assert(buf.String(), Equals, "failed\n\n")
+}
+
+func (s *S) TestCollectStatus(c *C) {
+	app1 := NewFakeApp("as_i_rise", "django", 0)
+	app2 := NewFakeApp("the_infanta", "gunicorn", 0)
+	p := LocalProvisioner{}
+	err := p.Provision(app1)
+	c.Assert(err, IsNil)
+	defer p.Destroy(app1)
+	err = p.Provision(app2)
+	c.Assert(err, IsNil)
+	defer p.Destroy(app2)
+	_, err = p.AddUnits(app2, 1)
+	c.Assert(err, IsNil)
+	pid, err := unitPid("the_infanta", 1)
+	c.Assert(err, IsNil)
+	err = syscall.Kill(-pid, syscall.SIGTERM)
+	c.Assert(err, IsNil)
+	for i := 0; i < 100 && unitIsRunning("the_infanta", 1); i++ {
+		time.Sleep(1e7)
+	}
+	expected := []provision.Unit{
+		{
+			Name:    "as_i_rise/0",
+			AppName: "as_i_rise",
+			Type:    "django",
+			Machine: 0,
+			Ip:      "127.0.0.1",
+			Status:  provision.StatusStarted,
+		},
+		{
+			Name:    "the_infanta/0",
+			AppName: "the_infanta",
+			Type:    "gunicorn",
+			Machine: 0,
+			Ip:      "127.0.0.1",
+			Status:  provision.StatusStarted,
+		},
+		{
+			Name:    "the_infanta/1",
+			AppName: "the_infanta",
+			Type:    "gunicorn",
+			Machine: 1,
+			Ip:      "127.0.0.1",
+			Status:  provision.StatusDown,
+		},
+	}
+	units, err := p.CollectStatus()
+	c.Assert(err, IsNil)
+	c.Assert(units, DeepEquals, expected)
+}
+
+func (s *S) TestCollectStatusWithoutUnits(c *C) {
+	p := LocalProvisioner{}
+	units, err := p.CollectStatus()
+	c.Assert(err, IsNil)
+	c.Assert(units, HasLen, 0)
+}
+
+func (s *S) TestExecuteCommandOnUnit(c *C) {
+	var buf bytes.Buffer
+	app := NewFakeApp("limelight", "static", 2)
+	p := LocalProvisioner{}
+	err := p.Provision(app)
+	c.Assert(err, IsNil)
+	defer p.Destroy(app)
+	_, err = p.AddUnits(app, 1)
+	c.Assert(err, IsNil)
+	err = p.ExecuteCommandOnUnit(&buf, &buf, app, "limelight/1", "echo $TSURU_UNIT")
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, "limelight/1\n")
+}
+
+func (s *S) TestExecuteCommandOnUnknownUnit(c *C) {
+	var buf bytes.Buffer
+	app := NewFakeApp("limelight", "static", 1)
+	p := LocalProvisioner{}
+	err := p.Provision(app)
+	c.Assert(err, IsNil)
+	defer p.Destroy(app)
+	err = p.ExecuteCommandOnUnit(&buf, &buf, app, "limelight/3", "echo $TSURU_UNIT")
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, `App "limelight" does not have a unit named "limelight/3".`)
+}
+
+func (s *S) TestUpload(c *C) {
+	app := NewFakeApp("limelight", "static", 1)
+	p := LocalProvisioner{}
+	err := p.Provision(app)
+	c.Assert(err, IsNil)
+	defer p.Destroy(app)
+	filePath := path.Join(s.tmpdir, "uploads", "archive.tar.gz")
+	err = p.Upload(app, strings.NewReader("archive"), filePath)
+	c.Assert(err, IsNil)
+	content, err := ioutil.ReadFile(filePath)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "archive")
+}
+
+func (s *S) TestUploadNotProvisioned(c *C) {
+	app := NewFakeApp("limelight", "static", 1)
+	p := LocalProvisioner{}
+	err := p.Upload(app, strings.NewReader("archive"), path.Join(s.tmpdir, "archive.tar.gz"))
+	c.Assert(err, NotNil)
+	pErr, ok := err.(*provision.Error)
+	c.Assert(ok, Equals, true)
+	c.Assert(pErr.Reason, Equals, "App is not provisioned.")
+}
+
+func (s *S) TestProvisionerIsAnUploader(c *C) {
+	var p provision.Provisioner = &LocalProvisioner{}
+	_, ok := p.(provision.Uploader)
+	c.Assert(ok, Equals, true)
+}
+
+func (s *S) TestRestart(c *C) {
+	app := NewFakeApp("entre_nous", "static", 2)
+	p := LocalProvisioner{}
+	err := p.Provision(app)
+	c.Assert(err, IsNil)
+	defer p.Destroy(app)
+	_, err = p.AddUnits(app, 1)
+	c.Assert(err, IsNil)
+	old0, err := unitPid("entre_nous", 0)
+	c.Assert(err, IsNil)
+	old1, err := unitPid("entre_nous", 1)
+	c.Assert(err, IsNil)
+	err = p.Restart(app)
+	c.Assert(err, IsNil)
+	pid0, err := unitPid("entre_nous", 0)
+	c.Assert(err, IsNil)
+	c.Assert(pid0, Not(Equals), old0)
+	pid1, err := unitPid("entre_nous", 1)
+	c.Assert(err, IsNil)
+	c.Assert(pid1, Not(Equals), old1)
+	c.Assert(unitIsRunning("entre_nous", 0), Equals, true)
+	c.Assert(unitIsRunning("entre_nous", 1), Equals, true)
+}
+
+func (s *S) TestRestartNotProvisioned(c *C) {
+	app := NewFakeApp("entre_nous", "static", 1)
+	p := LocalProvisioner{}
+	err := p.Restart(app)
+	c.Assert(err, NotNil)
+	pErr, ok := err.(*provision.Error)
+	c.Assert(ok, Equals, true)
+	c.Assert(pErr.Reason, Equals, "App is not provisioned.")
+}
+
+func (s *S) TestStop(c *C) {
+	app := NewFakeApp("anthem", "static", 1)
+	p := LocalProvisioner{}
+	err := p.Provision(app)
+	c.Assert(err, IsNil)
+	defer p.Destroy(app)
+	err = p.Stop(app)
+	c.Assert(err, IsNil)
+	for i := 0; i < 100 && unitIsRunning("anthem", 0); i++ {
+		time.Sleep(1e7)
+	}
+	c.Assert(unitIsRunning("anthem", 0), Equals, false)
+	_, err = os.Stat(path.Join(s.tmpdir, "anthem", "0"))
+	c.Assert(err, IsNil)
+}
+
+func (s *S) TestStopNotProvisioned(c *C) {
+	app := NewFakeApp("anthem", "static", 1)
+	p := LocalProvisioner{}
+	err := p.Stop(app)
+	c.Assert(err, NotNil)
+	pErr, ok := err.(*provision.Error)
+	c.Assert(ok, Equals, true)
+	c.Assert(pErr.Reason, Equals, "App is not provisioned.")
+}
+
+func (s *S) TestStart(c *C) {
+	app := NewFakeApp("anthem", "static", 1)
+	p := LocalProvisioner{}
+	err := p.Provision(app)
+	c.Assert(err, IsNil)
+	defer p.Destroy(app)
+	err = p.Stop(app)
+	c.Assert(err, IsNil)
+	for i := 0; i < 100 && unitIsRunning("anthem", 0); i++ {
+		time.Sleep(1e7)
+	}
+	err = p.Start(app)
+	c.Assert(err, IsNil)
+	c.Assert(unitIsRunning("anthem", 0), Equals, true)
+}
+
+func (s *S) TestStartRunningUnit(c *C) {
+	app := NewFakeApp("anthem", "static", 1)
+	p := LocalProvisioner{}
+	err := p.Provision(app)
+	c.Assert(err, IsNil)
+	defer p.Destroy(app)
+	pid, err := unitPid("anthem", 0)
+	c.Assert(err, IsNil)
+	err = p.Start(app)
+	c.Assert(err, IsNil)
+	newPid, err := unitPid("anthem", 0)
+	c.Assert(err, IsNil)
+	c.Assert(newPid, Equals, pid)
+}
@@ -0,0 +1,34 @@
+// Copyright 2012 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"github.com/globocom/config"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+	"testing"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type S struct {
+	tmpdir string
+}
+
+var _ = Suite(&S{})
+
+func (s *S) SetUpSuite(c *C) {
+	var err error
+	s.tmpdir, err = ioutil.TempDir("", "tsuru-local")
+	c.Assert(err, IsNil)
+	config.Set("local:path", s.tmpdir)
+	config.Set("local:command", "sleep 30")
+}
+
+func (s *S) TearDownSuite(c *C) {
+	os.RemoveAll(s.tmpdir)
+	config.Unset("local")
+}
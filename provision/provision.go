@@ -24,6 +24,11 @@ const (
 	StatusError      = Status("error")
 	StatusInstalling = Status("installing")
 	StatusCreating   = Status("creating")
+	StatusStopped    = Status("stopped")
+
+	// StatusPartiallyStarted is never reported for units. It's used for
+	// apps that have started units along with units in other states.
+	StatusPartiallyStarted = Status("partially started")
 )
 
 // Unit represents a provision unit. Can be a machine, container or anything
@@ -74,6 +79,9 @@ type App interface {
 //
 // Tsuru comes with a default provisioner: juju. One can add other provisioners
 // by satisfying this interface and registering it using the function Register.
+//
+// Provisioners may also implement the optional interfaces Stopper, Starter,
+// Restarter and UnitCommander. Tsuru checks for them at runtime.
 type Provisioner interface {
 	// Provision is called when tsuru is creating the app.
 	Provision(App) error
@@ -107,6 +115,38 @@ type Provisioner interface {
 	CollectStatus() ([]Unit, error)
 }
 
+// Stopper is implemented by provisioners that are able to stop all units of
+// an app without destroying them.
+type Stopper interface {
+	Stop(App) error
+}
+
+// Starter is implemented by provisioners that are able to start the units of
+// an app that were previously stopped.
+type Starter interface {
+	Start(App) error
+}
+
+// Restarter is implemented by provisioners that know how to restart all units
+// of an app. Tsuru runs the restart hook in every unit of apps whose
+// provisioner doesn't implement this interface.
+type Restarter interface {
+	Restart(App) error
+}
+
+// UnitCommander is implemented by provisioners that are able to run a command
+// in a single unit of an app. The unit is identified by its name.
+type UnitCommander interface {
+	ExecuteCommandOnUnit(stdout, stderr io.Writer, app App, unit string, cmd string, args ...string) error
+}
+
+// Uploader is implemented by provisioners that are able to copy a file to all
+// units of an app. The content of the file is read from r, and path is the
+// absolute path of the file in the units.
+type Uploader interface {
+	Upload(app App, r io.Reader, path string) error
+}
+
 var provisioners = make(map[string]Provisioner)
 
 // Register registers a new provisioner in the Provisioner registry.
@@ -10,6 +10,7 @@ import (
 	"github.com/globocom/config"
 	"github.com/globocom/tsuru/log"
 	"io"
+	"regexp"
 )
 
 // Unit interface represents a unit of execution.
@@ -30,9 +31,12 @@ type Unit interface {
 // Given a machine id (from juju), it runs a git clone into this machine,
 // cloning from the bare repository that is being served by git-daemon in the
 // tsuru server.
+//
+// Code that is not a repository, like the code deployed from an archive, is
+// removed before cloning, so it's replaced by the repository.
 func clone(u Unit) ([]byte, error) {
 	var buf bytes.Buffer
-	cmd := fmt.Sprintf("git clone %s /home/application/current --depth 1", GetReadOnlyUrl(u.GetName()))
+	cmd := fmt.Sprintf("(test -d /home/application/current/.git || rm -rf /home/application/current) && git clone %s /home/application/current --depth 1", GetReadOnlyUrl(u.GetName()))
 	err := u.Command(&buf, &buf, cmd)
 	b := buf.Bytes()
 	log.Printf(`"git clone" output: %s`, b)
@@ -63,6 +67,53 @@ func CloneOrPull(u Unit) ([]byte, error) {
 	return b, err
 }
 
+// fetch makes the given commit available in the clone of a unit.
+//
+// Clones are shallow, so commits older than the one that was cloned are not
+// available in units added after them. When the commit is not found, the
+// whole history is fetched from the bare repository.
+func fetch(u Unit, commit string) ([]byte, error) {
+	var buf bytes.Buffer
+	cmd := fmt.Sprintf("cd /home/application/current && (git cat-file -e %s^{commit} || git fetch --unshallow origin)", commit)
+	err := u.Command(&buf, &buf, cmd)
+	b := buf.Bytes()
+	log.Printf(`"git fetch" output: %s`, b)
+	return b, err
+}
+
+// Checkout moves the code in a unit to the given commit.
+//
+// The commit is fetched first, when it's missing in the clone of the unit.
+// The branch is reset instead of checked out, so that later pulls keep
+// working.
+func Checkout(u Unit, commit string) ([]byte, error) {
+	b, err := fetch(u, commit)
+	if err != nil {
+		return b, err
+	}
+	var buf bytes.Buffer
+	cmd := fmt.Sprintf("cd /home/application/current && git reset --hard %s", commit)
+	err = u.Command(&buf, &buf, cmd)
+	log.Printf(`"git reset" output: %s`, buf.Bytes())
+	return append(b, buf.Bytes()...), err
+}
+
+var commitRegexp = regexp.MustCompile(`(?m)^[0-9a-f]{40}$`)
+
+// Head returns the hash of the commit that is checked out in a unit.
+func Head(u Unit) (string, error) {
+	var buf bytes.Buffer
+	cmd := "cd /home/application/current && git rev-parse HEAD"
+	if err := u.Command(&buf, &buf, cmd); err != nil {
+		return "", err
+	}
+	commit := commitRegexp.Find(buf.Bytes())
+	if commit == nil {
+		return "", fmt.Errorf("Could not find the current commit in the output: %s", buf.Bytes())
+	}
+	return string(commit), nil
+}
+
 // getGitServer returns the git server defined in the tsuru.conf file.
 //
 // If git:host configuration is not defined, this function panics.
@@ -9,7 +9,11 @@ import (
 	"fmt"
 	"github.com/globocom/config"
 	"io"
+	"io/ioutil"
 	. "launchpad.net/gocheck"
+	"os"
+	"os/exec"
+	"path"
 	"strings"
 )
 
@@ -41,18 +45,46 @@ type FailingCloneUnit struct {
 }
 
 func (u *FailingCloneUnit) Command(stdout, stderr io.Writer, cmd ...string) error {
-	if strings.HasPrefix(cmd[0], "git clone") {
+	if strings.Contains(cmd[0], "git clone") {
 		u.commands = append(u.commands, cmd[0])
 		return errors.New("Failed to clone repository, it already exists!")
 	}
 	return u.FakeUnit.Command(nil, nil, cmd...)
 }
 
+// ShellUnit runs commands in a shell, replacing /home/application with dir,
+// and the URL of the repository of the unit with origin.
+type ShellUnit struct {
+	FakeUnit
+	dir    string
+	origin string
+}
+
+func (u *ShellUnit) Command(stdout, stderr io.Writer, cmd ...string) error {
+	u.commands = append(u.commands, cmd[0])
+	command := strings.Replace(cmd[0], GetReadOnlyUrl(u.name), u.origin, -1)
+	c := exec.Command("/bin/sh", "-c", strings.Replace(command, "/home/application", u.dir, -1))
+	c.Stdout = stdout
+	c.Stderr = stderr
+	return c.Run()
+}
+
+type OutputUnit struct {
+	FakeUnit
+	output string
+}
+
+func (u *OutputUnit) Command(stdout, stderr io.Writer, cmd ...string) error {
+	u.commands = append(u.commands, cmd[0])
+	_, err := io.WriteString(stdout, u.output)
+	return err
+}
+
 func (s *S) TestCloneRepository(c *C) {
 	u := FakeUnit{name: "my-unit"}
 	_, err := clone(&u)
 	c.Assert(err, IsNil)
-	expectedCommand := fmt.Sprintf("git clone %s /home/application/current --depth 1", GetReadOnlyUrl(u.GetName()))
+	expectedCommand := fmt.Sprintf("(test -d /home/application/current/.git || rm -rf /home/application/current) && git clone %s /home/application/current --depth 1", GetReadOnlyUrl(u.GetName()))
 	c.Assert(u.RanCommand(expectedCommand), Equals, true)
 }
 
@@ -68,7 +100,7 @@ func (s *S) TestCloneOrPullRepositoryRunsClone(c *C) {
 	u := FakeUnit{name: "my-unit"}
 	_, err := CloneOrPull(&u)
 	c.Assert(err, IsNil)
-	clone := fmt.Sprintf("git clone %s /home/application/current --depth 1", GetReadOnlyUrl(u.GetName()))
+	clone := fmt.Sprintf("(test -d /home/application/current/.git || rm -rf /home/application/current) && git clone %s /home/application/current --depth 1", GetReadOnlyUrl(u.GetName()))
 	pull := fmt.Sprintf("cd /home/application/current && git pull origin master")
 	c.Assert(u.RanCommand(clone), Equals, true)
 	c.Assert(u.RanCommand(pull), Equals, false)
@@ -78,7 +110,7 @@ func (s *S) TestCloneOrPullRepositoryRunsPullIfCloneFail(c *C) {
 	u := FailingCloneUnit{FakeUnit{name: "my-unit"}}
 	_, err := CloneOrPull(&u)
 	c.Assert(err, IsNil)
-	clone := fmt.Sprintf("git clone %s /home/application/current --depth 1", GetReadOnlyUrl(u.GetName()))
+	clone := fmt.Sprintf("(test -d /home/application/current/.git || rm -rf /home/application/current) && git clone %s /home/application/current --depth 1", GetReadOnlyUrl(u.GetName()))
 	pull := fmt.Sprintf("cd /home/application/current && git pull origin master")
 	c.Assert(u.RanCommand(clone), Equals, true)
 	c.Assert(u.RanCommand(pull), Equals, true)
@@ -130,3 +162,111 @@ func (s *S) TestGetServerUriWithoutPort(c *C) {
 	uri := GitServerUri()
 	c.Assert(uri, Equals, fmt.Sprintf("%s://%s", protocol, server))
 }
+
+func (s *S) TestCheckout(c *C) {
+	u := FakeUnit{name: "my-unit"}
+	_, err := Checkout(&u, "8e0f1ab1fc30b7e7aea8e7f6fc5f1a7d9e4ad3d1")
+	c.Assert(err, IsNil)
+	fetch := "cd /home/application/current && (git cat-file -e 8e0f1ab1fc30b7e7aea8e7f6fc5f1a7d9e4ad3d1^{commit} || git fetch --unshallow origin)"
+	reset := "cd /home/application/current && git reset --hard 8e0f1ab1fc30b7e7aea8e7f6fc5f1a7d9e4ad3d1"
+	c.Assert(u.commands, DeepEquals, []string{fetch, reset})
+}
+
+// git runs a git command in dir, returning its output.
+func git(c *C, dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=tsuru", "GIT_AUTHOR_EMAIL=tsuru@tsuru.io",
+		"GIT_COMMITTER_NAME=tsuru", "GIT_COMMITTER_EMAIL=tsuru@tsuru.io")
+	out, err := cmd.CombinedOutput()
+	c.Assert(err, IsNil, Commentf("git %s: %s", strings.Join(args, " "), out))
+	return strings.TrimSpace(string(out))
+}
+
+// createOrigin creates a repository in dir/origin, with one commit for each of
+// the given contents of the Procfile.
+func createOrigin(c *C, dir string, contents ...string) {
+	git(c, dir, "init", "origin")
+	git(c, dir, "--git-dir=origin/.git", "symbolic-ref", "HEAD", "refs/heads/master")
+	for _, content := range contents {
+		err := ioutil.WriteFile(path.Join(dir, "origin", "Procfile"), []byte(content), 0644)
+		c.Assert(err, IsNil)
+		git(c, dir, "--git-dir=origin/.git", "--work-tree=origin", "add", "Procfile")
+		git(c, dir, "--git-dir=origin/.git", "--work-tree=origin", "commit", "-m", content)
+	}
+}
+
+func (s *S) TestCheckoutCommitThatIsNotHead(c *C) {
+	dir, err := ioutil.TempDir("", "checkout")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	createOrigin(c, dir, "first", "second")
+	first := git(c, dir, "--git-dir=origin/.git", "rev-parse", "HEAD^")
+	git(c, dir, "clone", "--depth", "1", "file://"+path.Join(dir, "origin"), "current")
+	u := ShellUnit{FakeUnit: FakeUnit{name: "my-unit"}, dir: dir}
+	_, err = Checkout(&u, first)
+	c.Assert(err, IsNil)
+	content, err := ioutil.ReadFile(path.Join(dir, "current", "Procfile"))
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "first")
+}
+
+func (s *S) TestCloneOrPullAfterArchiveDeploy(c *C) {
+	dir, err := ioutil.TempDir("", "clone")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	createOrigin(c, dir, "web: ./server")
+	// Archives are extracted to a directory that is not a repository.
+	err = os.Mkdir(path.Join(dir, "current"), 0755)
+	c.Assert(err, IsNil)
+	err = ioutil.WriteFile(path.Join(dir, "current", "Procfile"), []byte("web: ./archive"), 0644)
+	c.Assert(err, IsNil)
+	u := ShellUnit{FakeUnit: FakeUnit{name: "my-unit"}, dir: dir, origin: "file://" + path.Join(dir, "origin")}
+	_, err = CloneOrPull(&u)
+	c.Assert(err, IsNil)
+	content, err := ioutil.ReadFile(path.Join(dir, "current", "Procfile"))
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "web: ./server")
+	_, err = CloneOrPull(&u)
+	c.Assert(err, IsNil)
+	c.Assert(u.commands, HasLen, 3)
+}
+
+func (s *S) TestHead(c *C) {
+	u := OutputUnit{
+		FakeUnit: FakeUnit{name: "my-unit"},
+		output:   "8e0f1ab1fc30b7e7aea8e7f6fc5f1a7d9e4ad3d1\n",
+	}
+	commit, err := Head(&u)
+	c.Assert(err, IsNil)
+	c.Assert(commit, Equals, "8e0f1ab1fc30b7e7aea8e7f6fc5f1a7d9e4ad3d1")
+	c.Assert(u.RanCommand("cd /home/application/current && git rev-parse HEAD"), Equals, true)
+}
+
+func (s *S) TestHeadWithMultipleUnits(c *C) {
+	u := OutputUnit{
+		FakeUnit: FakeUnit{name: "my-unit"},
+		output: `Output from unit "my-unit/0":
+
+8e0f1ab1fc30b7e7aea8e7f6fc5f1a7d9e4ad3d1
+
+Output from unit "my-unit/1":
+
+8e0f1ab1fc30b7e7aea8e7f6fc5f1a7d9e4ad3d1
+`,
+	}
+	commit, err := Head(&u)
+	c.Assert(err, IsNil)
+	c.Assert(commit, Equals, "8e0f1ab1fc30b7e7aea8e7f6fc5f1a7d9e4ad3d1")
+}
+
+func (s *S) TestHeadInvalidOutput(c *C) {
+	u := OutputUnit{
+		FakeUnit: FakeUnit{name: "my-unit"},
+		output:   "fatal: Not a git repository",
+	}
+	commit, err := Head(&u)
+	c.Assert(commit, Equals, "")
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "Could not find the current commit in the output: fatal: Not a git repository")
+}
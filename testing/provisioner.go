@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"github.com/globocom/tsuru/provision"
 	"io"
+	"io/ioutil"
 	"strconv"
 	"sync"
 	"time"
@@ -86,6 +87,7 @@ type Cmd struct {
 	Cmd  string
 	Args []string
 	App  provision.App
+	Unit string
 }
 
 type failure struct {
@@ -298,15 +300,19 @@ func (p *FakeProvisioner) RemoveUnits(app provision.App, n uint) ([]int, error)
 }
 
 func (p *FakeProvisioner) ExecuteCommand(stdout, stderr io.Writer, app provision.App, cmd string, args ...string) error {
-	var (
-		output []byte
-		err    error
-	)
 	command := Cmd{
 		Cmd:  cmd,
 		Args: args,
 		App:  app,
 	}
+	return p.execute(stdout, stderr, command)
+}
+
+func (p *FakeProvisioner) execute(stdout, stderr io.Writer, command Cmd) error {
+	var (
+		output []byte
+		err    error
+	)
 	p.cmdMut.Lock()
 	p.cmds = append(p.cmds, command)
 	p.cmdMut.Unlock()
@@ -358,3 +364,111 @@ func (p *FakeProvisioner) CollectStatus() ([]provision.Unit, error) {
 	}
 	return units, nil
 }
+
+// ExtensibleFakeProvisioner is a fake provisioner that also implements the
+// optional interfaces of the provision package.
+type ExtensibleFakeProvisioner struct {
+	*FakeProvisioner
+	restarts map[string]int
+	stops    map[string]int
+	starts   map[string]int
+	uploads  map[string]map[string][]byte
+	mut      sync.Mutex
+}
+
+func NewExtensibleFakeProvisioner() *ExtensibleFakeProvisioner {
+	return &ExtensibleFakeProvisioner{
+		FakeProvisioner: NewFakeProvisioner(),
+		restarts:        make(map[string]int),
+		stops:           make(map[string]int),
+		starts:          make(map[string]int),
+		uploads:         make(map[string]map[string][]byte),
+	}
+}
+
+func (p *ExtensibleFakeProvisioner) count(calls map[string]int, app provision.App) int {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	return calls[app.GetName()]
+}
+
+func (p *ExtensibleFakeProvisioner) record(method string, calls map[string]int, app provision.App) error {
+	if err := p.getError(method); err != nil {
+		return err
+	}
+	if p.FindApp(app) < 0 {
+		return errors.New("App is not provisioned.")
+	}
+	p.mut.Lock()
+	calls[app.GetName()]++
+	p.mut.Unlock()
+	return nil
+}
+
+// Restarts returns the number of times the given app was restarted by the
+// provisioner.
+func (p *ExtensibleFakeProvisioner) Restarts(app provision.App) int {
+	return p.count(p.restarts, app)
+}
+
+// Stops returns the number of times the given app was stopped by the
+// provisioner.
+func (p *ExtensibleFakeProvisioner) Stops(app provision.App) int {
+	return p.count(p.stops, app)
+}
+
+// Starts returns the number of times the given app was started by the
+// provisioner.
+func (p *ExtensibleFakeProvisioner) Starts(app provision.App) int {
+	return p.count(p.starts, app)
+}
+
+func (p *ExtensibleFakeProvisioner) Restart(app provision.App) error {
+	return p.record("Restart", p.restarts, app)
+}
+
+func (p *ExtensibleFakeProvisioner) Stop(app provision.App) error {
+	return p.record("Stop", p.stops, app)
+}
+
+func (p *ExtensibleFakeProvisioner) Start(app provision.App) error {
+	return p.record("Start", p.starts, app)
+}
+
+func (p *ExtensibleFakeProvisioner) ExecuteCommandOnUnit(stdout, stderr io.Writer, app provision.App, unit string, cmd string, args ...string) error {
+	command := Cmd{
+		Cmd:  cmd,
+		Args: args,
+		App:  app,
+		Unit: unit,
+	}
+	return p.execute(stdout, stderr, command)
+}
+
+func (p *ExtensibleFakeProvisioner) Upload(app provision.App, r io.Reader, path string) error {
+	if err := p.getError("Upload"); err != nil {
+		return err
+	}
+	if p.FindApp(app) < 0 {
+		return errors.New("App is not provisioned.")
+	}
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	if p.uploads[app.GetName()] == nil {
+		p.uploads[app.GetName()] = make(map[string][]byte)
+	}
+	p.uploads[app.GetName()][path] = content
+	return nil
+}
+
+// Uploaded returns the content of the file uploaded to the given path in the
+// units of the app, or nil if no file was uploaded to this path.
+func (p *ExtensibleFakeProvisioner) Uploaded(app provision.App, path string) []byte {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	return p.uploads[app.GetName()][path]
+}
@@ -9,6 +9,7 @@ import (
 	"errors"
 	"github.com/globocom/tsuru/provision"
 	. "launchpad.net/gocheck"
+	"strings"
 	"testing"
 )
 
@@ -336,3 +337,103 @@ func (s *S) TestCollectStatusNoApps(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(units, HasLen, 0)
 }
+
+func (s *S) TestExtensibleFakeProvisionerRestart(c *C) {
+	app := NewFakeApp("vital-signs", "rush", 1)
+	p := NewExtensibleFakeProvisioner()
+	p.apps = []provision.App{app}
+	err := p.Restart(app)
+	c.Assert(err, IsNil)
+	err = p.Restart(app)
+	c.Assert(err, IsNil)
+	c.Assert(p.Restarts(app), Equals, 2)
+}
+
+func (s *S) TestExtensibleFakeProvisionerRestartNotProvisioned(c *C) {
+	app := NewFakeApp("vital-signs", "rush", 1)
+	p := NewExtensibleFakeProvisioner()
+	err := p.Restart(app)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "App is not provisioned.")
+	c.Assert(p.Restarts(app), Equals, 0)
+}
+
+func (s *S) TestExtensibleFakeProvisionerRestartPreparedFailure(c *C) {
+	app := NewFakeApp("vital-signs", "rush", 1)
+	p := NewExtensibleFakeProvisioner()
+	p.apps = []provision.App{app}
+	p.PrepareFailure("Restart", errors.New("Failed to restart."))
+	err := p.Restart(app)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "Failed to restart.")
+}
+
+func (s *S) TestExtensibleFakeProvisionerStopAndStart(c *C) {
+	app := NewFakeApp("vital-signs", "rush", 1)
+	p := NewExtensibleFakeProvisioner()
+	p.apps = []provision.App{app}
+	err := p.Stop(app)
+	c.Assert(err, IsNil)
+	c.Assert(p.Stops(app), Equals, 1)
+	c.Assert(p.Starts(app), Equals, 0)
+	err = p.Start(app)
+	c.Assert(err, IsNil)
+	c.Assert(p.Stops(app), Equals, 1)
+	c.Assert(p.Starts(app), Equals, 1)
+}
+
+func (s *S) TestExtensibleFakeProvisionerStopPreparedFailure(c *C) {
+	app := NewFakeApp("vital-signs", "rush", 1)
+	p := NewExtensibleFakeProvisioner()
+	p.apps = []provision.App{app}
+	p.PrepareFailure("Stop", errors.New("Failed to stop."))
+	err := p.Stop(app)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "Failed to stop.")
+	c.Assert(p.Stops(app), Equals, 0)
+}
+
+func (s *S) TestExtensibleFakeProvisionerExecuteCommandOnUnit(c *C) {
+	var buf bytes.Buffer
+	app := NewFakeApp("vital-signs", "rush", 2)
+	p := NewExtensibleFakeProvisioner()
+	p.PrepareOutput([]byte("myoutput!"))
+	err := p.ExecuteCommandOnUnit(&buf, nil, app, "vital-signs/1", "ls", "-l")
+	c.Assert(err, IsNil)
+	cmds := p.GetCmds("ls", app)
+	c.Assert(cmds, HasLen, 1)
+	c.Assert(cmds[0].Unit, Equals, "vital-signs/1")
+	c.Assert(buf.String(), Equals, "myoutput!")
+}
+
+func (s *S) TestExtensibleFakeProvisionerUpload(c *C) {
+	app := NewFakeApp("vital-signs", "rush", 1)
+	p := NewExtensibleFakeProvisioner()
+	p.apps = []provision.App{app}
+	err := p.Upload(app, strings.NewReader("archive"), "/home/application/archive.tar.gz")
+	c.Assert(err, IsNil)
+	c.Assert(string(p.Uploaded(app, "/home/application/archive.tar.gz")), Equals, "archive")
+	c.Assert(p.Uploaded(app, "/home/application/other"), IsNil)
+}
+
+func (s *S) TestExtensibleFakeProvisionerUploadNotProvisioned(c *C) {
+	app := NewFakeApp("vital-signs", "rush", 1)
+	p := NewExtensibleFakeProvisioner()
+	err := p.Upload(app, strings.NewReader("archive"), "/home/application/archive.tar.gz")
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "App is not provisioned.")
+}
+
+func (s *S) TestExtensibleFakeProvisionerImplementsOptionalInterfaces(c *C) {
+	var p provision.Provisioner = NewExtensibleFakeProvisioner()
+	_, ok := p.(provision.Restarter)
+	c.Assert(ok, Equals, true)
+	_, ok = p.(provision.UnitCommander)
+	c.Assert(ok, Equals, true)
+	_, ok = p.(provision.Stopper)
+	c.Assert(ok, Equals, true)
+	_, ok = p.(provision.Starter)
+	c.Assert(ok, Equals, true)
+	_, ok = p.(provision.Uploader)
+	c.Assert(ok, Equals, true)
+}